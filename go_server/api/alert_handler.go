@@ -0,0 +1,365 @@
+package api
+
+import (
+	"control/go_server/internal/alerting"
+	"control/go_server/internal/models"
+	"control/go_server/internal/notify"
+	"control/go_server/internal/storage"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// alertEvaluationInterval is how often the alert rules are evaluated
+const alertEvaluationInterval = 15 * time.Second
+
+type AlertHandler struct {
+	store  *storage.AlertStore
+	engine *alerting.Engine
+}
+
+// NewAlertHandler loads the saved rules and silences and starts evaluating
+// them. The metrics store must be open.
+func NewAlertHandler(store *storage.AlertStore) *AlertHandler {
+	h := &AlertHandler{store: store}
+	h.engine = alerting.NewEngine(metricsDB, h.recordEvent)
+
+	if err := h.reloadRules(); err != nil {
+		log.Printf("Failed to load alert rules: %v", err)
+	}
+	if err := h.reloadSilences(); err != nil {
+		log.Printf("Failed to load alert silences: %v", err)
+	}
+	go h.evaluationRoutine()
+	return h
+}
+
+// evaluationRoutine evaluates the rules periodically and removes old history daily
+func (h *AlertHandler) evaluationRoutine() {
+	ticker := time.NewTicker(alertEvaluationInterval)
+	defer ticker.Stop()
+
+	lastCleanup := time.Now()
+	for now := range ticker.C {
+		// silences expire on their own, so refresh them with every evaluation
+		if err := h.reloadSilences(); err != nil {
+			log.Printf("Failed to load alert silences: %v", err)
+		}
+		h.engine.Evaluate(now)
+
+		if time.Since(lastCleanup) >= 24*time.Hour {
+			if err := h.store.CleanupOldEvents(90); err != nil {
+				log.Printf("Failed to remove old alert history: %v", err)
+			}
+			lastCleanup = time.Now()
+		}
+	}
+}
+
+// recordEvent stores a firing or resolved alert and notifies the channels
+// subscribed to alerts unless the alert is silenced
+func (h *AlertHandler) recordEvent(event models.AlertEvent) {
+	log.Printf("Alert %s %s (value %g, threshold %g, labels %v)", event.RuleName, event.State, event.Value, event.Threshold, event.Labels)
+	if err := h.store.CreateEvent(&event); err != nil {
+		log.Printf("Failed to record alert event: %v", err)
+	}
+	liveHub.Publish(liveTopicAlerts, event)
+	if !event.Silenced {
+		sendNotification(alertMessage(event))
+	}
+}
+
+// alertMessage formats an alert event as a notification
+func alertMessage(event models.AlertEvent) notify.Message {
+	var text strings.Builder
+	if summary := event.Annotations["summary"]; summary != "" {
+		text.WriteString(summary + "\n")
+	}
+	if description := event.Annotations["description"]; description != "" {
+		text.WriteString(description + "\n")
+	}
+	fmt.Fprintf(&text, "Value: %g (threshold %g)\n", event.Value, event.Threshold)
+
+	names := make([]string, 0, len(event.Labels))
+	for name := range event.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&text, "%s: %s\n", name, event.Labels[name])
+	}
+	fmt.Fprintf(&text, "Started: %s", event.StartsAt.Format("2006-01-02 15:04:05"))
+	if event.EndsAt != nil {
+		fmt.Fprintf(&text, "\nResolved: %s", event.EndsAt.Format("2006-01-02 15:04:05"))
+	}
+
+	return notify.Message{
+		Topic: "alert",
+		Title: fmt.Sprintf("[%s] %s", strings.ToUpper(string(event.State)), event.RuleName),
+		Text:  text.String(),
+		Data:  event,
+	}
+}
+
+func (h *AlertHandler) reloadRules() error {
+	rules, err := h.store.GetRules()
+	if err != nil {
+		return err
+	}
+	h.engine.SetRules(rules)
+	return nil
+}
+
+func (h *AlertHandler) reloadSilences() error {
+	silences, err := h.store.GetActiveSilences(time.Now())
+	if err != nil {
+		return err
+	}
+	h.engine.SetSilences(silences)
+	return nil
+}
+
+// GetRules godoc
+// @Summary Get alert rules
+// @Tags Alerts
+// @Success 200 {object} gin.H
+// @Router /api/alerts/rules [get]
+func (h *AlertHandler) GetRules(c *gin.Context) {
+	rules, err := h.store.GetRules()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rules": rules})
+}
+
+// CreateRule godoc
+// @Summary Create an alert rule
+// @Tags Alerts
+// @Param request body models.AlertRule true "Alert rule"
+// @Success 200 {object} gin.H
+// @Router /api/alerts/rules [post]
+func (h *AlertHandler) CreateRule(c *gin.Context) {
+	var rule models.AlertRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := alerting.ValidateRule(rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rule.ID = 0
+	if err := h.store.CreateRule(&rule); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.applyRules(c, rule)
+}
+
+// UpdateRule godoc
+// @Summary Update an alert rule
+// @Tags Alerts
+// @Param id path int true "Rule ID"
+// @Param request body models.AlertRule true "Alert rule"
+// @Success 200 {object} gin.H
+// @Router /api/alerts/rules/{id} [put]
+func (h *AlertHandler) UpdateRule(c *gin.Context) {
+	existing, ok := h.findRule(c)
+	if !ok {
+		return
+	}
+
+	var rule models.AlertRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := alerting.ValidateRule(rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rule.ID = existing.ID
+	rule.CreatedAt = existing.CreatedAt
+	if err := h.store.UpdateRule(&rule); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.applyRules(c, rule)
+}
+
+// DeleteRule godoc
+// @Summary Delete an alert rule
+// @Tags Alerts
+// @Param id path int true "Rule ID"
+// @Success 200 {object} gin.H
+// @Router /api/alerts/rules/{id} [delete]
+func (h *AlertHandler) DeleteRule(c *gin.Context) {
+	rule, ok := h.findRule(c)
+	if !ok {
+		return
+	}
+	if err := h.store.DeleteRule(rule.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.applyRules(c, *rule)
+}
+
+// findRule loads the rule of the :id parameter, replying with an error if there is none
+func (h *AlertHandler) findRule(c *gin.Context) (*models.AlertRule, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule ID"})
+		return nil, false
+	}
+	rule, err := h.store.GetRule(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Rule not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return rule, true
+}
+
+// applyRules reloads the engine after a rule change and replies with the rule
+func (h *AlertHandler) applyRules(c *gin.Context, rule models.AlertRule) {
+	if err := h.reloadRules(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rule": rule})
+}
+
+// GetActiveAlerts godoc
+// @Summary Get pending, firing and recently resolved alerts
+// @Tags Alerts
+// @Success 200 {object} gin.H
+// @Router /api/alerts/active [get]
+func (h *AlertHandler) GetActiveAlerts(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"alerts": h.engine.Alerts()})
+}
+
+// GetAlertHistory godoc
+// @Summary Get alert history
+// @Tags Alerts
+// @Param ruleId query int false "Rule ID"
+// @Param state query string false "firing or resolved"
+// @Param hours query int false "Only events of the last hours" default(168)
+// @Param limit query int false "Limit results" default(100)
+// @Success 200 {object} gin.H
+// @Router /api/alerts/history [get]
+func (h *AlertHandler) GetAlertHistory(c *gin.Context) {
+	ruleID, _ := strconv.ParseInt(c.Query("ruleId"), 10, 64)
+	hours, err := strconv.Atoi(c.DefaultQuery("hours", "168"))
+	if err != nil || hours <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid hours parameter"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit parameter"})
+		return
+	}
+
+	since := time.Now().Add(-time.Duration(hours) * time.Hour)
+	events, err := h.store.GetEvents(ruleID, models.AlertState(c.Query("state")), since, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"events": events})
+}
+
+// GetSilences godoc
+// @Summary Get current and scheduled silences
+// @Tags Alerts
+// @Success 200 {object} gin.H
+// @Router /api/alerts/silences [get]
+func (h *AlertHandler) GetSilences(c *gin.Context) {
+	silences, err := h.store.GetActiveSilences(time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"silences": silences})
+}
+
+// CreateSilence godoc
+// @Summary Silence the alerts matching labels for a time range
+// @Description Either endsAt or durationMinutes is required; startsAt defaults to now
+// @Tags Alerts
+// @Success 200 {object} gin.H
+// @Router /api/alerts/silences [post]
+func (h *AlertHandler) CreateSilence(c *gin.Context) {
+	var req struct {
+		models.AlertSilence
+		DurationMinutes int `json:"durationMinutes"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	silence := req.AlertSilence
+	silence.ID = 0
+	if len(silence.Matchers) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one matcher is required"})
+		return
+	}
+	if silence.StartsAt.IsZero() {
+		silence.StartsAt = time.Now()
+	}
+	if req.DurationMinutes > 0 {
+		silence.EndsAt = silence.StartsAt.Add(time.Duration(req.DurationMinutes) * time.Minute)
+	}
+	if !silence.EndsAt.After(silence.StartsAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Silence must end after it starts"})
+		return
+	}
+	silence.CreatedBy = sessionUser(c)
+
+	if err := h.store.CreateSilence(&silence); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.reloadSilences(); err != nil {
+		log.Printf("Failed to load alert silences: %v", err)
+	}
+	c.JSON(http.StatusOK, gin.H{"silence": silence})
+}
+
+// ExpireSilence godoc
+// @Summary End a silence now
+// @Tags Alerts
+// @Param id path int true "Silence ID"
+// @Success 200 {object} gin.H
+// @Router /api/alerts/silences/{id} [delete]
+func (h *AlertHandler) ExpireSilence(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid silence ID"})
+		return
+	}
+	if err := h.store.ExpireSilence(id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.reloadSilences(); err != nil {
+		log.Printf("Failed to load alert silences: %v", err)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Silence expired"})
+}
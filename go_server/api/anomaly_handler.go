@@ -0,0 +1,147 @@
+package api
+
+import (
+	"control/go_server/internal/anomaly"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// anomalyUpdateInterval is how often the baselines learn the newest 5 minute rollups
+	anomalyUpdateInterval = 5 * time.Minute
+	// defaultAnomalyThreshold is the number of standard deviations from the baseline that is anomalous
+	defaultAnomalyThreshold = 3
+)
+
+// anomalyWatches are the metrics that get baselines. MinStd keeps idle or
+// flat series from scoring small wobbles as anomalies.
+var anomalyWatches = []anomaly.Watch{
+	{Metric: seriesServiceCPU, MinStd: 2},
+	{Metric: seriesServiceMemory, MinStd: 10},
+	{Metric: "go_goroutines", MinStd: 20},
+	// proxies are checked every 10 minutes by the auto replace worker
+	{Metric: seriesProxyFailureRatio, MinStd: 0.02, Window: 20 * time.Minute},
+}
+
+// Baselines of the watched metrics, trained by anomalyDetectionRoutine
+var anomalyDetector *anomaly.Detector
+
+// startAnomalyDetection trains the baselines from the metrics history and keeps them up to date
+func startAnomalyDetection() {
+	anomalyDetector = anomaly.NewDetector(metricsDB, anomalyWatches, anomaly.DefaultConfig)
+	go anomalyDetectionRoutine()
+}
+
+func anomalyDetectionRoutine() {
+	ticker := time.NewTicker(anomalyUpdateInterval)
+	defer ticker.Stop()
+
+	for now := time.Now(); ; now = <-ticker.C {
+		if err := anomalyDetector.Update(now); err != nil {
+			log.Printf("Failed to update anomaly baselines: %v", err)
+			continue
+		}
+		publishAnomalies(now)
+	}
+}
+
+// publishAnomalies pushes the anomalous series to the live stream
+func publishAnomalies(now time.Time) {
+	scores, err := anomalyDetector.Current(now)
+	if err != nil {
+		log.Printf("Failed to score metrics: %v", err)
+		return
+	}
+	anomalies := []anomaly.Score{}
+	for _, score := range scores {
+		if score.Anomalous(defaultAnomalyThreshold) {
+			anomalies = append(anomalies, score)
+		}
+	}
+	liveHub.SetState(liveTopicAnomalies, anomalies)
+}
+
+// parseAnomalyThreshold reads the threshold parameter (standard deviations, default 3)
+func parseAnomalyThreshold(c *gin.Context) (float64, bool) {
+	threshold, err := strconv.ParseFloat(c.DefaultQuery("threshold", strconv.Itoa(defaultAnomalyThreshold)), 64)
+	if err != nil || threshold <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid threshold parameter"})
+		return 0, false
+	}
+	return threshold, true
+}
+
+// GetAnomaliesHandler lists the watched series whose latest value is at least
+// threshold standard deviations from its baseline for the current day of week
+// and hour. With all=true every scored series is returned.
+func GetAnomaliesHandler(c *gin.Context) {
+	threshold, ok := parseAnomalyThreshold(c)
+	if !ok {
+		return
+	}
+
+	scores, err := anomalyDetector.Current(time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to score metrics", "message": err.Error()})
+		return
+	}
+	all := c.Query("all") == "true"
+	anomalies := []anomaly.Score{}
+	for _, score := range scores {
+		if all || score.Anomalous(threshold) {
+			anomalies = append(anomalies, score)
+		}
+	}
+
+	metrics := make([]string, 0, len(anomalyWatches))
+	for _, watch := range anomalyWatches {
+		metrics = append(metrics, watch.Metric)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"anomalies":        anomalies,
+		"threshold":        threshold,
+		"metrics":          metrics,
+		"baselinesUpdated": anomalyDetector.Updated(),
+	})
+}
+
+// GetAnomalyScoresHandler scores each 5 minute rollup of a watched metric in
+// the duration window, optionally narrowed by label=key:value parameters
+func GetAnomalyScoresHandler(c *gin.Context) {
+	metric := c.Query("metric")
+	watched := false
+	for _, watch := range anomalyWatches {
+		watched = watched || watch.Metric == metric
+	}
+	if !watched {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "metric is not watched for anomalies"})
+		return
+	}
+	threshold, ok := parseAnomalyThreshold(c)
+	if !ok {
+		return
+	}
+	window, ok := parseHistoryWindow(c)
+	if !ok {
+		return
+	}
+
+	series, err := anomalyDetector.Scores(metric, metricLabelsFromQuery(c), window.start, window.end)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to score metrics", "message": err.Error()})
+		return
+	}
+	if series == nil {
+		series = []anomaly.ScoredSeries{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"metric":    metric,
+		"series":    series,
+		"threshold": threshold,
+		"timeRange": window.timeRange(),
+	})
+}
@@ -2,8 +2,10 @@ package api
 
 import (
 	"net/http"
+	"strings"
 
 	"control/go_server/config"
+	"control/go_server/internal/models"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/sessions"
 )
@@ -40,6 +42,21 @@ func AuthMiddleware() gin.HandlerFunc {
 	}
 }
 
+// RoleMiddleware refuses the users whose role isn't one of roles. It runs
+// after AuthMiddleware.
+func RoleMiddleware(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := userRole(sessionUser(c))
+		for _, r := range roles {
+			if r == role {
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Forbidden", "message": "Requires role " + strings.Join(roles, " or ")})
+	}
+}
+
 // LoginHandler handles user login.
 func LoginHandler(c *gin.Context) {
 	var req struct {
@@ -51,7 +68,7 @@ func LoginHandler(c *gin.Context) {
 		return
 	}
 
-	if req.Username == config.Conf.Login.Username && req.Password == config.Conf.Login.Password {
+	if checkCredentials(req.Username, req.Password) {
 		session := c.MustGet("session").(*sessions.Session)
 		session.Values["user"] = req.Username
 		if err := session.Save(c.Request, c.Writer); err != nil {
@@ -80,8 +97,58 @@ func LogoutHandler(c *gin.Context) {
 func CheckAuthHandler(c *gin.Context) {
 	session := c.MustGet("session").(*sessions.Session)
 	if user, ok := session.Values["user"].(string); ok && user != "" {
-		c.JSON(http.StatusOK, gin.H{"isAuthenticated": true, "user": gin.H{"username": user}})
+		c.JSON(http.StatusOK, gin.H{"isAuthenticated": true, "user": gin.H{"username": user, "role": userRole(user)}})
 	} else {
 		c.JSON(http.StatusOK, gin.H{"isAuthenticated": false})
 	}
 }
+
+// checkCredentials checks a login against the admin account and the users of config.json
+func checkCredentials(username, password string) bool {
+	if username == config.Conf.Login.Username && password == config.Conf.Login.Password {
+		return true
+	}
+	for _, account := range config.Conf.Login.Users {
+		if username == account.Username && password == account.Password {
+			return true
+		}
+	}
+	return false
+}
+
+// userRole returns the role of a user: the main account is admin, the other
+// users have their configured role, operator when it isn't set. Users no
+// longer configured, still logged in after their account was removed, are
+// viewers.
+func userRole(user string) string {
+	if user == config.Conf.Login.Username {
+		return models.RoleAdmin
+	}
+	for _, account := range config.Conf.Login.Users {
+		if account.Username == user {
+			if account.Role == "" {
+				return models.RoleOperator
+			}
+			return account.Role
+		}
+	}
+	return models.RoleViewer
+}
+
+// roleRanks orders the roles from the least to the most privileged
+var roleRanks = map[string]int{models.RoleViewer: 0, models.RoleOperator: 1, models.RoleAdmin: 2}
+
+// roleAtLeast reports whether role has at least the privileges of required
+func roleAtLeast(role, required string) bool {
+	return roleRanks[role] >= roleRanks[required]
+}
+
+// sessionUser returns the logged in user of the request, or "" without a session
+func sessionUser(c *gin.Context) string {
+	session, ok := c.MustGet("session").(*sessions.Session)
+	if !ok {
+		return ""
+	}
+	user, _ := session.Values["user"].(string)
+	return user
+}
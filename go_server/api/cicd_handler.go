@@ -344,6 +344,13 @@ func (h *CICDHandler) performDeployment(deployment *models.Deployment, environme
 			"build_log": buildLog.String(),
 		}
 		
+		result := "failure"
+		if success {
+			result = "success"
+		}
+		deploymentsCounter.Inc(deployment.ServiceName, string(environment), result)
+		deploymentDurationMetric.Observe(endTime.Sub(startTime).Seconds(), string(environment))
+
 		if success {
 			updates["status"] = models.StatusSuccess
 			// Update service environment
@@ -0,0 +1,326 @@
+package api
+
+import (
+	"context"
+	"control/go_server/config"
+	"control/go_server/internal/docker"
+	"control/go_server/internal/models"
+	"control/go_server/internal/utils"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// containerListTimeout bounds the container listing of a collection
+	containerListTimeout = 5 * time.Second
+	// containerStatsTimeout bounds the stats of one container; the daemon
+	// takes about a second to sample CPU usage
+	containerStatsTimeout = 5 * time.Second
+	// containerStopTimeout is how long a container may take to exit before it is killed
+	containerStopTimeout = 10 * time.Second
+)
+
+// Containers of the last collection. available is false while the daemon
+// can't be reached, and services then fall back to their processes.
+var serviceContainers struct {
+	sync.RWMutex
+	available  bool
+	err        string
+	containers []docker.Container
+	byService  map[string][]docker.Container
+}
+
+// Block IO counters of the previous collection of each containerized
+// service, for the IO rates
+var containerIOCounters struct {
+	sync.Mutex
+	samples map[string]containerIOSample
+}
+
+type containerIOSample struct {
+	time          time.Time
+	read, written uint64
+	containers    string // IDs the counters were summed over
+}
+
+// refreshContainers lists the containers and maps them to the services
+func refreshContainers() {
+	ctx, cancel := context.WithTimeout(context.Background(), containerListTimeout)
+	defer cancel()
+	containers, err := docker.Default.List(ctx, true)
+
+	serviceContainers.Lock()
+	defer serviceContainers.Unlock()
+	if err != nil {
+		// Log when the daemon goes away, not on every collection
+		if serviceContainers.available || serviceContainers.err == "" {
+			log.Printf("Docker is unavailable at %s: %v", docker.Default.Socket(), err)
+		}
+		serviceContainers.available = false
+		serviceContainers.err = err.Error()
+		serviceContainers.containers = nil
+		serviceContainers.byService = nil
+		return
+	}
+	if !serviceContainers.available && serviceContainers.err != "" {
+		log.Printf("Docker is available again at %s", docker.Default.Socket())
+	}
+	serviceContainers.available = true
+	serviceContainers.err = ""
+	serviceContainers.containers = containers
+	serviceContainers.byService = docker.ServiceContainers(containers, config.Conf.Services)
+}
+
+// containersOf returns the containers of a service from the last listing.
+// ok is false for a service run as processes: one without containers and
+// without a configured container name, or any service while the daemon is
+// unavailable.
+func containersOf(service models.Service) (containers []docker.Container, ok bool) {
+	serviceContainers.RLock()
+	defer serviceContainers.RUnlock()
+	if !serviceContainers.available {
+		return nil, false
+	}
+	containers = serviceContainers.byService[service.Name]
+	return containers, len(containers) > 0 || service.Container != ""
+}
+
+// containerServiceMetric samples the running containers of a service and
+// returns its entry in the /system-metrics response, caching the stats the
+// way serviceMetric does for processes
+func containerServiceMetric(name string, containers []docker.Container, now time.Time) gin.H {
+	metric := gin.H{
+		"serviceName": name,
+		"status":      "stopped",
+		"cpu":         0,
+		"memory":      0,
+		"processes":   0,
+		"goroutines":  0,
+		"ports":       []string{},
+		"containers":  containers,
+		"timestamp":   now.UnixMilli(),
+	}
+
+	var running []docker.Container
+	for _, c := range containers {
+		if c.Running() {
+			running = append(running, c)
+		}
+	}
+	if len(running) == 0 {
+		latestProcessStats.Delete(name)
+		containerIOCounters.Lock()
+		delete(containerIOCounters.samples, name)
+		containerIOCounters.Unlock()
+	} else {
+		stats := containerProcessStats(name, running, now)
+		latestProcessStats.Store(name, stats)
+
+		metric["status"] = "running"
+		metric["processes"] = stats.Processes
+		metric["cpu"] = stats.CPUPercent
+		metric["memory"] = stats.MemoryMB
+		metric["process"] = stats
+		metric["ports"] = containerPorts(running)
+	}
+
+	if status, ok := latestRuntimeStatuses.Load(name); ok {
+		status := status.(runtimeStatus)
+		metric["runtime"] = status
+		if status.Stats != nil {
+			metric["goroutines"] = status.Stats.Goroutines
+		}
+	}
+	return metric
+}
+
+// containerProcessStats sums the stats of running containers into the
+// process stats stored for native services. Containers whose stats can't be
+// read count as one process without usage.
+func containerProcessStats(name string, running []docker.Container, now time.Time) utils.ProcessStats {
+	results := make([]docker.Stats, len(running))
+	errs := make([]error, len(running))
+	var wg sync.WaitGroup
+	for i, c := range running {
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), containerStatsTimeout)
+			defer cancel()
+			results[i], errs[i] = docker.Default.Stats(ctx, id)
+		}(i, c.ID)
+	}
+	wg.Wait()
+
+	stats := utils.ProcessStats{TCPConnections: map[string]int{}}
+	var read, written uint64
+	var ids string
+	for i, s := range results {
+		if errs[i] != nil {
+			log.Printf("Failed to read stats of container %s of %s: %v", running[i].Name, name, errs[i])
+			stats.Processes++
+			continue
+		}
+		processes := int(s.Pids)
+		if processes == 0 {
+			processes = 1
+		}
+		stats.Processes += processes
+		stats.Threads += int32(s.Pids)
+		stats.CPUPercent += s.CPUPercent
+		stats.MemoryMB += float64(s.MemoryBytes) / 1024 / 1024
+		read += s.BlockRead
+		written += s.BlockWritten
+		ids += running[i].ID
+	}
+
+	containerIOCounters.Lock()
+	defer containerIOCounters.Unlock()
+	if containerIOCounters.samples == nil {
+		containerIOCounters.samples = make(map[string]containerIOSample)
+	}
+	// Counters restart with their container, so rates need the same containers
+	prev, ok := containerIOCounters.samples[name]
+	if elapsed := now.Sub(prev.time).Seconds(); ok && prev.containers == ids && elapsed > 0 {
+		if read >= prev.read {
+			stats.ReadBytesPerSec = float64(read-prev.read) / elapsed
+		}
+		if written >= prev.written {
+			stats.WriteBytesPerSec = float64(written-prev.written) / elapsed
+		}
+	}
+	containerIOCounters.samples[name] = containerIOSample{time: now, read: read, written: written, containers: ids}
+	return stats
+}
+
+// containerPorts lists the ports of containers like the ports of processes:
+// the host port where one is published, the container port otherwise
+func containerPorts(containers []docker.Container) []string {
+	ports := []string{}
+	seen := make(map[string]bool)
+	for _, c := range containers {
+		for _, p := range c.Ports {
+			port := fmt.Sprintf("%d", p.PrivatePort)
+			if p.PublicPort != 0 {
+				port = fmt.Sprintf("%d", p.PublicPort)
+			}
+			if !seen[port] {
+				seen[port] = true
+				ports = append(ports, port)
+			}
+		}
+	}
+	return ports
+}
+
+// containerAction runs action on the containers of a containerized service.
+// handled is false for services run as processes.
+func containerAction(serviceName string, action func(ctx context.Context, id string) error) (handled bool, err error) {
+	service, ok := utils.FindServiceByName(serviceName)
+	if !ok {
+		return false, nil
+	}
+	containers, ok := containersOf(service)
+	if !ok {
+		return false, nil
+	}
+	if len(containers) == 0 {
+		return true, fmt.Errorf("no container of %s found", serviceName)
+	}
+
+	for _, c := range containers {
+		if err := action(context.Background(), c.ID); err != nil {
+			return true, fmt.Errorf("container %s: %w", c.Name, err)
+		}
+	}
+	refreshContainers()
+	containers, _ = containersOf(service)
+	running := false
+	for _, c := range containers {
+		running = running || c.Running()
+	}
+	updateServiceStatus(serviceName, running)
+	return true, nil
+}
+
+func startContainer(ctx context.Context, id string) error {
+	return docker.Default.Start(ctx, id)
+}
+
+func stopContainer(ctx context.Context, id string) error {
+	return docker.Default.Stop(ctx, id, containerStopTimeout)
+}
+
+func restartContainer(ctx context.Context, id string) error {
+	return docker.Default.Restart(ctx, id, containerStopTimeout)
+}
+
+// containerActionResponse writes the response of a start, stop or restart of
+// a containerized service
+func containerActionResponse(c *gin.Context, verb, done string, err error) {
+	if err != nil {
+		log.Printf("Failed to %s containers: %v", verb, err)
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": fmt.Sprintf("Failed to %s service", verb), "logs": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Service containers " + done + " successfully"})
+}
+
+// GetContainersHandler godoc
+// @Summary List docker containers
+// @Description Lists the containers of the last collection with the service each runs, and whether the docker daemon is reachable
+// @Tags Services
+// @Success 200 {object} gin.H
+// @Router /api/containers [get]
+func GetContainersHandler(c *gin.Context) {
+	serviceContainers.RLock()
+	defer serviceContainers.RUnlock()
+
+	services := make(map[string]string)
+	for name, containers := range serviceContainers.byService {
+		for _, container := range containers {
+			services[container.ID] = name
+		}
+	}
+	type containerEntry struct {
+		docker.Container
+		Service string `json:"service,omitempty"`
+	}
+	containers := make([]containerEntry, 0, len(serviceContainers.containers))
+	for _, container := range serviceContainers.containers {
+		containers = append(containers, containerEntry{container, services[container.ID]})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"available":  serviceContainers.available,
+		"error":      serviceContainers.err,
+		"socket":     docker.Default.Socket(),
+		"containers": containers,
+	})
+}
+
+// GetContainerStatsHandler godoc
+// @Summary Docker container stats
+// @Description Reads the current CPU, memory, network and block IO usage of a container
+// @Tags Services
+// @Param id path string true "Container ID or name"
+// @Success 200 {object} docker.Stats
+// @Router /api/containers/{id}/stats [get]
+func GetContainerStatsHandler(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), containerStatsTimeout)
+	defer cancel()
+	stats, err := docker.Default.Stats(ctx, c.Param("id"))
+	if err != nil {
+		status := http.StatusBadGateway
+		if docker.IsNotFound(err) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": "Failed to read container stats", "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
@@ -0,0 +1,573 @@
+package api
+
+import (
+	"control/go_server/config"
+	"control/go_server/internal/files"
+	"control/go_server/internal/models"
+	"control/go_server/internal/storage"
+	"control/go_server/internal/utils"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// FileHandler browses and edits the files in the services' directories. A
+// path can't leave its service's directory, not even through a symlink, and
+// every change keeps a backup of what it replaced and a diff.
+type FileHandler struct {
+	store     *storage.FileStore
+	conf      config.FilesConfig
+	retention time.Duration
+
+	// Serializes changes, so the version a change was checked against is
+	// still the one it replaces
+	mutex sync.Mutex
+}
+
+// NewFileHandler creates the backup directory and starts removing changes
+// older than the retention daily
+func NewFileHandler(store *storage.FileStore, conf config.FilesConfig) *FileHandler {
+	h := &FileHandler{
+		store:     store,
+		conf:      conf,
+		retention: time.Duration(conf.BackupRetentionDays) * 24 * time.Hour,
+	}
+	if err := os.MkdirAll(h.conf.BackupDir, 0700); err != nil {
+		log.Printf("Failed to create file backup directory: %v", err)
+	}
+	go h.retentionRoutine()
+	return h
+}
+
+// retentionRoutine removes expired changes and their backups daily
+func (h *FileHandler) retentionRoutine() {
+	ticker := time.NewTicker(24 * time.Hour)
+	defer ticker.Stop()
+
+	for {
+		if h.retention > 0 {
+			h.removeChangesBefore(time.Now().Add(-h.retention))
+		}
+		<-ticker.C
+	}
+}
+
+func (h *FileHandler) removeChangesBefore(t time.Time) {
+	changes, err := h.store.GetChangesBefore(t)
+	if err != nil {
+		log.Printf("Failed to find expired file changes: %v", err)
+		return
+	}
+	for _, change := range changes {
+		if change.Backup != "" {
+			if err := os.Remove(filepath.Join(h.conf.BackupDir, change.Backup)); err != nil && !os.IsNotExist(err) {
+				log.Printf("Failed to remove file backup %s: %v", change.Backup, err)
+				continue
+			}
+		}
+		if err := h.store.DeleteChange(change.ID); err != nil {
+			log.Printf("Failed to delete file change %d: %v", change.ID, err)
+		}
+	}
+	if len(changes) > 0 {
+		log.Printf("Removed %d file changes older than %s", len(changes), t.Format(time.RFC3339))
+	}
+}
+
+// openService opens the directory of the :serviceName parameter and cleans
+// the path given by the client, replying with an error if either fails. The
+// caller closes the directory.
+func (h *FileHandler) openService(c *gin.Context, p string) (*files.Dir, models.Service, string, bool) {
+	service, found := utils.FindServiceByName(c.Param("serviceName"))
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Service not found"})
+		return nil, service, "", false
+	}
+	p, err := files.Clean(p)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid path"})
+		return nil, service, "", false
+	}
+	dir, err := files.Open(service.Path)
+	if err != nil {
+		fileError(c, err)
+		return nil, service, "", false
+	}
+	return dir, service, p, true
+}
+
+// runsOnDeploy reports whether the file at p is the service's deploy script
+// or an executable, which deploying or a runbook may run with whatever is
+// written into it. info is the file's, nil when it doesn't exist.
+func runsOnDeploy(dir *files.Dir, service models.Service, p string, info os.FileInfo) bool {
+	if info != nil && info.Mode()&0111 != 0 {
+		return true
+	}
+	script := service.DeployScript
+	if filepath.IsAbs(script) {
+		rel, err := filepath.Rel(service.Path, script)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			return false
+		}
+		script = rel
+	}
+	script, err := files.Clean(script)
+	if err != nil || script == "." {
+		return false
+	}
+	if p == script {
+		return true
+	}
+	// The same file reached through a symlinked directory
+	scriptInfo, err := dir.Stat(script)
+	return err == nil && info != nil && os.SameFile(scriptInfo, info)
+}
+
+// fileError replies with the status matching a file error
+func fileError(c *gin.Context, err error) {
+	var pathErr *os.PathError
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		status = http.StatusNotFound
+	case errors.Is(err, os.ErrPermission):
+		status = http.StatusForbidden
+	case errors.Is(err, files.ErrTooLarge):
+		status = http.StatusRequestEntityTooLarge
+	case errors.Is(err, files.ErrNotRegular), errors.Is(err, files.ErrInvalid), errors.As(err, &pathErr):
+		// Including paths escaping the service's directory through a symlink
+		status = http.StatusBadRequest
+	}
+	c.JSON(status, gin.H{"error": err.Error()})
+}
+
+// ListFiles godoc
+// @Summary List a directory of a service
+// @Tags Files
+// @Param serviceName path string true "Service name"
+// @Param path query string false "Directory, relative to the service's directory" default(/)
+// @Success 200 {object} gin.H
+// @Router /api/files/{serviceName} [get]
+func (h *FileHandler) ListFiles(c *gin.Context) {
+	dir, service, p, ok := h.openService(c, c.Query("path"))
+	if !ok {
+		return
+	}
+	defer dir.Close()
+
+	entries, err := dir.List(p)
+	if err != nil {
+		fileError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"service": service.Name, "root": service.Path, "path": p, "entries": entries})
+}
+
+// GetFileContent godoc
+// @Summary Read a text file of a service to show or edit it
+// @Description Files larger than the edit limit, or binary, can only be downloaded. The ETag of the content is sent back when saving it. Operators and admins only.
+// @Tags Files
+// @Param serviceName path string true "Service name"
+// @Param path query string true "File, relative to the service's directory"
+// @Success 200 {object} gin.H
+// @Router /api/files/{serviceName}/content [get]
+func (h *FileHandler) GetFileContent(c *gin.Context) {
+	dir, service, p, ok := h.openService(c, c.Query("path"))
+	if !ok {
+		return
+	}
+	defer dir.Close()
+
+	data, info, err := dir.ReadFile(p, h.conf.MaxEditSize)
+	if errors.Is(err, files.ErrTooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error": fmt.Sprintf("File is larger than %d bytes; download it instead", h.conf.MaxEditSize),
+			"size":  info.Size(),
+		})
+		return
+	}
+	if err != nil {
+		fileError(c, err)
+		return
+	}
+	etag := files.ETag(data)
+	c.Header("ETag", strconv.Quote(etag))
+	if !files.IsText(data) {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Binary file; download it instead", "size": info.Size(), "etag": etag})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"service": service.Name,
+		"path":    p,
+		"content": string(data),
+		"size":    info.Size(),
+		"mode":    info.Mode().String(),
+		"modTime": info.ModTime(),
+		"etag":    etag,
+	})
+}
+
+// DownloadFile godoc
+// @Summary Download a file of a service
+// @Description Operators and admins only
+// @Tags Files
+// @Param serviceName path string true "Service name"
+// @Param path query string true "File, relative to the service's directory"
+// @Success 200 {file} file
+// @Router /api/files/{serviceName}/download [get]
+func (h *FileHandler) DownloadFile(c *gin.Context) {
+	dir, _, p, ok := h.openService(c, c.Query("path"))
+	if !ok {
+		return
+	}
+	defer dir.Close()
+
+	file, info, err := dir.OpenFile(p)
+	if err != nil {
+		fileError(c, err)
+		return
+	}
+	defer file.Close()
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(p)))
+	http.ServeContent(c.Writer, c.Request, path.Base(p), info.ModTime(), file)
+}
+
+// SaveFileContent godoc
+// @Summary Save a text file of a service
+// @Description Optimistic locking: etag, or an If-Match header, must be the ETag the content was read with, and the save fails with 412 if the file changed since. Without it the file is created, and the save fails with 412 if it exists. Operators and admins only; only admins may change the deploy script or executables.
+// @Tags Files
+// @Param serviceName path string true "Service name"
+// @Success 200 {object} gin.H
+// @Router /api/files/{serviceName}/content [put]
+func (h *FileHandler) SaveFileContent(c *gin.Context) {
+	var req struct {
+		Path    string `json:"path" binding:"required"`
+		Content string `json:"content"`
+		ETag    string `json:"etag"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Path is required"})
+		return
+	}
+	if req.ETag == "" {
+		req.ETag = strings.Trim(c.GetHeader("If-Match"), `"`)
+	}
+	if int64(len(req.Content)) > h.conf.MaxEditSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Content is larger than %d bytes", h.conf.MaxEditSize)})
+		return
+	}
+
+	dir, service, p, ok := h.openService(c, req.Path)
+	if !ok {
+		return
+	}
+	defer dir.Close()
+	h.change(c, dir, service, p, models.FileEdited, strings.NewReader(req.Content), h.conf.MaxEditSize, req.ETag, false)
+}
+
+// UploadFile godoc
+// @Summary Upload a file into a directory of a service
+// @Description Multipart form with the file, the directory (path) and optionally another name. An existing file is only replaced with overwrite=true, or an etag matching its content. Operators and admins only; only admins may replace the deploy script or executables.
+// @Tags Files
+// @Param serviceName path string true "Service name"
+// @Param file formData file true "File"
+// @Param path formData string false "Directory, relative to the service's directory" default(/)
+// @Param name formData string false "File name, the uploaded file's by default"
+// @Param overwrite formData bool false "Replace an existing file"
+// @Param etag formData string false "ETag of the file it replaces"
+// @Success 200 {object} gin.H
+// @Router /api/files/{serviceName}/upload [post]
+func (h *FileHandler) UploadFile(c *gin.Context) {
+	// Room for the form around the file
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.conf.MaxUploadSize+1<<20)
+	header, err := c.FormFile("file")
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("File is larger than %d bytes", h.conf.MaxUploadSize)})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File is required"})
+		return
+	}
+	if header.Size > h.conf.MaxUploadSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("File is larger than %d bytes", h.conf.MaxUploadSize)})
+		return
+	}
+	name := c.PostForm("name")
+	if name == "" {
+		name = header.Filename
+	}
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	if name == "." || name == ".." || name == "/" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file name"})
+		return
+	}
+
+	dir, service, p, ok := h.openService(c, path.Join("/", c.PostForm("path"), name))
+	if !ok {
+		return
+	}
+	defer dir.Close()
+	upload, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer upload.Close()
+	h.change(c, dir, service, p, models.FileUploaded, upload, h.conf.MaxUploadSize, c.PostForm("etag"), c.PostForm("overwrite") == "true")
+}
+
+// change replaces the file at p with content, after checking etag against
+// the file it replaces unless overwrite is set, and records the change with
+// a backup of the old content and a diff
+func (h *FileHandler) change(c *gin.Context, dir *files.Dir, service models.Service, p string, action models.FileChangeAction, content io.Reader, limit int64, etag string, overwrite bool) {
+	user := sessionUser(c)
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	record := models.FileChange{Service: service.Name, Path: p, User: user, Action: action}
+	old := &capture{max: h.conf.MaxEditSize}
+	oldFile, info, err := dir.OpenFile(p)
+	if (err == nil || errors.Is(err, os.ErrNotExist)) && userRole(user) != models.RoleAdmin && runsOnDeploy(dir, service, p, info) {
+		if oldFile != nil {
+			oldFile.Close()
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden", "message": "Only admins may change the deploy script or executables"})
+		return
+	}
+	switch {
+	case err == nil:
+		record.Backup, record.OldETag, err = h.backup(service.Name, p, io.TeeReader(oldFile, old))
+		oldFile.Close()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to back up the file", "message": err.Error()})
+			return
+		}
+		if !overwrite && etag != record.OldETag {
+			os.Remove(filepath.Join(h.conf.BackupDir, record.Backup))
+			message := "File changed since it was read"
+			if etag == "" {
+				message = "File exists; send the ETag of the version it replaces"
+			}
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": message, "etag": record.OldETag})
+			return
+		}
+	case errors.Is(err, os.ErrNotExist):
+		if etag != "" {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": "File was removed since it was read"})
+			return
+		}
+	default:
+		fileError(c, err)
+		return
+	}
+
+	digest := files.NewDigest()
+	written := &capture{max: h.conf.MaxEditSize}
+	size, err := dir.WriteFile(p, io.TeeReader(content, io.MultiWriter(digest, written)), limit)
+	if err != nil {
+		if record.Backup != "" {
+			os.Remove(filepath.Join(h.conf.BackupDir, record.Backup))
+		}
+		fileError(c, err)
+		return
+	}
+	record.Size = size
+	record.NewETag = digest.ETag()
+
+	switch {
+	case old.over || written.over:
+		record.DiffNote = fmt.Sprintf("Not diffed: larger than %d bytes", h.conf.MaxEditSize)
+	case !files.IsText(old.data) || !files.IsText(written.data):
+		record.DiffNote = "Not diffed: binary file"
+	default:
+		record.Diff = files.Diff(p, old.data, written.data)
+	}
+	if err := h.store.CreateChange(&record); err != nil {
+		log.Printf("Failed to record change of %s/%s: %v", service.Name, p, err)
+	}
+	log.Printf("File %s of %s changed by %s (%s, %d bytes)", p, service.Name, user, action, size)
+	liveHub.Publish(liveTopicEvents, gin.H{
+		"type":    "file_change",
+		"id":      record.ID,
+		"service": service.Name,
+		"path":    p,
+		"user":    user,
+		"action":  action,
+	})
+
+	c.Header("ETag", strconv.Quote(record.NewETag))
+	c.JSON(http.StatusOK, gin.H{"change": record, "etag": record.NewETag})
+}
+
+// backup copies the content of a file about to be replaced into the backup
+// directory and returns the backup's name in it and the content's ETag
+func (h *FileHandler) backup(service, p string, content io.Reader) (string, string, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", "", err
+	}
+	name := filepath.Join(service, fmt.Sprintf("%s-%s-%s", time.Now().Format("20060102-150405"), hex.EncodeToString(suffix), path.Base(p)))
+	backupPath := filepath.Join(h.conf.BackupDir, name)
+	if err := os.MkdirAll(filepath.Dir(backupPath), 0700); err != nil {
+		return "", "", err
+	}
+	file, err := os.OpenFile(backupPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", "", err
+	}
+	digest := files.NewDigest()
+	_, err = io.Copy(io.MultiWriter(file, digest), content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(backupPath)
+		return "", "", err
+	}
+	return name, digest.ETag(), nil
+}
+
+// capture keeps the first max bytes written to it, to diff content that is streamed
+type capture struct {
+	max  int64
+	data []byte
+	over bool // more than max bytes were written
+}
+
+func (w *capture) Write(p []byte) (int, error) {
+	if !w.over {
+		if int64(len(w.data)+len(p)) > w.max {
+			w.over = true
+			w.data = nil
+		} else {
+			w.data = append(w.data, p...)
+		}
+	}
+	return len(p), nil
+}
+
+// GetFileChanges godoc
+// @Summary Get the changes of a service's files, newest first
+// @Description Without their diffs
+// @Tags Files
+// @Param serviceName path string true "Service name"
+// @Param path query string false "Only the changes of this file"
+// @Param limit query int false "Limit results" default(100)
+// @Success 200 {object} gin.H
+// @Router /api/files/{serviceName}/changes [get]
+func (h *FileHandler) GetFileChanges(c *gin.Context) {
+	service, found := utils.FindServiceByName(c.Param("serviceName"))
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Service not found"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit parameter"})
+		return
+	}
+	var p string
+	if c.Query("path") != "" {
+		if p, err = files.Clean(c.Query("path")); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid path"})
+			return
+		}
+	}
+
+	changes, err := h.store.GetChanges(service.Name, p, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"changes": changes})
+}
+
+// GetFileChange godoc
+// @Summary Get a change of a service's file with its diff
+// @Description Operators and admins only, as the diff shows the content
+// @Tags Files
+// @Param serviceName path string true "Service name"
+// @Param id path int true "Change ID"
+// @Success 200 {object} gin.H
+// @Router /api/files/{serviceName}/changes/{id} [get]
+func (h *FileHandler) GetFileChange(c *gin.Context) {
+	change, ok := h.findChange(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"change": change, "hasBackup": change.Backup != ""})
+}
+
+// DownloadFileBackup godoc
+// @Summary Download the content a change replaced
+// @Description Operators and admins only
+// @Tags Files
+// @Param serviceName path string true "Service name"
+// @Param id path int true "Change ID"
+// @Success 200 {file} file
+// @Router /api/files/{serviceName}/changes/{id}/backup [get]
+func (h *FileHandler) DownloadFileBackup(c *gin.Context) {
+	change, ok := h.findChange(c)
+	if !ok {
+		return
+	}
+	if change.Backup == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "The change created the file; there is no backup"})
+		return
+	}
+	file, err := os.Open(filepath.Join(h.conf.BackupDir, change.Backup))
+	if os.IsNotExist(err) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Backup file not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := fmt.Sprintf("%s.%d.bak", path.Base(change.Path), change.ID)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	http.ServeContent(c.Writer, c.Request, name, info.ModTime(), file)
+}
+
+// findChange loads the change of the :id parameter of the :serviceName
+// service, replying with an error if there is none
+func (h *FileHandler) findChange(c *gin.Context) (*models.FileChange, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid change ID"})
+		return nil, false
+	}
+	change, err := h.store.GetChange(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) || err == nil && change.Service != c.Param("serviceName") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Change not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return change, true
+}
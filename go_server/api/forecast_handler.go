@@ -0,0 +1,224 @@
+package api
+
+import (
+	"control/go_server/config"
+	"control/go_server/internal/forecast"
+	"control/go_server/internal/notify"
+	"control/go_server/internal/tsdb"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Forecasts fit the 5 minute rollups of the last week, with a daily season
+	forecastResolution = tsdb.FiveMinute
+	forecastStep       = time.Duration(tsdb.FiveMinute)
+	forecastSeason     = int(24 * time.Hour / forecastStep)
+	forecastLookback   = 7 * 24 * time.Hour
+	// forecastLimit is how far ahead exhaustion is searched for
+	forecastLimit = 90 * 24 * time.Hour
+	// forecastCheckInterval is how often forecasts are checked for warnings
+	forecastCheckInterval = time.Hour
+)
+
+// exhaustionTarget is a series forecast against a capacity
+type exhaustionTarget struct {
+	kind     string // filesystem, memory or service_memory
+	series   string
+	label    string // label naming the target, empty for host-wide series
+	unit     string
+	capacity func(current float64) (float64, bool)
+}
+
+var exhaustionTargets = []exhaustionTarget{
+	{"filesystem", "host_filesystem_used_percent", "mountpoint", "%", fullPercent},
+	{"memory", "host_memory_used_percent", "", "%", fullPercent},
+	// A service runs out when its growth takes the memory still available on the host
+	{"service_memory", seriesServiceMemory, "service", "MB", func(current float64) (float64, bool) {
+		latestHostStats.RLock()
+		defer latestHostStats.RUnlock()
+		if latestHostStats.stats == nil {
+			return 0, false
+		}
+		return current + float64(latestHostStats.stats.Memory.Available)/1024/1024, true
+	}},
+}
+
+func fullPercent(float64) (float64, bool) { return 100, true }
+
+// exhaustionForecast is the forecast of one target
+type exhaustionForecast struct {
+	Kind   string `json:"kind"`
+	Target string `json:"target"`
+	Unit   string `json:"unit"`
+	forecast.Forecast
+	Warning bool `json:"warning"` // exhaustion is expected within the horizon
+}
+
+func (f exhaustionForecast) key() string {
+	return f.Kind + "/" + f.Target
+}
+
+// forecastExhaustion forecasts every target with method (auto picks the
+// better fitting model) and flags those running out before now+horizon
+func forecastExhaustion(now time.Time, method string, horizon time.Duration) ([]exhaustionForecast, error) {
+	result := []exhaustionForecast{}
+	for _, target := range exhaustionTargets {
+		seriesList, err := metricsDB.Select(target.series, nil, forecastResolution, now.Add(-forecastLookback), now)
+		if err != nil {
+			return nil, err
+		}
+		for _, series := range seriesList {
+			points := make([]forecast.Point, 0, len(series.Samples))
+			for _, sample := range series.Samples {
+				points = append(points, forecast.Point{Time: sample.Time, Value: sample.Avg})
+			}
+			points = forecast.Resample(points, forecastStep)
+			if len(points) == 0 {
+				continue
+			}
+
+			capacity, ok := target.capacity(points[len(points)-1].Value)
+			if !ok {
+				continue
+			}
+			var f forecast.Forecast
+			switch forecast.Method(method) {
+			case forecast.MethodLinear:
+				f, err = forecast.Linear(points, forecastStep, capacity, forecastLimit)
+			case forecast.MethodHolt:
+				f, err = forecast.Holt(points, forecastStep, forecastSeason, capacity, forecastLimit)
+			default:
+				f, err = forecast.Best(points, forecastStep, forecastSeason, capacity, forecastLimit)
+			}
+			if err != nil {
+				continue // not enough history yet
+			}
+
+			name := "host"
+			if target.label != "" {
+				name = series.Labels[target.label]
+			}
+			result = append(result, exhaustionForecast{
+				Kind:     target.kind,
+				Target:   name,
+				Unit:     target.unit,
+				Forecast: f,
+				Warning:  f.Within(now.Add(horizon)),
+			})
+		}
+	}
+
+	// Soonest exhaustion first, then those that don't run out
+	sort.SliceStable(result, func(i, j int) bool {
+		a, b := result[i].ExhaustsAt, result[j].ExhaustsAt
+		switch {
+		case a == nil || b == nil:
+			return a != nil && b == nil
+		default:
+			return a.Before(*b)
+		}
+	})
+	return result, nil
+}
+
+// Targets warned about, so each is notified once until its forecast clears
+var forecastWarnings struct {
+	sync.Mutex
+	warned map[string]bool
+}
+
+// startExhaustionWarnings checks the forecasts hourly and warns about targets
+// expected to run out within the configured horizon
+func startExhaustionWarnings() {
+	forecastWarnings.warned = make(map[string]bool)
+	go func() {
+		ticker := time.NewTicker(forecastCheckInterval)
+		defer ticker.Stop()
+
+		for now := range ticker.C {
+			checkExhaustionWarnings(now)
+		}
+	}()
+}
+
+func checkExhaustionWarnings(now time.Time) {
+	horizon := config.Conf.ExhaustionWarningHorizon
+	if horizon <= 0 {
+		return
+	}
+	forecasts, err := forecastExhaustion(now, "auto", horizon)
+	if err != nil {
+		log.Printf("Failed to forecast exhaustion: %v", err)
+		return
+	}
+
+	forecastWarnings.Lock()
+	defer forecastWarnings.Unlock()
+	current := make(map[string]bool)
+	for _, f := range forecasts {
+		if !f.Warning {
+			continue
+		}
+		current[f.key()] = true
+		if forecastWarnings.warned[f.key()] {
+			continue
+		}
+
+		log.Printf("Exhaustion forecast: %s %s runs out at %s", f.Kind, f.Target, f.ExhaustsAt.Format(time.RFC3339))
+		liveHub.Publish(liveTopicEvents, gin.H{"type": "exhaustion_forecast", "forecast": f})
+		sendNotification(notify.Message{
+			Topic: "forecast",
+			Title: fmt.Sprintf("[FORECAST] %s %s runs out in %s", f.Kind, f.Target, f.ExhaustsAt.Sub(now).Round(time.Minute)),
+			Text: fmt.Sprintf("%s %s is at %.1f%s of %.1f%s, growing %.2f%s per hour (%s, confidence %.0f%%).\nExpected to run out at %s.",
+				f.Kind, f.Target, f.Current, f.Unit, f.Capacity, f.Unit, f.RatePerHour, f.Unit,
+				f.Method, f.Confidence*100, f.ExhaustsAt.Format("2006-01-02 15:04")),
+			Data: f,
+			Time: now,
+		})
+	}
+	forecastWarnings.warned = current
+}
+
+// GetExhaustionForecastsHandler godoc
+// @Summary Forecast disk and memory exhaustion
+// @Description Forecasts when each filesystem, the host memory and each service's memory run out, from the last week of history
+// @Tags Metrics
+// @Param method query string false "auto, linear or holt" default(auto)
+// @Param horizon query int false "Hours within which exhaustion is flagged as a warning, default from the config"
+// @Success 200 {object} gin.H
+// @Router /api/forecasts/exhaustion [get]
+func GetExhaustionForecastsHandler(c *gin.Context) {
+	method := c.DefaultQuery("method", "auto")
+	if method != "auto" && method != string(forecast.MethodLinear) && method != string(forecast.MethodHolt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "method must be auto, linear or holt"})
+		return
+	}
+	horizon := config.Conf.ExhaustionWarningHorizon
+	if s := c.Query("horizon"); s != "" {
+		hours, err := strconv.Atoi(s)
+		if err != nil || hours <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid horizon parameter"})
+			return
+		}
+		horizon = time.Duration(hours) * time.Hour
+	}
+
+	forecasts, err := forecastExhaustion(time.Now(), method, horizon)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to forecast", "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"forecasts":    forecasts,
+		"horizonHours": horizon.Hours(),
+		"method":       method,
+	})
+}
@@ -0,0 +1,219 @@
+package api
+
+import (
+	"control/go_server/internal/tsdb"
+	"control/go_server/internal/utils"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// hostField maps a host series to its field in the history response
+type hostField[T any] struct {
+	key    string
+	series string
+	value  func(T) float64
+}
+
+var hostCPUFields = []hostField[utils.CPUStats]{
+	{"usage", "host_cpu_usage_percent", func(s utils.CPUStats) float64 { return s.Usage }},
+	{"user", "host_cpu_user_percent", func(s utils.CPUStats) float64 { return s.User }},
+	{"system", "host_cpu_system_percent", func(s utils.CPUStats) float64 { return s.System }},
+	{"iowait", "host_cpu_iowait_percent", func(s utils.CPUStats) float64 { return s.IOWait }},
+	{"steal", "host_cpu_steal_percent", func(s utils.CPUStats) float64 { return s.Steal }},
+}
+
+var hostMemoryFields = []hostField[utils.MemoryStats]{
+	{"used", "host_memory_used_bytes", func(s utils.MemoryStats) float64 { return float64(s.Used) }},
+	{"available", "host_memory_available_bytes", func(s utils.MemoryStats) float64 { return float64(s.Available) }},
+	{"usedPercent", "host_memory_used_percent", func(s utils.MemoryStats) float64 { return s.UsedPercent }},
+	{"swapUsed", "host_swap_used_bytes", func(s utils.MemoryStats) float64 { return float64(s.SwapUsed) }},
+	{"swapPercent", "host_swap_used_percent", func(s utils.MemoryStats) float64 { return s.SwapPercent }},
+}
+
+var hostFilesystemFields = []hostField[utils.FilesystemStats]{
+	{"used", "host_filesystem_used_bytes", func(s utils.FilesystemStats) float64 { return float64(s.Used) }},
+	{"free", "host_filesystem_free_bytes", func(s utils.FilesystemStats) float64 { return float64(s.Free) }},
+	{"usedPercent", "host_filesystem_used_percent", func(s utils.FilesystemStats) float64 { return s.UsedPercent }},
+	{"inodesUsedPercent", "host_filesystem_inodes_used_percent", func(s utils.FilesystemStats) float64 { return s.InodesUsedPercent }},
+}
+
+var hostDiskFields = []hostField[utils.DiskIOStats]{
+	{"readIops", "host_disk_read_iops", func(s utils.DiskIOStats) float64 { return s.ReadIOPS }},
+	{"writeIops", "host_disk_write_iops", func(s utils.DiskIOStats) float64 { return s.WriteIOPS }},
+	{"readBytesPerSec", "host_disk_read_bytes_per_sec", func(s utils.DiskIOStats) float64 { return s.ReadBytesPerSec }},
+	{"writeBytesPerSec", "host_disk_write_bytes_per_sec", func(s utils.DiskIOStats) float64 { return s.WriteBytesPerSec }},
+	{"busyPercent", "host_disk_busy_percent", func(s utils.DiskIOStats) float64 { return s.BusyPercent }},
+}
+
+var hostInterfaceFields = []hostField[utils.InterfaceStats]{
+	{"receiveBytesPerSec", "host_network_receive_bytes_per_sec", func(s utils.InterfaceStats) float64 { return s.ReceiveBytesPerSec }},
+	{"transmitBytesPerSec", "host_network_transmit_bytes_per_sec", func(s utils.InterfaceStats) float64 { return s.TransmitBytesPerSec }},
+	{"receivePacketsPerSec", "host_network_receive_packets_per_sec", func(s utils.InterfaceStats) float64 { return s.ReceivePacketsPerSec }},
+	{"transmitPacketsPerSec", "host_network_transmit_packets_per_sec", func(s utils.InterfaceStats) float64 { return s.TransmitPacketsPerSec }},
+	{"errorsPerSec", "host_network_errors_per_sec", func(s utils.InterfaceStats) float64 { return s.ErrorsPerSec }},
+	{"dropsPerSec", "host_network_drops_per_sec", func(s utils.InterfaceStats) float64 { return s.DropsPerSec }},
+}
+
+var hostLoadFields = []hostField[[3]float64]{
+	{"load1", "host_load1", func(l [3]float64) float64 { return l[0] }},
+	{"load5", "host_load5", func(l [3]float64) float64 { return l[1] }},
+	{"load15", "host_load15", func(l [3]float64) float64 { return l[2] }},
+}
+
+// Host rate state and the latest host sample
+var (
+	hostSampler     = utils.NewHostSampler()
+	latestHostStats struct {
+		sync.RWMutex
+		stats *utils.HostStats
+	}
+)
+
+// collectAndStoreHostMetrics samples the host and stores every field
+func collectAndStoreHostMetrics(now time.Time) {
+	stats := hostSampler.Sample()
+	latestHostStats.Lock()
+	latestHostStats.stats = &stats
+	latestHostStats.Unlock()
+	liveHub.SetState(liveTopicHost, stats)
+
+	var err error
+	store := func(series string, labels tsdb.Labels, value float64) {
+		if err == nil {
+			err = metricsDB.Append(series, labels, now, value)
+		}
+	}
+	for _, core := range append([]utils.CPUStats{stats.CPU}, stats.Cores...) {
+		for _, field := range hostCPUFields {
+			store(field.series, tsdb.Labels{"cpu": core.CPU}, field.value(core))
+		}
+	}
+	for _, field := range hostMemoryFields {
+		store(field.series, nil, field.value(stats.Memory))
+	}
+	for _, fs := range stats.Filesystems {
+		for _, field := range hostFilesystemFields {
+			store(field.series, tsdb.Labels{"mountpoint": fs.Mountpoint}, field.value(fs))
+		}
+	}
+	for _, d := range stats.Disks {
+		for _, field := range hostDiskFields {
+			store(field.series, tsdb.Labels{"device": d.Device}, field.value(d))
+		}
+	}
+	for _, i := range stats.Interfaces {
+		for _, field := range hostInterfaceFields {
+			store(field.series, tsdb.Labels{"interface": i.Name}, field.value(i))
+		}
+	}
+	for _, field := range hostLoadFields {
+		store(field.series, nil, field.value(stats.Load))
+	}
+	if err != nil {
+		log.Printf("Failed to store host metrics: %v", err)
+	}
+}
+
+// hostHistory reads the fields of a group of host series and returns their
+// points per value of label, e.g. per mountpoint. Unlabeled groups are keyed "".
+func hostHistory[T any](fields []hostField[T], label string, window historyWindow) (map[string][]gin.H, error) {
+	byGroup := make(map[string]map[int64]gin.H)
+	for _, field := range fields {
+		seriesList, err := metricsDB.Select(field.series, nil, window.resolution, window.start, window.end)
+		if err != nil {
+			return nil, err
+		}
+		for _, series := range seriesList {
+			group := series.Labels[label]
+			if byGroup[group] == nil {
+				byGroup[group] = make(map[int64]gin.H)
+			}
+			for _, sample := range series.Samples {
+				ts := sample.Time.UnixMilli()
+				point, ok := byGroup[group][ts]
+				if !ok {
+					point = gin.H{
+						"timestamp":          ts,
+						"timestampFormatted": sample.Time.Format(window.timeFormat()),
+					}
+					byGroup[group][ts] = point
+				}
+				point[field.key] = sample.Avg
+				if window.resolution != tsdb.Raw {
+					point[field.key+"Min"] = sample.Min
+					point[field.key+"Max"] = sample.Max
+				}
+			}
+		}
+	}
+
+	history := make(map[string][]gin.H, len(byGroup))
+	for group, points := range byGroup {
+		list := make([]gin.H, 0, len(points))
+		for _, point := range points {
+			list = append(list, point)
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i]["timestamp"].(int64) < list[j]["timestamp"].(int64) })
+		history[group] = list
+	}
+	return history, nil
+}
+
+// HostHistoryHandler returns the host metrics history: total and per-core CPU,
+// memory and swap, load, and per-filesystem, per-disk and per-interface series.
+// It takes the same duration and resolution parameters as the service history.
+func HostHistoryHandler(c *gin.Context) {
+	window, ok := parseHistoryWindow(c)
+	if !ok {
+		return
+	}
+
+	cpu, err := hostHistory(hostCPUFields, "cpu", window)
+	var memory, load, filesystems, disks, interfaces map[string][]gin.H
+	if err == nil {
+		memory, err = hostHistory(hostMemoryFields, "", window)
+	}
+	if err == nil {
+		load, err = hostHistory(hostLoadFields, "", window)
+	}
+	if err == nil {
+		filesystems, err = hostHistory(hostFilesystemFields, "mountpoint", window)
+	}
+	if err == nil {
+		disks, err = hostHistory(hostDiskFields, "device", window)
+	}
+	if err == nil {
+		interfaces, err = hostHistory(hostInterfaceFields, "interface", window)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read host metrics history", "message": err.Error()})
+		return
+	}
+
+	total := cpu["all"]
+	delete(cpu, "all")
+	c.JSON(http.StatusOK, gin.H{
+		"cpu":         orEmpty(total),
+		"cores":       cpu,
+		"memory":      orEmpty(memory[""]),
+		"load":        orEmpty(load[""]),
+		"filesystems": filesystems,
+		"disks":       disks,
+		"interfaces":  interfaces,
+		"resolution":  window.resolution.String(),
+		"timeRange":   window.timeRange(),
+	})
+}
+
+// orEmpty keeps an empty history a JSON array instead of null
+func orEmpty(points []gin.H) []gin.H {
+	if points == nil {
+		return []gin.H{}
+	}
+	return points
+}
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"control/go_server/internal/live"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Topics of the live stream. metrics, services, host and anomalies are state
+// topics: a new subscriber receives their current value first.
+const (
+	liveTopicMetrics   = "metrics"   // the /system-metrics snapshot, every collection
+	liveTopicServices  = "services"  // the /services-status map, every collection
+	liveTopicHost      = "host"      // host CPU, memory, disks and network, every collection
+	liveTopicAnomalies = "anomalies" // current anomalies, every baseline update
+	liveTopicEvents    = "events"    // service status changes and exhaustion forecasts
+	liveTopicAlerts    = "alerts"    // firing and resolved alerts
+	liveTopicLogAlerts = "log_alerts"
+	liveTopicApprovals = "approvals" // terminal commands held, approved or rejected
+)
+
+var liveTopics = []string{
+	liveTopicMetrics, liveTopicServices, liveTopicHost, liveTopicAnomalies,
+	liveTopicEvents, liveTopicAlerts, liveTopicLogAlerts, liveTopicApprovals,
+}
+
+// liveHeartbeatInterval keeps idle streams from being closed by proxies
+const liveHeartbeatInterval = 15 * time.Second
+
+// liveHub carries the collectors' updates to the dashboard streams
+var liveHub = live.NewHub()
+
+// serviceStatuses are the service statuses of the last collection, to detect changes
+var serviceStatuses struct {
+	sync.Mutex
+	statuses map[string]string
+}
+
+// publishServiceSnapshot publishes the services' metrics and statuses and an
+// event for every service whose status changed since the last collection
+func publishServiceSnapshot(snapshot map[string]gin.H) {
+	statuses := make(map[string]string, len(snapshot))
+	for name, metric := range snapshot {
+		statuses[name] = metric["status"].(string)
+	}
+	liveHub.SetState(liveTopicMetrics, snapshot)
+	setServiceStatuses(statuses)
+}
+
+// updateServiceStatus records the status of one service found outside the
+// metrics collection, e.g. right after it was stopped
+func updateServiceStatus(name string, running bool) {
+	serviceStatuses.Lock()
+	statuses := make(map[string]string, len(serviceStatuses.statuses))
+	for k, v := range serviceStatuses.statuses {
+		statuses[k] = v
+	}
+	serviceStatuses.Unlock()
+
+	if _, ok := statuses[name]; !ok {
+		return // not a configured service
+	}
+	statuses[name] = "stopped"
+	if running {
+		statuses[name] = "running"
+	}
+	setServiceStatuses(statuses)
+}
+
+// setServiceStatuses publishes the statuses and the changes from the previous ones
+func setServiceStatuses(statuses map[string]string) {
+	serviceStatuses.Lock()
+	defer serviceStatuses.Unlock()
+	previous := serviceStatuses.statuses
+	serviceStatuses.statuses = statuses
+
+	liveHub.SetState(liveTopicServices, statuses)
+	for name, status := range statuses {
+		if from, ok := previous[name]; ok && from != status {
+			liveHub.Publish(liveTopicEvents, gin.H{
+				"type":    "service_status",
+				"service": name,
+				"from":    from,
+				"to":      status,
+			})
+		}
+	}
+}
+
+// cachedServiceStatus returns a service's status from the last collection
+func cachedServiceStatus(name string) (string, bool) {
+	serviceStatuses.Lock()
+	defer serviceStatuses.Unlock()
+	status, ok := serviceStatuses.statuses[name]
+	return status, ok
+}
+
+// LiveHandler streams updates as server-sent events
+// @Summary Live dashboard updates
+// @Description Server-sent events named after their topic, with the data as JSON. Topics are metrics, services, host, anomalies, events, alerts and log_alerts; state topics send their current value first.
+// @Tags System
+// @Param topics query string false "Comma separated topics, default all"
+// @Success 200 {string} string "text/event-stream"
+// @Router /api/live [get]
+func LiveHandler(c *gin.Context) {
+	var topics []string
+	if s := c.Query("topics"); s != "" {
+		for _, topic := range strings.Split(s, ",") {
+			topic = strings.TrimSpace(topic)
+			known := false
+			for _, t := range liveTopics {
+				known = known || t == topic
+			}
+			if !known {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown topic " + topic, "topics": liveTopics})
+				return
+			}
+			topics = append(topics, topic)
+		}
+	}
+
+	sub := liveHub.Subscribe(topics...)
+	defer sub.Close()
+
+	heartbeat := time.NewTicker(liveHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no") // don't let nginx buffer the stream
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case event, ok := <-sub.C:
+			if !ok {
+				return false
+			}
+			data, err := json.Marshal(event.Data)
+			if err != nil {
+				log.Printf("Failed to encode live %s event: %v", event.Topic, err)
+				return true
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Topic, data)
+		case <-heartbeat.C:
+			io.WriteString(w, ": heartbeat\n\n")
+		}
+		return true
+	})
+}
+
+// LiveStatsHandler returns the number of open streams and the topics
+func LiveStatsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"subscribers": liveHub.Subscribers(), "topics": liveTopics})
+}
@@ -0,0 +1,287 @@
+package api
+
+import (
+	"control/go_server/config"
+	"control/go_server/internal/logs"
+	"control/go_server/internal/models"
+	"control/go_server/internal/notify"
+	"control/go_server/internal/storage"
+	"control/go_server/internal/utils"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	logAlertStorage *storage.LogAlertStorage
+	logAlertEngine  *logs.AlertEngine
+	logAlertRules   []models.LogAlertRule
+	logAlertMutex   sync.Mutex // guards logAlertRules and rule file updates
+	logAlertOnce    sync.Once
+
+	// followers and detected parsers of the log sources watched by alert rules
+	logAlertFollowers = make(map[logs.AlertTarget]logs.LineFollower)
+	logAlertParsers   = make(map[logs.AlertTarget]logs.Parser)
+)
+
+func init() {
+	logAlertStorage = storage.NewLogAlertStorage("./logs/log_alerts")
+	logAlertEngine = logs.NewAlertEngine(recordLogAlertEvent)
+	go logAlertRoutine()
+
+	// Remove alert events older than 90 days
+	go func() {
+		ticker := time.NewTicker(24 * time.Hour)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			logAlertStorage.CleanupOldLogs(90)
+		}
+	}()
+}
+
+// recordLogAlertEvent stores a firing or resolved log alert
+func recordLogAlertEvent(event models.LogAlertEvent) {
+	log.Printf("Log alert %s %s for %s (%d matching lines)", event.Rule, event.State, event.Service, event.Count)
+	if err := logAlertStorage.LogEvent(event); err != nil {
+		log.Printf("Failed to record log alert event: %v", err)
+	}
+	liveHub.Publish(liveTopicLogAlerts, event)
+
+	text := fmt.Sprintf("Service: %s\nMatching lines: %d\nStarted: %s", event.Service, event.Count, event.StartsAt.Format("2006-01-02 15:04:05"))
+	if len(event.Samples) > 0 {
+		text += "\n\n" + strings.Join(event.Samples, "\n")
+	}
+	sendNotification(notify.Message{
+		Topic: "log_alert",
+		Title: fmt.Sprintf("[%s] %s", strings.ToUpper(string(event.State)), event.Rule),
+		Text:  text,
+		Data:  event,
+	})
+}
+
+// loadLogAlertRules loads saved rules, seeding them from the config on first start.
+// It runs after the config is loaded, which happens after package init.
+func loadLogAlertRules() {
+	logAlertMutex.Lock()
+	defer logAlertMutex.Unlock()
+
+	rules, ok, err := logAlertStorage.LoadRules()
+	if err != nil {
+		log.Printf("Failed to load log alert rules: %v", err)
+		return
+	}
+	if !ok {
+		rules = config.Conf.LogAlertRules
+		if err := logAlertStorage.SaveRules(rules); err != nil {
+			log.Printf("Failed to save default log alert rules: %v", err)
+		}
+	}
+	if err := logAlertEngine.SetRules(rules); err != nil {
+		log.Printf("Invalid log alert rules: %v", err)
+		return
+	}
+	logAlertRules = rules
+}
+
+// logAlertRoutine follows the logs of services with alert rules and feeds new lines to the engine
+func logAlertRoutine() {
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		logAlertOnce.Do(loadLogAlertRules)
+
+		now := time.Now()
+		for _, target := range logAlertEngine.Targets() {
+			service, found := utils.FindServiceByName(target.Service)
+			if !found {
+				continue
+			}
+			entries := pollServiceLog(service, target)
+			if len(entries) > 0 {
+				logAlertEngine.Process(target, entries, now)
+			}
+		}
+		logAlertEngine.Evaluate(now)
+	}
+}
+
+// pollServiceLog returns the entries appended to a service log source since the last poll
+func pollServiceLog(service models.Service, target logs.AlertTarget) []logs.Entry {
+	follower, ok := logAlertFollowers[target]
+	if !ok {
+		source, err := logs.FindSource(service, target.Source)
+		if err != nil {
+			log.Printf("Failed to open log source for %s: %v", service.Name, err)
+			return nil
+		}
+		follower = source.NewFollower()
+		logAlertFollowers[target] = follower
+	}
+
+	lines, err := follower.Poll()
+	if err != nil {
+		log.Printf("Failed to follow log of %s: %v", service.Name, err)
+		return nil
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	parser, ok := logAlertParsers[target]
+	if !ok {
+		format := service.LogFormat
+		if source, err := logs.FindSource(service, target.Source); err == nil {
+			format = source.Definition().Format
+		}
+		parser, err = logs.ParserFor(format, lines)
+		if err != nil {
+			log.Printf("Invalid log format for %s: %v", service.Name, err)
+			parser = logs.Detect(lines)
+		}
+		logAlertParsers[target] = parser
+	}
+	return logs.ParseLines(parser, lines)
+}
+
+// GetLogAlertRulesHandler lists the log alert rules
+func GetLogAlertRulesHandler(c *gin.Context) {
+	logAlertOnce.Do(loadLogAlertRules)
+
+	logAlertMutex.Lock()
+	defer logAlertMutex.Unlock()
+	c.JSON(http.StatusOK, gin.H{"success": true, "rules": logAlertRules})
+}
+
+// SaveLogAlertRuleHandler creates a rule or replaces the rule with the same name
+func SaveLogAlertRuleHandler(c *gin.Context) {
+	var rule models.LogAlertRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid request parameters"})
+		return
+	}
+	if err := logs.ValidateAlertRule(rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	service, found := utils.FindServiceByName(rule.Service)
+	if !found {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Service not found"})
+		return
+	}
+	if _, err := logs.FindSource(service, rule.Source); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	logAlertOnce.Do(loadLogAlertRules)
+	logAlertMutex.Lock()
+	defer logAlertMutex.Unlock()
+
+	rules := make([]models.LogAlertRule, 0, len(logAlertRules)+1)
+	replaced := false
+	for _, existing := range logAlertRules {
+		if existing.Name == rule.Name {
+			rules = append(rules, rule)
+			replaced = true
+		} else {
+			rules = append(rules, existing)
+		}
+	}
+	if !replaced {
+		rules = append(rules, rule)
+	}
+
+	if err := applyLogAlertRules(rules); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "rule": rule})
+}
+
+// DeleteLogAlertRuleHandler removes a rule by name
+func DeleteLogAlertRuleHandler(c *gin.Context) {
+	name := c.Param("name")
+
+	logAlertOnce.Do(loadLogAlertRules)
+	logAlertMutex.Lock()
+	defer logAlertMutex.Unlock()
+
+	rules := make([]models.LogAlertRule, 0, len(logAlertRules))
+	for _, existing := range logAlertRules {
+		if existing.Name != name {
+			rules = append(rules, existing)
+		}
+	}
+	if len(rules) == len(logAlertRules) {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Rule not found"})
+		return
+	}
+
+	if err := applyLogAlertRules(rules); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// applyLogAlertRules saves and activates a rule set; logAlertMutex must be held
+func applyLogAlertRules(rules []models.LogAlertRule) error {
+	if err := logAlertEngine.SetRules(rules); err != nil {
+		return err
+	}
+	if err := logAlertStorage.SaveRules(rules); err != nil {
+		return err
+	}
+	logAlertRules = rules
+	return nil
+}
+
+// GetLogAlertStatusHandler returns the live firing/resolved state of every rule
+func GetLogAlertStatusHandler(c *gin.Context) {
+	logAlertOnce.Do(loadLogAlertRules)
+
+	statuses := logAlertEngine.Status()
+	firing := 0
+	for _, status := range statuses {
+		if status.State == models.LogAlertFiring {
+			firing++
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "firing": firing, "rules": statuses})
+}
+
+// GetLogAlertEventsHandler lists recorded alert events within a date range
+func GetLogAlertEventsHandler(c *gin.Context) {
+	startDateStr := c.DefaultQuery("startDate", time.Now().AddDate(0, 0, -7).Format("2006-01-02"))
+	endDateStr := c.DefaultQuery("endDate", time.Now().Format("2006-01-02"))
+
+	startDate, err := time.ParseInLocation("2006-01-02", startDateStr, time.Local)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid start date format"})
+		return
+	}
+
+	endDate, err := time.ParseInLocation("2006-01-02", endDateStr, time.Local)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid end date format"})
+		return
+	}
+
+	// Set end date to end of day
+	endDate = endDate.Add(23*time.Hour + 59*time.Minute + 59*time.Second)
+
+	events, err := logAlertStorage.GetEvents(startDate, endDate)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to retrieve events"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "totalRecords": len(events), "events": events})
+}
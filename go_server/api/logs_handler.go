@@ -0,0 +1,458 @@
+package api
+
+import (
+	"control/go_server/config"
+	"control/go_server/internal/logs"
+	"control/go_server/internal/models"
+	"control/go_server/internal/utils"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Global log rotator shared by the background routine and the manual rotate API
+var logRotator = logs.NewRotator()
+
+// Start log rotation routine
+func init() {
+	go logRotationRoutine()
+}
+
+// logRotationRoutine periodically rotates, compresses and prunes service logs
+func logRotationRoutine() {
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for _, service := range config.Conf.Services {
+			for _, logPath := range serviceLogFiles(service) {
+				if _, err := logRotator.Check(logPath, logs.PolicyFor(service)); err != nil {
+					log.Printf("Log rotation failed for %s (%s): %v", service.Name, logPath, err)
+				}
+			}
+		}
+	}
+}
+
+// serviceLogFiles returns the active files of a service's file log sources,
+// which are the logs this server rotates
+func serviceLogFiles(service models.Service) []string {
+	var paths []string
+	for _, def := range logs.SourcesFor(service) {
+		if def.Type == models.LogSourceFile {
+			paths = append(paths, def.Path)
+		}
+	}
+	return paths
+}
+
+// serviceLogSource opens the log source named by the source query parameter,
+// defaulting to the service's first source. It writes the error response itself.
+func serviceLogSource(c *gin.Context, service models.Service) (logs.Source, bool) {
+	source, err := logs.FindSource(service, c.Query("source"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return source, true
+}
+
+// sourceLocation describes where a log source reads from
+func sourceLocation(def models.LogSource) string {
+	switch def.Type {
+	case models.LogSourceJournald:
+		return "journald:" + def.Unit
+	case models.LogSourceContainer:
+		return "container:" + def.Container
+	}
+	return def.Path
+}
+
+// logFilterFromQuery builds a structured log filter from the request:
+// level=ERROR,WARN and repeated field=key:value parameters
+func logFilterFromQuery(c *gin.Context) logs.Filter {
+	var filter logs.Filter
+	if levels := c.Query("level"); levels != "" {
+		for _, level := range strings.Split(levels, ",") {
+			if level = strings.TrimSpace(level); level != "" {
+				filter.Levels = append(filter.Levels, level)
+			}
+		}
+	}
+	for _, field := range c.QueryArray("field") {
+		parts := strings.SplitN(field, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			continue
+		}
+		if filter.Fields == nil {
+			filter.Fields = make(map[string]string)
+		}
+		filter.Fields[parts[0]] = parts[1]
+	}
+	return filter
+}
+
+// serviceLogParser returns the parser for a log source, honouring an explicit
+// format query parameter, then the source's configured format, then detection
+func serviceLogParser(c *gin.Context, source logs.Source) (logs.Parser, error) {
+	format := c.DefaultQuery("format", source.Definition().Format)
+	var sample []string
+	if format == "" || format == "auto" {
+		sample, _ = source.Tail(50)
+	}
+	return logs.ParserFor(format, sample)
+}
+
+// LogsHandler gets the last lines of a service log, across rotated segments.
+// With structured=true (or any level/field filter) parsed entries are returned too.
+func LogsHandler(c *gin.Context) {
+	serviceName := c.Param("serviceName")
+	lines, err := strconv.Atoi(c.DefaultQuery("lines", "100"))
+	if err != nil || lines <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid lines parameter"})
+		return
+	}
+
+	service, found := utils.FindServiceByName(serviceName)
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Service not found"})
+		return
+	}
+
+	source, ok := serviceLogSource(c, service)
+	if !ok {
+		return
+	}
+	def := source.Definition()
+	logPath := sourceLocation(def)
+	logLines, err := source.Tail(lines)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"serviceName": serviceName, "source": def.Name, "logPath": logPath, "lines": []string{fmt.Sprintf("无法读取日志文件: %s", err.Error())}})
+		return
+	}
+
+	filter := logFilterFromQuery(c)
+	if c.Query("structured") != "true" && filter.Empty() {
+		c.JSON(http.StatusOK, gin.H{"serviceName": serviceName, "source": def.Name, "logPath": logPath, "totalLines": len(logLines), "lines": logLines})
+		return
+	}
+
+	parser, err := logs.ParserFor(c.DefaultQuery("format", def.Format), logLines)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var entries []logs.Entry
+	for _, entry := range logs.ParseLines(parser, logLines) {
+		if filter.Match(entry) {
+			entries = append(entries, entry)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"serviceName": serviceName,
+		"source":      def.Name,
+		"logPath":     logPath,
+		"format":      parser.Name(),
+		"totalLines":  len(logLines),
+		"lines":       logLines,
+		"entries":     entries,
+		"fields":      logs.FieldNames(entries),
+	})
+}
+
+// LogFormatsHandler lists the available log parsers and the format used by each service log source.
+func LogFormatsHandler(c *gin.Context) {
+	services := make(map[string]gin.H)
+	for _, service := range config.Conf.Services {
+		sources := make(map[string]gin.H)
+		for _, def := range logs.SourcesFor(service) {
+			configured := def.Format
+			if configured == "" {
+				configured = "auto"
+			}
+			source, err := logs.NewSource(def)
+			if err != nil {
+				sources[def.Name] = gin.H{"configured": configured, "error": err.Error()}
+				continue
+			}
+			sample, _ := source.Tail(50)
+			parser, err := logs.ParserFor(def.Format, sample)
+			if err != nil {
+				sources[def.Name] = gin.H{"configured": configured, "error": err.Error()}
+				continue
+			}
+			sources[def.Name] = gin.H{"configured": configured, "detected": parser.Name()}
+		}
+		services[service.Name] = gin.H{"sources": sources}
+	}
+	c.JSON(http.StatusOK, gin.H{"formats": logs.ParserNames(), "services": services})
+}
+
+// LogSearchHandler searches a service log, including rotated and compressed segments.
+func LogSearchHandler(c *gin.Context) {
+	serviceName := c.Param("serviceName")
+	keyword := c.Query("keyword")
+	filter := logFilterFromQuery(c)
+	if keyword == "" && filter.Empty() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Keyword or filter is required"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "500"))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit parameter"})
+		return
+	}
+
+	service, found := utils.FindServiceByName(serviceName)
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Service not found"})
+		return
+	}
+
+	source, ok := serviceLogSource(c, service)
+	if !ok {
+		return
+	}
+	opts := logs.SearchOptions{
+		Keyword:       keyword,
+		CaseSensitive: c.Query("caseSensitive") == "true",
+		Limit:         limit,
+		Filter:        filter,
+	}
+	if c.Query("structured") == "true" || !filter.Empty() {
+		if opts.Parser, err = serviceLogParser(c, source); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	matches, truncated, err := logs.Search(source, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search logs", "message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"serviceName":  serviceName,
+		"source":       source.Definition().Name,
+		"logPath":      sourceLocation(source.Definition()),
+		"keyword":      keyword,
+		"totalMatches": len(matches),
+		"truncated":    truncated,
+		"matches":      matches,
+	})
+}
+
+// LogDownloadHandler streams a service log source, with all rotated segments decompressed and in order.
+func LogDownloadHandler(c *gin.Context) {
+	serviceName := c.Param("serviceName")
+	service, found := utils.FindServiceByName(serviceName)
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Service not found"})
+		return
+	}
+
+	source, ok := serviceLogSource(c, service)
+	if !ok {
+		return
+	}
+	def := source.Definition()
+	if info := logs.Describe(def); !info.Available {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Log file not found"})
+		return
+	}
+
+	filename := fmt.Sprintf("%s_%s_%s.log", serviceName, def.Name, time.Now().Format("20060102-150405"))
+	c.Header("Content-Description", "File Transfer")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+	c.Header("Content-Type", "text/plain; charset=utf-8")
+	c.Status(http.StatusOK)
+
+	if err := logs.WriteAll(source, c.Writer); err != nil {
+		log.Printf("Failed to stream logs of %s: %v", serviceName, err)
+	}
+}
+
+// LogUsageHandler reports log disk usage of every file log source and the rotation policy per service.
+func LogUsageHandler(c *gin.Context) {
+	usages := make(map[string]gin.H)
+	var total int64
+
+	for _, service := range config.Conf.Services {
+		files := make(map[string]gin.H)
+		var serviceTotal int64
+		for _, logPath := range serviceLogFiles(service) {
+			usage, err := logs.Usage(logPath)
+			if err != nil {
+				files[logPath] = gin.H{"error": err.Error()}
+				continue
+			}
+			serviceTotal += usage.TotalBytes
+			files[logPath] = gin.H{"usage": usage}
+		}
+		total += serviceTotal
+		usages[service.Name] = gin.H{
+			"files":      files,
+			"totalBytes": serviceTotal,
+			"policy":     logs.PolicyFor(service),
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"services": usages, "totalBytes": total})
+}
+
+// LogRotateHandler rotates the file log sources of a service immediately, or only the one named by source.
+func LogRotateHandler(c *gin.Context) {
+	serviceName := c.Param("serviceName")
+	service, found := utils.FindServiceByName(serviceName)
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Service not found"})
+		return
+	}
+
+	var paths []string
+	for _, def := range logs.SourcesFor(service) {
+		if def.Type == models.LogSourceFile && (c.Query("source") == "" || c.Query("source") == def.Name) {
+			paths = append(paths, def.Path)
+		}
+	}
+	if len(paths) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "No rotatable log file source"})
+		return
+	}
+
+	for _, logPath := range paths {
+		if err := logRotator.Rotate(logPath, logs.PolicyFor(service)); err != nil {
+			c.JSON(http.StatusOK, gin.H{"success": false, "message": "Failed to rotate log", "error": err.Error()})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Log rotated successfully"})
+}
+
+// LogSourcesHandler lists the log sources of a service and whether each is readable.
+func LogSourcesHandler(c *gin.Context) {
+	serviceName := c.Param("serviceName")
+	service, found := utils.FindServiceByName(serviceName)
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Service not found"})
+		return
+	}
+
+	var sources []logs.SourceInfo
+	for _, def := range logs.SourcesFor(service) {
+		sources = append(sources, logs.Describe(def))
+	}
+	c.JSON(http.StatusOK, gin.H{"serviceName": serviceName, "sources": sources})
+}
+
+// parseLogTime parses an export boundary given as RFC3339 or local "2006-01-02 15:04:05"
+func parseLogTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", value)
+}
+
+// LogExportHandler streams the logs of one or more services between two
+// timestamps as a tar.gz or zip archive, chosen by archive, with a
+// manifest.json. services lists service names, or service:source to export a
+// single source of a service.
+func LogExportHandler(c *gin.Context) {
+	var items []logs.ExportItem
+	for _, name := range strings.Split(c.Query("services"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		serviceName, sourceName, _ := strings.Cut(name, ":")
+		service, found := utils.FindServiceByName(serviceName)
+		if !found {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Service %s not found", serviceName)})
+			return
+		}
+		matched := 0
+		for _, def := range logs.SourcesFor(service) {
+			if sourceName != "" && def.Name != sourceName {
+				continue
+			}
+			matched++
+			source, err := logs.NewSource(def)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			items = append(items, logs.ExportItem{Service: service.Name, Source: source})
+		}
+		if matched == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Log source %s not found for service %s", sourceName, serviceName)})
+			return
+		}
+	}
+	if len(items) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one service is required"})
+		return
+	}
+
+	opts := logs.ExportOptions{
+		End:     time.Now(),
+		Keyword: c.Query("keyword"),
+		Levels:  logFilterFromQuery(c).Levels,
+		Archive: c.DefaultQuery("archive", logs.ExportTarGz),
+	}
+	if c.Query("start") == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Start time is required"})
+		return
+	}
+	var err error
+	if opts.Start, err = parseLogTime(c.Query("start")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if end := c.Query("end"); end != "" {
+		if opts.End, err = parseLogTime(end); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if !opts.End.After(opts.Start) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "End time must be after start time"})
+		return
+	}
+
+	var contentType string
+	switch opts.Archive {
+	case logs.ExportTarGz:
+		contentType = "application/gzip"
+	case logs.ExportZip:
+		contentType = "application/zip"
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Archive must be tar.gz or zip"})
+		return
+	}
+
+	filename := fmt.Sprintf("logs_%s_%s.%s", opts.Start.Format("20060102-150405"), opts.End.Format("20060102-150405"), opts.Archive)
+	c.Header("Content-Description", "File Transfer")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+	c.Header("Content-Type", contentType)
+	c.Status(http.StatusOK)
+
+	manifest, err := logs.Export(c.Writer, items, opts)
+	if err != nil {
+		log.Printf("Failed to export logs: %v", err)
+		return
+	}
+	log.Printf("Exported %d log lines from %d sources", manifest.TotalLines, len(manifest.Sources))
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"control/go_server/config"
+	"control/go_server/internal/metrics"
+	"crypto/subtle"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/host"
+	"github.com/shirou/gopsutil/v3/load"
+	"github.com/shirou/gopsutil/v3/mem"
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+// Service metrics, updated by the metrics collection routine
+var (
+	serviceUpGauge         = metrics.NewGaugeVec("control_service_up", "Whether the service has running processes.", "service")
+	serviceProcessesGauge  = metrics.NewGaugeVec("control_service_processes", "Number of running processes of the service.", "service")
+	serviceCPUGauge        = metrics.NewGaugeVec("control_service_cpu_percent", "CPU usage of the service processes in percent of one core.", "service")
+	serviceMemoryGauge     = metrics.NewGaugeVec("control_service_memory_bytes", "Resident memory of the service processes.", "service")
+	serviceGoroutinesGauge = metrics.NewGaugeVec("control_service_goroutines", "Goroutines reported by the service's pprof endpoint.", "service")
+	serviceHeapInuseGauge  = metrics.NewGaugeVec("control_service_heap_inuse_bytes", "Heap in use reported by the service's Go runtime.", "service")
+	serviceGCPauseGauge    = metrics.NewGaugeVec("control_service_gc_pause_seconds", "Quantiles of the service's recent GC pauses.", "service", "quantile")
+
+	serviceOpenFDsGauge        = metrics.NewGaugeVec("control_service_open_fds", "Open file descriptors of the service processes.", "service")
+	serviceThreadsGauge        = metrics.NewGaugeVec("control_service_threads", "Threads of the service processes.", "service")
+	serviceTCPConnectionsGauge = metrics.NewGaugeVec("control_service_tcp_connections", "TCP connections of the service processes by state.", "service", "state")
+)
+
+// Host metrics, read on every scrape
+var (
+	hostCPUUsageGauge    = metrics.NewGaugeVec("control_host_cpu_usage_percent", "Host CPU usage.")
+	hostCPUCoresGauge    = metrics.NewGaugeVec("control_host_cpu_cores", "Number of host CPU cores.")
+	hostMemoryGauge      = metrics.NewGaugeVec("control_host_memory_bytes", "Host memory by state.", "state")
+	hostDiskGauge        = metrics.NewGaugeVec("control_host_disk_bytes", "Disk space of the root filesystem by state.", "mountpoint", "state")
+	hostLoadGauge        = metrics.NewGaugeVec("control_host_load_average", "Host load average.", "period")
+	hostNetworkGauge     = metrics.NewGaugeVec("control_host_network_bytes", "Bytes received and sent by the host since boot.", "direction")
+	hostUptimeGauge      = metrics.NewGaugeVec("control_host_uptime_seconds", "Host uptime.")
+	metricsScrapeCounter = metrics.NewCounterVec("control_metrics_scrapes_total", "Scrapes of the /metrics endpoint.")
+)
+
+// Proxy and auto-replace metrics, updated by the auto-replace worker
+var (
+	proxyCheckGauge        = metrics.NewGaugeVec("control_proxy_last_check", "Proxies found in the last availability check by result.", "result")
+	proxyCheckTimeGauge    = metrics.NewGaugeVec("control_proxy_last_check_timestamp_seconds", "Unix time of the last proxy availability check.")
+	autoReplaceRunsCounter = metrics.NewCounterVec("control_auto_replace_runs_total", "Auto-replace runs by outcome.", "outcome")
+	proxyReplaceCounter    = metrics.NewCounterVec("control_proxy_replacements_total", "Proxy replacements by trigger and result.", "trigger", "result")
+)
+
+// Deployment metrics, updated by the CI/CD handler
+var (
+	deploymentsCounter       = metrics.NewCounterVec("control_deployments_total", "Finished deployments by service, environment and result.", "service", "environment", "result")
+	deploymentDurationMetric = metrics.NewHistogramVec("control_deployment_duration_seconds", "Duration of finished deployments.", []float64{10, 30, 60, 120, 300, 600, 1200}, "environment")
+)
+
+// Control plane HTTP metrics
+var (
+	httpRequestsCounter  = metrics.NewCounterVec("control_http_requests_total", "HTTP requests handled by the control plane.", "method", "route", "status")
+	httpDurationMetric   = metrics.NewHistogramVec("control_http_request_duration_seconds", "Latency of HTTP requests handled by the control plane.", nil, "method", "route")
+	httpInFlightGauge    = metrics.NewGaugeVec("control_http_requests_in_flight", "HTTP requests currently being served.")
+	httpInFlightRequests atomic.Int64
+)
+
+func init() {
+	metrics.Default.OnCollect(collectHostMetrics)
+}
+
+// collectHostMetrics refreshes the host gauges with the values shown by SystemInfoHandler
+func collectHostMetrics() {
+	if usage, err := cpu.Percent(0, false); err == nil && len(usage) > 0 {
+		hostCPUUsageGauge.Set(usage[0])
+	}
+	if cores, err := cpu.Counts(true); err == nil {
+		hostCPUCoresGauge.Set(float64(cores))
+	}
+	if memInfo, err := mem.VirtualMemory(); err == nil {
+		hostMemoryGauge.Set(float64(memInfo.Total), "total")
+		hostMemoryGauge.Set(float64(memInfo.Used), "used")
+		hostMemoryGauge.Set(float64(memInfo.Free), "free")
+		hostMemoryGauge.Set(float64(memInfo.Available), "available")
+	}
+	if diskInfo, err := disk.Usage("/"); err == nil {
+		hostDiskGauge.Set(float64(diskInfo.Total), "/", "total")
+		hostDiskGauge.Set(float64(diskInfo.Used), "/", "used")
+		hostDiskGauge.Set(float64(diskInfo.Free), "/", "free")
+	}
+	if loadAvg, err := load.Avg(); err == nil {
+		hostLoadGauge.Set(loadAvg.Load1, "1m")
+		hostLoadGauge.Set(loadAvg.Load5, "5m")
+		hostLoadGauge.Set(loadAvg.Load15, "15m")
+	}
+	if netIO, err := net.IOCounters(false); err == nil && len(netIO) > 0 {
+		hostNetworkGauge.Set(float64(netIO[0].BytesRecv), "receive")
+		hostNetworkGauge.Set(float64(netIO[0].BytesSent), "transmit")
+	}
+	if uptime, err := host.Uptime(); err == nil {
+		hostUptimeGauge.Set(float64(uptime))
+	}
+}
+
+// PrometheusMetricsHandler serves all metrics in the Prometheus text format.
+// When a metrics token is configured, scrapers must send it as a bearer token;
+// without one, only scrapers on the same host are served.
+func PrometheusMetricsHandler(c *gin.Context) {
+	if token := config.Conf.MetricsToken; token != "" {
+		sent := []byte(c.GetHeader("Authorization"))
+		if subtle.ConstantTimeCompare(sent, []byte("Bearer "+token)) != 1 {
+			c.String(http.StatusUnauthorized, "unauthorized\n")
+			return
+		}
+	} else if addr, err := netip.ParseAddr(c.RemoteIP()); err != nil || !addr.IsLoopback() {
+		// The peer's own address, as forwarding headers can be forged
+		c.String(http.StatusForbidden, "metrics token required for remote scrapers\n")
+		return
+	}
+
+	metricsScrapeCounter.Inc()
+	c.Header("Content-Type", metrics.ContentType)
+	c.Status(http.StatusOK)
+	metrics.Default.WriteText(c.Writer)
+}
+
+// MetricsMiddleware records request counts and latencies per route template,
+// so /api/logs/:serviceName is one series however many services exist
+func MetricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		httpInFlightGauge.Set(float64(httpInFlightRequests.Add(1)))
+		defer func() { httpInFlightGauge.Set(float64(httpInFlightRequests.Add(-1))) }()
+
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			// unmatched paths are static files or 404s; don't create a series per URL
+			route = "unmatched"
+			if !strings.HasPrefix(c.Request.URL.Path, "/api") {
+				route = "static"
+			}
+		}
+		status := strconv.Itoa(c.Writer.Status())
+		httpRequestsCounter.Inc(c.Request.Method, route, status)
+		httpDurationMetric.Observe(time.Since(start).Seconds(), c.Request.Method, route)
+	}
+}
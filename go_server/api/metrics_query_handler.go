@@ -0,0 +1,282 @@
+package api
+
+import (
+	"control/go_server/internal/tsdb"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxQueryPoints caps the steps per series of a query
+	maxQueryPoints = 11000
+	// defaultQueryPoints is the number of steps when no step is given
+	defaultQueryPoints = 240
+	// minQueryStep is the collection interval; finer steps would only add gaps
+	minQueryStep = 10 * time.Second
+)
+
+// metricsQuery is a parsed query or top request
+type metricsQuery struct {
+	metrics    []string
+	services   []string // glob patterns matched against the service label
+	match      tsdb.Labels
+	start, end time.Time
+	step       time.Duration
+	agg        tsdb.Aggregation
+}
+
+// parseQueryTime parses unix seconds, unix milliseconds or RFC 3339
+func parseQueryTime(s string) (time.Time, error) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if n > 1e12 {
+			return time.UnixMilli(n), nil
+		}
+		return time.Unix(n, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// parseQueryStep parses a duration such as 30s or 5m, or a number of seconds
+func parseQueryStep(s string) (time.Duration, error) {
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(n * float64(time.Second)), nil
+	}
+	return time.ParseDuration(s)
+}
+
+// parseMetricsQuery reads the metric, service, label, start, end (or
+// duration in minutes, default 60), step and agg parameters, replying with
+// 400 when they are invalid
+func parseMetricsQuery(c *gin.Context) (metricsQuery, bool) {
+	fail := func(format string, args ...any) (metricsQuery, bool) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf(format, args...)})
+		return metricsQuery{}, false
+	}
+
+	q := metricsQuery{
+		metrics:  c.QueryArray("metric"),
+		services: c.QueryArray("service"),
+		match:    metricLabelsFromQuery(c),
+		end:      time.Now(),
+	}
+	if len(q.metrics) == 0 {
+		return fail("At least one metric is required")
+	}
+	for _, pattern := range q.services {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fail("Invalid service pattern %q", pattern)
+		}
+	}
+
+	var err error
+	if s := c.Query("end"); s != "" {
+		if q.end, err = parseQueryTime(s); err != nil {
+			return fail("Invalid end parameter")
+		}
+	}
+	if s := c.Query("start"); s != "" {
+		if q.start, err = parseQueryTime(s); err != nil {
+			return fail("Invalid start parameter")
+		}
+	} else {
+		minutes, err := strconv.Atoi(c.DefaultQuery("duration", "60"))
+		if err != nil || minutes <= 0 {
+			return fail("Invalid duration parameter")
+		}
+		q.start = q.end.Add(-time.Duration(minutes) * time.Minute)
+	}
+	if !q.start.Before(q.end) {
+		return fail("start must be before end")
+	}
+
+	if q.agg, err = tsdb.ParseAggregation(c.DefaultQuery("agg", "avg")); err != nil {
+		return fail("%v", err)
+	}
+
+	span := q.end.Sub(q.start)
+	if s := c.Query("step"); s != "" {
+		if q.step, err = parseQueryStep(s); err != nil || q.step <= 0 {
+			return fail("Invalid step parameter")
+		}
+	} else {
+		q.step = (span / defaultQueryPoints).Round(time.Second)
+	}
+	// Steps can't be finer than the data kept for the span
+	q.step = max(q.step, minQueryStep, time.Duration(tsdb.ResolutionFor(span)))
+	if span/q.step > maxQueryPoints {
+		return fail("Too many points: use a step of at least %s", span/maxQueryPoints)
+	}
+	return q, true
+}
+
+// selectService reports whether a series is picked by the service patterns.
+// Without patterns every series is; with them only series of a matching service.
+func (q metricsQuery) selectService(labels tsdb.Labels) bool {
+	if len(q.services) == 0 {
+		return true
+	}
+	service, ok := labels["service"]
+	if !ok {
+		return false
+	}
+	for _, pattern := range q.services {
+		if matched, _ := path.Match(pattern, service); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// selectSeries reads every selected series of the query's metrics from the
+// start of the first step, so rollups beginning before start are included
+func (q metricsQuery) selectSeries() ([]tsdb.Series, tsdb.Resolution, error) {
+	resolution := tsdb.SourceResolution(q.end.Sub(q.start), q.step, q.agg)
+	start := q.start
+	if q.step > 0 {
+		start = start.Truncate(q.step)
+	}
+	var result []tsdb.Series
+	for _, metric := range q.metrics {
+		seriesList, err := metricsDB.Select(metric, q.match, resolution, start, q.end)
+		if err != nil {
+			return nil, resolution, err
+		}
+		for _, series := range seriesList {
+			if q.selectService(series.Labels) {
+				result = append(result, series)
+			}
+		}
+	}
+	return result, resolution, nil
+}
+
+// MetricsQueryHandler returns series aggregated into aligned steps
+// @Summary Query metrics
+// @Description Every series shares the timestamps array; steps without samples are null. Steps are multiples of step since the epoch.
+// @Tags Metrics
+// @Param metric query []string true "Metric names"
+// @Param service query []string false "Service name patterns, e.g. api-*"
+// @Param label query []string false "Label matchers as key:value"
+// @Param start query string false "Start as unix time or RFC 3339"
+// @Param end query string false "End as unix time or RFC 3339, default now"
+// @Param duration query int false "Minutes before end when start is not given" default(60)
+// @Param step query string false "Step such as 30s or 5m, default 1/240 of the range"
+// @Param agg query string false "avg, min, max, p95 or rate" default(avg)
+// @Success 200 {object} gin.H
+// @Router /api/metrics/query [get]
+func MetricsQueryHandler(c *gin.Context) {
+	q, ok := parseMetricsQuery(c)
+	if !ok {
+		return
+	}
+	seriesList, resolution, err := q.selectSeries()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read metrics", "message": err.Error()})
+		return
+	}
+
+	times := tsdb.StepTimes(q.start, q.end, q.step)
+	timestamps := make([]int64, len(times))
+	for i, t := range times {
+		timestamps[i] = t.UnixMilli()
+	}
+	series := make([]gin.H, 0, len(seriesList))
+	for _, s := range seriesList {
+		series = append(series, gin.H{
+			"metric": s.Name,
+			"labels": s.Labels,
+			"values": tsdb.Align(s.Samples, q.start, q.end, q.step, q.agg),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"start":       q.start.UnixMilli(),
+		"end":         q.end.UnixMilli(),
+		"step":        q.step.Seconds(),
+		"aggregation": q.agg,
+		"resolution":  resolution.String(),
+		"timestamps":  timestamps,
+		"series":      series,
+	})
+}
+
+// MetricsTopHandler ranks the values of a label, services by default, by a
+// metric aggregated over the whole range. Series with the same label value,
+// such as the TCP states of one service, are summed.
+// @Summary Top N by metric
+// @Tags Metrics
+// @Param metric query string true "Metric name"
+// @Param by query string false "Label to group by" default(service)
+// @Param limit query int false "Number of results" default(10)
+// @Param order query string false "desc or asc" default(desc)
+// @Param agg query string false "avg, min, max, p95 or rate" default(avg)
+// @Success 200 {object} gin.H
+// @Router /api/metrics/top [get]
+func MetricsTopHandler(c *gin.Context) {
+	q, ok := parseMetricsQuery(c)
+	if !ok {
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit parameter"})
+		return
+	}
+	order := c.DefaultQuery("order", "desc")
+	if order != "desc" && order != "asc" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "order must be desc or asc"})
+		return
+	}
+	by := c.DefaultQuery("by", "service")
+
+	// Read the finest resolution kept for the range
+	q.metrics = q.metrics[:1]
+	q.step = time.Duration(tsdb.ResolutionFor(q.end.Sub(q.start)))
+	seriesList, resolution, err := q.selectSeries()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read metrics", "message": err.Error()})
+		return
+	}
+
+	totals := make(map[string]float64)
+	for _, s := range seriesList {
+		group, ok := s.Labels[by]
+		if !ok {
+			continue
+		}
+		if v, ok := tsdb.Reduce(s.Samples, q.agg); ok {
+			totals[group] += v
+		}
+	}
+
+	top := make([]gin.H, 0, len(totals))
+	for group, value := range totals {
+		top = append(top, gin.H{by: group, "value": value})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		vi, vj := top[i]["value"].(float64), top[j]["value"].(float64)
+		if vi == vj {
+			return top[i][by].(string) < top[j][by].(string)
+		}
+		return (vi > vj) == (order == "desc")
+	})
+	if len(top) > limit {
+		top = top[:limit]
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"metric":      q.metrics[0],
+		"by":          by,
+		"aggregation": q.agg,
+		"resolution":  resolution.String(),
+		"start":       q.start.UnixMilli(),
+		"end":         q.end.UnixMilli(),
+		"top":         top,
+	})
+}
@@ -0,0 +1,259 @@
+package api
+
+import (
+	"control/go_server/internal/models"
+	"control/go_server/internal/notify"
+	"control/go_server/internal/storage"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// maskedSetting replaces secret channel settings in responses. Sending it back
+// on update keeps the stored value.
+const maskedSetting = "******"
+
+// notifier sends notifications to the configured channels, set by NewNotificationHandler
+var notifier *notify.Notifier
+
+// sendNotification sends msg to the subscribed channels in the background and
+// returns how many channels it went to
+func sendNotification(msg notify.Message) int {
+	if notifier == nil {
+		return 0
+	}
+	return notifier.Notify(msg)
+}
+
+type NotificationHandler struct {
+	store *storage.NotificationStore
+}
+
+// NewNotificationHandler loads the channels and makes them available to sendNotification
+func NewNotificationHandler(store *storage.NotificationStore) *NotificationHandler {
+	h := &NotificationHandler{store: store}
+	notifier = notify.NewNotifier(store)
+	if err := h.reloadChannels(); err != nil {
+		log.Printf("Failed to load notification channels: %v", err)
+	}
+
+	// Remove delivery records older than 30 days
+	go func() {
+		ticker := time.NewTicker(24 * time.Hour)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := store.CleanupOldDeliveries(30); err != nil {
+				log.Printf("Failed to remove old notification deliveries: %v", err)
+			}
+		}
+	}()
+	return h
+}
+
+func (h *NotificationHandler) reloadChannels() error {
+	channels, err := h.store.GetChannels()
+	if err != nil {
+		return err
+	}
+	notifier.SetChannels(channels)
+	return nil
+}
+
+// maskChannel hides the secret settings of a channel
+func maskChannel(channel models.NotificationChannel) models.NotificationChannel {
+	settings := make(map[string]string, len(channel.Settings))
+	for k, v := range channel.Settings {
+		settings[k] = v
+	}
+	for _, name := range notify.SecretSettings[channel.Type] {
+		if settings[name] != "" {
+			settings[name] = maskedSetting
+		}
+	}
+	channel.Settings = settings
+	return channel
+}
+
+// GetChannels godoc
+// @Summary Get notification channels
+// @Tags Notifications
+// @Success 200 {object} gin.H
+// @Router /api/notifications/channels [get]
+func (h *NotificationHandler) GetChannels(c *gin.Context) {
+	channels, err := h.store.GetChannels()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for i := range channels {
+		channels[i] = maskChannel(channels[i])
+	}
+	c.JSON(http.StatusOK, gin.H{"channels": channels, "drivers": notify.DriverSettings})
+}
+
+// CreateChannel godoc
+// @Summary Create a notification channel
+// @Tags Notifications
+// @Param request body models.NotificationChannel true "Channel"
+// @Success 200 {object} gin.H
+// @Router /api/notifications/channels [post]
+func (h *NotificationHandler) CreateChannel(c *gin.Context) {
+	var channel models.NotificationChannel
+	if err := c.ShouldBindJSON(&channel); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := notify.ValidateChannel(channel); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	channel.ID = 0
+	if err := h.store.CreateChannel(&channel); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.applyChannels(c, channel)
+}
+
+// UpdateChannel godoc
+// @Summary Update a notification channel
+// @Description Secret settings sent back masked keep their stored value
+// @Tags Notifications
+// @Param id path int true "Channel ID"
+// @Param request body models.NotificationChannel true "Channel"
+// @Success 200 {object} gin.H
+// @Router /api/notifications/channels/{id} [put]
+func (h *NotificationHandler) UpdateChannel(c *gin.Context) {
+	existing, ok := h.findChannel(c)
+	if !ok {
+		return
+	}
+
+	var channel models.NotificationChannel
+	if err := c.ShouldBindJSON(&channel); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for _, name := range notify.SecretSettings[channel.Type] {
+		if channel.Settings[name] == maskedSetting {
+			channel.Settings[name] = existing.Settings[name]
+		}
+	}
+	if err := notify.ValidateChannel(channel); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	channel.ID = existing.ID
+	channel.CreatedAt = existing.CreatedAt
+	if err := h.store.UpdateChannel(&channel); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.applyChannels(c, channel)
+}
+
+// DeleteChannel godoc
+// @Summary Delete a notification channel
+// @Tags Notifications
+// @Param id path int true "Channel ID"
+// @Success 200 {object} gin.H
+// @Router /api/notifications/channels/{id} [delete]
+func (h *NotificationHandler) DeleteChannel(c *gin.Context) {
+	channel, ok := h.findChannel(c)
+	if !ok {
+		return
+	}
+	if err := h.store.DeleteChannel(channel.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.applyChannels(c, *channel)
+}
+
+// TestChannel godoc
+// @Summary Send a test message through a channel
+// @Description Sends synchronously, ignoring the enabled flag and topics, and returns the delivery record
+// @Tags Notifications
+// @Param id path int true "Channel ID"
+// @Success 200 {object} gin.H
+// @Router /api/notifications/channels/{id}/test [post]
+func (h *NotificationHandler) TestChannel(c *gin.Context) {
+	channel, ok := h.findChannel(c)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	delivery := notifier.Send(*channel, notify.Message{
+		Topic: "test",
+		Title: "Test notification",
+		Text:  "This is a test message from the control panel for channel " + channel.Name + ", sent at " + now.Format("2006-01-02 15:04:05") + ".",
+		Data:  gin.H{"channel": channel.Name, "user": sessionUser(c)},
+		Time:  now,
+	})
+	status := http.StatusOK
+	if delivery.Status != models.DeliverySent {
+		status = http.StatusBadGateway
+	}
+	c.JSON(status, gin.H{"delivery": delivery})
+}
+
+// GetDeliveries godoc
+// @Summary Get notification delivery records
+// @Tags Notifications
+// @Param channelId query int false "Channel ID"
+// @Param status query string false "sent, failed, rate_limited or pending"
+// @Param limit query int false "Limit results" default(100)
+// @Success 200 {object} gin.H
+// @Router /api/notifications/deliveries [get]
+func (h *NotificationHandler) GetDeliveries(c *gin.Context) {
+	channelID, _ := strconv.ParseInt(c.Query("channelId"), 10, 64)
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit parameter"})
+		return
+	}
+
+	deliveries, err := h.store.GetDeliveries(channelID, models.DeliveryStatus(c.Query("status")), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deliveries": deliveries})
+}
+
+// findChannel loads the channel of the :id parameter, replying with an error if there is none
+func (h *NotificationHandler) findChannel(c *gin.Context) (*models.NotificationChannel, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel ID"})
+		return nil, false
+	}
+	channel, err := h.store.GetChannel(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Channel not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return channel, true
+}
+
+// applyChannels reloads the notifier after a channel change and replies with the channel
+func (h *NotificationHandler) applyChannels(c *gin.Context, channel models.NotificationChannel) {
+	if err := h.reloadChannels(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"channel": maskChannel(channel)})
+}
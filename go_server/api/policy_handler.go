@@ -0,0 +1,503 @@
+package api
+
+import (
+	"control/go_server/config"
+	"control/go_server/internal/models"
+	"control/go_server/internal/notify"
+	"control/go_server/internal/policy"
+	"control/go_server/internal/storage"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Sources of the commands the policy decides on
+const (
+	commandSourceExecute     = "execute"     // /terminal/execute
+	commandSourceInteractive = "interactive" // the shell of a /terminal/ws session
+)
+
+// commandDecisionRetentionDays is how long decisions and settled approvals are kept
+const commandDecisionRetentionDays = 180
+
+// commandPolicy decides on the terminal's commands, set up by SetupRouter
+var commandPolicy *PolicyHandler
+
+// PolicyHandler decides on terminal commands with the command rules, holds
+// the commands that need approval and records every decision
+type PolicyHandler struct {
+	store   *storage.PolicyStore
+	timeout time.Duration
+
+	mutex  sync.RWMutex
+	policy *policy.Policy
+}
+
+// NewPolicyHandler seeds the rules of the configuration into an empty rules
+// table, loads them and starts expiring held commands
+func NewPolicyHandler(store *storage.PolicyStore, conf config.TerminalConfig) *PolicyHandler {
+	h := &PolicyHandler{store: store, timeout: conf.ApprovalTimeout, policy: &policy.Policy{}}
+
+	if count, err := store.CountRules(); err != nil {
+		log.Printf("Failed to count command rules: %v", err)
+	} else if count == 0 {
+		for _, rule := range config.Conf.CommandRules {
+			if err := store.CreateRule(&rule); err != nil {
+				log.Printf("Failed to seed command rule %s: %v", rule.Name, err)
+			}
+		}
+	}
+	if err := h.reloadRules(); err != nil {
+		log.Printf("Failed to load command rules: %v", err)
+	}
+	go h.expirationRoutine()
+	return h
+}
+
+// expirationRoutine expires held commands every minute and removes old decisions daily
+func (h *PolicyHandler) expirationRoutine() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	lastCleanup := time.Now()
+	for now := range ticker.C {
+		if err := h.store.ExpireApprovals(now); err != nil {
+			log.Printf("Failed to expire held commands: %v", err)
+		}
+		if time.Since(lastCleanup) >= 24*time.Hour {
+			if err := h.store.CleanupOldDecisions(commandDecisionRetentionDays); err != nil {
+				log.Printf("Failed to remove old command decisions: %v", err)
+			}
+			lastCleanup = time.Now()
+		}
+	}
+}
+
+func (h *PolicyHandler) reloadRules() error {
+	rules, err := h.store.GetRules()
+	if err != nil {
+		return err
+	}
+	compiled, err := policy.Compile(rules)
+	if err != nil {
+		return err
+	}
+	h.mutex.Lock()
+	h.policy = compiled
+	h.mutex.Unlock()
+	return nil
+}
+
+func (h *PolicyHandler) evaluate(role, command, dir string) policy.Decision {
+	homeDir, _ := os.UserHomeDir()
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.policy.Decide(role, command, policy.Env{Dir: dir, Home: homeDir})
+}
+
+// authorize decides whether user may run command in dir now and records the
+// decision. A command that needs approval is held, and the pending approval
+// returned, unless approvalID is an approval of the same command by another
+// operator: that approval is used up and the command allowed. Interactive
+// sessions can't wait, so they are denied what needs approval.
+func (h *PolicyHandler) authorize(user, sessionID, source, command, dir string, approvalID int64) (policy.Decision, *models.CommandApproval) {
+	role := userRole(user)
+	decision := h.evaluate(role, command, dir)
+	record := models.CommandDecision{
+		User:      user,
+		Role:      role,
+		SessionID: sessionID,
+		Source:    source,
+		Command:   command,
+		Dir:       dir,
+	}
+
+	var approval *models.CommandApproval
+	if decision.Action == models.PolicyApprove {
+		var err error
+		if approvalID > 0 {
+			approval, err = h.redeem(user, command, dir, approvalID)
+			if err == nil {
+				decision.Action = models.PolicyAllow
+				decision.Reason = fmt.Sprintf("Approved by %s", approval.Approver)
+			}
+		} else if source == commandSourceInteractive {
+			err = errors.New("an interactive session can't wait for approval")
+		} else {
+			approval, err = h.hold(record, decision)
+		}
+		if err != nil {
+			decision.Action = models.PolicyDeny
+			decision.Reason = err.Error()
+			approval = nil
+		}
+		if approval != nil {
+			record.ApprovalID = approval.ID
+		}
+	}
+
+	record.Action = decision.Action
+	record.Rule = decision.Rule
+	record.Reason = decision.Reason
+	if err := h.store.CreateDecision(&record); err != nil {
+		log.Printf("Failed to record command decision: %v", err)
+	}
+	if decision.Action != models.PolicyAllow {
+		log.Printf("Command of %s (%s) %s by rule %q: %s", user, role, decision.Action, decision.Rule, command)
+	}
+	return decision, approval
+}
+
+// hold holds a command for approval and asks the other operators for it
+func (h *PolicyHandler) hold(record models.CommandDecision, decision policy.Decision) (*models.CommandApproval, error) {
+	approval := &models.CommandApproval{
+		User:      record.User,
+		Role:      record.Role,
+		SessionID: record.SessionID,
+		Command:   record.Command,
+		Dir:       record.Dir,
+		Rule:      decision.Rule,
+		Reason:    decision.Reason,
+		Status:    models.ApprovalPending,
+		ExpiresAt: time.Now().Add(h.timeout),
+	}
+	if err := h.store.CreateApproval(approval); err != nil {
+		log.Printf("Failed to hold command for approval: %v", err)
+		return nil, fmt.Errorf("command requires approval but can't be held: %w", err)
+	}
+	h.announce(approval)
+	return approval, nil
+}
+
+// redeem uses up the approval of a command, which must be approved, unused,
+// not expired and for the same user, command and directory
+func (h *PolicyHandler) redeem(user, command, dir string, approvalID int64) (*models.CommandApproval, error) {
+	approval, err := h.store.GetApproval(approvalID)
+	if err != nil {
+		return nil, fmt.Errorf("approval #%d not found", approvalID)
+	}
+	if approval.User != user || approval.Command != command || approval.Dir != dir {
+		return nil, fmt.Errorf("approval #%d is for another command", approvalID)
+	}
+	if approval.Status != models.ApprovalApproved {
+		return nil, fmt.Errorf("approval #%d is %s", approvalID, approval.Status)
+	}
+	if time.Now().After(approval.ExpiresAt) {
+		return nil, fmt.Errorf("approval #%d expired", approvalID)
+	}
+	ok, err := h.store.ConsumeApproval(approvalID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to use approval #%d: %w", approvalID, err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("approval #%d was already used", approvalID)
+	}
+	approval.Status = models.ApprovalExecuted
+	return approval, nil
+}
+
+// announce publishes a change of a held command and notifies the channels
+// subscribed to approvals
+func (h *PolicyHandler) announce(approval *models.CommandApproval) {
+	liveHub.Publish(liveTopicApprovals, approval)
+
+	var title, text string
+	switch approval.Status {
+	case models.ApprovalPending:
+		title = fmt.Sprintf("Command of %s needs approval", approval.User)
+		text = fmt.Sprintf("%s\n\nDirectory: %s\nRule: %s (%s)\nApprove by: %s",
+			approval.Command, approval.Dir, approval.Rule, approval.Reason, approval.ExpiresAt.Format("2006-01-02 15:04:05"))
+	default:
+		title = fmt.Sprintf("Command of %s %s by %s", approval.User, approval.Status, approval.Approver)
+		text = approval.Command
+		if approval.Comment != "" {
+			text += "\n\n" + approval.Comment
+		}
+	}
+	sendNotification(notify.Message{Topic: "approval", Title: title, Text: text, Data: approval})
+}
+
+// GetRules godoc
+// @Summary Get the command rules in the order they are tried
+// @Tags Terminal
+// @Success 200 {object} gin.H
+// @Router /api/terminal/policy/rules [get]
+func (h *PolicyHandler) GetRules(c *gin.Context) {
+	rules, err := h.store.GetRules()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rules": rules})
+}
+
+// CreateRule godoc
+// @Summary Create a command rule
+// @Description Admins only
+// @Tags Terminal
+// @Param request body models.CommandRule true "Command rule"
+// @Success 200 {object} gin.H
+// @Router /api/terminal/policy/rules [post]
+func (h *PolicyHandler) CreateRule(c *gin.Context) {
+	var rule models.CommandRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := policy.ValidateRule(rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rule.ID = 0
+	if err := h.store.CreateRule(&rule); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.applyRules(c, rule)
+}
+
+// UpdateRule godoc
+// @Summary Update a command rule
+// @Description Admins only
+// @Tags Terminal
+// @Param id path int true "Rule ID"
+// @Param request body models.CommandRule true "Command rule"
+// @Success 200 {object} gin.H
+// @Router /api/terminal/policy/rules/{id} [put]
+func (h *PolicyHandler) UpdateRule(c *gin.Context) {
+	existing, ok := h.findRule(c)
+	if !ok {
+		return
+	}
+
+	var rule models.CommandRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := policy.ValidateRule(rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rule.ID = existing.ID
+	rule.CreatedAt = existing.CreatedAt
+	if err := h.store.UpdateRule(&rule); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.applyRules(c, rule)
+}
+
+// DeleteRule godoc
+// @Summary Delete a command rule
+// @Description Admins only
+// @Tags Terminal
+// @Param id path int true "Rule ID"
+// @Success 200 {object} gin.H
+// @Router /api/terminal/policy/rules/{id} [delete]
+func (h *PolicyHandler) DeleteRule(c *gin.Context) {
+	rule, ok := h.findRule(c)
+	if !ok {
+		return
+	}
+	if err := h.store.DeleteRule(rule.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.applyRules(c, *rule)
+}
+
+// findRule loads the rule of the :id parameter, replying with an error if there is none
+func (h *PolicyHandler) findRule(c *gin.Context) (*models.CommandRule, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule ID"})
+		return nil, false
+	}
+	rule, err := h.store.GetRule(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Rule not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return rule, true
+}
+
+// applyRules recompiles the policy after a rule change and replies with the rule
+func (h *PolicyHandler) applyRules(c *gin.Context, rule models.CommandRule) {
+	if err := h.reloadRules(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rule": rule})
+}
+
+// CheckCommand godoc
+// @Summary Decide on a command without running or recording it
+// @Description The role defaults to the caller's, the directory to the home directory
+// @Tags Terminal
+// @Success 200 {object} policy.Decision
+// @Router /api/terminal/policy/check [post]
+func (h *PolicyHandler) CheckCommand(c *gin.Context) {
+	var req struct {
+		Command string `json:"command" binding:"required"`
+		Role    string `json:"role"`
+		Dir     string `json:"dir"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Command is required"})
+		return
+	}
+	if req.Role == "" {
+		req.Role = userRole(sessionUser(c))
+	}
+	if req.Dir == "" {
+		req.Dir, _ = os.UserHomeDir()
+	}
+	c.JSON(http.StatusOK, h.evaluate(req.Role, req.Command, req.Dir))
+}
+
+// GetDecisions godoc
+// @Summary Get the recorded decisions of the command policy
+// @Tags Terminal
+// @Param user query string false "User"
+// @Param action query string false "allow, deny or approve"
+// @Param hours query int false "Only decisions of the last hours" default(168)
+// @Param limit query int false "Limit results" default(100)
+// @Success 200 {object} gin.H
+// @Router /api/terminal/policy/decisions [get]
+func (h *PolicyHandler) GetDecisions(c *gin.Context) {
+	hours, err := strconv.Atoi(c.DefaultQuery("hours", "168"))
+	if err != nil || hours <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid hours parameter"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit parameter"})
+		return
+	}
+
+	since := time.Now().Add(-time.Duration(hours) * time.Hour)
+	decisions, err := h.store.GetDecisions(c.Query("user"), models.PolicyAction(c.Query("action")), since, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"decisions": decisions})
+}
+
+// GetApprovals godoc
+// @Summary Get the commands held for approval
+// @Tags Terminal
+// @Param status query string false "pending, approved, rejected, expired or executed"
+// @Param limit query int false "Limit results" default(100)
+// @Success 200 {object} gin.H
+// @Router /api/terminal/approvals [get]
+func (h *PolicyHandler) GetApprovals(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit parameter"})
+		return
+	}
+	if err := h.store.ExpireApprovals(time.Now()); err != nil {
+		log.Printf("Failed to expire held commands: %v", err)
+	}
+	approvals, err := h.store.GetApprovals(models.ApprovalStatus(c.Query("status")), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"approvals": approvals})
+}
+
+// ApproveCommand godoc
+// @Summary Approve a held command
+// @Description The requester then runs it by resubmitting it with the approval's ID before it expires. Operators and admins only, and never the requester.
+// @Tags Terminal
+// @Param id path int true "Approval ID"
+// @Success 200 {object} gin.H
+// @Router /api/terminal/approvals/{id}/approve [post]
+func (h *PolicyHandler) ApproveCommand(c *gin.Context) {
+	h.decideApproval(c, models.ApprovalApproved)
+}
+
+// RejectCommand godoc
+// @Summary Reject a held command
+// @Description Operators and admins only, and never the requester
+// @Tags Terminal
+// @Param id path int true "Approval ID"
+// @Success 200 {object} gin.H
+// @Router /api/terminal/approvals/{id}/reject [post]
+func (h *PolicyHandler) RejectCommand(c *gin.Context) {
+	h.decideApproval(c, models.ApprovalRejected)
+}
+
+func (h *PolicyHandler) decideApproval(c *gin.Context, status models.ApprovalStatus) {
+	var req struct {
+		Comment string `json:"comment"`
+	}
+	c.ShouldBindJSON(&req) // the comment is optional
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid approval ID"})
+		return
+	}
+	approval, err := h.store.GetApproval(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Approval not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	approver := sessionUser(c)
+	if approver == approval.User {
+		c.JSON(http.StatusForbidden, gin.H{"error": "A command must be approved by another operator"})
+		return
+	}
+	if approval.Status == models.ApprovalPending && time.Now().After(approval.ExpiresAt) {
+		h.store.ExpireApprovals(time.Now())
+		approval.Status = models.ApprovalExpired
+	}
+	if approval.Status != models.ApprovalPending {
+		c.JSON(http.StatusConflict, gin.H{"error": "Command is " + string(approval.Status)})
+		return
+	}
+
+	approval.Status = status
+	approval.Approver = approver
+	approval.Comment = req.Comment
+	if status == models.ApprovalApproved {
+		// the requester gets a full window to run it
+		approval.ExpiresAt = time.Now().Add(h.timeout)
+	}
+	ok, err := h.store.DecideApproval(approval)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusConflict, gin.H{"error": "Command was already decided"})
+		return
+	}
+	log.Printf("Command #%d of %s %s by %s", approval.ID, approval.User, status, approver)
+	h.announce(approval)
+	c.JSON(http.StatusOK, gin.H{"approval": approval})
+}
@@ -46,6 +46,12 @@ func LogProxyReplacement(oldProxyID, newProxyID, oldMerchantID, newMerchantID in
 		MerchantID: newMerchantID,
 	}
 	
+	result := "failure"
+	if success {
+		result = "success"
+	}
+	proxyReplaceCounter.Inc(operatorType, result)
+
 	return proxyLogStorage.LogProxyReplace(
 		oldProxy,
 		newProxy,
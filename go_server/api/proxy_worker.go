@@ -64,6 +64,7 @@ func executeAndLog() {
 		autoReplaceTaskMutex.Lock()
 		autoReplaceStatusMessage = fmt.Sprintf("错误: %v", err)
 		autoReplaceTaskMutex.Unlock()
+		autoReplaceRunsCounter.Inc("error")
 		return
 	}
 
@@ -72,6 +73,7 @@ func executeAndLog() {
 		autoReplaceTaskMutex.Lock()
 		autoReplaceStatusMessage = "没有正在使用的代理，等待下一轮。"
 		autoReplaceTaskMutex.Unlock()
+		autoReplaceRunsCounter.Inc("no_proxies")
 		return
 	}
 
@@ -116,12 +118,16 @@ func executeAndLog() {
 		}
 	}
 
+	// 更新代理可用性指标
+	recordProxyCheck(len(proxyStatuses), len(unavailableProxies))
+
 	log.Printf("检测到 %d 个不可用代理", len(unavailableProxies))
 	if len(unavailableProxies) == 0 {
 		log.Println("所有代理均可用，本轮检测结束。")
 		autoReplaceTaskMutex.Lock()
 		autoReplaceStatusMessage = "所有代理均可用，等待下一轮。"
 		autoReplaceTaskMutex.Unlock()
+		autoReplaceRunsCounter.Inc("all_available")
 		return
 	}
 
@@ -133,11 +139,36 @@ func executeAndLog() {
 	replaceUnavailableProxies(unavailableProxies)
 
 	log.Println("本轮代理自动检测与更换完成")
+	autoReplaceRunsCounter.Inc("replaced")
 	autoReplaceTaskMutex.Lock()
 	autoReplaceStatusMessage = "更换完成，等待下一轮检测..."
 	autoReplaceTaskMutex.Unlock()
 }
 
+// Series names written after each proxy availability check
+const (
+	seriesProxyChecked      = "proxy_checked"
+	seriesProxyUnavailable  = "proxy_unavailable"
+	seriesProxyFailureRatio = "proxy_failure_ratio"
+)
+
+// recordProxyCheck 更新代理可用性指标，并把检测结果写入指标存储，供历史查询和异常检测使用
+func recordProxyCheck(total, unavailable int) {
+	now := time.Now()
+	proxyCheckGauge.Set(float64(total-unavailable), "available")
+	proxyCheckGauge.Set(float64(unavailable), "unavailable")
+	proxyCheckTimeGauge.Set(float64(now.Unix()))
+
+	if metricsDB == nil || total == 0 {
+		return
+	}
+	metricsDB.Append(seriesProxyChecked, nil, now, float64(total))
+	metricsDB.Append(seriesProxyUnavailable, nil, now, float64(unavailable))
+	if err := metricsDB.Append(seriesProxyFailureRatio, nil, now, float64(unavailable)/float64(total)); err != nil {
+		log.Printf("Failed to store proxy check metrics: %v", err)
+	}
+}
+
 // getDevicesAndProxies 封装了获取设备和代理信息的逻辑
 func getDevicesAndProxies() (map[int64][]DeviceInfo, []ProxyInfo, error) {
 	aiBoxDevices, err := getAIBoxDevicesWithProxy()
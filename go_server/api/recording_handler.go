@@ -0,0 +1,441 @@
+package api
+
+import (
+	"control/go_server/config"
+	"control/go_server/internal/models"
+	"control/go_server/internal/recording"
+	"control/go_server/internal/storage"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Default size of recordings of /terminal/execute sessions, which have no terminal
+const (
+	commandRecordingWidth  = 120
+	commandRecordingHeight = 40
+)
+
+// terminalRecordings records the /terminal/execute sessions; set up with the router
+var terminalRecordings *RecordingHandler
+
+// RecordingHandler records terminal sessions as asciicast files and serves
+// them for search and playback
+type RecordingHandler struct {
+	store     *storage.RecordingStore
+	dir       string
+	retention time.Duration
+	host      string
+
+	// Open recordings of /terminal/execute sessions, by user and session ID
+	mutex    sync.Mutex
+	commands map[string]*models.TerminalRecording
+}
+
+// NewRecordingHandler creates the recordings directory and starts removing
+// recordings older than the retention daily
+func NewRecordingHandler(store *storage.RecordingStore, conf config.TerminalConfig) *RecordingHandler {
+	host, _ := os.Hostname()
+	h := &RecordingHandler{
+		store:     store,
+		dir:       conf.RecordingDir,
+		retention: time.Duration(conf.RecordingRetentionDays) * 24 * time.Hour,
+		host:      host,
+		commands:  make(map[string]*models.TerminalRecording),
+	}
+	if err := os.MkdirAll(h.dir, 0700); err != nil {
+		log.Printf("Failed to create recordings directory: %v", err)
+	}
+	go h.retentionRoutine()
+	return h
+}
+
+// retentionRoutine removes expired recordings daily
+func (h *RecordingHandler) retentionRoutine() {
+	ticker := time.NewTicker(24 * time.Hour)
+	defer ticker.Stop()
+
+	for {
+		if h.retention > 0 {
+			h.removeRecordingsBefore(time.Now().Add(-h.retention))
+		}
+		<-ticker.C
+	}
+}
+
+func (h *RecordingHandler) removeRecordingsBefore(t time.Time) {
+	recordings, err := h.store.GetRecordingsBefore(t)
+	if err != nil {
+		log.Printf("Failed to find expired terminal recordings: %v", err)
+		return
+	}
+	for _, r := range recordings {
+		if err := os.Remove(filepath.Join(h.dir, r.File)); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove terminal recording %s: %v", r.File, err)
+			continue
+		}
+		// The date directory goes with its last recording; Remove fails while it has others
+		os.Remove(filepath.Dir(filepath.Join(h.dir, r.File)))
+		if err := h.store.DeleteRecording(r.ID); err != nil {
+			log.Printf("Failed to delete terminal recording %d: %v", r.ID, err)
+		}
+	}
+	if len(recordings) > 0 {
+		log.Printf("Removed %d terminal recordings older than %s", len(recordings), t.Format(time.RFC3339))
+	}
+}
+
+// create starts the file and row of a recording
+func (h *RecordingHandler) create(r *models.TerminalRecording, title string) (*recording.Writer, error) {
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	r.Host = h.host
+	r.File = filepath.Join(r.StartedAt.Format("2006-01-02"), fmt.Sprintf("%s-%s.cast", r.Kind, hex.EncodeToString(suffix)))
+	path := filepath.Join(h.dir, r.File)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	writer, err := recording.Create(path, recording.Header{
+		Width:     r.Width,
+		Height:    r.Height,
+		Timestamp: r.StartedAt.Unix(),
+		Title:     title,
+		Env:       map[string]string{"TERM": "xterm-256color", "SHELL": config.Conf.Terminal.Shell},
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.Size = writer.Size()
+	if err := h.store.SaveRecording(r); err != nil {
+		writer.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	return writer, nil
+}
+
+// sessionRecording is an interactive session being recorded
+type sessionRecording struct {
+	handler *RecordingHandler
+	writer  *recording.Writer
+
+	mutex    sync.Mutex
+	row      *models.TerminalRecording
+	lines    recording.LineBuffer
+	commands []string
+}
+
+// recordSession starts recording an interactive session
+func (h *RecordingHandler) recordSession(user, sessionID string, cols, rows uint16) (*sessionRecording, error) {
+	row := &models.TerminalRecording{
+		SessionID: sessionID,
+		Kind:      models.RecordingInteractive,
+		User:      user,
+		Width:     int(cols),
+		Height:    int(rows),
+		StartedAt: time.Now(),
+	}
+	writer, err := h.create(row, fmt.Sprintf("%s@%s", user, h.host))
+	if err != nil {
+		return nil, err
+	}
+	return &sessionRecording{handler: h, writer: writer, row: row}, nil
+}
+
+// Output records what the terminal printed
+func (s *sessionRecording) Output(data []byte) {
+	if err := s.writer.Output(data); err != nil {
+		log.Printf("Failed to record terminal session %s: %v", s.row.SessionID, err)
+	}
+}
+
+// Input records keystrokes. Completed command lines are saved right away so
+// they are searchable while the session is still open.
+func (s *sessionRecording) Input(data []byte) {
+	if err := s.writer.Input(data); err != nil {
+		log.Printf("Failed to record terminal session %s: %v", s.row.SessionID, err)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if lines := s.lines.Write(data); len(lines) > 0 {
+		s.commands = append(s.commands, lines...)
+		s.saveLocked()
+	}
+}
+
+// Resize records a change of the terminal's size
+func (s *sessionRecording) Resize(cols, rows uint16) {
+	if err := s.writer.Resize(cols, rows); err != nil {
+		log.Printf("Failed to record terminal session %s: %v", s.row.SessionID, err)
+	}
+}
+
+// Finish closes the recording with the shell's exit code
+func (s *sessionRecording) Finish(exitCode int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ended := time.Now()
+	s.row.EndedAt = &ended
+	s.row.ExitCode = &exitCode
+	s.saveLocked()
+	s.writer.Close()
+}
+
+func (s *sessionRecording) saveLocked() {
+	s.row.Size = s.writer.Size()
+	s.row.Commands = strings.Join(s.commands, "\n")
+	if err := s.handler.store.SaveRecording(s.row); err != nil {
+		log.Printf("Failed to save recording of terminal session %s: %v", s.row.SessionID, err)
+	}
+}
+
+// commandRecording is a /terminal/execute command being recorded
+type commandRecording struct {
+	handler *RecordingHandler
+	writer  *recording.Writer
+	row     *models.TerminalRecording
+
+	mutex       sync.Mutex
+	lineStarted bool // the last output didn't end its line
+}
+
+// recordCommand records a command of a /terminal/execute session, shown like
+// it was typed at a prompt in dir. Every session of a user is one recording,
+// continued by each of its commands.
+func (h *RecordingHandler) recordCommand(user, sessionID, dir, command string) (*commandRecording, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	key := user + "\x00" + sessionID
+	row, ok := h.commands[key]
+	var writer *recording.Writer
+	var err error
+	if ok {
+		writer, err = recording.Open(filepath.Join(h.dir, row.File))
+	}
+	if !ok || err != nil {
+		// A new session, or its recording expired
+		row = &models.TerminalRecording{
+			SessionID: sessionID,
+			Kind:      models.RecordingCommand,
+			User:      user,
+			Width:     commandRecordingWidth,
+			Height:    commandRecordingHeight,
+			StartedAt: time.Now(),
+		}
+		if writer, err = h.create(row, fmt.Sprintf("%s@%s commands", user, h.host)); err != nil {
+			return nil, err
+		}
+		h.commands[key] = row
+	}
+
+	writer.Output([]byte(fmt.Sprintf("%s@%s:%s$ ", user, h.host, dir)))
+	writer.Input([]byte(command + "\r"))
+	writer.Output([]byte(command + "\r\n"))
+	if row.Commands != "" {
+		row.Commands += "\n"
+	}
+	row.Commands += command
+	return &commandRecording{handler: h, writer: writer, row: row}, nil
+}
+
+// endCommandSession forgets the recording of a closed /terminal/execute
+// session; a session reusing its ID starts a new recording
+func (h *RecordingHandler) endCommandSession(user, sessionID string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	delete(h.commands, user+"\x00"+sessionID)
+}
+
+// Output records output of the command as it is produced
+func (r *commandRecording) Output(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	// Commands run without a terminal, so lines end in a bare newline
+	output := strings.ReplaceAll(string(data), "\r\n", "\n")
+	output = strings.ReplaceAll(output, "\n", "\r\n")
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.writer.Output([]byte(output))
+	r.lineStarted = !strings.HasSuffix(output, "\n")
+}
+
+// endLine ends the line the output left unfinished, so what follows starts on its own line
+func (r *commandRecording) endLine() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.lineStarted {
+		r.writer.Output([]byte("\r\n"))
+		r.lineStarted = false
+	}
+}
+
+// Finish records the rest of a command's output and its exit code and closes
+// the file until the next command
+func (r *commandRecording) Finish(stdout, stderr string, exitCode int) {
+	h := r.handler
+	for _, output := range []string{stdout, stderr} {
+		if output != "" {
+			r.endLine()
+			r.Output([]byte(output))
+		}
+	}
+	r.endLine()
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	ended := time.Now()
+	r.row.EndedAt = &ended
+	r.row.ExitCode = &exitCode
+	r.row.Size = r.writer.Size()
+	if err := h.store.SaveRecording(r.row); err != nil {
+		log.Printf("Failed to save recording of terminal session %s: %v", r.row.SessionID, err)
+	}
+	r.writer.Close()
+}
+
+// parseRecordingID reads the :id parameter, writing the error response itself
+func parseRecordingID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recording ID"})
+		return 0, false
+	}
+	return id, true
+}
+
+// getRecording loads the recording named by :id, writing the error response
+// itself. Users other than admins only see their own recordings.
+func (h *RecordingHandler) getRecording(c *gin.Context) (*models.TerminalRecording, bool) {
+	id, ok := parseRecordingID(c)
+	if !ok {
+		return nil, false
+	}
+	r, err := h.store.GetRecording(id)
+	user := sessionUser(c)
+	if errors.Is(err, gorm.ErrRecordNotFound) || err == nil && r.User != user && userRole(user) != models.RoleAdmin {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Recording not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return r, true
+}
+
+// GetRecordings godoc
+// @Summary Search terminal recordings
+// @Description Lists recorded terminal sessions, newest first, by user, time and typed command text. Users other than admins only see their own.
+// @Tags Terminal
+// @Param user query string false "User, for admins"
+// @Param kind query string false "interactive or command"
+// @Param from query string false "Sessions open at or after, RFC3339"
+// @Param to query string false "Sessions started before, RFC3339"
+// @Param command query string false "Text contained in a typed command"
+// @Param limit query int false "Limit results" default(100)
+// @Success 200 {object} gin.H
+// @Router /api/terminal/recordings [get]
+func (h *RecordingHandler) GetRecordings(c *gin.Context) {
+	user := sessionUser(c)
+	if userRole(user) == models.RoleAdmin {
+		user = c.Query("user")
+	}
+	filter := storage.RecordingFilter{
+		User:    user,
+		Kind:    models.TerminalRecordingKind(c.Query("kind")),
+		Command: c.Query("command"),
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit parameter"})
+		return
+	}
+	filter.Limit = limit
+	for param, t := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if s := c.Query(param); s != "" {
+			if *t, err = time.Parse(time.RFC3339, s); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param + " parameter, expected RFC3339"})
+				return
+			}
+		}
+	}
+
+	recordings, err := h.store.SearchRecordings(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recordings": recordings, "total": len(recordings)})
+}
+
+// GetRecording godoc
+// @Summary Get a terminal recording
+// @Tags Terminal
+// @Param id path int true "Recording ID"
+// @Success 200 {object} models.TerminalRecording
+// @Router /api/terminal/recordings/{id} [get]
+func (h *RecordingHandler) GetRecording(c *gin.Context) {
+	r, ok := h.getRecording(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, r)
+}
+
+// GetRecordingCast godoc
+// @Summary Play a terminal recording
+// @Description Serves the asciicast v2 file of a recording, playable with asciinema-player or `asciinema play`
+// @Tags Terminal
+// @Param id path int true "Recording ID"
+// @Param download query bool false "Serve as an attachment"
+// @Success 200 {file} file
+// @Router /api/terminal/recordings/{id}/cast [get]
+func (h *RecordingHandler) GetRecordingCast(c *gin.Context) {
+	r, ok := h.getRecording(c)
+	if !ok {
+		return
+	}
+	file, err := os.Open(filepath.Join(h.dir, r.File))
+	if os.IsNotExist(err) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Recording file not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := fmt.Sprintf("%s-%s-%d.cast", r.User, r.StartedAt.Format("20060102-150405"), r.ID)
+	disposition := "inline"
+	if c.Query("download") == "true" {
+		disposition = "attachment"
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("%s; filename=%q", disposition, name))
+	c.Header("Content-Type", recording.ContentType)
+	// Serves ranges, and a session still being recorded as far as it got
+	http.ServeContent(c.Writer, c.Request, name, info.ModTime(), file)
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"control/go_server/db"
+	"control/go_server/internal/notify"
 	"control/go_server/internal/storage"
 	"control/go_server/internal/utils"
 	"encoding/json"
@@ -12,6 +13,7 @@ import (
 	"log"
 	"net/http"
 	"os/exec"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -1842,7 +1844,7 @@ func ReplaceProxyHandler(c *gin.Context) {
 	})
 }
 
-// NotifyMerchantHandler 通知商户代理不可用（预留功能）
+// NotifyMerchantHandler 通知商户代理不可用，发送到订阅了 proxy 主题的通知渠道
 func NotifyMerchantHandler(c *gin.Context) {
 	var req struct {
 		ProxyIDs    []int64 `json:"proxy_ids"`
@@ -1854,19 +1856,69 @@ func NotifyMerchantHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request parameters"})
 		return
 	}
+	if len(req.ProxyIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "请选择需要通知的代理"})
+		return
+	}
 
-	// TODO: 实现通知逻辑
-	// 这里可以：
-	// 1. 发送邮件通知
-	// 2. 发送短信通知
-	// 3. 系统内消息通知
-	// 4. webhook通知等
+	// 获取代理信息（不包含账号密码）
+	var proxies []struct {
+		ID          int64  `gorm:"column:id" json:"id"`
+		IP          string `gorm:"column:ip" json:"ip"`
+		Port        string `gorm:"column:port" json:"port"`
+		CountryCode string `gorm:"column:country_code" json:"country_code"`
+		MerchantID  int64  `gorm:"column:merchant_id" json:"merchant_id"`
+	}
+	query := db.G.Table("proxy").
+		Select("id, ip, port, country_code, merchant_id").
+		Where("id IN ? AND deleted_at IS NULL", req.ProxyIDs)
+	if !req.NotifyAll && len(req.MerchantIDs) > 0 {
+		query = query.Where("merchant_id IN ?", req.MerchantIDs)
+	}
+	if err := query.Scan(&proxies).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to fetch proxy info", "message": err.Error()})
+		return
+	}
+	if len(proxies) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "没有找到需要通知的代理"})
+		return
+	}
+
+	// 按商户分组生成通知内容
+	byMerchant := make(map[int64][]string)
+	var merchantIDs []int64
+	for _, p := range proxies {
+		if _, ok := byMerchant[p.MerchantID]; !ok {
+			merchantIDs = append(merchantIDs, p.MerchantID)
+		}
+		byMerchant[p.MerchantID] = append(byMerchant[p.MerchantID], fmt.Sprintf("%s:%s (%s)", p.IP, p.Port, p.CountryCode))
+	}
+	sort.Slice(merchantIDs, func(i, j int) bool { return merchantIDs[i] < merchantIDs[j] })
+	var text strings.Builder
+	for _, merchantID := range merchantIDs {
+		fmt.Fprintf(&text, "商户 %d:\n", merchantID)
+		for _, proxy := range byMerchant[merchantID] {
+			fmt.Fprintf(&text, "  - %s\n", proxy)
+		}
+	}
+
+	channels := sendNotification(notify.Message{
+		Topic: "proxy",
+		Title: fmt.Sprintf("代理不可用通知（%d 个代理）", len(proxies)),
+		Text:  text.String(),
+		Data:  gin.H{"proxies": proxies, "merchantIds": merchantIDs},
+	})
+	if channels == 0 {
+		c.JSON(http.StatusOK, gin.H{"success": false, "error": "没有启用并订阅代理通知的渠道"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"success":           true,
-		"message":           "通知功能暂未实现，敬请期待",
-		"notifiedProxies":   len(req.ProxyIDs),
-		"notifiedMerchants": len(req.MerchantIDs),
+		"message":           "通知已发送",
+		"channels":          channels,
+		"notifiedProxies":   len(proxies),
+		"notifiedMerchants": len(merchantIDs),
 		"timestamp":         time.Now().UTC().Format(time.RFC3339),
 	})
 }
@@ -2086,6 +2138,14 @@ func performAsyncProxyCheck(taskID string, task *AsyncCheckStatus) {
 	cacheTimestamp = time.Now()
 	cacheMutex.Unlock()
 
+	unavailable := 0
+	for _, status := range proxyStatuses {
+		if !status.IsAvailable {
+			unavailable++
+		}
+	}
+	recordProxyCheck(len(proxyStatuses), unavailable)
+
 	// 任务完成
 	taskMutex.Lock()
 	task.Status = "completed"
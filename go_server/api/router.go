@@ -1,7 +1,9 @@
 package api
 
 import (
+	"control/go_server/config"
 	"control/go_server/db"
+	"control/go_server/internal/models"
 	"control/go_server/internal/storage"
 	"net/http"
 	"os"
@@ -29,14 +31,56 @@ func SetupRouter() *gin.Engine {
 		MaxAge:           12 * time.Hour,
 	}))
 
+	// Request metrics middleware
+	router.Use(MetricsMiddleware())
+
 	// Session middleware
 	router.Use(SessionsMiddleware())
 
+	// Prometheus metrics, outside /api so scrapers don't need a session
+	router.GET("/metrics", PrometheusMetricsHandler)
+
 	// Initialize CI/CD store
 	cicdStore := storage.NewCICDStore(db.G)
 	cicdStore.AutoMigrate()
 	cicdHandler := NewCICDHandler(cicdStore)
 
+	// Initialize notification channels
+	notificationStore := storage.NewNotificationStore(db.G)
+	notificationStore.AutoMigrate()
+	notificationHandler := NewNotificationHandler(notificationStore)
+
+	// Initialize alerting store
+	alertStore := storage.NewAlertStore(db.G)
+	alertStore.AutoMigrate()
+	alertHandler := NewAlertHandler(alertStore)
+
+	// Terminal recordings
+	recordingStore := storage.NewRecordingStore(db.G)
+	recordingStore.AutoMigrate()
+	recordingHandler := NewRecordingHandler(recordingStore, config.Conf.Terminal)
+	terminalRecordings = recordingHandler
+
+	// Command policy of the terminal
+	policyStore := storage.NewPolicyStore(db.G)
+	policyStore.AutoMigrate()
+	policyHandler := NewPolicyHandler(policyStore, config.Conf.Terminal)
+	commandPolicy = policyHandler
+
+	// Interactive terminal sessions
+	terminalHandler := NewTerminalHandler(config.Conf.Terminal, recordingHandler, policyHandler)
+	startTerminalSessionExpiry(config.Conf.Terminal.IdleTimeout)
+
+	// Runbook library
+	runbookStore := storage.NewRunbookStore(db.G)
+	runbookStore.AutoMigrate()
+	runbookHandler := NewRunbookHandler(runbookStore, config.Conf.Terminal)
+
+	// File browser of the services' directories
+	fileStore := storage.NewFileStore(db.G)
+	fileStore.AutoMigrate()
+	fileHandler := NewFileHandler(fileStore, config.Conf.Files)
+
 	// API Routes
 	api := router.Group("/api")
 	{
@@ -54,16 +98,119 @@ func SetupRouter() *gin.Engine {
 			auth.GET("/system-metrics", SystemMetricsHandler)
 			auth.GET("/system-metrics/history", SystemMetricsHistoryHandler)
 			auth.GET("/system-metrics/stats", MetricsStatsHandler)
+			auth.GET("/system-metrics/series", MetricsSeriesHandler)
+			auth.GET("/system-metrics/anomalies", GetAnomaliesHandler)
+			auth.GET("/system-metrics/anomalies/scores", GetAnomalyScoresHandler)
+			auth.GET("/metrics/query", MetricsQueryHandler)
+			auth.GET("/forecasts/exhaustion", GetExhaustionForecastsHandler)
+			auth.GET("/live", LiveHandler)
+			auth.GET("/live/stats", LiveStatsHandler)
+			auth.GET("/metrics/top", MetricsTopHandler)
+			auth.GET("/scrape/targets", GetScrapeTargetsHandler)
+			auth.POST("/scrape/targets/:serviceName/:name/scrape", ScrapeTargetHandler)
 			auth.GET("/service-status", ServiceStatusHandler)
 			auth.GET("/services-status", ServicesStatusHandler)
 			auth.POST("/service/start", ServiceStartHandler)
 			auth.POST("/service/stop", ServiceStopHandler)
 			auth.POST("/service/restart", ServiceRestartHandler)
+			auth.GET("/containers", GetContainersHandler)
+			auth.GET("/containers/:id/stats", GetContainerStatsHandler)
+			auth.GET("/logs/usage", LogUsageHandler)
+			auth.GET("/logs/formats", LogFormatsHandler)
+			auth.GET("/logs/export", LogExportHandler)
 			auth.GET("/logs/:serviceName", LogsHandler)
+			auth.GET("/logs/:serviceName/sources", LogSourcesHandler)
+			auth.GET("/logs/:serviceName/search", LogSearchHandler)
+			auth.GET("/logs/:serviceName/download", LogDownloadHandler)
+			auth.POST("/logs/:serviceName/rotate", LogRotateHandler)
 			auth.GET("system/info", SystemInfoHandler)
+			auth.GET("/system-info/history", HostHistoryHandler)
 			auth.POST("/terminal/execute", ExecuteCommandHandler)
+			auth.GET("/terminal/commands", GetCommandsHandler)
+			auth.POST("/terminal/commands/:id/cancel", CancelCommandHandler)
+			auth.GET("/terminal/sessions", terminalHandler.GetSessions)
+			auth.GET("/terminal/sessions/:id/history", terminalHandler.GetSessionHistory)
+			auth.DELETE("/terminal/sessions/:id", terminalHandler.CloseSession)
+			// Typed commands escape the policy, so only admins get a shell
+			auth.GET("/terminal/ws", RoleMiddleware(models.RoleAdmin), terminalHandler.Connect)
+			auth.GET("/terminal/recordings", recordingHandler.GetRecordings)
+			auth.GET("/terminal/recordings/:id", recordingHandler.GetRecording)
+			auth.GET("/terminal/recordings/:id/cast", recordingHandler.GetRecordingCast)
+			auth.GET("/terminal/approvals", policyHandler.GetApprovals)
+			auth.POST("/terminal/approvals/:id/approve", RoleMiddleware(models.RoleAdmin, models.RoleOperator), policyHandler.ApproveCommand)
+			auth.POST("/terminal/approvals/:id/reject", RoleMiddleware(models.RoleAdmin, models.RoleOperator), policyHandler.RejectCommand)
+
+			policyGroup := auth.Group("/terminal/policy")
+			{
+				policyGroup.GET("/rules", policyHandler.GetRules)
+				policyGroup.POST("/rules", RoleMiddleware(models.RoleAdmin), policyHandler.CreateRule)
+				policyGroup.PUT("/rules/:id", RoleMiddleware(models.RoleAdmin), policyHandler.UpdateRule)
+				policyGroup.DELETE("/rules/:id", RoleMiddleware(models.RoleAdmin), policyHandler.DeleteRule)
+				policyGroup.POST("/check", policyHandler.CheckCommand)
+				policyGroup.GET("/decisions", policyHandler.GetDecisions)
+			}
+
+			runbookGroup := auth.Group("/runbooks")
+			{
+				runbookGroup.GET("", runbookHandler.GetRunbooks)
+				runbookGroup.POST("", RoleMiddleware(models.RoleAdmin), runbookHandler.CreateRunbook)
+				runbookGroup.GET("/runs", runbookHandler.GetRuns)
+				runbookGroup.GET("/runs/:runId", runbookHandler.GetRun)
+				runbookGroup.GET("/:id", runbookHandler.GetRunbook)
+				runbookGroup.PUT("/:id", RoleMiddleware(models.RoleAdmin), runbookHandler.UpdateRunbook)
+				runbookGroup.DELETE("/:id", RoleMiddleware(models.RoleAdmin), runbookHandler.DeleteRunbook)
+				runbookGroup.POST("/:id/run", runbookHandler.RunRunbook)
+			}
+
+			fileGroup := auth.Group("/files/:serviceName")
+			{
+				fileGroup.GET("", fileHandler.ListFiles)
+				fileGroup.GET("/content", RoleMiddleware(models.RoleAdmin, models.RoleOperator), fileHandler.GetFileContent)
+				fileGroup.PUT("/content", RoleMiddleware(models.RoleAdmin, models.RoleOperator), fileHandler.SaveFileContent)
+				fileGroup.GET("/download", RoleMiddleware(models.RoleAdmin, models.RoleOperator), fileHandler.DownloadFile)
+				fileGroup.POST("/upload", RoleMiddleware(models.RoleAdmin, models.RoleOperator), fileHandler.UploadFile)
+				fileGroup.GET("/changes", fileHandler.GetFileChanges)
+				fileGroup.GET("/changes/:id", RoleMiddleware(models.RoleAdmin, models.RoleOperator), fileHandler.GetFileChange)
+				fileGroup.GET("/changes/:id/backup", RoleMiddleware(models.RoleAdmin, models.RoleOperator), fileHandler.DownloadFileBackup)
+			}
+
 			auth.GET("/device-monitoring", GetDeviceMonitoringHandler)
 
+			// Log alert routes
+			logAlertGroup := auth.Group("/log-alerts")
+			{
+				logAlertGroup.GET("/rules", GetLogAlertRulesHandler)
+				logAlertGroup.POST("/rules", RoleMiddleware(models.RoleAdmin), SaveLogAlertRuleHandler)
+				logAlertGroup.DELETE("/rules/:name", RoleMiddleware(models.RoleAdmin), DeleteLogAlertRuleHandler)
+				logAlertGroup.GET("/status", GetLogAlertStatusHandler)
+				logAlertGroup.GET("/events", GetLogAlertEventsHandler)
+			}
+
+			// Metric alert routes
+			alertGroup := auth.Group("/alerts")
+			{
+				alertGroup.GET("/rules", alertHandler.GetRules)
+				alertGroup.POST("/rules", RoleMiddleware(models.RoleAdmin), alertHandler.CreateRule)
+				alertGroup.PUT("/rules/:id", RoleMiddleware(models.RoleAdmin), alertHandler.UpdateRule)
+				alertGroup.DELETE("/rules/:id", RoleMiddleware(models.RoleAdmin), alertHandler.DeleteRule)
+				alertGroup.GET("/active", alertHandler.GetActiveAlerts)
+				alertGroup.GET("/history", alertHandler.GetAlertHistory)
+				alertGroup.GET("/silences", alertHandler.GetSilences)
+				alertGroup.POST("/silences", RoleMiddleware(models.RoleAdmin, models.RoleOperator), alertHandler.CreateSilence)
+				alertGroup.DELETE("/silences/:id", RoleMiddleware(models.RoleAdmin, models.RoleOperator), alertHandler.ExpireSilence)
+			}
+
+			// Notification routes
+			notificationGroup := auth.Group("/notifications")
+			{
+				notificationGroup.GET("/channels", notificationHandler.GetChannels)
+				notificationGroup.POST("/channels", RoleMiddleware(models.RoleAdmin), notificationHandler.CreateChannel)
+				notificationGroup.PUT("/channels/:id", RoleMiddleware(models.RoleAdmin), notificationHandler.UpdateChannel)
+				notificationGroup.DELETE("/channels/:id", RoleMiddleware(models.RoleAdmin), notificationHandler.DeleteChannel)
+				notificationGroup.POST("/channels/:id/test", RoleMiddleware(models.RoleAdmin), notificationHandler.TestChannel)
+				notificationGroup.GET("/deliveries", notificationHandler.GetDeliveries)
+			}
+
 			// Redis routes
 			redisGroup := auth.Group("/redis")
 			{
@@ -0,0 +1,444 @@
+package api
+
+import (
+	"control/go_server/config"
+	"control/go_server/internal/models"
+	"control/go_server/internal/runbook"
+	"control/go_server/internal/storage"
+	"control/go_server/internal/terminal"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// commandSourceRunbook is the source of the commands runbooks run
+const commandSourceRunbook = "runbook"
+
+// runbookRunRetentionDays is how long runs and their output are kept
+const runbookRunRetentionDays = 90
+
+// RunbookHandler keeps the runbook library and runs runbooks through the
+// terminal's command policy and command runner
+type RunbookHandler struct {
+	store *storage.RunbookStore
+	conf  config.TerminalConfig
+}
+
+// NewRunbookHandler seeds the runbooks of the configuration into an empty
+// library and starts removing old runs
+func NewRunbookHandler(store *storage.RunbookStore, conf config.TerminalConfig) *RunbookHandler {
+	h := &RunbookHandler{store: store, conf: conf}
+
+	if count, err := store.CountRunbooks(); err != nil {
+		log.Printf("Failed to count runbooks: %v", err)
+	} else if count == 0 {
+		for _, rb := range config.Conf.Runbooks {
+			if err := store.CreateRunbook(&rb); err != nil {
+				log.Printf("Failed to seed runbook %s: %v", rb.Name, err)
+			}
+		}
+	}
+	// Their commands died with the previous process
+	if err := store.FailRunningRuns("Server restarted while the runbook ran"); err != nil {
+		log.Printf("Failed to close interrupted runbook runs: %v", err)
+	}
+	go h.cleanupRoutine()
+	return h
+}
+
+// cleanupRoutine removes old runs daily
+func (h *RunbookHandler) cleanupRoutine() {
+	ticker := time.NewTicker(24 * time.Hour)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := h.store.CleanupOldRuns(runbookRunRetentionDays); err != nil {
+			log.Printf("Failed to remove old runbook runs: %v", err)
+		}
+	}
+}
+
+// services indexes the configured services by name
+func (h *RunbookHandler) services() map[string]models.Service {
+	services := make(map[string]models.Service, len(config.Conf.Services))
+	for _, s := range config.Conf.Services {
+		services[s.Name] = s
+	}
+	return services
+}
+
+// GetRunbooks godoc
+// @Summary Get the runbook library
+// @Tags Runbooks
+// @Success 200 {object} gin.H
+// @Router /api/runbooks [get]
+func (h *RunbookHandler) GetRunbooks(c *gin.Context) {
+	runbooks, err := h.store.GetRunbooks()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"runbooks": runbooks})
+}
+
+// GetRunbook godoc
+// @Summary Get a runbook
+// @Tags Runbooks
+// @Param id path int true "Runbook ID"
+// @Success 200 {object} gin.H
+// @Router /api/runbooks/{id} [get]
+func (h *RunbookHandler) GetRunbook(c *gin.Context) {
+	rb, ok := h.findRunbook(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"runbook": rb})
+}
+
+// CreateRunbook godoc
+// @Summary Create a runbook
+// @Description Admins only
+// @Tags Runbooks
+// @Param request body models.Runbook true "Runbook"
+// @Success 200 {object} gin.H
+// @Router /api/runbooks [post]
+func (h *RunbookHandler) CreateRunbook(c *gin.Context) {
+	var rb models.Runbook
+	if err := c.ShouldBindJSON(&rb); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if rb.RequiredRole == "" {
+		rb.RequiredRole = models.RoleOperator
+	}
+	if err := runbook.ValidateRunbook(rb, h.services()); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rb.ID = 0
+	rb.CreatedBy = sessionUser(c)
+	if err := h.store.CreateRunbook(&rb); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"runbook": rb})
+}
+
+// UpdateRunbook godoc
+// @Summary Update a runbook
+// @Description Admins only
+// @Tags Runbooks
+// @Param id path int true "Runbook ID"
+// @Param request body models.Runbook true "Runbook"
+// @Success 200 {object} gin.H
+// @Router /api/runbooks/{id} [put]
+func (h *RunbookHandler) UpdateRunbook(c *gin.Context) {
+	existing, ok := h.findRunbook(c)
+	if !ok {
+		return
+	}
+
+	var rb models.Runbook
+	if err := c.ShouldBindJSON(&rb); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if rb.RequiredRole == "" {
+		rb.RequiredRole = models.RoleOperator
+	}
+	if err := runbook.ValidateRunbook(rb, h.services()); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rb.ID = existing.ID
+	rb.CreatedBy = existing.CreatedBy
+	rb.CreatedAt = existing.CreatedAt
+	if err := h.store.UpdateRunbook(&rb); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"runbook": rb})
+}
+
+// DeleteRunbook godoc
+// @Summary Delete a runbook
+// @Description Admins only. Its runs are kept.
+// @Tags Runbooks
+// @Param id path int true "Runbook ID"
+// @Success 200 {object} gin.H
+// @Router /api/runbooks/{id} [delete]
+func (h *RunbookHandler) DeleteRunbook(c *gin.Context) {
+	rb, ok := h.findRunbook(c)
+	if !ok {
+		return
+	}
+	if err := h.store.DeleteRunbook(rb.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"runbook": rb})
+}
+
+// findRunbook loads the runbook of the :id parameter, replying with an error if there is none
+func (h *RunbookHandler) findRunbook(c *gin.Context) (*models.Runbook, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid runbook ID"})
+		return nil, false
+	}
+	rb, err := h.store.GetRunbook(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Runbook not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return rb, true
+}
+
+// RunRunbook godoc
+// @Summary Run a runbook
+// @Description Checks the parameters and decides on the script, with its parameters substituted, with the command policy. A script that needs approval is held and the run is pending (202); run it again with the same parameters and the approval's ID once approved. Otherwise the run starts and is returned while running; cancel it with its command ID.
+// @Tags Runbooks
+// @Param id path int true "Runbook ID"
+// @Success 200 {object} gin.H
+// @Router /api/runbooks/{id}/run [post]
+func (h *RunbookHandler) RunRunbook(c *gin.Context) {
+	var req struct {
+		Params map[string]string `json:"params"`
+		// ApprovalID runs a runbook held for approval once it was approved
+		ApprovalID int64 `json:"approvalId"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rb, ok := h.findRunbook(c)
+	if !ok {
+		return
+	}
+
+	user := sessionUser(c)
+	if !rb.Enabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Runbook is disabled"})
+		return
+	}
+	if !roleAtLeast(userRole(user), rb.RequiredRole) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden", "message": "Requires role " + rb.RequiredRole})
+		return
+	}
+	services := h.services()
+	params, err := runbook.ResolveParams(*rb, req.Params, services)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	dir, _ := os.UserHomeDir()
+	if rb.Service != "" {
+		dir = services[rb.Service].Path
+	}
+	script := runbook.Render(*rb, params, services)
+	if rb.Host != "" {
+		if script, err = runbook.Remote(rb.Host, script); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	run := &models.RunbookRun{
+		RunbookID:   rb.ID,
+		RunbookName: rb.Name,
+		User:        user,
+		Params:      params,
+		Service:     rb.Service,
+		Host:        rb.Host,
+		Dir:         dir,
+		Script:      script,
+		StartedAt:   time.Now(),
+	}
+
+	// The policy decides on the script as it will really run, with the
+	// parameters in place of their variables
+	sessionID := fmt.Sprintf("runbook-%d", rb.ID)
+	// Every run is recorded like a terminal command, refused ones included
+	rec, err := terminalRecordings.recordCommand(user, sessionID, dir, script)
+	if err != nil {
+		log.Printf("Failed to record run of runbook %s: %v", rb.Name, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record run", "message": err.Error()})
+		return
+	}
+	decision, approval := commandPolicy.authorize(user, sessionID, commandSourceRunbook, runbook.Expand(*rb, params, services), dir, req.ApprovalID)
+	switch decision.Action {
+	case models.PolicyDeny:
+		run.Status = models.RunDenied
+		run.Reason = "Denied by policy: " + decision.Reason
+		rec.Finish("", run.Reason, 1)
+		h.endRun(run, nil, 0)
+		c.JSON(http.StatusOK, gin.H{"run": run, "policy": decision})
+		return
+	case models.PolicyApprove:
+		run.Status = models.RunPending
+		run.ApprovalID = approval.ID
+		run.Reason = fmt.Sprintf("Requires approval (%s); held as #%d until %s", decision.Reason, approval.ID, approval.ExpiresAt.Format("2006-01-02 15:04:05"))
+		rec.Finish("", run.Reason, 1)
+		h.endRun(run, nil, 0)
+		c.JSON(http.StatusAccepted, gin.H{"run": run, "policy": decision, "approvalId": approval.ID, "expiresAt": approval.ExpiresAt})
+		return
+	}
+	run.ApprovalID = req.ApprovalID
+
+	timeout := h.conf.CommandTimeout
+	if rb.TimeoutSeconds > 0 {
+		timeout = time.Duration(rb.TimeoutSeconds) * time.Second
+	}
+	cmd, err := terminalCommands.Start(user, sessionID, terminal.CommandOptions{
+		Shell:     h.conf.Shell,
+		Command:   script,
+		Dir:       dir,
+		Env:       runbookEnv(),
+		Timeout:   timeout,
+		MaxOutput: h.conf.MaxCommandOutput,
+		Output:    func(_ terminal.Stream, data []byte) { rec.Output(data) },
+	})
+	if err != nil {
+		run.Status = models.RunFailed
+		run.Reason = "Failed to start: " + err.Error()
+		rec.Finish("", run.Reason, 1)
+		h.endRun(run, nil, 0)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start runbook", "message": err.Error(), "run": run})
+		return
+	}
+
+	run.Status = models.RunRunning
+	run.CommandID = cmd.ID
+	if err := h.store.SaveRun(run); err != nil {
+		log.Printf("Failed to save run of runbook %s: %v", rb.Name, err)
+	}
+	log.Printf("Runbook %s run #%d started by %s", rb.Name, run.ID, user)
+	// The run outlives the request; its output is saved once it ends
+	done := *run
+	go func() {
+		result := cmd.Wait()
+		rec.Finish("", commandEndMessage(result, timeout), result.ExitCode)
+		h.endRun(&done, &result, timeout)
+		log.Printf("Runbook %s run #%d %s (exit code %d)", rb.Name, done.ID, done.Status, result.ExitCode)
+	}()
+	c.JSON(http.StatusOK, gin.H{"run": run})
+}
+
+// runbookEnv is the server's environment with the configured Redis password
+// for redis-cli, so runbooks needn't put it in their scripts
+func runbookEnv() []string {
+	env := os.Environ()
+	if config.Conf.Redis.Password != "" {
+		env = append(env, "REDISCLI_AUTH="+config.Conf.Redis.Password)
+	}
+	return env
+}
+
+// endRun saves a run that ended, with the result of its command when it
+// ran, and announces it
+func (h *RunbookHandler) endRun(run *models.RunbookRun, result *terminal.CommandResult, timeout time.Duration) {
+	ended := time.Now()
+	run.EndedAt = &ended
+	if result != nil {
+		run.ExitCode = &result.ExitCode
+		run.Stdout = result.Stdout
+		run.Stderr = result.Stderr
+		run.StdoutTruncated = result.StdoutTruncated
+		run.StderrTruncated = result.StderrTruncated
+		run.Reason = commandEndMessage(*result, timeout)
+		switch {
+		case result.End == terminal.EndTimeout:
+			run.Status = models.RunTimeout
+		case result.End == terminal.EndCanceled:
+			run.Status = models.RunCanceled
+		case result.ExitCode == 0:
+			run.Status = models.RunSucceeded
+		default:
+			run.Status = models.RunFailed
+		}
+	}
+	if err := h.store.SaveRun(run); err != nil {
+		log.Printf("Failed to save run of runbook %s: %v", run.RunbookName, err)
+	}
+	liveHub.Publish(liveTopicEvents, gin.H{
+		"type":      "runbook_run",
+		"id":        run.ID,
+		"runbookId": run.RunbookID,
+		"runbook":   run.RunbookName,
+		"user":      run.User,
+		"status":    run.Status,
+	})
+}
+
+// GetRuns godoc
+// @Summary Get the runs of runbooks, newest first
+// @Description Without their output. Admins see the runs of every user, others their own.
+// @Tags Runbooks
+// @Param runbookId query int false "Runbook ID"
+// @Param user query string false "User, for admins"
+// @Param limit query int false "Limit results" default(100)
+// @Success 200 {object} gin.H
+// @Router /api/runbooks/runs [get]
+func (h *RunbookHandler) GetRuns(c *gin.Context) {
+	var runbookID int64
+	if id := c.Query("runbookId"); id != "" {
+		var err error
+		if runbookID, err = strconv.ParseInt(id, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid runbookId parameter"})
+			return
+		}
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit parameter"})
+		return
+	}
+	user := sessionUser(c)
+	if userRole(user) == models.RoleAdmin {
+		user = c.Query("user")
+	}
+
+	runs, err := h.store.GetRuns(runbookID, user, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"runs": runs})
+}
+
+// GetRun godoc
+// @Summary Get a run of a runbook with its output
+// @Tags Runbooks
+// @Param runId path int true "Run ID"
+// @Success 200 {object} gin.H
+// @Router /api/runbooks/runs/{runId} [get]
+func (h *RunbookHandler) GetRun(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("runId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid run ID"})
+		return
+	}
+	run, err := h.store.GetRun(id)
+	user := sessionUser(c)
+	if errors.Is(err, gorm.ErrRecordNotFound) || err == nil && run.User != user && userRole(user) != models.RoleAdmin {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Run not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"run": run})
+}
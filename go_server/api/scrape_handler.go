@@ -0,0 +1,35 @@
+package api
+
+import (
+	"control/go_server/config"
+	"control/go_server/internal/scrape"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Scraper of the application metrics endpoints declared by services
+var metricsScraper *scrape.Scraper
+
+// startMetricsScraper starts scraping service targets into the metrics store
+func startMetricsScraper() {
+	metricsScraper = scrape.NewScraper(metricsDB)
+	metricsScraper.SetTargets(scrape.TargetsFor(config.Conf.Services))
+	go metricsScraper.Run(make(chan struct{}))
+}
+
+// GetScrapeTargetsHandler lists the scrape targets and the result of their last scrape
+func GetScrapeTargetsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"success": true, "targets": metricsScraper.Status()})
+}
+
+// ScrapeTargetHandler scrapes a target immediately and returns the result
+func ScrapeTargetHandler(c *gin.Context) {
+	target, found := metricsScraper.Target(c.Param("serviceName"), c.Param("name"))
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Scrape target not found"})
+		return
+	}
+	status := metricsScraper.Scrape(target)
+	c.JSON(http.StatusOK, gin.H{"success": status.Up, "target": status})
+}
@@ -1,7 +1,6 @@
 package api
 
 import (
-	"control/go_server/config"
 	"control/go_server/internal/models"
 	"control/go_server/internal/utils"
 	"fmt"
@@ -10,8 +9,6 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strings"
-	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -25,6 +22,12 @@ func ServiceStatusHandler(c *gin.Context) {
 		return
 	}
 
+	// Services in the config are scanned by the metrics collection
+	if status, ok := cachedServiceStatus(serviceName); ok {
+		c.JSON(http.StatusOK, gin.H{"status": status})
+		return
+	}
+
 	pids, _ := utils.FindPidsByName(serviceName)
 	status := "stopped"
 	if len(pids) > 0 {
@@ -33,27 +36,13 @@ func ServiceStatusHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"status": status})
 }
 
-// ServicesStatusHandler checks the status of all services.
+// ServicesStatusHandler returns the status of all services from the last
+// metrics collection.
 func ServicesStatusHandler(c *gin.Context) {
-	statusMap := make(map[string]string)
-	var wg sync.WaitGroup
-	var mu sync.Mutex
-
-	for _, service := range config.Conf.Services {
-		wg.Add(1)
-		go func(s models.Service) {
-			defer wg.Done()
-			pids, _ := utils.FindPidsByName(s.Name)
-			status := "stopped"
-			if len(pids) > 0 {
-				status = "running"
-			}
-			mu.Lock()
-			statusMap[s.Name] = status
-			mu.Unlock()
-		}(service)
-	}
-	wg.Wait()
+	statusMap, ok := liveHub.State(liveTopicServices)
+	if !ok {
+		statusMap = map[string]string{}
+	}
 	c.JSON(http.StatusOK, statusMap)
 }
 
@@ -64,6 +53,15 @@ func ServiceStartHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing required parameters"})
 		return
 	}
+	if handled, err := containerAction(req.Name, startContainer); handled {
+		containerActionResponse(c, "start", "started", err)
+		return
+	}
+	runDeployScript(c, req)
+}
+
+// runDeployScript starts a service's deploy script in the background
+func runDeployScript(c *gin.Context, req models.Service) {
 	scriptPath := filepath.Join(req.Path, req.DeployScript)
 	if _, err := os.Stat(scriptPath); os.IsNotExist(err) {
 		c.JSON(http.StatusOK, gin.H{"success": false, "message": fmt.Sprintf("Script %s does not exist in %s", req.DeployScript, req.Path)})
@@ -134,6 +132,9 @@ cd %s
 	go func() {
 		cmd.Wait()
 		os.Remove(tmpFile.Name()) // Clean up temp file
+
+		pids, _ := utils.FindPidsByName(req.Name)
+		updateServiceStatus(req.Name, len(pids) > 0)
 	}()
 
 	// Return immediately without waiting for completion
@@ -149,6 +150,10 @@ func ServiceStopHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Service name is required"})
 		return
 	}
+	if handled, err := containerAction(req.ServiceName, stopContainer); handled {
+		containerActionResponse(c, "stop", "stopped", err)
+		return
+	}
 
 	cmd := exec.Command("pkill", "-f", req.ServiceName)
 	cmd.Run() // Ignore error, pkill returns 1 if no process is found
@@ -156,6 +161,7 @@ func ServiceStopHandler(c *gin.Context) {
 	time.Sleep(1 * time.Second)
 
 	pids, _ := utils.FindPidsByName(req.ServiceName)
+	updateServiceStatus(req.ServiceName, len(pids) > 0)
 	if len(pids) == 0 {
 		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Service stopped successfully"})
 	} else {
@@ -163,32 +169,17 @@ func ServiceStopHandler(c *gin.Context) {
 	}
 }
 
-// ServiceRestartHandler restarts a service.
+// ServiceRestartHandler restarts a service. Containers are restarted; for
+// processes, restart is the same as start.
 func ServiceRestartHandler(c *gin.Context) {
-	// In this implementation, restart is the same as start
-	ServiceStartHandler(c)
-}
-
-// LogsHandler gets the logs of a service.
-func LogsHandler(c *gin.Context) {
-	serviceName := c.Param("serviceName")
-	lines := c.DefaultQuery("lines", "100")
-
-	service, found := utils.FindServiceByName(serviceName)
-	if !found {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Service not found"})
+	var req models.Service
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing required parameters"})
 		return
 	}
-
-	logPath := filepath.Join(service.Path, "run.log")
-	cmd := exec.Command("tail", "-n", lines, logPath)
-	output, err := cmd.CombinedOutput()
-
-	if err != nil {
-		c.JSON(http.StatusOK, gin.H{"serviceName": serviceName, "logPath": logPath, "lines": []string{fmt.Sprintf("无法读取日志文件: %s", err.Error())}})
+	if handled, err := containerAction(req.Name, restartContainer); handled {
+		containerActionResponse(c, "restart", "restarted", err)
 		return
 	}
-
-	logLines := strings.Split(strings.TrimSpace(string(output)), "\n")
-	c.JSON(http.StatusOK, gin.H{"serviceName": serviceName, "logPath": logPath, "totalLines": len(logLines), "lines": logLines})
+	runDeployScript(c, req)
 }
@@ -1,16 +1,18 @@
 package api
 
 import (
-	"bufio"
 	"control/go_server/config"
+	"control/go_server/internal/goruntime"
 	"control/go_server/internal/models"
-	"control/go_server/internal/storage"
+	"control/go_server/internal/terminal"
+	"control/go_server/internal/tsdb"
 	"control/go_server/internal/utils"
+	"errors"
+	"fmt"
 	"io"
+	"log"
 	"net/http"
-	"os"
-	"os/exec"
-	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -22,158 +24,306 @@ import (
 	"github.com/shirou/gopsutil/v3/load"
 	"github.com/shirou/gopsutil/v3/mem"
 	"github.com/shirou/gopsutil/v3/net"
-	"github.com/shirou/gopsutil/v3/process"
 )
 
-// TerminalSession represents a terminal session state
-type TerminalSession struct {
-	ID          string
-	WorkingDir  string
-	Environment map[string]string
-	LastUsed    time.Time
+// terminalCommands are the running /terminal/execute commands
+var terminalCommands = terminal.NewRunner()
+
+// Persistent metrics store, opened by InitMetricsStore once the config is loaded
+var metricsDB *tsdb.DB
+
+// Series names written by the metrics collection routine
+const (
+	seriesServiceCPU            = "service_cpu_percent"
+	seriesServiceMemory         = "service_memory_mb"
+	seriesServiceTCPConnections = "service_tcp_connections"
+)
+
+// serviceHistoryField maps a per-service series to its field in the history and live responses
+type serviceHistoryField struct {
+	key    string
+	series string
+	value  func(utils.ProcessStats) float64
+}
+
+var serviceHistoryFields = []serviceHistoryField{
+	{"cpu", seriesServiceCPU, func(s utils.ProcessStats) float64 { return s.CPUPercent }},
+	{"memory", seriesServiceMemory, func(s utils.ProcessStats) float64 { return s.MemoryMB }},
+	{"openFds", "service_open_fds", func(s utils.ProcessStats) float64 { return float64(s.OpenFDs) }},
+	{"fdLimit", "service_fd_limit", func(s utils.ProcessStats) float64 { return float64(s.FDLimit) }},
+	{"threads", "service_threads", func(s utils.ProcessStats) float64 { return float64(s.Threads) }},
+	{"readBytesPerSec", "service_read_bytes_per_sec", func(s utils.ProcessStats) float64 { return s.ReadBytesPerSec }},
+	{"writeBytesPerSec", "service_write_bytes_per_sec", func(s utils.ProcessStats) float64 { return s.WriteBytesPerSec }},
+	{"voluntaryCtxSwitchesPerSec", "service_voluntary_ctx_switches_per_sec", func(s utils.ProcessStats) float64 { return s.VoluntaryCtxSwitchesPerSec }},
+	{"involuntaryCtxSwitchesPerSec", "service_involuntary_ctx_switches_per_sec", func(s utils.ProcessStats) float64 { return s.InvoluntaryCtxSwitchesPerSec }},
+	{"uptimeSeconds", "service_uptime_seconds", func(s utils.ProcessStats) float64 { return s.UptimeSeconds }},
+}
+
+// runtimeHistoryField maps a Go runtime series of a service to its field in the history response
+type runtimeHistoryField struct {
+	key    string
+	series string
+	value  func(goruntime.Stats) float64
+}
+
+var runtimeHistoryFields = []runtimeHistoryField{
+	{"goroutines", "go_goroutines", func(s goruntime.Stats) float64 { return float64(s.Goroutines) }},
+	{"heapAlloc", "go_heap_alloc_bytes", func(s goruntime.Stats) float64 { return float64(s.HeapAlloc) }},
+	{"heapInuse", "go_heap_inuse_bytes", func(s goruntime.Stats) float64 { return float64(s.HeapInuse) }},
+	{"numGC", "go_gc_count", func(s goruntime.Stats) float64 { return float64(s.NumGC) }},
+	{"gcPauseP50", "go_gc_pause_p50_seconds", func(s goruntime.Stats) float64 { return s.GCPause.P50 }},
+	{"gcPauseP95", "go_gc_pause_p95_seconds", func(s goruntime.Stats) float64 { return s.GCPause.P95 }},
+	{"gcPauseP99", "go_gc_pause_p99_seconds", func(s goruntime.Stats) float64 { return s.GCPause.P99 }},
+	{"gcPauseMax", "go_gc_pause_max_seconds", func(s goruntime.Stats) float64 { return s.GCPause.Max }},
+}
+
+// historySeries is a field of the service history response and the series it is read from
+type historySeries struct {
+	key    string
+	series string
+}
+
+// serviceHistorySeries lists the process and runtime fields of the service history
+func serviceHistorySeries() []historySeries {
+	var list []historySeries
+	for _, field := range serviceHistoryFields {
+		list = append(list, historySeries{field.key, field.series})
+	}
+	for _, field := range runtimeHistoryFields {
+		list = append(list, historySeries{field.key, field.series})
+	}
+	return list
 }
 
-// Global session storage (in production, use proper storage)
-var terminalSessions = make(map[string]*TerminalSession)
-var sessionMutex sync.RWMutex
+// runtimeStatus is the cached result of the last runtime collection of a service
+type runtimeStatus struct {
+	Stats       *goruntime.Stats `json:"stats,omitempty"` // last successful reading
+	Error       string           `json:"error,omitempty"`
+	LastAttempt time.Time        `json:"lastAttempt"`
+}
 
-// Global memory store for metrics history
-var metricsStore = storage.NewMemoryStore()
+// tcpStates are the connection states stored for every service, so a state
+// that drops to zero is recorded as zero instead of disappearing
+var tcpStates = []string{"ESTABLISHED", "LISTEN", "SYN_SENT", "SYN_RECV", "FIN_WAIT1", "FIN_WAIT2", "TIME_WAIT", "CLOSE", "CLOSE_WAIT", "LAST_ACK", "CLOSING"}
 
-// Start metrics collection routine
-func init() {
+// Per-process rate state and the latest stats of each service, keyed by service name
+var (
+	processSampler     = utils.NewProcessSampler()
+	latestProcessStats sync.Map
+)
+
+// Go runtime readings of services with a pprof URL, keyed by service name.
+// The dashboard is served from this cache instead of querying pprof per request.
+var (
+	runtimeClient         = &http.Client{Timeout: 5 * time.Second}
+	latestRuntimeStatuses sync.Map
+)
+
+// InitMetricsStore opens the metrics store and starts collecting service metrics into it
+func InitMetricsStore() error {
+	retention := config.Conf.MetricsRetention
+	db, err := tsdb.Open(config.Conf.MetricsDir, map[tsdb.Resolution]time.Duration{
+		tsdb.Raw:        time.Duration(retention.RawHours) * time.Hour,
+		tsdb.Minute:     time.Duration(retention.MinuteDays) * 24 * time.Hour,
+		tsdb.FiveMinute: time.Duration(retention.FiveMinuteDays) * 24 * time.Hour,
+		tsdb.Hour:       time.Duration(retention.HourDays) * 24 * time.Hour,
+	})
+	if err != nil {
+		return err
+	}
+	metricsDB = db
 	go metricsCollectionRoutine()
+	startMetricsScraper()
+	startAnomalyDetection()
+	startExhaustionWarnings()
+	return nil
+}
+
+// CloseMetricsStore writes buffered points and open rollups to disk
+func CloseMetricsStore() error {
+	if metricsDB == nil {
+		return nil
+	}
+	return metricsDB.Close()
 }
 
 // metricsCollectionRoutine periodically collects and stores metrics
 func metricsCollectionRoutine() {
 	ticker := time.NewTicker(10 * time.Second) // collect every 10 seconds
 	defer ticker.Stop()
-	
+
+	// Collect once right away so the dashboard has a snapshot to serve
+	collectAndStoreMetrics()
+
+	lastRetention := time.Now()
 	for range ticker.C {
 		collectAndStoreMetrics()
-	}
-}
+		collectAndStoreHostMetrics(time.Now())
+		if err := metricsDB.Flush(); err != nil {
+			log.Printf("Failed to flush metrics: %v", err)
+		}
 
-// collectAndStoreMetrics collects metrics for all services and stores them
-func collectAndStoreMetrics() {
-	var wg sync.WaitGroup
-	
-	for _, service := range config.Conf.Services {
-		wg.Add(1)
-		go func(s models.Service) {
-			defer wg.Done()
-			
-			pids, _ := utils.FindPidsByName(s.Name)
-			
-			if len(pids) > 0 {
-				var totalCpu float64
-				var totalMemory float64 // in MB
-				
-				for _, pid := range pids {
-					proc, err := process.NewProcess(pid)
-					if err != nil {
-						continue
-					}
-					cpuPercent, _ := proc.CPUPercent()
-					memInfo, _ := proc.MemoryInfo()
-					
-					totalCpu += cpuPercent
-					totalMemory += float64(memInfo.RSS) / 1024 / 1024 // Bytes to MB
-				}
-				
-				// Store metrics in memory
-				metricsStore.AddMetric(s.Name, totalCpu, totalMemory)
+		// Drop expired segments hourly
+		if time.Since(lastRetention) >= time.Hour {
+			if err := metricsDB.ApplyRetention(time.Now()); err != nil {
+				log.Printf("Failed to apply metrics retention: %v", err)
 			}
-		}(service)
+			lastRetention = time.Now()
+		}
 	}
-	
-	wg.Wait()
 }
 
-// SystemMetricsHandler gets metrics for all services.
-func SystemMetricsHandler(c *gin.Context) {
-	metricsData := make(map[string]any)
+// collectAndStoreMetrics collects metrics for all services, stores them and
+// publishes the services' live snapshot
+func collectAndStoreMetrics() {
 	var wg sync.WaitGroup
 	var mu sync.Mutex
+	now := time.Now()
+	snapshot := make(map[string]gin.H)
 
+	refreshContainers()
 	for _, service := range config.Conf.Services {
 		wg.Add(1)
 		go func(s models.Service) {
 			defer wg.Done()
-			pids, _ := utils.FindPidsByName(s.Name)
-
-			metric := gin.H{
-				"serviceName": s.Name,
-				"status":      "stopped",
-				"cpu":         0,
-				"memory":      0,
-				"processes":   0,
-				"goroutines":  0,
-				"ports":       []string{},
-				"timestamp":   time.Now().UnixMilli(),
-			}
 
-			if len(pids) > 0 {
-				metric["status"] = "running"
-				metric["processes"] = len(pids)
-				var totalCpu float64
-				var totalMemory float64 // in MB
-
-				for _, pid := range pids {
-					proc, err := process.NewProcess(pid)
-					if err != nil {
-						continue
-					}
-					cpuPercent, _ := proc.CPUPercent()
-					memInfo, _ := proc.MemoryInfo()
-
-					totalCpu += cpuPercent
-					totalMemory += float64(memInfo.RSS) / 1024 / 1024 // Bytes to MB
-				}
-				metric["cpu"] = totalCpu
-				metric["memory"] = totalMemory
-
-				// Get listening ports for the service
-				ports, err := utils.GetServicePorts(s.Name)
-				if err == nil {
-					metric["ports"] = ports
-				} else {
-					metric["ports"] = []string{}
-				}
-
-				// Get goroutine count if pprof is available
-				if s.PprofURL != "" {
-					goroutineURL := s.PprofURL + "goroutine"
-					resp, err := http.Get(goroutineURL)
-					if err == nil {
-						defer resp.Body.Close()
-						body, err := io.ReadAll(resp.Body)
-						if err == nil {
-							scanner := bufio.NewScanner(strings.NewReader(string(body)))
-							for scanner.Scan() {
-								line := scanner.Text()
-								if strings.HasPrefix(line, "goroutine profile: total ") {
-									parts := strings.Split(line, " ")
-									if len(parts) == 4 {
-										count, _ := strconv.Atoi(parts[3])
-										metric["goroutines"] = count
-									}
-									break
-								}
-							}
-						}
-					}
-				}
+			// pprof endpoints may be on another host, so collect even without local processes
+			if s.PprofURL != "" {
+				collectRuntimeMetrics(s, now)
 			}
 
+			var metric gin.H
+			if containers, ok := containersOf(s); ok {
+				metric = containerServiceMetric(s.Name, containers, now)
+			} else {
+				pids, _ := utils.FindPidsByName(s.Name)
+				metric = serviceMetric(s.Name, pids, now)
+			}
+			serviceProcessesGauge.Set(float64(metric["processes"].(int)), s.Name)
 			mu.Lock()
-			metricsData[s.Name] = metric
+			snapshot[s.Name] = metric
 			mu.Unlock()
+
+			stats, ok := latestProcessStats.Load(s.Name)
+			if !ok {
+				serviceUpGauge.Set(0, s.Name)
+				serviceCPUGauge.Set(0, s.Name)
+				serviceMemoryGauge.Set(0, s.Name)
+				return
+			}
+			storeProcessStats(s.Name, stats.(utils.ProcessStats), now)
 		}(service)
 	}
 
 	wg.Wait()
+	publishServiceSnapshot(snapshot)
+}
+
+// serviceMetric samples a service's processes and returns its entry in the
+// /system-metrics response, caching the process stats on the way
+func serviceMetric(name string, pids []int32, now time.Time) gin.H {
+	metric := gin.H{
+		"serviceName": name,
+		"status":      "stopped",
+		"cpu":         0,
+		"memory":      0,
+		"processes":   0,
+		"goroutines":  0,
+		"ports":       []string{},
+		"timestamp":   now.UnixMilli(),
+	}
+
+	if len(pids) == 0 {
+		latestProcessStats.Delete(name)
+	} else {
+		stats := processSampler.Sample(name, pids)
+		latestProcessStats.Store(name, stats)
+
+		metric["status"] = "running"
+		metric["processes"] = len(pids)
+		metric["cpu"] = stats.CPUPercent
+		metric["memory"] = stats.MemoryMB
+		// FDs, threads, IO and connection stats
+		metric["process"] = stats
+		metric["ports"] = utils.GetPidsPorts(pids)
+	}
+
+	// Goroutines, heap and GC from the last runtime collection
+	if status, ok := latestRuntimeStatuses.Load(name); ok {
+		status := status.(runtimeStatus)
+		metric["runtime"] = status
+		if status.Stats != nil {
+			metric["goroutines"] = status.Stats.Goroutines
+		}
+	}
+	return metric
+}
+
+// storeProcessStats updates the gauges of a running service and stores its process stats
+func storeProcessStats(name string, stats utils.ProcessStats, now time.Time) {
+	serviceUpGauge.Set(1, name)
+	serviceCPUGauge.Set(stats.CPUPercent, name)
+	serviceMemoryGauge.Set(stats.MemoryMB*1024*1024, name)
+	serviceOpenFDsGauge.Set(float64(stats.OpenFDs), name)
+	serviceThreadsGauge.Set(float64(stats.Threads), name)
+
+	labels := tsdb.Labels{"service": name}
+	for _, field := range serviceHistoryFields {
+		if err := metricsDB.Append(field.series, labels, now, field.value(stats)); err != nil {
+			log.Printf("Failed to store metrics of %s: %v", name, err)
+			return
+		}
+	}
+	for _, state := range tcpStates {
+		count := float64(stats.TCPConnections[state])
+		serviceTCPConnectionsGauge.Set(count, name, state)
+		metricsDB.Append(seriesServiceTCPConnections, tsdb.Labels{"service": name, "state": state}, now, count)
+	}
+}
+
+// collectRuntimeMetrics reads the Go runtime stats of a service, caches them
+// for the dashboard and stores them. A failed reading keeps the last good stats
+// in the cache but drops the gauges, so a dead endpoint isn't reported as live.
+func collectRuntimeMetrics(s models.Service, now time.Time) {
+	status := runtimeStatus{LastAttempt: now}
+	if prev, ok := latestRuntimeStatuses.Load(s.Name); ok {
+		status.Stats = prev.(runtimeStatus).Stats
+	}
+
+	stats, err := goruntime.Fetch(runtimeClient, s.PprofURL)
+	if err != nil {
+		status.Error = err.Error()
+		latestRuntimeStatuses.Store(s.Name, status)
+		serviceGoroutinesGauge.Delete(s.Name)
+		serviceHeapInuseGauge.Delete(s.Name)
+		serviceGCPauseGauge.Delete(s.Name, "0.5")
+		serviceGCPauseGauge.Delete(s.Name, "0.99")
+		return
+	}
+	status.Stats = &stats
+	latestRuntimeStatuses.Store(s.Name, status)
+
+	serviceGoroutinesGauge.Set(float64(stats.Goroutines), s.Name)
+	serviceHeapInuseGauge.Set(float64(stats.HeapInuse), s.Name)
+	serviceGCPauseGauge.Set(stats.GCPause.P50, s.Name, "0.5")
+	serviceGCPauseGauge.Set(stats.GCPause.P99, s.Name, "0.99")
+
+	labels := tsdb.Labels{"service": s.Name}
+	for _, field := range runtimeHistoryFields {
+		if err := metricsDB.Append(field.series, labels, now, field.value(stats)); err != nil {
+			log.Printf("Failed to store runtime metrics of %s: %v", s.Name, err)
+			return
+		}
+	}
+}
+
+// SystemMetricsHandler gets metrics for all services from the last
+// collection; the live stream pushes the same snapshot as it is collected.
+func SystemMetricsHandler(c *gin.Context) {
+	metricsData, ok := liveHub.State(liveTopicMetrics)
+	if !ok {
+		metricsData = gin.H{}
+	}
 	c.JSON(http.StatusOK, metricsData)
 }
 
@@ -206,6 +356,11 @@ func SystemInfoHandler(c *gin.Context) {
 	// Uptime
 	uptime, _ := utils.GetUptime()
 
+	// Rates and per-device usage from the last host collection
+	latestHostStats.RLock()
+	hostStats := latestHostStats.stats
+	latestHostStats.RUnlock()
+
 	c.JSON(http.StatusOK, gin.H{
 		"cpu": gin.H{
 			"usage": cpuUsage[0],
@@ -228,41 +383,21 @@ func SystemInfoHandler(c *gin.Context) {
 		"uptime":      uptime,
 		"loadAverage": []float64{loadAvg.Load1, loadAvg.Load5, loadAvg.Load15},
 		"processes":   serviceProcesses,
+		"host":        hostStats,
 	})
 }
 
-// getOrCreateSession gets or creates a terminal session
-func getOrCreateSession(sessionID string) *TerminalSession {
-	sessionMutex.Lock()
-	defer sessionMutex.Unlock()
-	
-	if session, exists := terminalSessions[sessionID]; exists {
-		session.LastUsed = time.Now()
-		return session
-	}
-	
-	// Create new session
-	homeDir, _ := os.UserHomeDir()
-	if homeDir == "" {
-		homeDir = "/root"
-	}
-	
-	session := &TerminalSession{
-		ID:          sessionID,
-		WorkingDir:  homeDir,
-		Environment: make(map[string]string),
-		LastUsed:    time.Now(),
-	}
-	
-	terminalSessions[sessionID] = session
-	return session
-}
-
 // ExecuteCommandHandler executes a command on the server.
 func ExecuteCommandHandler(c *gin.Context) {
 	var req struct {
 		Command   string `json:"command"`
 		SessionID string `json:"sessionId"`
+		// ApprovalID runs a command held for approval once it was approved
+		ApprovalID int64 `json:"approvalId"`
+		// Timeout in seconds, up to the configured maximum
+		Timeout int `json:"timeout"`
+		// Stream streams the output as server-sent events
+		Stream bool `json:"stream"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Command is required"})
@@ -270,115 +405,246 @@ func ExecuteCommandHandler(c *gin.Context) {
 	}
 
 	// Get or create session
-	session := getOrCreateSession(req.SessionID)
-	
-	// Basic security check
-	forbidden := []string{"rm -rf /", "mkfs", "format", "fdisk"}
-	for _, f := range forbidden {
-		if strings.Contains(strings.ToLower(req.Command), f) {
-			c.JSON(http.StatusOK, gin.H{
-				"command":   req.Command,
-				"sessionId": req.SessionID,
-				"stdout":    "",
-				"stderr":    "Command not allowed for security reasons",
-				"exitCode":  1,
-				"timestamp": time.Now().UTC().Format(time.RFC3339),
-			})
-			return
-		}
+	user := sessionUser(c)
+	session, err := getOrCreateSession(user, req.SessionID)
+	if errors.Is(err, errSessionNotOwned) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden", "message": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session", "message": err.Error()})
+		return
 	}
+	dir, env := session.state()
 
-	// Handle cd command specially
-	if strings.HasPrefix(strings.TrimSpace(req.Command), "cd ") {
-		handleCdCommand(req.Command, session, c)
+	// Every command is recorded, refused ones included; a command that can't be recorded isn't run
+	rec, err := terminalRecordings.recordCommand(user, session.ID, dir, req.Command)
+	if err != nil {
+		log.Printf("Failed to record terminal command: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record command", "message": err.Error()})
+		return
+	}
+
+	decision, approval := commandPolicy.authorize(user, session.ID, commandSourceExecute, req.Command, dir, req.ApprovalID)
+	switch decision.Action {
+	case models.PolicyDeny:
+		message := "Command denied by policy: " + decision.Reason
+		rec.Finish("", message, 1)
+		c.JSON(http.StatusOK, gin.H{
+			"command":   req.Command,
+			"sessionId": session.ID,
+			"stdout":    "",
+			"stderr":    message,
+			"exitCode":  1,
+			"policy":    decision,
+			"timestamp": time.Now().UTC().Format(time.RFC3339),
+		})
 		return
+	case models.PolicyApprove:
+		message := fmt.Sprintf("Command requires approval (%s); held as #%d until %s", decision.Reason, approval.ID, approval.ExpiresAt.Format("2006-01-02 15:04:05"))
+		rec.Finish("", message, 1)
+		c.JSON(http.StatusAccepted, gin.H{
+			"command":    req.Command,
+			"sessionId":  session.ID,
+			"stdout":     "",
+			"stderr":     message,
+			"exitCode":   1,
+			"policy":     decision,
+			"approvalId": approval.ID,
+			"status":     approval.Status,
+			"expiresAt":  approval.ExpiresAt,
+			"timestamp":  time.Now().UTC().Format(time.RFC3339),
+		})
+		return
+	}
+
+	timeout := config.Conf.Terminal.CommandTimeout
+	if req.Timeout > 0 {
+		timeout = min(time.Duration(req.Timeout)*time.Second, config.Conf.Terminal.MaxCommandTimeout)
+	}
+	// The session's working directory and environment, like a shell carries
+	// them from one command to the next
+	opts := terminal.CommandOptions{
+		Shell:     config.Conf.Terminal.Shell,
+		Command:   req.Command,
+		Dir:       dir,
+		Env:       env,
+		Timeout:   timeout,
+		MaxOutput: config.Conf.Terminal.MaxCommandOutput,
+		Output:    func(_ terminal.Stream, data []byte) { rec.Output(data) },
+		State:     true,
 	}
 
-	// Execute regular command
-	cmd := exec.Command("bash", "-c", req.Command)
-	cmd.Dir = session.WorkingDir
-	cmd.Env = os.Environ() // Inherit all environment variables
-	
-	// Add session-specific environment variables
-	for k, v := range session.Environment {
-		cmd.Env = append(cmd.Env, k+"="+v)
+	if req.Stream {
+		streamCommand(c, user, session, opts, rec)
+		return
 	}
-	
-	output, err := cmd.CombinedOutput()
 
-	exitCode := 0
+	cmd, err := terminalCommands.Start(user, session.ID, opts)
 	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			exitCode = exitErr.ExitCode()
-		} else {
-			exitCode = 1
-		}
+		rec.Finish("", err.Error(), 1)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start command", "message": err.Error()})
+		return
 	}
+	// Nobody is left to read the output of a request that went away
+	select {
+	case <-cmd.Done():
+	case <-c.Request.Context().Done():
+		cmd.Cancel()
+	}
+	result := cmd.Wait()
+	session.finish(req.Command, dir, result)
+	rec.Finish("", commandEndMessage(result, timeout), result.ExitCode)
 
 	c.JSON(http.StatusOK, gin.H{
-		"command":     req.Command,
-		"sessionId":   req.SessionID,
-		"stdout":      string(output),
-		"stderr":      "",
-		"exitCode":    exitCode,
-		"workingDir":  session.WorkingDir,
-		"timestamp":   time.Now().UTC().Format(time.RFC3339),
+		"command":         req.Command,
+		"commandId":       cmd.ID,
+		"sessionId":       session.ID,
+		"stdout":          result.Stdout,
+		"stderr":          result.Stderr,
+		"stdoutTruncated": result.StdoutTruncated,
+		"stderrTruncated": result.StderrTruncated,
+		"exitCode":        result.ExitCode,
+		"end":             result.End,
+		"message":         commandEndMessage(result, timeout),
+		"durationMs":      result.DurationMs,
+		"workingDir":      session.workingDir(),
+		"timestamp":       time.Now().UTC().Format(time.RFC3339),
 	})
 }
 
-// handleCdCommand handles cd command specifically
-func handleCdCommand(command string, session *TerminalSession, c *gin.Context) {
-	parts := strings.Fields(command)
-	var targetDir string
-	
-	if len(parts) == 1 {
-		// cd with no arguments - go to home directory
-		homeDir, _ := os.UserHomeDir()
-		if homeDir == "" {
-			homeDir = "/root"
+// streamCommand runs a command and streams its output as server-sent events:
+// start with the command's ID, stdout and stderr with each chunk of output,
+// and exit once it ended
+func streamCommand(c *gin.Context, user string, session *TerminalSession, opts terminal.CommandOptions, rec *commandRecording) {
+	type chunk struct {
+		stream terminal.Stream
+		data   string
+	}
+	chunks := make(chan chunk, 64)
+	gone := make(chan struct{})
+	ctx := c.Request.Context()
+	record := opts.Output
+	opts.Output = func(stream terminal.Stream, data []byte) {
+		record(stream, data)
+		// A client that went away never reads the queue, so it mustn't hold
+		// the command's output back until the stream ends
+		select {
+		case chunks <- chunk{stream, string(data)}:
+		case <-ctx.Done():
+		case <-gone:
 		}
-		targetDir = homeDir
-	} else {
-		targetDir = parts[1]
-	}
-	
-	// Handle relative paths
-	if !filepath.IsAbs(targetDir) {
-		targetDir = filepath.Join(session.WorkingDir, targetDir)
-	}
-	
-	// Clean the path
-	targetDir = filepath.Clean(targetDir)
-	
-	// Check if directory exists
-	if _, err := os.Stat(targetDir); os.IsNotExist(err) {
-		c.JSON(http.StatusOK, gin.H{
-			"command":     command,
-			"sessionId":   session.ID,
-			"stdout":      "",
-			"stderr":      "bash: cd: " + parts[1] + ": No such file or directory",
-			"exitCode":    1,
-			"workingDir":  session.WorkingDir,
-			"timestamp":   time.Now().UTC().Format(time.RFC3339),
-		})
+	}
+
+	cmd, err := terminalCommands.Start(user, session.ID, opts)
+	if err != nil {
+		rec.Finish("", err.Error(), 1)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start command", "message": err.Error()})
 		return
 	}
-	
-	// Update session working directory
-	sessionMutex.Lock()
-	session.WorkingDir = targetDir
-	session.LastUsed = time.Now()
-	sessionMutex.Unlock()
-	
-	c.JSON(http.StatusOK, gin.H{
-		"command":     command,
-		"sessionId":   session.ID,
-		"stdout":      "",
-		"stderr":      "",
-		"exitCode":    0,
-		"workingDir":  session.WorkingDir,
-		"timestamp":   time.Now().UTC().Format(time.RFC3339),
+	defer close(gone)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no") // don't let nginx buffer the stream
+	c.SSEvent("start", gin.H{"commandId": cmd.ID, "sessionId": session.ID, "deadline": cmd.Deadline})
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(liveHeartbeatInterval)
+	defer heartbeat.Stop()
+	finished := false
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-ctx.Done():
+			cmd.Cancel()
+			return false
+		case ch := <-chunks:
+			c.SSEvent(string(ch.stream), gin.H{"data": ch.data})
+			return true
+		case <-heartbeat.C:
+			io.WriteString(w, ": heartbeat\n\n")
+			return true
+		case <-cmd.Done():
+		}
+		// Output written before the command exited is still queued
+		for len(chunks) > 0 {
+			ch := <-chunks
+			c.SSEvent(string(ch.stream), gin.H{"data": ch.data})
+		}
+		result := cmd.Wait()
+		session.finish(opts.Command, opts.Dir, result)
+		finished = true
+		c.SSEvent("exit", gin.H{
+			"exitCode":        result.ExitCode,
+			"end":             result.End,
+			"message":         commandEndMessage(result, opts.Timeout),
+			"stdoutTruncated": result.StdoutTruncated,
+			"stderrTruncated": result.StderrTruncated,
+			"durationMs":      result.DurationMs,
+			"workingDir":      session.workingDir(),
+		})
+		return false
 	})
+	result := cmd.Wait()
+	if !finished {
+		session.finish(opts.Command, opts.Dir, result)
+	}
+	rec.Finish("", commandEndMessage(result, opts.Timeout), result.ExitCode)
+}
+
+// commandEndMessage explains a command that didn't exit on its own or whose output was cut
+func commandEndMessage(result terminal.CommandResult, timeout time.Duration) string {
+	var message string
+	switch result.End {
+	case terminal.EndTimeout:
+		message = fmt.Sprintf("Command killed after timeout of %s", timeout)
+	case terminal.EndCanceled:
+		message = "Command canceled"
+	}
+	if result.StdoutTruncated || result.StderrTruncated {
+		if message != "" {
+			message += "; "
+		}
+		message += fmt.Sprintf("output truncated to %d bytes", config.Conf.Terminal.MaxCommandOutput)
+	}
+	return message
+}
+
+// GetCommandsHandler godoc
+// @Summary Get the running terminal commands
+// @Description Admins see the commands of every user, others their own
+// @Tags Terminal
+// @Success 200 {object} gin.H
+// @Router /api/terminal/commands [get]
+func GetCommandsHandler(c *gin.Context) {
+	user := sessionUser(c)
+	admin := userRole(user) == models.RoleAdmin
+	commands := []*terminal.Command{}
+	for _, cmd := range terminalCommands.List() {
+		if admin || cmd.User == user {
+			commands = append(commands, cmd)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"commands": commands})
+}
+
+// CancelCommandHandler godoc
+// @Summary Cancel a running terminal command
+// @Description Kills the command's process group. Admins may cancel the commands of every user, others their own.
+// @Tags Terminal
+// @Param id path string true "Command ID"
+// @Success 200 {object} gin.H
+// @Router /api/terminal/commands/{id}/cancel [post]
+func CancelCommandHandler(c *gin.Context) {
+	user := sessionUser(c)
+	cmd, ok := terminalCommands.Get(c.Param("id"))
+	if !ok || cmd.User != user && userRole(user) != models.RoleAdmin {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Command not found"})
+		return
+	}
+	cmd.Cancel()
+	log.Printf("Terminal command %s of %s canceled by %s", cmd.ID, cmd.User, user)
+	c.JSON(http.StatusOK, gin.H{"message": "Command canceled", "result": cmd.Wait()})
 }
 
 // HealthCheckHandler returns the health of the service.
@@ -386,65 +652,229 @@ func HealthCheckHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"status": "ok", "timestamp": time.Now().UTC().Format(time.RFC3339)})
 }
 
-// SystemMetricsHistoryHandler gets historical metrics for all services
-func SystemMetricsHistoryHandler(c *gin.Context) {
-	// Parse duration parameter (in minutes)
-	durationStr := c.DefaultQuery("duration", "60")
-	durationMinutes, err := strconv.Atoi(durationStr)
+// metricLabelsFromQuery reads repeated label=key:value parameters
+func metricLabelsFromQuery(c *gin.Context) tsdb.Labels {
+	labels := tsdb.Labels{}
+	for _, label := range c.QueryArray("label") {
+		parts := strings.SplitN(label, ":", 2)
+		if len(parts) == 2 && parts[0] != "" {
+			labels[parts[0]] = parts[1]
+		}
+	}
+	return labels
+}
+
+// historyWindow is the time range and resolution of a history request
+type historyWindow struct {
+	minutes    int
+	start      time.Time
+	end        time.Time
+	resolution tsdb.Resolution
+}
+
+// parseHistoryWindow reads the duration (in minutes, default 60) and optional
+// resolution parameters, replying with 400 when they are invalid. The
+// resolution is chosen from the duration unless given as raw, 1m, 5m or 1h.
+func parseHistoryWindow(c *gin.Context) (historyWindow, bool) {
+	durationMinutes, err := strconv.Atoi(c.DefaultQuery("duration", "60"))
 	if err != nil || durationMinutes <= 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid duration parameter"})
-		return
+		return historyWindow{}, false
 	}
-	
+
 	duration := time.Duration(durationMinutes) * time.Minute
-	
-	// Get all configured service names
-	var serviceNames []string
-	for _, service := range config.Conf.Services {
-		serviceNames = append(serviceNames, service.Name)
+	resolution := tsdb.ResolutionFor(duration)
+	if r := c.Query("resolution"); r != "" {
+		if resolution, err = tsdb.ParseResolution(r); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return historyWindow{}, false
+		}
+	}
+
+	now := time.Now()
+	return historyWindow{minutes: durationMinutes, start: now.Add(-duration), end: now, resolution: resolution}, true
+}
+
+// timeFormat returns the layout of timestampFormatted, with the date for windows over a day
+func (w historyWindow) timeFormat() string {
+	if w.end.Sub(w.start) > 24*time.Hour {
+		return "01-02 15:04"
+	}
+	return "15:04:05"
+}
+
+func (w historyWindow) timeRange() gin.H {
+	return gin.H{
+		"start":    w.start.UnixMilli(),
+		"end":      w.end.UnixMilli(),
+		"duration": w.minutes,
+	}
+}
+
+// SystemMetricsHistoryHandler gets historical metrics for all services. The
+// resolution is chosen from the duration unless given as raw, 1m, 5m or 1h.
+// With metric=name it returns every stored series of that metric instead,
+// optionally narrowed by repeated label=key:value parameters.
+func SystemMetricsHistoryHandler(c *gin.Context) {
+	window, ok := parseHistoryWindow(c)
+	if !ok {
+		return
+	}
+	resolution, startTime, now := window.resolution, window.start, window.end
+	timeFormat := window.timeFormat()
+
+	if metric := c.Query("metric"); metric != "" {
+		series, err := metricsDB.Select(metric, metricLabelsFromQuery(c), resolution, startTime, now)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read metrics history", "message": err.Error()})
+			return
+		}
+		if series == nil {
+			series = []tsdb.Series{}
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"metric":     metric,
+			"series":     series,
+			"resolution": resolution.String(),
+			"timeRange":  window.timeRange(),
+		})
+		return
+	}
+
+	// Samples of every per-service field, by service and then by timestamp
+	fieldSamples := make(map[string]map[string]map[int64]tsdb.Sample)
+	for _, field := range serviceHistorySeries() {
+		seriesList, err := metricsDB.Select(field.series, nil, resolution, startTime, now)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read metrics history", "message": err.Error()})
+			return
+		}
+		for _, series := range seriesList {
+			service := series.Labels["service"]
+			if fieldSamples[service] == nil {
+				fieldSamples[service] = make(map[string]map[int64]tsdb.Sample)
+			}
+			byTime := make(map[int64]tsdb.Sample, len(series.Samples))
+			for _, sample := range series.Samples {
+				byTime[sample.Time.UnixMilli()] = sample
+			}
+			fieldSamples[service][field.key] = byTime
+		}
+	}
+
+	// TCP connections by state, by service and then by timestamp
+	tcpSeries, err := metricsDB.Select(seriesServiceTCPConnections, nil, resolution, startTime, now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read metrics history", "message": err.Error()})
+		return
 	}
-	
-	// Get historical data from memory store
-	historyData := metricsStore.GetHistory(serviceNames, duration)
-	
-	// Format response according to frontend expectations
+	tcpByService := make(map[string]map[int64]map[string]float64)
+	for _, series := range tcpSeries {
+		service, state := series.Labels["service"], series.Labels["state"]
+		if tcpByService[service] == nil {
+			tcpByService[service] = make(map[int64]map[string]float64)
+		}
+		for _, sample := range series.Samples {
+			ts := sample.Time.UnixMilli()
+			if tcpByService[service][ts] == nil {
+				tcpByService[service][ts] = make(map[string]float64)
+			}
+			if sample.Avg > 0 {
+				tcpByService[service][ts][state] = sample.Avg
+			}
+		}
+	}
+
+	// Format response according to frontend expectations. There is a point
+	// for every collection that stored any field; rollups also carry the min
+	// and max of every field.
+	fields := serviceHistorySeries()
 	services := make(map[string]gin.H)
-	for serviceName, serviceHistory := range historyData {
-		// Format data points for frontend
-		var dataPoints []gin.H
-		for _, point := range serviceHistory.DataPoints {
-			dataPoints = append(dataPoints, gin.H{
-				"timestamp":          point.Timestamp.UnixMilli(),
-				"timestampFormatted": point.Timestamp.Format("15:04:05"),
-				"cpu":                point.CPU,
-				"memory":             point.Memory,
-			})
+	for _, service := range config.Conf.Services {
+		samples := fieldSamples[service.Name]
+		seen := make(map[int64]bool)
+		timestamps := []int64{}
+		for _, byTime := range samples {
+			for ts := range byTime {
+				if !seen[ts] {
+					seen[ts] = true
+					timestamps = append(timestamps, ts)
+				}
+			}
+		}
+		sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+
+		dataPoints := []gin.H{}
+		for _, ts := range timestamps {
+			point := gin.H{
+				"timestamp":          ts,
+				"timestampFormatted": time.UnixMilli(ts).Format(timeFormat),
+			}
+			for _, field := range fields {
+				sample, ok := samples[field.key][ts]
+				if !ok {
+					continue
+				}
+				point[field.key] = sample.Avg
+				if resolution != tsdb.Raw {
+					point[field.key+"Min"] = sample.Min
+					point[field.key+"Max"] = sample.Max
+				}
+			}
+			if tcp, ok := tcpByService[service.Name][ts]; ok {
+				point["tcpConnections"] = tcp
+			}
+			dataPoints = append(dataPoints, point)
+		}
+
+		status := "unknown"
+		if last, ok := metricsDB.Last(seriesServiceCPU, tsdb.Labels{"service": service.Name}); ok {
+			status = "stopped"
+			if time.Since(last) < 2*time.Minute {
+				status = "running"
+			}
+		} else if len(dataPoints) > 0 {
+			status = "stopped"
 		}
-		
-		services[serviceName] = gin.H{
-			"serviceName": serviceHistory.ServiceName,
-			"status":      serviceHistory.Status,
+
+		services[service.Name] = gin.H{
+			"serviceName": service.Name,
+			"status":      status,
 			"dataPoints":  dataPoints,
 		}
 	}
-	
-	now := time.Now()
-	startTime := now.Add(-duration)
-	
+
 	response := gin.H{
-		"services": services,
-		"timeRange": gin.H{
-			"start":    startTime.UnixMilli(),
-			"end":      now.UnixMilli(),
-			"duration": durationMinutes,
-		},
+		"services":   services,
+		"resolution": resolution.String(),
+		"timeRange":  window.timeRange(),
 	}
-	
+
 	c.JSON(http.StatusOK, response)
 }
 
 // MetricsStatsHandler returns statistics about the metrics storage
 func MetricsStatsHandler(c *gin.Context) {
-	stats := metricsStore.GetStats()
+	stats, err := metricsDB.Stats()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read metrics storage", "message": err.Error()})
+		return
+	}
 	c.JSON(http.StatusOK, stats)
 }
+
+// MetricsSeriesHandler lists the stored series, optionally of one metric name
+// and narrowed by repeated label=key:value parameters
+func MetricsSeriesHandler(c *gin.Context) {
+	match := metricLabelsFromQuery(c)
+	series := []tsdb.SeriesInfo{}
+	names := make(map[string]int)
+	for _, info := range metricsDB.Series(c.Query("metric")) {
+		if !info.Labels.Matches(match) {
+			continue
+		}
+		series = append(series, info)
+		names[info.Name]++
+	}
+	c.JSON(http.StatusOK, gin.H{"metrics": names, "series": series})
+}
@@ -0,0 +1,268 @@
+package api
+
+import (
+	"control/go_server/config"
+	"control/go_server/internal/models"
+	"control/go_server/internal/terminal"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// terminalReadLimit bounds one message from the browser; pastes are split by xterm.js
+	terminalReadLimit = 64 * 1024
+	// terminalPingInterval keeps the connection alive through proxies and
+	// detects browsers that went away without closing it
+	terminalPingInterval = 30 * time.Second
+	terminalPongTimeout  = 2 * terminalPingInterval
+	terminalWriteTimeout = 10 * time.Second
+	terminalFlushTimeout = 500 * time.Millisecond
+)
+
+// WebSockets aren't subject to CORS, and SameSite cookies still reach pages
+// on sibling subdomains, so the upgrader checks origins itself
+var terminalUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 32 * 1024,
+	CheckOrigin:     checkTerminalOrigin,
+}
+
+// checkTerminalOrigin accepts pages served from the host the request was sent
+// to, and the configured origins. Requests without an Origin don't come from
+// a browser, which always sends one.
+func checkTerminalOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range config.Conf.Terminal.AllowedOrigins {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	log.Printf("Refused terminal connection from origin %s to host %s", origin, r.Host)
+	return false
+}
+
+// terminalMessage is a control message, sent as a text frame. Binary frames
+// carry the terminal's raw input and output.
+//
+//	browser → server: {"type":"input","data":"ls\r"}, {"type":"resize","cols":120,"rows":40}
+//	server → browser: {"type":"session","id":"…"}, {"type":"exit","code":0,"reason":"exited"}
+type terminalMessage struct {
+	Type   string `json:"type"`
+	Data   string `json:"data,omitempty"`
+	Cols   uint16 `json:"cols,omitempty"`
+	Rows   uint16 `json:"rows,omitempty"`
+	ID     string `json:"id,omitempty"`
+	Code   *int   `json:"code,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// TerminalHandler serves interactive shells on pseudo-terminals over WebSocket,
+// recording every session
+type TerminalHandler struct {
+	sessions   *terminal.Manager
+	recordings *RecordingHandler
+	policy     *PolicyHandler
+	conf       config.TerminalConfig
+}
+
+func NewTerminalHandler(conf config.TerminalConfig, recordings *RecordingHandler, policy *PolicyHandler) *TerminalHandler {
+	return &TerminalHandler{sessions: terminal.NewManager(conf.MaxSessionsPerUser), recordings: recordings, policy: policy, conf: conf}
+}
+
+// terminalConn serializes the writes to a WebSocket
+type terminalConn struct {
+	*websocket.Conn
+	mutex sync.Mutex
+}
+
+func (c *terminalConn) write(messageType int, data []byte) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.SetWriteDeadline(time.Now().Add(terminalWriteTimeout))
+	return c.WriteMessage(messageType, data)
+}
+
+func (c *terminalConn) send(msg terminalMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return c.write(websocket.TextMessage, data)
+}
+
+// Connect godoc
+// @Summary Interactive terminal
+// @Description Upgrades to a WebSocket running a shell on a pseudo-terminal. Binary frames carry input and output; text frames carry JSON control messages (input, resize, session, exit). The shell and everything started from it end when the connection closes or stays idle past the configured timeout. What is typed into the shell isn't checked by the command policy, so sessions are limited to admins; other roles run commands through /terminal/execute.
+// @Tags Terminal
+// @Param cols query int false "Initial columns" default(80)
+// @Param rows query int false "Initial rows" default(24)
+// @Success 101 {string} string "Switching Protocols"
+// @Router /api/terminal/ws [get]
+func (h *TerminalHandler) Connect(c *gin.Context) {
+	user := sessionUser(c)
+	if h.conf.MaxSessionsPerUser > 0 && h.sessions.Count(user) >= h.conf.MaxSessionsPerUser {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many terminal sessions", "limit": h.conf.MaxSessionsPerUser})
+		return
+	}
+	// What is typed into a shell can't be decided on before it runs, so the
+	// route is limited to admins, and the policy decides on the shell itself
+	homeDir, _ := os.UserHomeDir()
+	if decision, _ := h.policy.authorize(user, "", commandSourceInteractive, h.conf.Shell, homeDir, 0); decision.Action != models.PolicyAllow {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Interactive terminal denied by policy", "message": decision.Reason, "rule": decision.Rule})
+		return
+	}
+	cols, _ := strconv.ParseUint(c.Query("cols"), 10, 16)
+	rows, _ := strconv.ParseUint(c.Query("rows"), 10, 16)
+
+	ws, err := terminalUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return // the upgrader has written the error response
+	}
+	conn := &terminalConn{Conn: ws}
+	defer conn.Close()
+
+	session, err := h.sessions.Open(user, terminal.Options{
+		Shell: h.conf.Shell,
+		Dir:   homeDir,
+		Cols:  uint16(cols),
+		Rows:  uint16(rows),
+	})
+	if err != nil {
+		reason := "Failed to start shell: " + err.Error()
+		if errors.Is(err, terminal.ErrSessionLimit) {
+			reason = "Too many terminal sessions"
+		}
+		conn.send(terminalMessage{Type: "exit", Reason: reason})
+		conn.write(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason))
+		return
+	}
+	// Sessions that can't be recorded aren't allowed
+	width, height := session.Size()
+	rec, err := h.recordings.recordSession(user, session.ID, width, height)
+	if err != nil {
+		h.sessions.Close(session)
+		log.Printf("Failed to record terminal session of %s: %v", user, err)
+		reason := "Failed to start recording: " + err.Error()
+		conn.send(terminalMessage{Type: "exit", Reason: reason})
+		conn.write(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, reason))
+		return
+	}
+	log.Printf("Terminal session %s opened by %s", session.ID, user)
+	conn.send(terminalMessage{Type: "session", ID: session.ID})
+
+	// Output: terminal → browser
+	outputDone := make(chan struct{})
+	go func() {
+		defer close(outputDone)
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := session.Read(buf)
+			if n > 0 {
+				rec.Output(buf[:n])
+				if conn.write(websocket.BinaryMessage, buf[:n]) != nil {
+					return
+				}
+			}
+			if err != nil {
+				return // the shell exited or the session was closed
+			}
+		}
+	}()
+
+	// Input: browser → terminal
+	inputDone := make(chan struct{})
+	go func() {
+		defer close(inputDone)
+		conn.SetReadLimit(terminalReadLimit)
+		conn.SetReadDeadline(time.Now().Add(terminalPongTimeout))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(terminalPongTimeout))
+		})
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.SetReadDeadline(time.Now().Add(terminalPongTimeout))
+			if messageType == websocket.BinaryMessage {
+				rec.Input(data)
+				session.Write(data)
+				continue
+			}
+			var msg terminalMessage
+			if err := json.Unmarshal(data, &msg); err != nil {
+				continue
+			}
+			switch msg.Type {
+			case "input":
+				rec.Input([]byte(msg.Data))
+				session.Write([]byte(msg.Data))
+			case "resize":
+				if session.Resize(msg.Cols, msg.Rows) == nil {
+					rec.Resize(msg.Cols, msg.Rows)
+				}
+			}
+		}
+	}()
+
+	ping := time.NewTicker(terminalPingInterval)
+	defer ping.Stop()
+	idle := time.NewTicker(time.Minute)
+	defer idle.Stop()
+
+	reason := ""
+	for reason == "" {
+		select {
+		case <-session.Done():
+			reason = "exited"
+		case <-inputDone:
+			reason = "disconnected"
+		case <-ping.C:
+			if conn.write(websocket.PingMessage, nil) != nil {
+				reason = "disconnected"
+			}
+		case <-idle.C:
+			if h.conf.IdleTimeout > 0 && time.Since(session.LastActive()) > h.conf.IdleTimeout {
+				reason = "idle"
+			}
+		}
+	}
+
+	// Flush what the shell printed last before reporting the exit; background
+	// jobs may still hold the terminal open, so don't wait for them
+	if reason == "exited" {
+		select {
+		case <-outputDone:
+		case <-time.After(terminalFlushTimeout):
+		}
+	}
+	h.sessions.Close(session)
+	code := session.ExitCode()
+	rec.Finish(code)
+	log.Printf("Terminal session %s of %s ended: %s (exit code %d)", session.ID, user, reason, code)
+	if reason != "disconnected" {
+		conn.send(terminalMessage{Type: "exit", Code: &code, Reason: reason})
+		conn.write(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, reason))
+	}
+}
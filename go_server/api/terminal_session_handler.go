@@ -0,0 +1,335 @@
+package api
+
+import (
+	"control/go_server/internal/models"
+	"control/go_server/internal/terminal"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// terminalHistorySize is how many commands a session's history keeps
+const terminalHistorySize = 500
+
+// errSessionNotOwned refuses a session ID that belongs to another user
+var errSessionNotOwned = errors.New("terminal session belongs to another user")
+
+// shellVariables are set by the shell itself, so they aren't session state
+var shellVariables = map[string]bool{"PWD": true, "OLDPWD": true, "SHLVL": true, "_": true}
+
+// TerminalSession is the state /terminal/execute carries from one command of
+// a session to the next, like a shell would
+type TerminalSession struct {
+	ID         string `json:"id"`
+	User       string `json:"user"`
+	WorkingDir string `json:"workingDir"`
+	// Environment holds the variables the session exported or changed, Unset
+	// the server's variables it unset
+	Environment map[string]string     `json:"environment"`
+	Unset       []string              `json:"unset,omitempty"`
+	History     []TerminalHistoryItem `json:"-"`
+	Created     time.Time             `json:"created"`
+	LastUsed    time.Time             `json:"lastUsed"`
+}
+
+// TerminalHistoryItem is a command run in a session
+type TerminalHistoryItem struct {
+	Command  string    `json:"command"`
+	Dir      string    `json:"dir"`
+	ExitCode int       `json:"exitCode"`
+	End      string    `json:"end"`
+	Time     time.Time `json:"time"`
+}
+
+// Global session storage (in production, use proper storage)
+var terminalSessions = make(map[string]*TerminalSession)
+var sessionMutex sync.RWMutex
+
+// getOrCreateSession gets a terminal session of user, creating it when it
+// doesn't exist. An empty ID creates a session with a new ID.
+func getOrCreateSession(user, sessionID string) (*TerminalSession, error) {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+
+	if session, exists := terminalSessions[sessionID]; exists {
+		if session.User != user {
+			return nil, errSessionNotOwned
+		}
+		session.LastUsed = time.Now()
+		return session, nil
+	}
+
+	if sessionID == "" {
+		id := make([]byte, 16)
+		if _, err := rand.Read(id); err != nil {
+			return nil, err
+		}
+		sessionID = hex.EncodeToString(id)
+	}
+
+	// Create new session
+	homeDir, _ := os.UserHomeDir()
+	if homeDir == "" {
+		homeDir = "/root"
+	}
+
+	session := &TerminalSession{
+		ID:          sessionID,
+		User:        user,
+		WorkingDir:  homeDir,
+		Environment: make(map[string]string),
+		Created:     time.Now(),
+		LastUsed:    time.Now(),
+	}
+
+	terminalSessions[sessionID] = session
+	return session, nil
+}
+
+// state returns the working directory and the environment for the session's next command
+func (s *TerminalSession) state() (string, []string) {
+	sessionMutex.RLock()
+	defer sessionMutex.RUnlock()
+
+	unset := make(map[string]bool, len(s.Unset))
+	for _, name := range s.Unset {
+		unset[name] = true
+	}
+	var env []string
+	for _, v := range os.Environ() {
+		name, _, _ := strings.Cut(v, "=")
+		if _, changed := s.Environment[name]; !changed && !unset[name] {
+			env = append(env, v)
+		}
+	}
+	for name, value := range s.Environment {
+		env = append(env, name+"="+value)
+	}
+	return s.WorkingDir, env
+}
+
+func (s *TerminalSession) workingDir() string {
+	sessionMutex.RLock()
+	defer sessionMutex.RUnlock()
+	return s.WorkingDir
+}
+
+// finish keeps what a command changed: its history entry, and the working
+// directory and environment it left the shell in, when they are known
+func (s *TerminalSession) finish(command, dir string, result terminal.CommandResult) {
+	base := make(map[string]string)
+	for _, v := range os.Environ() {
+		name, value, _ := strings.Cut(v, "=")
+		base[name] = value
+	}
+	environment := make(map[string]string)
+	seen := make(map[string]bool)
+	for _, v := range result.Env {
+		name, value, _ := strings.Cut(v, "=")
+		seen[name] = true
+		if shellVariables[name] {
+			continue
+		}
+		if old, ok := base[name]; !ok || old != value {
+			environment[name] = value
+		}
+	}
+	var unset []string
+	for name := range base {
+		if !seen[name] && !shellVariables[name] {
+			unset = append(unset, name)
+		}
+	}
+	sort.Strings(unset)
+
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+	if result.Dir != "" {
+		s.WorkingDir = result.Dir
+		s.Environment = environment
+		s.Unset = unset
+	}
+	s.History = append(s.History, TerminalHistoryItem{
+		Command:  command,
+		Dir:      dir,
+		ExitCode: result.ExitCode,
+		End:      result.End,
+		Time:     time.Now(),
+	})
+	if len(s.History) > terminalHistorySize {
+		s.History = s.History[len(s.History)-terminalHistorySize:]
+	}
+	s.LastUsed = time.Now()
+}
+
+// closeTerminalSession cancels the running commands of a session and forgets it
+func closeTerminalSession(session *TerminalSession) {
+	sessionMutex.Lock()
+	delete(terminalSessions, session.ID)
+	sessionMutex.Unlock()
+
+	for _, cmd := range terminalCommands.List() {
+		if cmd.User == session.User && cmd.SessionID == session.ID {
+			cmd.Cancel()
+		}
+	}
+	terminalRecordings.endCommandSession(session.User, session.ID)
+}
+
+// startTerminalSessionExpiry closes the /terminal/execute sessions idle
+// longer than the terminal's idle timeout
+func startTerminalSessionExpiry(idleTimeout time.Duration) {
+	if idleTimeout <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for range ticker.C {
+			for _, session := range idleTerminalSessions(idleTimeout) {
+				log.Printf("Terminal session %s of %s expired after %s idle", session.ID, session.User, idleTimeout)
+				closeTerminalSession(session)
+			}
+		}
+	}()
+}
+
+// idleTerminalSessions lists the sessions unused for longer than timeout
+// that aren't running a command
+func idleTerminalSessions(timeout time.Duration) []*TerminalSession {
+	running := make(map[string]bool)
+	for _, cmd := range terminalCommands.List() {
+		running[cmd.SessionID] = true
+	}
+
+	sessionMutex.RLock()
+	defer sessionMutex.RUnlock()
+	var idle []*TerminalSession
+	for id, session := range terminalSessions {
+		if !running[id] && time.Since(session.LastUsed) > timeout {
+			idle = append(idle, session)
+		}
+	}
+	return idle
+}
+
+// terminalSessionInfo describes a session of either kind for the sessions endpoints
+type terminalSessionInfo struct {
+	ID         string    `json:"id"`
+	Kind       string    `json:"kind"` // command or interactive
+	User       string    `json:"user"`
+	WorkingDir string    `json:"workingDir,omitempty"`
+	Shell      string    `json:"shell,omitempty"`
+	Running    int       `json:"running"` // commands running, for command sessions
+	Created    time.Time `json:"created"`
+	LastUsed   time.Time `json:"lastUsed"`
+	ExpiresAt  time.Time `json:"expiresAt"` // when it closes unless used
+}
+
+// GetSessions godoc
+// @Summary Get the open terminal sessions
+// @Description Both the /terminal/execute sessions and the interactive ones. Admins see the sessions of every user, others their own.
+// @Tags Terminal
+// @Success 200 {object} gin.H
+// @Router /api/terminal/sessions [get]
+func (h *TerminalHandler) GetSessions(c *gin.Context) {
+	user := sessionUser(c)
+	admin := userRole(user) == models.RoleAdmin
+	visible := func(owner string) bool { return admin || owner == user }
+
+	running := make(map[string]int)
+	for _, cmd := range terminalCommands.List() {
+		running[cmd.SessionID]++
+	}
+
+	sessions := []terminalSessionInfo{}
+	sessionMutex.RLock()
+	for _, s := range terminalSessions {
+		if visible(s.User) {
+			sessions = append(sessions, terminalSessionInfo{
+				ID:         s.ID,
+				Kind:       string(models.RecordingCommand),
+				User:       s.User,
+				WorkingDir: s.WorkingDir,
+				Running:    running[s.ID],
+				Created:    s.Created,
+				LastUsed:   s.LastUsed,
+				ExpiresAt:  s.LastUsed.Add(h.conf.IdleTimeout),
+			})
+		}
+	}
+	sessionMutex.RUnlock()
+	for _, s := range h.sessions.List() {
+		if visible(s.User) {
+			sessions = append(sessions, terminalSessionInfo{
+				ID:        s.ID,
+				Kind:      string(models.RecordingInteractive),
+				User:      s.User,
+				Shell:     s.Shell,
+				Created:   s.Started,
+				LastUsed:  s.LastActive(),
+				ExpiresAt: s.LastActive().Add(h.conf.IdleTimeout),
+			})
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].Created.Before(sessions[j].Created) })
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+// GetSessionHistory godoc
+// @Summary Get the command history of a /terminal/execute session
+// @Tags Terminal
+// @Param id path string true "Session ID"
+// @Success 200 {object} gin.H
+// @Router /api/terminal/sessions/{id}/history [get]
+func (h *TerminalHandler) GetSessionHistory(c *gin.Context) {
+	user := sessionUser(c)
+	sessionMutex.RLock()
+	defer sessionMutex.RUnlock()
+	session, ok := terminalSessions[c.Param("id")]
+	if !ok || session.User != user && userRole(user) != models.RoleAdmin {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+	history := append([]TerminalHistoryItem{}, session.History...)
+	c.JSON(http.StatusOK, gin.H{"session": session, "history": history})
+}
+
+// CloseSession godoc
+// @Summary Kill a terminal session
+// @Description Cancels the running commands of a /terminal/execute session, or hangs up an interactive one. Admins may kill the sessions of every user, others their own.
+// @Tags Terminal
+// @Param id path string true "Session ID"
+// @Success 200 {object} gin.H
+// @Router /api/terminal/sessions/{id} [delete]
+func (h *TerminalHandler) CloseSession(c *gin.Context) {
+	user := sessionUser(c)
+	admin := userRole(user) == models.RoleAdmin
+	id := c.Param("id")
+
+	sessionMutex.RLock()
+	session, ok := terminalSessions[id]
+	sessionMutex.RUnlock()
+	if ok && (admin || session.User == user) {
+		closeTerminalSession(session)
+		log.Printf("Terminal session %s of %s killed by %s", id, session.User, user)
+		c.JSON(http.StatusOK, gin.H{"message": "Session closed"})
+		return
+	}
+	if s, ok := h.sessions.Get(id); ok && (admin || s.User == user) {
+		h.sessions.Close(s)
+		log.Printf("Terminal session %s of %s killed by %s", id, s.User, user)
+		c.JSON(http.StatusOK, gin.H{"message": "Session closed"})
+		return
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+}
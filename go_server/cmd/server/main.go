@@ -7,7 +7,9 @@ import (
 	"control/go_server/db"
 	"fmt"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 )
 
 func getSqlConnFromConf() (string, error) {
@@ -55,6 +57,23 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Open the metrics store and start collecting
+	if err := api.InitMetricsStore(); err != nil {
+		fmt.Println("Error opening metrics store:", err)
+		os.Exit(1)
+	}
+
+	// Write buffered metrics before exiting
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		if err := api.CloseMetricsStore(); err != nil {
+			fmt.Println("Error closing metrics store:", err)
+		}
+		os.Exit(0)
+	}()
+
 	// Setup router
 	router := api.SetupRouter()
 
@@ -3,10 +3,12 @@ module control/go_server
 go 1.24.3
 
 require (
+	github.com/creack/pty v1.1.24
 	github.com/gin-contrib/cors v1.7.6
 	github.com/gin-gonic/gin v1.10.1
 	github.com/go-redis/redis/v8 v8.11.5
 	github.com/gorilla/sessions v1.4.0
+	github.com/gorilla/websocket v1.5.3
 	github.com/shirou/gopsutil/v3 v3.24.5
 	gorm.io/driver/mysql v1.6.0
 	gorm.io/gorm v1.30.2
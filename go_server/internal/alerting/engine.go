@@ -0,0 +1,348 @@
+// Package alerting evaluates threshold rules against the metrics store.
+package alerting
+
+import (
+	"bytes"
+	"control/go_server/internal/models"
+	"control/go_server/internal/tsdb"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// resolvedRetention is how long a resolved alert stays in the active list
+const resolvedRetention = 15 * time.Minute
+
+// Querier reads series from the metrics store; *tsdb.DB implements it
+type Querier interface {
+	Select(name string, match tsdb.Labels, r tsdb.Resolution, start, end time.Time) ([]tsdb.Series, error)
+}
+
+// ValidateRule checks a rule before it is saved
+func ValidateRule(rule models.AlertRule) error {
+	if rule.Name == "" {
+		return fmt.Errorf("rule name is required")
+	}
+	if rule.Metric == "" {
+		return fmt.Errorf("rule metric is required")
+	}
+	switch rule.Operator {
+	case models.AlertAbove, models.AlertAboveOrEqual, models.AlertBelow, models.AlertBelowOrEqual, models.AlertEqual, models.AlertNotEqual:
+	default:
+		return fmt.Errorf("unknown operator %q", rule.Operator)
+	}
+	switch rule.Reducer {
+	case "", models.ReduceLast, models.ReduceAvg, models.ReduceMin, models.ReduceMax:
+	default:
+		return fmt.Errorf("unknown reducer %q", rule.Reducer)
+	}
+	if rule.WindowSeconds < 0 || rule.ForSeconds < 0 {
+		return fmt.Errorf("window and for durations cannot be negative")
+	}
+	for name, text := range rule.Annotations {
+		if _, err := template.New(name).Parse(text); err != nil {
+			return fmt.Errorf("invalid annotation %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Alert is the live state of one series of a rule
+type Alert struct {
+	RuleID      int64             `json:"ruleId"`
+	RuleName    string            `json:"ruleName"`
+	Fingerprint string            `json:"fingerprint"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	Severity    string            `json:"severity"`
+	State       models.AlertState `json:"state"`
+	Value       float64           `json:"value"`
+	Threshold   float64           `json:"threshold"`
+	ActiveAt    time.Time         `json:"activeAt"` // when the condition started to hold
+	FiredAt     *time.Time        `json:"firedAt,omitempty"`
+	ResolvedAt  *time.Time        `json:"resolvedAt,omitempty"`
+	Silenced    bool              `json:"silenced"`
+	LastEval    time.Time         `json:"lastEval"`
+}
+
+// event returns the history record of the alert's current state
+func (a *Alert) event() models.AlertEvent {
+	startsAt := a.ActiveAt
+	if a.FiredAt != nil {
+		startsAt = *a.FiredAt
+	}
+	return models.AlertEvent{
+		RuleID:      a.RuleID,
+		RuleName:    a.RuleName,
+		Fingerprint: a.Fingerprint,
+		Labels:      a.Labels,
+		Annotations: a.Annotations,
+		Severity:    a.Severity,
+		State:       a.State,
+		Value:       a.Value,
+		Threshold:   a.Threshold,
+		StartsAt:    startsAt,
+		EndsAt:      a.ResolvedAt,
+		Silenced:    a.Silenced,
+	}
+}
+
+// Engine evaluates rules on demand. An alert goes pending when its condition
+// starts to hold, fires once it has held for the rule's for duration and
+// resolves when it stops holding; a pending alert whose condition stops
+// holding is dropped without an event.
+type Engine struct {
+	mutex    sync.Mutex
+	querier  Querier
+	rules    []models.AlertRule
+	silences []models.AlertSilence
+	alerts   map[string]*Alert   // by fingerprint
+	events   []models.AlertEvent // reported once the mutex is released
+	onEvent  func(models.AlertEvent)
+}
+
+// NewEngine creates an engine that reports firing and resolved alerts to onEvent
+func NewEngine(querier Querier, onEvent func(models.AlertEvent)) *Engine {
+	return &Engine{
+		querier: querier,
+		alerts:  make(map[string]*Alert),
+		onEvent: onEvent,
+	}
+}
+
+// SetRules replaces the rules. Alerts of removed or disabled rules are dropped;
+// firing ones are reported as resolved first.
+func (e *Engine) SetRules(rules []models.AlertRule) {
+	e.mutex.Lock()
+	defer e.unlockAndReport()
+
+	enabled := make(map[int64]bool)
+	for _, rule := range rules {
+		if rule.Enabled {
+			enabled[rule.ID] = true
+		}
+	}
+	now := time.Now()
+	for fp, alert := range e.alerts {
+		if enabled[alert.RuleID] {
+			continue
+		}
+		if alert.State == models.AlertFiring {
+			e.resolve(alert, now)
+		}
+		delete(e.alerts, fp)
+	}
+	e.rules = rules
+}
+
+// SetSilences replaces the silences; they apply from the next evaluation
+func (e *Engine) SetSilences(silences []models.AlertSilence) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.silences = silences
+}
+
+// Evaluate runs every enabled rule against the store at now
+func (e *Engine) Evaluate(now time.Time) {
+	e.mutex.Lock()
+	defer e.unlockAndReport()
+
+	seen := make(map[string]bool)
+	for _, rule := range e.rules {
+		if !rule.Enabled {
+			continue
+		}
+		series, err := e.querier.Select(rule.Metric, rule.Matchers, tsdb.Raw, now.Add(-rule.Window()), now)
+		if err != nil {
+			log.Printf("Failed to evaluate alert rule %s: %v", rule.Name, err)
+			// keep the rule's alerts as they are rather than resolving them on a read error
+			for fp, alert := range e.alerts {
+				if alert.RuleID == rule.ID {
+					seen[fp] = true
+				}
+			}
+			continue
+		}
+		for _, s := range series {
+			value, ok := reduce(rule.Reducer, s.Samples)
+			if !ok || !rule.Operator.Compare(value, rule.Threshold) {
+				continue
+			}
+			labels := alertLabels(rule, s.Labels)
+			fp := fingerprint(rule.ID, labels)
+			seen[fp] = true
+			e.activate(rule, fp, labels, value, now)
+		}
+	}
+
+	for fp, alert := range e.alerts {
+		switch {
+		case seen[fp]:
+		case alert.State == models.AlertFiring:
+			e.resolve(alert, now)
+		case alert.State == models.AlertPending:
+			delete(e.alerts, fp)
+		case alert.State == models.AlertResolved && now.Sub(*alert.ResolvedAt) > resolvedRetention:
+			delete(e.alerts, fp)
+		}
+	}
+}
+
+// activate records that the condition of an alert holds at now
+func (e *Engine) activate(rule models.AlertRule, fp string, labels map[string]string, value float64, now time.Time) {
+	alert, ok := e.alerts[fp]
+	if !ok || alert.State == models.AlertResolved {
+		alert = &Alert{
+			RuleID:      rule.ID,
+			RuleName:    rule.Name,
+			Fingerprint: fp,
+			Labels:      labels,
+			Severity:    rule.Severity,
+			State:       models.AlertPending,
+			Threshold:   rule.Threshold,
+			ActiveAt:    now,
+		}
+		e.alerts[fp] = alert
+	}
+	alert.Value = value
+	alert.LastEval = now
+	alert.Silenced = e.silenced(labels, now)
+	alert.Annotations = expandAnnotations(rule.Annotations, labels, value)
+
+	if alert.State == models.AlertPending && now.Sub(alert.ActiveAt) >= rule.For() {
+		firedAt := now
+		alert.State = models.AlertFiring
+		alert.FiredAt = &firedAt
+		e.events = append(e.events, alert.event())
+	}
+}
+
+func (e *Engine) resolve(alert *Alert, now time.Time) {
+	resolvedAt := now
+	alert.State = models.AlertResolved
+	alert.ResolvedAt = &resolvedAt
+	alert.LastEval = now
+	alert.Silenced = e.silenced(alert.Labels, now)
+	e.events = append(e.events, alert.event())
+}
+
+// unlockAndReport releases the mutex and then reports the queued events, so a
+// slow onEvent doesn't block readers of the alert list
+func (e *Engine) unlockAndReport() {
+	events := e.events
+	e.events = nil
+	e.mutex.Unlock()
+	for _, event := range events {
+		e.onEvent(event)
+	}
+}
+
+func (e *Engine) silenced(labels map[string]string, now time.Time) bool {
+	for _, silence := range e.silences {
+		if silence.Active(now) && silence.Matches(labels) {
+			return true
+		}
+	}
+	return false
+}
+
+// Alerts returns the pending, firing and recently resolved alerts, firing first
+func (e *Engine) Alerts() []Alert {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	alerts := make([]Alert, 0, len(e.alerts))
+	for _, alert := range e.alerts {
+		alerts = append(alerts, *alert)
+	}
+	rank := map[models.AlertState]int{models.AlertFiring: 0, models.AlertPending: 1, models.AlertResolved: 2}
+	sort.Slice(alerts, func(i, j int) bool {
+		if rank[alerts[i].State] != rank[alerts[j].State] {
+			return rank[alerts[i].State] < rank[alerts[j].State]
+		}
+		return alerts[i].ActiveAt.Before(alerts[j].ActiveAt)
+	})
+	return alerts
+}
+
+// reduce turns the samples of a series into the value compared with the threshold
+func reduce(reducer models.AlertReducer, samples []tsdb.Sample) (float64, bool) {
+	if len(samples) == 0 {
+		return 0, false
+	}
+	switch reducer {
+	case models.ReduceAvg:
+		sum := 0.0
+		for _, s := range samples {
+			sum += s.Avg
+		}
+		return sum / float64(len(samples)), true
+	case models.ReduceMin:
+		value := math.Inf(1)
+		for _, s := range samples {
+			value = math.Min(value, s.Min)
+		}
+		return value, true
+	case models.ReduceMax:
+		value := math.Inf(-1)
+		for _, s := range samples {
+			value = math.Max(value, s.Max)
+		}
+		return value, true
+	}
+	return samples[len(samples)-1].Avg, true
+}
+
+// alertLabels combines the series labels with the rule's labels, name and severity
+func alertLabels(rule models.AlertRule, seriesLabels tsdb.Labels) map[string]string {
+	labels := make(map[string]string, len(seriesLabels)+len(rule.Labels)+2)
+	for k, v := range seriesLabels {
+		labels[k] = v
+	}
+	for k, v := range rule.Labels {
+		labels[k] = v
+	}
+	labels["alertname"] = rule.Name
+	if rule.Severity != "" {
+		labels["severity"] = rule.Severity
+	}
+	return labels
+}
+
+// fingerprint identifies an alert by its rule and labels
+func fingerprint(ruleID int64, labels map[string]string) string {
+	sum := sha256.Sum256([]byte(strconv.FormatInt(ruleID, 10) + tsdb.Labels(labels).String()))
+	return hex.EncodeToString(sum[:8])
+}
+
+// expandAnnotations renders the rule's annotation templates with .Labels and
+// .Value; an annotation that fails to render is kept as written
+func expandAnnotations(annotations map[string]string, labels map[string]string, value float64) map[string]string {
+	expanded := make(map[string]string, len(annotations))
+	data := struct {
+		Labels map[string]string
+		Value  string
+	}{labels, strconv.FormatFloat(value, 'f', -1, 64)}
+	if math.Abs(value) >= 0.01 {
+		data.Value = strconv.FormatFloat(value, 'f', 2, 64)
+	}
+	for name, text := range annotations {
+		expanded[name] = text
+		tmpl, err := template.New(name).Option("missingkey=zero").Parse(text)
+		if err != nil {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err == nil {
+			expanded[name] = buf.String()
+		}
+	}
+	return expanded
+}
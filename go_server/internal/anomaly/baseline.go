@@ -0,0 +1,124 @@
+// Package anomaly learns seasonal baselines of metric series and scores how
+// far new values are from them.
+package anomaly
+
+import (
+	"math"
+	"time"
+)
+
+// Config tunes how baselines learn and score
+type Config struct {
+	Alpha      float64 // EWMA weight of a new sample in its seasonal bucket
+	MinSamples int     // samples a bucket needs before it is used instead of a coarser one
+	MinStd     float64 // lower bound of the standard deviation, in the metric's unit
+	Clamp      float64 // samples are clamped to mean ± Clamp·std before learning
+}
+
+// DefaultConfig suits 5 minute samples: a day-of-week/hour bucket sees 12
+// samples a week, so it remembers roughly the last week's level
+var DefaultConfig = Config{Alpha: 0.1, MinSamples: 6, MinStd: 1e-6, Clamp: 4}
+
+// Stat is an exponentially weighted mean and variance
+type Stat struct {
+	Mean  float64 `json:"mean"`
+	Var   float64 `json:"var"`
+	Count int     `json:"count"`
+}
+
+func (s *Stat) update(value, alpha float64) {
+	if s.Count == 0 {
+		s.Mean, s.Var, s.Count = value, 0, 1
+		return
+	}
+	diff := value - s.Mean
+	incr := alpha * diff
+	s.Mean += incr
+	s.Var = (1 - alpha) * (s.Var + diff*incr)
+	s.Count++
+}
+
+// Expectation is the baseline's value for a point in time
+type Expectation struct {
+	Mean    float64 `json:"mean"`
+	Std     float64 `json:"std"`
+	Level   string  `json:"level"`   // weekly (day-of-week and hour), daily (hour) or overall
+	Samples int     `json:"samples"` // samples the level has learned from
+}
+
+// Baseline is the seasonal EWMA of one series. Each sample updates its
+// day-of-week/hour bucket, its hour-of-day bucket and the overall level; the
+// most specific bucket with enough samples provides the expectation.
+type Baseline struct {
+	cfg     Config
+	weekly  [7 * 24]Stat
+	daily   [24]Stat
+	overall Stat
+	last    time.Time
+}
+
+// NewBaseline creates an empty baseline
+func NewBaseline(cfg Config) *Baseline {
+	return &Baseline{cfg: cfg}
+}
+
+// Last returns the time of the newest learned sample
+func (b *Baseline) Last() time.Time {
+	return b.last
+}
+
+// Observe learns a sample. Samples not newer than the last one are ignored, so
+// overlapping history can be fed again safely.
+func (b *Baseline) Observe(t time.Time, value float64) {
+	if !t.After(b.last) || math.IsNaN(value) || math.IsInf(value, 0) {
+		return
+	}
+	b.last = t
+
+	// Clamp outliers so a spike doesn't drag the baseline along with it; a
+	// lasting shift is still learned, just gradually
+	if e, ok := b.Expected(t); ok {
+		limit := b.cfg.Clamp * e.Std
+		value = math.Max(e.Mean-limit, math.Min(e.Mean+limit, value))
+	}
+
+	hour := t.Hour()
+	b.weekly[int(t.Weekday())*24+hour].update(value, b.cfg.Alpha)
+	b.daily[hour].update(value, b.cfg.Alpha/2)
+	b.overall.update(value, b.cfg.Alpha/10)
+}
+
+// Expected returns the expectation for t; ok is false until the overall
+// level has MinSamples samples
+func (b *Baseline) Expected(t time.Time) (Expectation, bool) {
+	hour := t.Hour()
+	levels := []struct {
+		name string
+		stat Stat
+	}{
+		{"weekly", b.weekly[int(t.Weekday())*24+hour]},
+		{"daily", b.daily[hour]},
+		{"overall", b.overall},
+	}
+	for _, level := range levels {
+		if level.stat.Count >= b.cfg.MinSamples {
+			return Expectation{
+				Mean:    level.stat.Mean,
+				Std:     math.Max(math.Sqrt(level.stat.Var), b.cfg.MinStd),
+				Level:   level.name,
+				Samples: level.stat.Count,
+			}, true
+		}
+	}
+	return Expectation{}, false
+}
+
+// Score returns how many standard deviations value is from the expectation
+// at t, positive above and negative below it
+func (b *Baseline) Score(t time.Time, value float64) (float64, Expectation, bool) {
+	e, ok := b.Expected(t)
+	if !ok {
+		return 0, e, false
+	}
+	return (value - e.Mean) / e.Std, e, true
+}
@@ -0,0 +1,217 @@
+package anomaly
+
+import (
+	"control/go_server/internal/tsdb"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// Baselines learn from 5 minute rollups, which keep a few weeks of history
+	sampleResolution = tsdb.FiveMinute
+	sampleInterval   = time.Duration(tsdb.FiveMinute)
+
+	// historyWindow is how far back baselines are trained when first built
+	historyWindow = 28 * 24 * time.Hour
+)
+
+// Querier reads series from the metrics store
+type Querier interface {
+	Select(name string, match tsdb.Labels, r tsdb.Resolution, start, end time.Time) ([]tsdb.Series, error)
+}
+
+// Watch is a metric whose series get baselines
+type Watch struct {
+	Metric string
+	MinStd float64       // smallest deviation that counts, so flat series don't alarm on noise
+	Window time.Duration // how far back Current looks for the latest value, 5 minutes by default
+}
+
+// Score is a value compared with its baseline
+type Score struct {
+	Metric   string      `json:"metric"`
+	Labels   tsdb.Labels `json:"labels"`
+	Time     time.Time   `json:"timestamp"`
+	Value    float64     `json:"value"`
+	Expected Expectation `json:"expected"`
+	Score    float64     `json:"score"`
+}
+
+// Anomalous reports whether the score is at least threshold standard deviations away
+func (s Score) Anomalous(threshold float64) bool {
+	return math.Abs(s.Score) >= threshold
+}
+
+// ScoredSeries is a series with a score for each sample
+type ScoredSeries struct {
+	Metric string      `json:"metric"`
+	Labels tsdb.Labels `json:"labels"`
+	Scores []Score     `json:"scores"`
+}
+
+// Detector keeps a baseline for every series of the watched metrics
+type Detector struct {
+	querier Querier
+	watches []Watch
+	cfg     Config
+
+	mutex     sync.RWMutex
+	baselines map[string]*Baseline
+	updated   time.Time
+}
+
+// NewDetector creates a detector without baselines; Update trains them
+func NewDetector(querier Querier, watches []Watch, cfg Config) *Detector {
+	return &Detector{querier: querier, watches: watches, cfg: cfg, baselines: make(map[string]*Baseline)}
+}
+
+// Updated returns when the baselines were last trained
+func (d *Detector) Updated() time.Time {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	return d.updated
+}
+
+// Update trains the baselines with the rollups completed before now. The
+// first call reads the whole history window; later calls only the rollups
+// since the previous one.
+func (d *Detector) Update(now time.Time) error {
+	end := now.Truncate(sampleInterval) // the current rollup is still open
+	start := end.Add(-historyWindow)
+	if updated := d.Updated(); !updated.IsZero() {
+		start = updated.Truncate(sampleInterval).Add(-sampleInterval)
+	}
+
+	for _, watch := range d.watches {
+		seriesList, err := d.querier.Select(watch.Metric, nil, sampleResolution, start, end)
+		if err != nil {
+			return err
+		}
+		d.mutex.Lock()
+		for _, series := range seriesList {
+			baseline := d.baseline(watch, series.Labels, true)
+			for _, sample := range series.Samples {
+				if sample.Time.Before(end) {
+					baseline.Observe(sample.Time, sample.Avg)
+				}
+			}
+		}
+		d.mutex.Unlock()
+	}
+
+	d.mutex.Lock()
+	d.updated = end
+	d.mutex.Unlock()
+	return nil
+}
+
+// baseline returns the baseline of a series, creating it if create is set.
+// The caller holds the mutex.
+func (d *Detector) baseline(watch Watch, labels tsdb.Labels, create bool) *Baseline {
+	key := tsdb.SeriesKey(watch.Metric, labels)
+	baseline, ok := d.baselines[key]
+	if !ok && create {
+		cfg := d.cfg
+		cfg.MinStd = math.Max(cfg.MinStd, watch.MinStd)
+		baseline = NewBaseline(cfg)
+		d.baselines[key] = baseline
+	}
+	return baseline
+}
+
+func (d *Detector) watch(metric string) (Watch, bool) {
+	for _, watch := range d.watches {
+		if watch.Metric == metric {
+			return watch, true
+		}
+	}
+	return Watch{}, false
+}
+
+// Current scores the latest value of every watched series: the average of
+// its raw samples over the watch window, comparable with the rollups the
+// baselines learn from. Series without a trained baseline are left out.
+func (d *Detector) Current(now time.Time) ([]Score, error) {
+	var scores []Score
+	for _, watch := range d.watches {
+		window := watch.Window
+		if window <= 0 {
+			window = sampleInterval
+		}
+		seriesList, err := d.querier.Select(watch.Metric, nil, tsdb.Raw, now.Add(-window), now)
+		if err != nil {
+			return nil, err
+		}
+		for _, series := range seriesList {
+			if len(series.Samples) == 0 {
+				continue
+			}
+			var sum float64
+			for _, sample := range series.Samples {
+				sum += sample.Avg
+			}
+			value := sum / float64(len(series.Samples))
+			if score, ok := d.score(watch, series.Labels, now, value); ok {
+				scores = append(scores, score)
+			}
+		}
+	}
+	sortScores(scores)
+	return scores, nil
+}
+
+// Scores scores each 5 minute rollup of a metric between start and end
+// against the current baselines
+func (d *Detector) Scores(metric string, match tsdb.Labels, start, end time.Time) ([]ScoredSeries, error) {
+	watch, ok := d.watch(metric)
+	if !ok {
+		return nil, nil
+	}
+	seriesList, err := d.querier.Select(metric, match, sampleResolution, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []ScoredSeries
+	for _, series := range seriesList {
+		scored := ScoredSeries{Metric: metric, Labels: series.Labels, Scores: []Score{}}
+		for _, sample := range series.Samples {
+			if score, ok := d.score(watch, series.Labels, sample.Time, sample.Avg); ok {
+				scored.Scores = append(scored.Scores, score)
+			}
+		}
+		result = append(result, scored)
+	}
+	return result, nil
+}
+
+func (d *Detector) score(watch Watch, labels tsdb.Labels, t time.Time, value float64) (Score, bool) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	baseline := d.baseline(watch, labels, false)
+	if baseline == nil {
+		return Score{}, false
+	}
+	score, expected, ok := baseline.Score(t, value)
+	if !ok {
+		return Score{}, false
+	}
+	return Score{
+		Metric:   watch.Metric,
+		Labels:   labels,
+		Time:     t,
+		Value:    value,
+		Expected: expected,
+		Score:    score,
+	}, true
+}
+
+// sortScores orders scores by how far they are from the baseline, largest first
+func sortScores(scores []Score) {
+	sort.Slice(scores, func(i, j int) bool {
+		return math.Abs(scores[i].Score) > math.Abs(scores[j].Score)
+	})
+}
@@ -0,0 +1,381 @@
+// Package docker is a small client of the Docker Engine API over its Unix
+// socket, covering what the control plane needs to manage containerized
+// services: listing, start/stop/restart, logs and stats.
+package docker
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultSocket is the Docker Engine socket used unless DOCKER_HOST names another unix socket
+const DefaultSocket = "/var/run/docker.sock"
+
+// ServiceLabel maps a container to the service of the same name
+const ServiceLabel = "control.service"
+
+// requestTimeout bounds every request except log streams
+const requestTimeout = 30 * time.Second
+
+// Error is an error response of the Engine API
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("docker: %s (HTTP %d)", e.Message, e.StatusCode)
+}
+
+// IsNotFound reports whether err is a 404 from the Engine API
+func IsNotFound(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// Client talks to the Engine API
+type Client struct {
+	socket string
+	http   *http.Client
+}
+
+// NewClient creates a client of the Engine API listening on socket
+func NewClient(socket string) *Client {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}
+	return &Client{socket: socket, http: &http.Client{Transport: transport}}
+}
+
+// SocketFromEnv returns the socket of a unix:// DOCKER_HOST, or DefaultSocket
+func SocketFromEnv() string {
+	if host := os.Getenv("DOCKER_HOST"); strings.HasPrefix(host, "unix://") {
+		return strings.TrimPrefix(host, "unix://")
+	}
+	return DefaultSocket
+}
+
+// Socket returns the socket the client connects to
+func (c *Client) Socket() string {
+	return c.socket
+}
+
+func (c *Client) do(ctx context.Context, method, path string, query url.Values) (*http.Response, error) {
+	u := "http://docker" + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		var body struct {
+			Message string `json:"message"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		if json.Unmarshal(data, &body) != nil || body.Message == "" {
+			body.Message = strings.TrimSpace(string(data))
+		}
+		return nil, &Error{StatusCode: resp.StatusCode, Message: body.Message}
+	}
+	return resp, nil
+}
+
+func (c *Client) getJSON(ctx context.Context, path string, query url.Values, v any) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	resp, err := c.do(ctx, http.MethodGet, path, query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// post sends a request without a body; the caller bounds its time
+func (c *Client) post(ctx context.Context, path string, query url.Values) error {
+	resp, err := c.do(ctx, http.MethodPost, path, query)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Ping checks that the daemon answers
+func (c *Client) Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	resp, err := c.do(ctx, http.MethodGet, "/_ping", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Port is a port of a container
+type Port struct {
+	IP          string `json:"ip,omitempty"`
+	PrivatePort int    `json:"privatePort"`
+	PublicPort  int    `json:"publicPort,omitempty"`
+	Type        string `json:"type"`
+}
+
+// Container is a container as listed by the daemon
+type Container struct {
+	ID      string            `json:"id"`
+	Name    string            `json:"name"` // without the leading slash
+	Image   string            `json:"image"`
+	State   string            `json:"state"`  // created, running, paused, restarting, exited or dead
+	Status  string            `json:"status"` // e.g. "Up 2 hours"
+	Created time.Time         `json:"created"`
+	Labels  map[string]string `json:"labels"`
+	Ports   []Port            `json:"ports"`
+}
+
+// Running reports whether the container is running
+func (c Container) Running() bool {
+	return c.State == "running"
+}
+
+// List returns the containers, including stopped ones when all is set
+func (c *Client) List(ctx context.Context, all bool) ([]Container, error) {
+	var raw []struct {
+		ID      string            `json:"Id"`
+		Names   []string          `json:"Names"`
+		Image   string            `json:"Image"`
+		State   string            `json:"State"`
+		Status  string            `json:"Status"`
+		Created int64             `json:"Created"`
+		Labels  map[string]string `json:"Labels"`
+		Ports   []struct {
+			IP          string `json:"IP"`
+			PrivatePort int    `json:"PrivatePort"`
+			PublicPort  int    `json:"PublicPort"`
+			Type        string `json:"Type"`
+		} `json:"Ports"`
+	}
+	if err := c.getJSON(ctx, "/containers/json", url.Values{"all": {strconv.FormatBool(all)}}, &raw); err != nil {
+		return nil, err
+	}
+
+	containers := make([]Container, 0, len(raw))
+	for _, r := range raw {
+		container := Container{
+			ID:      r.ID,
+			Image:   r.Image,
+			State:   r.State,
+			Status:  r.Status,
+			Created: time.Unix(r.Created, 0),
+			Labels:  r.Labels,
+			Ports:   []Port{},
+		}
+		if len(r.Names) > 0 {
+			container.Name = strings.TrimPrefix(r.Names[0], "/")
+		}
+		for _, p := range r.Ports {
+			container.Ports = append(container.Ports, Port{IP: p.IP, PrivatePort: p.PrivatePort, PublicPort: p.PublicPort, Type: p.Type})
+		}
+		containers = append(containers, container)
+	}
+	return containers, nil
+}
+
+// Start starts a container. The daemon answers 304 for a running
+// container, which is not an error.
+func (c *Client) Start(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	return c.post(ctx, "/containers/"+url.PathEscape(id)+"/start", nil)
+}
+
+// Stop stops a container, killing it if it hasn't exited after timeout.
+// Stopping a stopped container is not an error.
+func (c *Client) Stop(ctx context.Context, id string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout+requestTimeout)
+	defer cancel()
+	return c.post(ctx, "/containers/"+url.PathEscape(id)+"/stop", url.Values{"t": {strconv.Itoa(int(timeout.Seconds()))}})
+}
+
+// Restart restarts a container, killing it if it hasn't exited after timeout
+func (c *Client) Restart(ctx context.Context, id string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout+requestTimeout)
+	defer cancel()
+	return c.post(ctx, "/containers/"+url.PathEscape(id)+"/restart", url.Values{"t": {strconv.Itoa(int(timeout.Seconds()))}})
+}
+
+// LogsOptions select the lines of a logs request
+type LogsOptions struct {
+	Tail       int       // last lines only, 0 for all
+	Since      time.Time // only lines after this time, zero for all
+	Timestamps bool      // prefix every line with its RFC 3339 time
+	Follow     bool      // keep streaming new lines until the context ends
+}
+
+// Logs streams a container's stdout and stderr. The returned reader yields
+// plain text: the multiplexing headers of containers without a TTY are removed.
+func (c *Client) Logs(ctx context.Context, id string, opts LogsOptions) (io.ReadCloser, error) {
+	query := url.Values{
+		"stdout":     {"true"},
+		"stderr":     {"true"},
+		"timestamps": {strconv.FormatBool(opts.Timestamps)},
+		"follow":     {strconv.FormatBool(opts.Follow)},
+	}
+	if opts.Tail > 0 {
+		query.Set("tail", strconv.Itoa(opts.Tail))
+	}
+	if !opts.Since.IsZero() {
+		query.Set("since", strconv.FormatFloat(float64(opts.Since.UnixNano())/1e9, 'f', 9, 64))
+	}
+	resp, err := c.do(ctx, http.MethodGet, "/containers/"+url.PathEscape(id)+"/logs", query)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Header.Get("Content-Type") == "application/vnd.docker.raw-stream" {
+		return resp.Body, nil // TTY containers aren't multiplexed
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		defer resp.Body.Close()
+		pw.CloseWithError(demultiplex(pw, resp.Body))
+	}()
+	return pr, nil
+}
+
+// demultiplex copies the payloads of a multiplexed stream, where every frame
+// has an 8 byte header: the stream type, three zero bytes and the big endian
+// payload size
+func demultiplex(w io.Writer, r io.Reader) error {
+	br := bufio.NewReader(r)
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(br, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.CopyN(w, br, size); err != nil {
+			return err
+		}
+	}
+}
+
+// Stats is a container's resource usage
+type Stats struct {
+	CPUPercent   float64   `json:"cpu"` // of one core, like top
+	MemoryBytes  uint64    `json:"memoryBytes"`
+	MemoryLimit  uint64    `json:"memoryLimit"`
+	Pids         uint64    `json:"pids"`
+	NetRxBytes   uint64    `json:"netRxBytes"`
+	NetTxBytes   uint64    `json:"netTxBytes"`
+	BlockRead    uint64    `json:"blockReadBytes"`
+	BlockWritten uint64    `json:"blockWriteBytes"`
+	Time         time.Time `json:"timestamp"`
+}
+
+// Stats returns a container's current usage. The daemon takes two samples
+// about a second apart for the CPU figure, so this takes about that long.
+func (c *Client) Stats(ctx context.Context, id string) (Stats, error) {
+	var raw struct {
+		Read     time.Time `json:"read"`
+		CPUStats cpuStats  `json:"cpu_stats"`
+		PreCPU   cpuStats  `json:"precpu_stats"`
+		Memory   struct {
+			Usage uint64            `json:"usage"`
+			Limit uint64            `json:"limit"`
+			Stats map[string]uint64 `json:"stats"`
+		} `json:"memory_stats"`
+		Pids struct {
+			Current uint64 `json:"current"`
+		} `json:"pids_stats"`
+		Networks map[string]struct {
+			RxBytes uint64 `json:"rx_bytes"`
+			TxBytes uint64 `json:"tx_bytes"`
+		} `json:"networks"`
+		Blkio struct {
+			IOServiceBytes []struct {
+				Op    string `json:"op"`
+				Value uint64 `json:"value"`
+			} `json:"io_service_bytes_recursive"`
+		} `json:"blkio_stats"`
+	}
+	if err := c.getJSON(ctx, "/containers/"+url.PathEscape(id)+"/stats", url.Values{"stream": {"false"}}, &raw); err != nil {
+		return Stats{}, err
+	}
+
+	stats := Stats{
+		MemoryLimit: raw.Memory.Limit,
+		Pids:        raw.Pids.Current,
+		Time:        raw.Read,
+	}
+
+	cpuDelta := float64(raw.CPUStats.Usage.Total) - float64(raw.PreCPU.Usage.Total)
+	systemDelta := float64(raw.CPUStats.System) - float64(raw.PreCPU.System)
+	cpus := float64(raw.CPUStats.OnlineCPUs)
+	if cpus == 0 {
+		cpus = float64(len(raw.CPUStats.Usage.PerCPU))
+	}
+	if cpuDelta > 0 && systemDelta > 0 {
+		stats.CPUPercent = cpuDelta / systemDelta * cpus * 100
+	}
+
+	// Page cache counts towards usage but can be reclaimed; docker stats leaves it out too
+	stats.MemoryBytes = raw.Memory.Usage
+	for _, key := range []string{"inactive_file", "total_inactive_file"} {
+		if cache, ok := raw.Memory.Stats[key]; ok && cache < stats.MemoryBytes {
+			stats.MemoryBytes -= cache
+			break
+		}
+	}
+
+	for _, n := range raw.Networks {
+		stats.NetRxBytes += n.RxBytes
+		stats.NetTxBytes += n.TxBytes
+	}
+	for _, b := range raw.Blkio.IOServiceBytes {
+		switch strings.ToLower(b.Op) {
+		case "read":
+			stats.BlockRead += b.Value
+		case "write":
+			stats.BlockWritten += b.Value
+		}
+	}
+	return stats, nil
+}
+
+type cpuStats struct {
+	Usage struct {
+		Total  uint64   `json:"total_usage"`
+		PerCPU []uint64 `json:"percpu_usage"`
+	} `json:"cpu_usage"`
+	System     uint64 `json:"system_cpu_usage"`
+	OnlineCPUs uint32 `json:"online_cpus"`
+}
+
+// Default is the client of the socket named by DOCKER_HOST or DefaultSocket
+var Default = NewClient(SocketFromEnv())
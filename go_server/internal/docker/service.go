@@ -0,0 +1,23 @@
+package docker
+
+import "control/go_server/internal/models"
+
+// Matches reports whether a container runs a service: it carries the
+// control.service label with the service's name, or its name is the
+// service's Container
+func Matches(c Container, service models.Service) bool {
+	return c.Labels[ServiceLabel] == service.Name || (service.Container != "" && c.Name == service.Container)
+}
+
+// ServiceContainers groups containers by the service they run
+func ServiceContainers(containers []Container, services []models.Service) map[string][]Container {
+	result := make(map[string][]Container)
+	for _, service := range services {
+		for _, c := range containers {
+			if Matches(c, service) {
+				result[service.Name] = append(result[service.Name], c)
+			}
+		}
+	}
+	return result
+}
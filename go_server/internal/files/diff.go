@@ -0,0 +1,217 @@
+package files
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is how many unchanged lines surround each change
+const diffContext = 3
+
+// maxDiffEdits bounds the work of a diff; files differing in more lines are
+// shown as replaced whole
+const maxDiffEdits = 2000
+
+type editKind int
+
+const (
+	editEqual editKind = iota
+	editDelete
+	editInsert
+)
+
+type edit struct {
+	kind editKind
+	old  int // line in the old file, for equal and delete
+	new  int // line in the new file, for equal and insert
+}
+
+// Diff returns the unified diff between two versions of a file, empty when
+// they are the same
+func Diff(name string, old, new []byte) string {
+	a, b := splitLines(string(old)), splitLines(string(new))
+	edits := diffLines(a, b)
+
+	var out strings.Builder
+	for start := 0; start < len(edits); {
+		// Find the next change, and the hunk around it
+		for start < len(edits) && edits[start].kind == editEqual {
+			start++
+		}
+		if start == len(edits) {
+			break
+		}
+		end := start
+		for i := start; i < len(edits); i++ {
+			if edits[i].kind != editEqual {
+				end = i + 1
+			} else if i-end >= 2*diffContext {
+				break
+			}
+		}
+		first := max(start-diffContext, 0)
+		last := min(end+diffContext, len(edits))
+
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- a/%s\n+++ b/%s\n", name, name)
+		}
+		writeHunk(&out, a, b, edits[first:last])
+		start = last
+	}
+	return out.String()
+}
+
+func writeHunk(out *strings.Builder, a, b []string, edits []edit) {
+	oldStart, newStart := -1, -1
+	oldCount, newCount := 0, 0
+	for _, e := range edits {
+		if e.kind != editInsert {
+			if oldStart < 0 {
+				oldStart = e.old
+			}
+			oldCount++
+		}
+		if e.kind != editDelete {
+			if newStart < 0 {
+				newStart = e.new
+			}
+			newCount++
+		}
+	}
+	fmt.Fprintf(out, "@@ -%s +%s @@\n", hunkRange(oldStart, oldCount, edits, true), hunkRange(newStart, newCount, edits, false))
+	for _, e := range edits {
+		switch e.kind {
+		case editEqual:
+			writeLine(out, ' ', a[e.old])
+		case editDelete:
+			writeLine(out, '-', a[e.old])
+		case editInsert:
+			writeLine(out, '+', b[e.new])
+		}
+	}
+}
+
+// hunkRange formats the lines of a hunk in one version; a hunk without lines
+// in it names the line before it
+func hunkRange(start, count int, edits []edit, old bool) string {
+	if count == 0 {
+		// Every edit inserts, or every edit deletes; the other version's
+		// position is where the first one lands
+		e := edits[0]
+		if old {
+			return fmt.Sprintf("%d,0", e.old)
+		}
+		return fmt.Sprintf("%d,0", e.new)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+func writeLine(out *strings.Builder, prefix byte, line string) {
+	out.WriteByte(prefix)
+	out.WriteString(line)
+	if !strings.HasSuffix(line, "\n") {
+		out.WriteString("\n\\ No newline at end of file\n")
+	}
+}
+
+// splitLines splits text into lines, each keeping its newline
+func splitLines(text string) []string {
+	var lines []string
+	for text != "" {
+		i := strings.IndexByte(text, '\n')
+		if i < 0 {
+			lines = append(lines, text)
+			break
+		}
+		lines = append(lines, text[:i+1])
+		text = text[i+1:]
+	}
+	return lines
+}
+
+// diffLines finds the shortest edit script from a to b with Myers'
+// algorithm, with a and b positions kept on every edit
+func diffLines(a, b []string) []edit {
+	n, m := len(a), len(b)
+	limit := min(n+m, maxDiffEdits)
+	offset := limit + 1
+	v := make([]int, 2*limit+3)
+	// The diagonals -d-1 to d+1 of v as each round starts, all the walk back needs
+	var trace [][]int
+
+	found := false
+	for d := 0; d <= limit && !found; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || k != d && v[offset+k-1] < v[offset+k+1] {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+	}
+	if !found {
+		return replaceAll(n, m)
+	}
+
+	// Walk back from the end through the rounds
+	var edits []edit
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v, offset := trace[d], d+1
+		k := x - y
+		var prevK int
+		if k == -d || k != d && v[offset+k-1] < v[offset+k+1] {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			edits = append(edits, edit{kind: editEqual, old: x, new: y})
+		}
+		if d > 0 {
+			if x == prevX {
+				y--
+				edits = append(edits, edit{kind: editInsert, old: x, new: y})
+			} else {
+				x--
+				edits = append(edits, edit{kind: editDelete, old: x, new: y})
+			}
+		}
+		x, y = prevX, prevY
+	}
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
+
+// replaceAll deletes every line of a and inserts every line of b
+func replaceAll(n, m int) []edit {
+	edits := make([]edit, 0, n+m)
+	for i := 0; i < n; i++ {
+		edits = append(edits, edit{kind: editDelete, old: i})
+	}
+	for j := 0; j < m; j++ {
+		edits = append(edits, edit{kind: editInsert, old: n, new: j})
+	}
+	return edits
+}
@@ -0,0 +1,273 @@
+// Package files gives access to the files of a directory tree without
+// letting a path, or a symlink in it, reach outside of it.
+package files
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	ErrTooLarge   = errors.New("file is too large")
+	ErrNotRegular = errors.New("not a regular file")
+	ErrInvalid    = errors.New("invalid path")
+)
+
+// Entry is a file or directory in a listing
+type Entry struct {
+	Name    string    `json:"name"`
+	Path    string    `json:"path"` // relative to the root
+	Type    string    `json:"type"` // file, dir, symlink or other
+	Size    int64     `json:"size"`
+	Mode    string    `json:"mode"`
+	ModTime time.Time `json:"modTime"`
+	Target  string    `json:"target,omitempty"` // of a symlink
+}
+
+// Dir is a directory tree files are accessed in
+type Dir struct {
+	root *os.Root
+}
+
+// Open opens the directory tree at dir
+func Open(dir string) (*Dir, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Dir{root: root}, nil
+}
+
+func (d *Dir) Close() error {
+	return d.root.Close()
+}
+
+// Clean turns a path given by a client into a path relative to the root,
+// "." for the root itself. A leading slash is the root, and .. doesn't climb
+// above it.
+func Clean(p string) (string, error) {
+	if strings.ContainsRune(p, 0) {
+		return "", ErrInvalid
+	}
+	p = path.Clean("/" + p)
+	if p == "/" {
+		return ".", nil
+	}
+	return strings.TrimPrefix(p, "/"), nil
+}
+
+// List lists a directory, directories first
+func (d *Dir) List(p string) ([]Entry, error) {
+	f, err := d.root.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	entries, err := f.ReadDir(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]Entry, 0, len(entries))
+	for _, e := range entries {
+		entryPath := path.Join(p, e.Name())
+		entry := Entry{Name: e.Name(), Path: entryPath, Type: "other"}
+		if info, err := e.Info(); err == nil {
+			entry.Size = info.Size()
+			entry.Mode = info.Mode().String()
+			entry.ModTime = info.ModTime()
+		}
+		switch t := e.Type(); {
+		case t.IsDir():
+			entry.Type = "dir"
+		case t.IsRegular():
+			entry.Type = "file"
+		case t&fs.ModeSymlink != 0:
+			entry.Type = "symlink"
+			// Relative to the directory's descriptor, as the root's path isn't known
+			entry.Target, _ = os.Readlink(fmt.Sprintf("/proc/self/fd/%d/%s", f.Fd(), e.Name()))
+		}
+		list = append(list, entry)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if (list[i].Type == "dir") != (list[j].Type == "dir") {
+			return list[i].Type == "dir"
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list, nil
+}
+
+// Stat describes a file, following symlinks inside the root
+func (d *Dir) Stat(p string) (fs.FileInfo, error) {
+	return d.root.Stat(p)
+}
+
+// OpenFile opens a regular file for reading
+func (d *Dir) OpenFile(p string) (*os.File, fs.FileInfo, error) {
+	f, err := d.root.Open(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if !info.Mode().IsRegular() {
+		f.Close()
+		return nil, nil, ErrNotRegular
+	}
+	return f, info, nil
+}
+
+// ReadFile reads a regular file of at most max bytes
+func (d *Dir) ReadFile(p string, max int64) ([]byte, fs.FileInfo, error) {
+	f, info, err := d.OpenFile(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	if info.Size() > max {
+		return nil, info, ErrTooLarge
+	}
+	// The file may have grown since it was stat'ed
+	data, err := io.ReadAll(io.LimitReader(f, max+1))
+	if err != nil {
+		return nil, info, err
+	}
+	if int64(len(data)) > max {
+		return nil, info, ErrTooLarge
+	}
+	return data, info, nil
+}
+
+// WriteFile replaces the file at p with the content of r, at most max bytes,
+// and returns how many bytes were written. The content is written to a
+// temporary file renamed over the old one, so readers see either version
+// whole. A replaced file keeps its mode and owner; a new one is created
+// with mode 0644.
+func (d *Dir) WriteFile(p string, r io.Reader, max int64) (int64, error) {
+	dir, name := path.Split(p)
+	if name == "" || name == "." || name == ".." {
+		return 0, ErrInvalid
+	}
+	if dir == "" {
+		dir = "."
+	}
+
+	// Every step works on this directory's descriptor, so a directory
+	// swapped for a symlink meanwhile can't redirect the write
+	parent, err := d.root.Open(dir)
+	if err != nil {
+		return 0, err
+	}
+	defer parent.Close()
+	if info, err := parent.Stat(); err != nil {
+		return 0, err
+	} else if !info.IsDir() {
+		return 0, fmt.Errorf("%s is not a directory", dir)
+	}
+	dirfd := int(parent.Fd())
+
+	mode := uint32(0644)
+	uid, gid := -1, -1
+	// A symlink isn't followed, so it can't be replaced by a file
+	if fd, err := syscall.Openat(dirfd, name, syscall.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0); err == nil {
+		var st syscall.Stat_t
+		err = syscall.Fstat(fd, &st)
+		syscall.Close(fd)
+		if err != nil {
+			return 0, err
+		}
+		if st.Mode&syscall.S_IFMT != syscall.S_IFREG {
+			return 0, ErrNotRegular
+		}
+		mode = st.Mode & 0777
+		uid, gid = int(st.Uid), int(st.Gid)
+	} else if errors.Is(err, syscall.ELOOP) {
+		return 0, ErrNotRegular
+	} else if !errors.Is(err, syscall.ENOENT) {
+		return 0, err
+	}
+
+	suffix := make([]byte, 6)
+	if _, err := rand.Read(suffix); err != nil {
+		return 0, err
+	}
+	tmpName := "." + name + ".tmp-" + hex.EncodeToString(suffix)
+	fd, err := syscall.Openat(dirfd, tmpName, syscall.O_WRONLY|syscall.O_CREAT|syscall.O_EXCL|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, mode)
+	if err != nil {
+		return 0, err
+	}
+	tmp := os.NewFile(uintptr(fd), tmpName)
+	written, err := io.Copy(tmp, io.LimitReader(r, max+1))
+	if err == nil && written > max {
+		err = ErrTooLarge
+	}
+	if err == nil && uid >= 0 {
+		err = tmp.Chown(uid, gid)
+	}
+	if err == nil {
+		// The umask applied when it was created
+		err = tmp.Chmod(os.FileMode(mode))
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = syscall.Renameat(dirfd, tmpName, dirfd, name)
+	}
+	if err != nil {
+		syscall.Unlinkat(dirfd, tmpName)
+		return 0, err
+	}
+	return written, nil
+}
+
+// ETag identifies the content of a file, to notice it changed since it was read
+func ETag(data []byte) string {
+	d := NewDigest()
+	d.Write(data)
+	return d.ETag()
+}
+
+// Digest computes the ETag of the content written to it, for content that
+// is streamed rather than read whole
+type Digest struct {
+	hash hash.Hash
+}
+
+func NewDigest() *Digest {
+	return &Digest{hash: sha256.New()}
+}
+
+func (d *Digest) Write(p []byte) (int, error) {
+	return d.hash.Write(p)
+}
+
+func (d *Digest) ETag() string {
+	return hex.EncodeToString(d.hash.Sum(nil))
+}
+
+// IsText reports whether data looks like text to show and edit: valid UTF-8
+// without NUL bytes
+func IsText(data []byte) bool {
+	return utf8.Valid(data) && !strings.ContainsRune(string(data), 0)
+}
@@ -0,0 +1,326 @@
+// Package forecast predicts when a growing series reaches a capacity, using a
+// linear fit or Holt-Winters exponential smoothing.
+package forecast
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Method is a forecasting model
+type Method string
+
+const (
+	MethodLinear Method = "linear" // least squares line through the history
+	MethodHolt   Method = "holt"   // Holt-Winters: level and trend, plus a daily season when the history covers two days
+)
+
+// minPoints is the shortest history that is forecast
+const minPoints = 6
+
+// Point is one value of a series
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+// Forecast is the predicted time a series reaches its capacity. Earliest and
+// latest bound the prediction with two standard deviations of the model's
+// error; a nil time means the capacity isn't reached within the limit.
+type Forecast struct {
+	Method      Method     `json:"method"`
+	Current     float64    `json:"current"`
+	Capacity    float64    `json:"capacity"`
+	RatePerHour float64    `json:"ratePerHour"`
+	ExhaustsAt  *time.Time `json:"exhaustsAt"`
+	Earliest    *time.Time `json:"earliest"`
+	Latest      *time.Time `json:"latest"`
+	// Confidence is the share of the history's variance the model explains, 0 to 1
+	Confidence float64 `json:"confidence"`
+}
+
+// Within reports whether the capacity is expected to be reached before deadline
+func (f Forecast) Within(deadline time.Time) bool {
+	return f.ExhaustsAt != nil && f.ExhaustsAt.Before(deadline)
+}
+
+// model predicts the value h steps after the last point and its standard error
+type model func(h int) (value, sigma float64)
+
+// fit is a model fitted to a history
+type fit struct {
+	predict     model
+	current     float64
+	ratePerStep float64
+	confidence  float64
+}
+
+// Linear fits a least squares line to evenly spaced points and forecasts along it
+func Linear(points []Point, step time.Duration, capacity float64, limit time.Duration) (Forecast, error) {
+	if len(points) < minPoints {
+		return Forecast{}, fmt.Errorf("need at least %d points, got %d", minPoints, len(points))
+	}
+	fit, err := fitLinear(points)
+	if err != nil {
+		return Forecast{}, err
+	}
+	return fit.forecast(MethodLinear, points, step, capacity, limit), nil
+}
+
+// Holt smooths evenly spaced points with Holt-Winters, with a season of
+// period steps when the history covers two periods, and forecasts from the
+// final level, trend and season. The smoothing weights are the ones with the
+// lowest one-step error on the history.
+func Holt(points []Point, step time.Duration, period int, capacity float64, limit time.Duration) (Forecast, error) {
+	if len(points) < minPoints {
+		return Forecast{}, fmt.Errorf("need at least %d points, got %d", minPoints, len(points))
+	}
+	return fitHolt(points, period).forecast(MethodHolt, points, step, capacity, limit), nil
+}
+
+func (f fit) forecast(method Method, points []Point, step time.Duration, capacity float64, limit time.Duration) Forecast {
+	result := Forecast{
+		Method:      method,
+		Current:     f.current,
+		Capacity:    capacity,
+		RatePerHour: f.ratePerStep / step.Hours(),
+		Confidence:  f.confidence,
+	}
+	result.crossings(f.predict, points[len(points)-1].Time, step, limit)
+	return result
+}
+
+// fitLinear fits a least squares line to evenly spaced points; its
+// confidence is the R² of the fit
+func fitLinear(points []Point) (fit, error) {
+	var sumX, sumY, sumXX, sumXY float64
+	for i, p := range points {
+		x := float64(i)
+		sumX += x
+		sumY += p.Value
+		sumXX += x * x
+		sumXY += x * p.Value
+	}
+	n := float64(len(points))
+	denom := n*sumXX - sumX*sumX
+	if denom == 0 {
+		return fit{}, fmt.Errorf("need at least two points")
+	}
+	slope := (n*sumXY - sumX*sumY) / denom
+	intercept := (sumY - slope*sumX) / n
+
+	mean := sumY / n
+	var sse, sst float64
+	for i, p := range points {
+		v := intercept + slope*float64(i)
+		sse += (p.Value - v) * (p.Value - v)
+		sst += (p.Value - mean) * (p.Value - mean)
+	}
+	sigma := math.Sqrt(sse / n)
+
+	last := n - 1
+	return fit{
+		predict: func(h int) (float64, float64) {
+			return intercept + slope*(last+float64(h)), sigma
+		},
+		current:     intercept + slope*last,
+		ratePerStep: slope,
+		confidence:  explained(sse, sst),
+	}, nil
+}
+
+// fitHolt runs Holt-Winters over evenly spaced points with the smoothing
+// weights that have the lowest one-step error; its confidence is the share of
+// variance the one-step predictions explain
+func fitHolt(points []Point, period int) fit {
+	values := make([]float64, len(points))
+	for i, p := range points {
+		values[i] = p.Value
+	}
+	if len(values) < 2*period {
+		period = 0
+	}
+
+	var best *holtState
+	for _, alpha := range []float64{0.1, 0.3, 0.5, 0.7, 0.9} {
+		for _, beta := range []float64{0.01, 0.05, 0.1, 0.2, 0.4} {
+			s := smooth(values, period, alpha, beta, 0.2)
+			if best == nil || s.sse < best.sse {
+				best = &s
+			}
+		}
+	}
+
+	var mean, sst float64
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	for _, v := range values {
+		sst += (v - mean) * (v - mean)
+	}
+	sigma := math.Sqrt(best.sse / float64(best.count))
+
+	n := len(values)
+	return fit{
+		predict: func(h int) (float64, float64) {
+			v := best.level + float64(h)*best.trend
+			if period > 0 {
+				v += best.season[(n+h-1)%period]
+			}
+			return v, sigma * math.Sqrt(float64(h))
+		},
+		current:     best.level,
+		ratePerStep: best.trend,
+		confidence:  explained(best.sse*float64(n)/float64(best.count), sst),
+	}
+}
+
+// Best returns the forecast of whichever model predicted the last fifth of
+// the history better when fitted to the rest of it
+func Best(points []Point, step time.Duration, period int, capacity float64, limit time.Duration) (Forecast, error) {
+	linear, err := Linear(points, step, capacity, limit)
+	if err != nil {
+		return Forecast{}, err
+	}
+	split := len(points) * 4 / 5
+	if split < minPoints || len(points)-split < 1 {
+		return linear, nil
+	}
+	train, test := points[:split], points[split:]
+	linearFit, err := fitLinear(train)
+	if err != nil {
+		return linear, nil
+	}
+	holtFit := fitHolt(train, period)
+	if holdoutError(holtFit.predict, test) >= holdoutError(linearFit.predict, test) {
+		return linear, nil
+	}
+	return Holt(points, step, period, capacity, limit)
+}
+
+// holdoutError is the mean squared error of predict over the points following the fitted ones
+func holdoutError(predict model, test []Point) float64 {
+	var sse float64
+	for i, p := range test {
+		v, _ := predict(i + 1)
+		sse += (p.Value - v) * (p.Value - v)
+	}
+	return sse / float64(len(test))
+}
+
+type holtState struct {
+	level, trend float64
+	season       []float64
+	sse          float64 // squared one-step errors
+	count        int     // number of one-step errors
+}
+
+// smooth runs additive Holt-Winters over values; period 0 leaves out the season
+func smooth(values []float64, period int, alpha, beta, gamma float64) holtState {
+	var s holtState
+	start := 1
+	if period > 0 {
+		// Initial level, trend and season from the first two periods
+		var first, second float64
+		for i := 0; i < period; i++ {
+			first += values[i]
+			second += values[period+i]
+		}
+		first /= float64(period)
+		second /= float64(period)
+		s.level = first
+		s.trend = (second - first) / float64(period)
+		s.season = make([]float64, period)
+		for i := 0; i < period; i++ {
+			s.season[i] = values[i] - first
+		}
+		start = period
+	} else {
+		s.level = values[0]
+		s.trend = values[1] - values[0]
+	}
+
+	for t := start; t < len(values); t++ {
+		x := values[t]
+		var seasonal float64
+		if period > 0 {
+			seasonal = s.season[t%period]
+		}
+		err := x - (s.level + s.trend + seasonal)
+		s.sse += err * err
+		s.count++
+
+		level := alpha*(x-seasonal) + (1-alpha)*(s.level+s.trend)
+		s.trend = beta*(level-s.level) + (1-beta)*s.trend
+		s.level = level
+		if period > 0 {
+			s.season[t%period] = gamma*(x-level) + (1-gamma)*seasonal
+		}
+	}
+	if s.count == 0 {
+		s.count = 1
+	}
+	return s
+}
+
+// crossings sets the expected, earliest and latest times the prediction
+// reaches the capacity, stepping forward from last up to limit
+func (f *Forecast) crossings(predict model, last time.Time, step time.Duration, limit time.Duration) {
+	if f.Current >= f.Capacity {
+		f.ExhaustsAt, f.Earliest, f.Latest = &last, &last, &last
+		return
+	}
+	steps := int(limit / step)
+	for h := 1; h <= steps && f.Latest == nil; h++ {
+		value, sigma := predict(h)
+		at := last.Add(time.Duration(h) * step)
+		if f.Earliest == nil && value+2*sigma >= f.Capacity {
+			f.Earliest = &at
+		}
+		if f.ExhaustsAt == nil && value >= f.Capacity {
+			f.ExhaustsAt = &at
+		}
+		if value-2*sigma >= f.Capacity {
+			f.Latest = &at
+		}
+	}
+}
+
+// explained returns 1 - sse/sst clamped to [0, 1]
+func explained(sse, sst float64) float64 {
+	if sst == 0 {
+		if sse == 0 {
+			return 1
+		}
+		return 0
+	}
+	return math.Max(0, math.Min(1, 1-sse/sst))
+}
+
+// Resample turns irregular points into one point per step from the first to
+// the last, interpolating linearly across gaps
+func Resample(points []Point, step time.Duration) []Point {
+	if len(points) == 0 {
+		return nil
+	}
+	start := points[0].Time.Truncate(step)
+	end := points[len(points)-1].Time
+	var result []Point
+	i := 0
+	for t := start; !t.After(end); t = t.Add(step) {
+		for i+1 < len(points) && !points[i+1].Time.After(t) {
+			i++
+		}
+		p := points[i]
+		value := p.Value
+		if i+1 < len(points) && t.After(p.Time) {
+			next := points[i+1]
+			frac := float64(t.Sub(p.Time)) / float64(next.Time.Sub(p.Time))
+			value = p.Value + (next.Value-p.Value)*frac
+		}
+		result = append(result, Point{Time: t, Value: value})
+	}
+	return result
+}
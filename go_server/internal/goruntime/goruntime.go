@@ -0,0 +1,211 @@
+// Package goruntime reads Go runtime statistics of a service from its
+// net/http/pprof and expvar endpoints.
+package goruntime
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxBodyBytes = 16 << 20
+
+// PauseQuantiles summarizes the recent GC pauses, in seconds
+type PauseQuantiles struct {
+	P50 float64 `json:"p50"`
+	P95 float64 `json:"p95"`
+	P99 float64 `json:"p99"`
+	Max float64 `json:"max"`
+}
+
+// Stats is one reading of a service's Go runtime
+type Stats struct {
+	Goroutines    int            `json:"goroutines"`
+	HeapAlloc     uint64         `json:"heapAlloc"`
+	HeapInuse     uint64         `json:"heapInuse"`
+	HeapSys       uint64         `json:"heapSys"`
+	NumGC         uint32         `json:"numGC"`
+	GCPause       PauseQuantiles `json:"gcPause"` // over the last 256 collections at most
+	GCCPUFraction float64        `json:"gcCpuFraction"`
+	Source        string         `json:"source"` // where the memory stats came from: expvar or pprof
+	Timestamp     time.Time      `json:"timestamp"`
+}
+
+// memStats holds the runtime.MemStats fields used here, as exposed by both
+// /debug/vars and the heap profile's debug=1 output
+type memStats struct {
+	HeapAlloc     uint64
+	HeapInuse     uint64
+	HeapSys       uint64
+	NumGC         uint32
+	PauseNs       []uint64
+	GCCPUFraction float64
+}
+
+// VarsURL returns the expvar URL served next to a pprof URL such as
+// http://host:port/debug/pprof/
+func VarsURL(pprofURL string) string {
+	base := strings.TrimSuffix(strings.TrimSuffix(pprofURL, "/"), "/pprof")
+	return base + "/vars"
+}
+
+// Fetch reads the goroutine count from the goroutine profile and the memory
+// stats from /debug/vars, falling back to the heap profile when the service
+// doesn't publish expvar.
+func Fetch(client *http.Client, pprofURL string) (Stats, error) {
+	stats := Stats{Timestamp: time.Now()}
+
+	goroutines, err := fetchGoroutines(client, pprofURL)
+	if err != nil {
+		return stats, fmt.Errorf("goroutine profile: %v", err)
+	}
+	stats.Goroutines = goroutines
+
+	mem, err := fetchExpvar(client, VarsURL(pprofURL))
+	stats.Source = "expvar"
+	if err != nil {
+		if mem, err = fetchHeapProfile(client, pprofURL); err != nil {
+			return stats, fmt.Errorf("heap profile: %v", err)
+		}
+		stats.Source = "pprof"
+	}
+
+	stats.HeapAlloc = mem.HeapAlloc
+	stats.HeapInuse = mem.HeapInuse
+	stats.HeapSys = mem.HeapSys
+	stats.NumGC = mem.NumGC
+	stats.GCCPUFraction = mem.GCCPUFraction
+	stats.GCPause = pauseQuantiles(mem.PauseNs, mem.NumGC)
+	return stats, nil
+}
+
+func get(client *http.Client, url string) (io.ReadCloser, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// fetchGoroutines reads the "goroutine profile: total N" header of the debug=1 goroutine profile
+func fetchGoroutines(client *http.Client, pprofURL string) (int, error) {
+	body, err := get(client, pprofURL+"goroutine?debug=1")
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+
+	scanner := bufio.NewScanner(io.LimitReader(body, maxBodyBytes))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if total, ok := strings.CutPrefix(scanner.Text(), "goroutine profile: total "); ok {
+			return strconv.Atoi(strings.TrimSpace(total))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("no goroutine total in response")
+}
+
+func fetchExpvar(client *http.Client, url string) (memStats, error) {
+	body, err := get(client, url)
+	if err != nil {
+		return memStats{}, err
+	}
+	defer body.Close()
+
+	var vars struct {
+		Memstats *memStats `json:"memstats"`
+	}
+	if err := json.NewDecoder(io.LimitReader(body, maxBodyBytes)).Decode(&vars); err != nil {
+		return memStats{}, err
+	}
+	if vars.Memstats == nil {
+		return memStats{}, fmt.Errorf("no memstats in expvar output")
+	}
+	return *vars.Memstats, nil
+}
+
+// fetchHeapProfile reads the "# Name = value" runtime.MemStats trailer of the debug=1 heap profile
+func fetchHeapProfile(client *http.Client, pprofURL string) (memStats, error) {
+	body, err := get(client, pprofURL+"heap?debug=1")
+	if err != nil {
+		return memStats{}, err
+	}
+	defer body.Close()
+	return parseHeapProfile(io.LimitReader(body, maxBodyBytes))
+}
+
+func parseHeapProfile(r io.Reader) (memStats, error) {
+	var mem memStats
+	found := false
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(strings.TrimPrefix(scanner.Text(), "# "), " = ")
+		if !ok {
+			continue
+		}
+		switch name {
+		case "HeapAlloc":
+			mem.HeapAlloc, _ = strconv.ParseUint(value, 10, 64)
+			found = true
+		case "HeapInuse":
+			mem.HeapInuse, _ = strconv.ParseUint(value, 10, 64)
+		case "HeapSys":
+			mem.HeapSys, _ = strconv.ParseUint(value, 10, 64)
+		case "NumGC":
+			n, _ := strconv.ParseUint(value, 10, 32)
+			mem.NumGC = uint32(n)
+		case "GCCPUFraction":
+			mem.GCCPUFraction, _ = strconv.ParseFloat(value, 64)
+		case "PauseNs":
+			for _, field := range strings.Fields(strings.Trim(value, "[]")) {
+				ns, _ := strconv.ParseUint(field, 10, 64)
+				mem.PauseNs = append(mem.PauseNs, ns)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return mem, err
+	}
+	if !found {
+		return mem, fmt.Errorf("no memory stats in heap profile")
+	}
+	return mem, nil
+}
+
+// pauseQuantiles summarizes the valid entries of the PauseNs ring buffer,
+// which holds the most recent min(numGC, 256) pauses
+func pauseQuantiles(pauseNs []uint64, numGC uint32) PauseQuantiles {
+	n := min(int(numGC), len(pauseNs))
+	if n == 0 {
+		return PauseQuantiles{}
+	}
+	pauses := make([]float64, n)
+	for i := 0; i < n; i++ {
+		pauses[i] = float64(pauseNs[i]) / 1e9
+	}
+	sort.Float64s(pauses)
+
+	quantile := func(q float64) float64 {
+		return pauses[min(int(q*float64(n)), n-1)]
+	}
+	return PauseQuantiles{
+		P50: quantile(0.5),
+		P95: quantile(0.95),
+		P99: quantile(0.99),
+		Max: pauses[n-1],
+	}
+}
@@ -0,0 +1,138 @@
+// Package live fans out events from the background collectors to the
+// dashboard clients subscribed to their topics.
+package live
+
+import (
+	"sync"
+	"time"
+)
+
+// subscriptionBuffer is how many events a slow client may fall behind before
+// new ones are dropped for it
+const subscriptionBuffer = 64
+
+// Event is a message on a topic
+type Event struct {
+	ID    uint64    `json:"id"`
+	Topic string    `json:"topic"`
+	Data  any       `json:"data"`
+	Time  time.Time `json:"timestamp"`
+}
+
+// Subscription receives the events of its topics on C until it is closed
+type Subscription struct {
+	C <-chan Event
+
+	hub     *Hub
+	topics  map[string]bool // empty means every topic
+	events  chan Event
+	dropped uint64
+	closed  bool
+}
+
+// Dropped returns how many events were dropped because the subscriber was too slow
+func (s *Subscription) Dropped() uint64 {
+	s.hub.mutex.Lock()
+	defer s.hub.mutex.Unlock()
+	return s.dropped
+}
+
+// Close unsubscribes and closes C
+func (s *Subscription) Close() {
+	s.hub.mutex.Lock()
+	defer s.hub.mutex.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	delete(s.hub.subscriptions, s)
+	close(s.events)
+}
+
+func (s *Subscription) wants(topic string) bool {
+	return len(s.topics) == 0 || s.topics[topic]
+}
+
+// send delivers an event without blocking; the caller holds the hub mutex
+func (s *Subscription) send(event Event) {
+	select {
+	case s.events <- event:
+	default:
+		s.dropped++
+	}
+}
+
+// Hub publishes events to subscriptions. State topics keep their last event,
+// which new subscriptions receive first, so clients start from the current
+// state instead of waiting for the next collection.
+type Hub struct {
+	mutex         sync.Mutex
+	nextID        uint64
+	subscriptions map[*Subscription]bool
+	state         map[string]Event
+}
+
+// NewHub creates a hub without subscriptions
+func NewHub() *Hub {
+	return &Hub{subscriptions: make(map[*Subscription]bool), state: make(map[string]Event)}
+}
+
+// Subscribe subscribes to topics, or to every topic when none are given
+func (h *Hub) Subscribe(topics ...string) *Subscription {
+	events := make(chan Event, subscriptionBuffer)
+	s := &Subscription{C: events, hub: h, topics: make(map[string]bool), events: events}
+	for _, topic := range topics {
+		s.topics[topic] = true
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for topic, event := range h.state {
+		if s.wants(topic) {
+			s.send(event)
+		}
+	}
+	h.subscriptions[s] = true
+	return s
+}
+
+// Publish sends an event to the subscribers of its topic
+func (h *Hub) Publish(topic string, data any) {
+	h.publish(topic, data, false)
+}
+
+// SetState publishes the current state of a topic and keeps it for new subscribers
+func (h *Hub) SetState(topic string, data any) {
+	h.publish(topic, data, true)
+}
+
+// State returns the kept state of a topic
+func (h *Hub) State(topic string) (any, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	event, ok := h.state[topic]
+	return event.Data, ok
+}
+
+// Subscribers returns the number of subscriptions
+func (h *Hub) Subscribers() int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return len(h.subscriptions)
+}
+
+func (h *Hub) publish(topic string, data any, keep bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.nextID++
+	event := Event{ID: h.nextID, Topic: topic, Data: data, Time: time.Now()}
+	if keep {
+		h.state[topic] = event
+	}
+	for s := range h.subscriptions {
+		if s.wants(topic) {
+			s.send(event)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package logs
+
+import (
+	"control/go_server/internal/models"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxAlertSamples is the number of matching lines kept on a firing alert
+const maxAlertSamples = 5
+
+var levelRank = map[string]int{"DEBUG": 1, "INFO": 2, "WARN": 3, "ERROR": 4, "FATAL": 5, "PANIC": 6}
+
+// LevelAtLeast reports whether level is at least as severe as min
+func LevelAtLeast(level, min string) bool {
+	return levelRank[NormalizeLevel(level)] >= levelRank[NormalizeLevel(min)]
+}
+
+// ValidateAlertRule checks a rule before it is added to the engine
+func ValidateAlertRule(rule models.LogAlertRule) error {
+	if rule.Name == "" {
+		return fmt.Errorf("rule name is required")
+	}
+	if rule.Service == "" {
+		return fmt.Errorf("rule service is required")
+	}
+	if rule.Pattern == "" && rule.Level == "" {
+		return fmt.Errorf("rule needs a pattern or a level")
+	}
+	if rule.Level != "" && NormalizeLevel(rule.Level) == "" {
+		return fmt.Errorf("unknown level %q", rule.Level)
+	}
+	if rule.Threshold < 0 {
+		return fmt.Errorf("threshold cannot be negative")
+	}
+	if _, err := regexp.Compile(rule.Pattern); err != nil {
+		return fmt.Errorf("invalid pattern: %v", err)
+	}
+	return nil
+}
+
+// AlertStatus is the live state of one rule
+type AlertStatus struct {
+	Rule        models.LogAlertRule   `json:"rule"`
+	State       models.LogAlertState  `json:"state"`
+	WindowCount int                   `json:"windowCount"` // matches inside the current window
+	Active      *models.LogAlertEvent `json:"active,omitempty"`
+	LastMatch   *time.Time            `json:"lastMatch,omitempty"`
+}
+
+// matchCount counts the matches of a rule in one second, so a flood of
+// matching lines takes no more memory than a trickle
+type matchCount struct {
+	second time.Time
+	count  int
+}
+
+type ruleState struct {
+	rule    models.LogAlertRule
+	pattern *regexp.Regexp
+	matches []matchCount // matches inside the window by second, oldest first
+	count   int          // matches inside the window
+	samples []string     // recent matching lines while not firing
+	active  *models.LogAlertEvent
+	state   models.LogAlertState
+	last    *time.Time
+}
+
+// AlertEngine evaluates log alert rules against log entries. Each rule fires
+// once while its condition holds and resolves once it stops holding, so a
+// burst of matching lines produces a single alert.
+type AlertEngine struct {
+	mutex   sync.Mutex
+	rules   map[string]*ruleState
+	onEvent func(models.LogAlertEvent)
+}
+
+// NewAlertEngine creates an engine that reports firing and resolved alerts to onEvent
+func NewAlertEngine(onEvent func(models.LogAlertEvent)) *AlertEngine {
+	return &AlertEngine{
+		rules:   make(map[string]*ruleState),
+		onEvent: onEvent,
+	}
+}
+
+// SetRules replaces the rule set. Rules that keep their name keep their state.
+func (e *AlertEngine) SetRules(rules []models.LogAlertRule) error {
+	next := make(map[string]*ruleState, len(rules))
+	for _, rule := range rules {
+		if err := ValidateAlertRule(rule); err != nil {
+			return fmt.Errorf("rule %s: %v", rule.Name, err)
+		}
+		pattern, _ := regexp.Compile(rule.Pattern)
+		next[rule.Name] = &ruleState{rule: rule, pattern: pattern, state: models.LogAlertInactive}
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for name, state := range next {
+		if old, ok := e.rules[name]; ok && old.rule.Service == state.rule.Service && old.rule.Source == state.rule.Source {
+			state.matches, state.count, state.samples, state.active, state.state, state.last = old.matches, old.count, old.samples, old.active, old.state, old.last
+		}
+	}
+	e.rules = next
+	return nil
+}
+
+// AlertTarget is a service log source watched by at least one enabled rule.
+// An empty Source stands for the service's first source.
+type AlertTarget struct {
+	Service string
+	Source  string
+}
+
+// Targets lists the log sources referenced by enabled rules
+func (e *AlertEngine) Targets() []AlertTarget {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	seen := make(map[AlertTarget]bool)
+	var targets []AlertTarget
+	for _, rs := range e.rules {
+		target := AlertTarget{Service: rs.rule.Service, Source: rs.rule.Source}
+		if rs.rule.Enabled && !seen[target] {
+			seen[target] = true
+			targets = append(targets, target)
+		}
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		if targets[i].Service != targets[j].Service {
+			return targets[i].Service < targets[j].Service
+		}
+		return targets[i].Source < targets[j].Source
+	})
+	return targets
+}
+
+// Process feeds new log entries of a service log source to the rules watching it
+func (e *AlertEngine) Process(target AlertTarget, entries []Entry, now time.Time) {
+	var events []models.LogAlertEvent
+
+	e.mutex.Lock()
+	for _, rs := range e.rules {
+		if !rs.rule.Enabled || rs.rule.Service != target.Service || rs.rule.Source != target.Source {
+			continue
+		}
+		for _, entry := range entries {
+			if !rs.matchEntry(entry) {
+				continue
+			}
+			rs.match(now)
+			t := now
+			rs.last = &t
+			if rs.active != nil {
+				rs.active.Count++
+				if len(rs.active.Samples) < maxAlertSamples {
+					rs.active.Samples = append(rs.active.Samples, entry.Raw)
+				}
+			} else {
+				// keep the most recent lines leading up to the alert as its samples
+				rs.samples = append(rs.samples, entry.Raw)
+				if len(rs.samples) > maxAlertSamples {
+					rs.samples = rs.samples[1:]
+				}
+			}
+		}
+		rs.prune(now)
+		if rs.active == nil && rs.count > rs.rule.Threshold {
+			rs.fire(now)
+			events = append(events, *rs.active)
+		}
+	}
+	e.mutex.Unlock()
+
+	e.emit(events)
+}
+
+// Evaluate resolves alerts whose condition no longer holds. It should be
+// called periodically, since resolution happens when lines stop arriving.
+func (e *AlertEngine) Evaluate(now time.Time) {
+	var events []models.LogAlertEvent
+
+	e.mutex.Lock()
+	for _, rs := range e.rules {
+		rs.prune(now)
+		if rs.active == nil {
+			continue
+		}
+		if !rs.rule.Enabled || rs.count <= rs.rule.Threshold {
+			end := now
+			rs.active.State = models.LogAlertResolved
+			rs.active.EndsAt = &end
+			rs.active.EventTime = now
+			events = append(events, *rs.active)
+			rs.active = nil
+			rs.state = models.LogAlertResolved
+		}
+	}
+	e.mutex.Unlock()
+
+	e.emit(events)
+}
+
+// Status returns the live state of every rule, sorted by name
+func (e *AlertEngine) Status() []AlertStatus {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	statuses := make([]AlertStatus, 0, len(e.rules))
+	for _, rs := range e.rules {
+		status := AlertStatus{Rule: rs.rule, State: rs.state, WindowCount: rs.count, LastMatch: rs.last}
+		if rs.active != nil {
+			active := *rs.active
+			active.Samples = append([]string(nil), rs.active.Samples...)
+			status.Active = &active
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Rule.Name < statuses[j].Rule.Name })
+	return statuses
+}
+
+func (e *AlertEngine) emit(events []models.LogAlertEvent) {
+	if e.onEvent == nil {
+		return
+	}
+	for _, event := range events {
+		e.onEvent(event)
+	}
+}
+
+func (rs *ruleState) matchEntry(entry Entry) bool {
+	if rs.rule.Level != "" && !LevelAtLeast(entry.Level, rs.rule.Level) {
+		return false
+	}
+	if rs.rule.Pattern != "" && !rs.pattern.MatchString(entry.Raw) {
+		return false
+	}
+	return true
+}
+
+// match counts a match at now
+func (rs *ruleState) match(now time.Time) {
+	second := now.Truncate(time.Second)
+	if n := len(rs.matches); n > 0 && rs.matches[n-1].second.Equal(second) {
+		rs.matches[n-1].count++
+	} else {
+		rs.matches = append(rs.matches, matchCount{second: second, count: 1})
+	}
+	rs.count++
+}
+
+// prune drops the seconds of matches that fell out of the window
+func (rs *ruleState) prune(now time.Time) {
+	cutoff := now.Add(-rs.rule.Window()).Truncate(time.Second)
+	i := 0
+	for i < len(rs.matches) && rs.matches[i].second.Before(cutoff) {
+		rs.count -= rs.matches[i].count
+		i++
+	}
+	rs.matches = rs.matches[i:]
+}
+
+func (rs *ruleState) fire(now time.Time) {
+	rs.active = &models.LogAlertEvent{
+		ID:        fmt.Sprintf("%s-%d", rs.rule.Name, now.UnixNano()),
+		Rule:      rs.rule.Name,
+		Service:   rs.rule.Service,
+		Source:    rs.rule.Source,
+		Severity:  rs.rule.Severity,
+		State:     models.LogAlertFiring,
+		StartsAt:  now,
+		Count:     rs.count,
+		Samples:   rs.samples,
+		EventTime: now,
+	}
+	rs.samples = nil
+	rs.state = models.LogAlertFiring
+}
@@ -0,0 +1,284 @@
+package logs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Export archive formats
+const (
+	ExportTarGz = "tar.gz"
+	ExportZip   = "zip"
+)
+
+// ExportOptions selects the lines written to a log export
+type ExportOptions struct {
+	Start   time.Time
+	End     time.Time
+	Keyword string   // case-insensitive substring, empty matches every line
+	Levels  []string // empty matches every level
+	Archive string   // ExportTarGz or ExportZip
+}
+
+// ExportItem is one log source of a service to export
+type ExportItem struct {
+	Service string
+	Source  Source
+}
+
+// ExportManifestSource records what was exported from one source
+type ExportManifestSource struct {
+	Service  string   `json:"service"`
+	Source   string   `json:"source"`
+	Type     string   `json:"type"`
+	Location string   `json:"location"`
+	File     string   `json:"file"`               // path inside the archive
+	Segments []string `json:"segments,omitempty"` // files read, oldest first
+	Lines    int      `json:"lines"`
+	Bytes    int64    `json:"bytes"`
+	// Skipped lines came before the first line with a timestamp, so there
+	// was no time to decide on them by
+	Skipped int    `json:"skipped,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// ExportManifest is written to manifest.json at the end of every export
+type ExportManifest struct {
+	GeneratedAt time.Time              `json:"generatedAt"`
+	Start       time.Time              `json:"start"`
+	End         time.Time              `json:"end"`
+	Keyword     string                 `json:"keyword,omitempty"`
+	Levels      []string               `json:"levels,omitempty"`
+	Sources     []ExportManifestSource `json:"sources"`
+	TotalLines  int                    `json:"totalLines"`
+}
+
+// segmentedSource is implemented by sources backed by files, which lets an
+// export skip segments entirely outside the requested window
+type segmentedSource interface {
+	segments() ([]Segment, error)
+}
+
+func (s fileSource) segments() ([]Segment, error) { return Segments(s.def.Path) }
+
+func (s globSource) segments() ([]Segment, error) { return s.files() }
+
+// archiveWriter adds files to a tar.gz or zip stream
+type archiveWriter interface {
+	add(name string, r io.Reader, size int64, modTime time.Time) error
+	Close() error
+}
+
+type tarGzWriter struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func (t *tarGzWriter) add(name string, r io.Reader, size int64, modTime time.Time) error {
+	if err := t.tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: size, ModTime: modTime}); err != nil {
+		return err
+	}
+	_, err := io.Copy(t.tw, r)
+	return err
+}
+
+func (t *tarGzWriter) Close() error {
+	if err := t.tw.Close(); err != nil {
+		return err
+	}
+	return t.gz.Close()
+}
+
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func (z *zipWriter) add(name string, r io.Reader, size int64, modTime time.Time) error {
+	w, err := z.zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modTime})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+func (z *zipWriter) Close() error { return z.zw.Close() }
+
+func newArchiveWriter(format string, w io.Writer) (archiveWriter, error) {
+	switch format {
+	case ExportTarGz, "":
+		gz := gzip.NewWriter(w)
+		return &tarGzWriter{gz: gz, tw: tar.NewWriter(gz)}, nil
+	case ExportZip:
+		return &zipWriter{zw: zip.NewWriter(w)}, nil
+	}
+	return nil, fmt.Errorf("unsupported export format %q", format)
+}
+
+// Export streams the matching lines of every item to w as an archive with one
+// file per source, named service/source.log, followed by manifest.json.
+// A source that fails to read is recorded in the manifest and skipped.
+func Export(w io.Writer, items []ExportItem, opts ExportOptions) (ExportManifest, error) {
+	manifest := ExportManifest{
+		GeneratedAt: time.Now(),
+		Start:       opts.Start,
+		End:         opts.End,
+		Keyword:     opts.Keyword,
+		Levels:      opts.Levels,
+	}
+
+	archive, err := newArchiveWriter(opts.Archive, w)
+	if err != nil {
+		return manifest, err
+	}
+
+	for _, item := range items {
+		def := item.Source.Definition()
+		entry := ExportManifestSource{
+			Service:  item.Service,
+			Source:   def.Name,
+			Type:     string(def.Type),
+			Location: def.Path,
+			File:     fmt.Sprintf("%s/%s.log", item.Service, def.Name),
+		}
+		if def.Unit != "" {
+			entry.Location = def.Unit
+		} else if def.Container != "" {
+			entry.Location = def.Container
+		}
+
+		if err := exportSource(archive, item.Source, opts, &entry); err != nil {
+			entry.Error = err.Error()
+		}
+		manifest.TotalLines += entry.Lines
+		manifest.Sources = append(manifest.Sources, entry)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return manifest, err
+	}
+	if err := archive.add("manifest.json", strings.NewReader(string(data)), int64(len(data)), manifest.GeneratedAt); err != nil {
+		return manifest, err
+	}
+	return manifest, archive.Close()
+}
+
+// exportSource filters one source into a temporary file, since tar needs the
+// size of an entry before its content, then adds it to the archive
+func exportSource(archive archiveWriter, src Source, opts ExportOptions, entry *ExportManifestSource) error {
+	tmp, err := os.CreateTemp("", "log-export-*.log")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	bw := bufio.NewWriter(tmp)
+	var writeErr error
+	write := func(line string) bool {
+		if _, writeErr = bw.WriteString(line + "\n"); writeErr != nil {
+			return false
+		}
+		entry.Lines++
+		return true
+	}
+
+	if err := scanExportLines(src, opts, entry, write); err != nil {
+		return err
+	}
+	if writeErr != nil {
+		return writeErr
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	entry.Bytes = size
+	return archive.add(entry.File, tmp, size, time.Now())
+}
+
+// scanExportLines calls write for every line of src inside the export window.
+// Lines without a timestamp of their own (stack traces, wrapped messages)
+// inherit the timestamp and filter decision of the line before them.
+func scanExportLines(src Source, opts ExportOptions, entry *ExportManifestSource, write func(string) bool) error {
+	filter := Filter{Levels: opts.Levels}
+	keyword := strings.ToLower(opts.Keyword)
+
+	format := src.Definition().Format
+	var sample []string
+	if format == "" || format == "auto" {
+		sample, _ = src.Tail(50)
+	}
+	parser, err := ParserFor(format, sample)
+	if err != nil {
+		parser = Detect(sample)
+	}
+
+	var current *time.Time
+	include := false
+
+	fn := func(segment, line string) bool {
+		if parsed, ok := parser.Parse(line); ok && parsed.Time != nil {
+			current = parsed.Time
+			include = !current.Before(opts.Start) && !current.After(opts.End) &&
+				filter.Match(parsed) &&
+				(keyword == "" || strings.Contains(strings.ToLower(line), keyword))
+		} else if current == nil {
+			// nothing to inherit from yet
+			entry.Skipped++
+			return true
+		}
+
+		if include {
+			return write(line)
+		}
+		return true
+	}
+
+	// Without any timestamp, every line was skipped however the export was filtered
+	defer func() {
+		if current == nil && entry.Skipped > 0 && entry.Error == "" {
+			entry.Error = fmt.Sprintf("no timestamp found in %d lines; check the source's log format", entry.Skipped)
+		}
+	}()
+
+	seg, ok := src.(segmentedSource)
+	if !ok {
+		entry.Segments = []string{entry.Location}
+		return src.Scan(fn)
+	}
+
+	segments, err := seg.segments()
+	if err != nil {
+		return err
+	}
+	var selected []Segment
+	for i, segment := range segments {
+		// a segment holds lines written between the previous segment's time and its own
+		if segment.Time.Before(opts.Start) {
+			continue
+		}
+		if i > 0 && segments[i-1].Time.After(opts.End) {
+			break
+		}
+		selected = append(selected, segment)
+		entry.Segments = append(entry.Segments, segment.Name)
+	}
+	return scanSegments(selected, fn)
+}
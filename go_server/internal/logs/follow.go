@@ -0,0 +1,84 @@
+package logs
+
+import (
+	"io"
+	"os"
+	"strings"
+)
+
+// Follower reads lines appended to a log file, like tail -F. It survives
+// copytruncate rotation (the file shrinks) and rename rotation (the path
+// points at a new file) by starting over at the beginning of the new data.
+type Follower struct {
+	path    string
+	info    os.FileInfo
+	offset  int64
+	partial string
+}
+
+// NewFollower creates a follower positioned at the current end of path, so
+// only lines written from now on are returned
+func NewFollower(path string) *Follower {
+	f := &Follower{path: path}
+	if info, err := os.Stat(path); err == nil {
+		f.info = info
+		f.offset = info.Size()
+	}
+	return f
+}
+
+// Path returns the followed file
+func (f *Follower) Path() string {
+	return f.path
+}
+
+// Poll returns the complete lines written since the previous call. A missing
+// file yields no lines and no error; following resumes once it reappears.
+func (f *Follower) Poll() ([]string, error) {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if f.info == nil || !os.SameFile(f.info, info) || info.Size() < f.offset {
+		// new or truncated file, read it from the start
+		f.offset = 0
+		f.partial = ""
+	}
+	f.info = info
+
+	if info.Size() == f.offset {
+		return nil, nil
+	}
+
+	file, err := os.Open(f.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// cap a single poll so a burst doesn't hold everything in memory
+	const maxRead = 8 * 1024 * 1024
+	size := info.Size() - f.offset
+	if size > maxRead {
+		size = maxRead
+	}
+	buf := make([]byte, size)
+	n, err := file.ReadAt(buf, f.offset)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	f.offset += int64(n)
+
+	data := f.partial + string(buf[:n])
+	last := strings.LastIndexByte(data, '\n')
+	if last < 0 {
+		f.partial = data
+		return nil, nil
+	}
+	f.partial = data[last+1:]
+	return strings.Split(data[:last], "\n"), nil
+}
@@ -0,0 +1,498 @@
+package logs
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry is a structured log line
+type Entry struct {
+	Time    *time.Time     `json:"time,omitempty"`
+	Level   string         `json:"level,omitempty"` // DEBUG, INFO, WARN, ERROR, FATAL or PANIC
+	Caller  string         `json:"caller,omitempty"`
+	TraceID string         `json:"traceId,omitempty"`
+	Message string         `json:"message"`
+	Fields  map[string]any `json:"fields,omitempty"`
+	Stack   string         `json:"stack,omitempty"` // continuation lines such as stack traces
+	Format  string         `json:"format"`
+	Raw     string         `json:"raw"`
+}
+
+// Parser turns a raw log line into an Entry. Parse returns false when the
+// line isn't in the parser's format.
+type Parser interface {
+	Name() string
+	Parse(line string) (Entry, bool)
+}
+
+var (
+	parsers      = make(map[string]Parser)
+	parserOrder  []string
+	parsersMutex sync.RWMutex
+)
+
+func init() {
+	RegisterParser(jsonParser{})
+	RegisterParser(consoleParser{})
+	RegisterParser(logrusParser{})
+	RegisterParser(plainParser{})
+}
+
+// RegisterParser makes a parser available to detection and by name
+func RegisterParser(p Parser) {
+	parsersMutex.Lock()
+	defer parsersMutex.Unlock()
+	if _, exists := parsers[p.Name()]; !exists {
+		parserOrder = append(parserOrder, p.Name())
+	}
+	parsers[p.Name()] = p
+}
+
+// ParserNames lists the registered parsers in registration order
+func ParserNames() []string {
+	parsersMutex.RLock()
+	defer parsersMutex.RUnlock()
+	return append([]string(nil), parserOrder...)
+}
+
+// ParserFor returns the parser configured by format, or detects one from
+// the sample lines when format is empty or "auto"
+func ParserFor(format string, sample []string) (Parser, error) {
+	if format == "" || format == "auto" {
+		return Detect(sample), nil
+	}
+	parsersMutex.RLock()
+	defer parsersMutex.RUnlock()
+	p, ok := parsers[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+	return p, nil
+}
+
+// Detect picks the parser that understands the largest share of the sample.
+// Plain text is returned when nothing matches.
+func Detect(sample []string) Parser {
+	parsersMutex.RLock()
+	defer parsersMutex.RUnlock()
+
+	best := parsers["plain"]
+	bestScore := 0
+	for _, name := range parserOrder {
+		p := parsers[name]
+		score := 0
+		for _, line := range sample {
+			if _, ok := p.Parse(line); ok {
+				score++
+			}
+		}
+		if score > bestScore {
+			best, bestScore = p, score
+		}
+	}
+	return best
+}
+
+// ParseLines parses lines with p. Lines the parser doesn't understand that
+// look like continuations (indented, or goroutine headers of a panic) are
+// attached to the previous entry's Stack; other lines become raw entries.
+func ParseLines(p Parser, lines []string) []Entry {
+	entries := make([]Entry, 0, len(lines))
+	for _, line := range lines {
+		if entry, ok := p.Parse(line); ok {
+			entries = append(entries, entry)
+			continue
+		}
+		if len(entries) > 0 && isContinuation(line) {
+			last := &entries[len(entries)-1]
+			if last.Stack != "" {
+				last.Stack += "\n"
+			}
+			last.Stack += line
+			continue
+		}
+		entries = append(entries, rawEntry(line))
+	}
+	return entries
+}
+
+func isContinuation(line string) bool {
+	return strings.HasPrefix(line, "\t") || strings.HasPrefix(line, "  ") ||
+		strings.HasPrefix(line, "goroutine ") || line == ""
+}
+
+func rawEntry(line string) Entry {
+	return Entry{
+		Level:   levelFromText(line),
+		TraceID: traceIDFromText(line),
+		Message: line,
+		Format:  "raw",
+		Raw:     line,
+	}
+}
+
+// Filter selects entries by level and field values
+type Filter struct {
+	Levels []string          // normalized levels, empty matches all
+	Fields map[string]string // field -> expected value; level, caller, traceId and message are also accepted
+}
+
+// Empty reports whether the filter matches everything
+func (f Filter) Empty() bool {
+	return len(f.Levels) == 0 && len(f.Fields) == 0
+}
+
+// Match reports whether the entry passes the filter
+func (f Filter) Match(e Entry) bool {
+	if len(f.Levels) > 0 {
+		found := false
+		for _, level := range f.Levels {
+			if NormalizeLevel(level) == e.Level {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for key, want := range f.Fields {
+		var got string
+		switch key {
+		case "level":
+			got, want = e.Level, NormalizeLevel(want)
+		case "caller":
+			got = e.Caller
+		case "traceId", "trace_id":
+			got = e.TraceID
+		case "message", "msg":
+			if !strings.Contains(e.Message, want) {
+				return false
+			}
+			continue
+		default:
+			v, ok := e.Fields[key]
+			if !ok {
+				return false
+			}
+			got = fmt.Sprint(v)
+		}
+		if got != want {
+			return false
+		}
+	}
+	return true
+}
+
+// NormalizeLevel maps level spellings of zap, logrus and friends to one set
+func NormalizeLevel(level string) string {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "debug", "trace", "dbg":
+		return "DEBUG"
+	case "info", "information", "inf":
+		return "INFO"
+	case "warn", "warning", "wrn":
+		return "WARN"
+	case "error", "err", "erro":
+		return "ERROR"
+	case "fatal", "fata", "crit", "critical":
+		return "FATAL"
+	case "panic", "dpanic", "pani":
+		return "PANIC"
+	}
+	return ""
+}
+
+var (
+	bracketLevelRegex = regexp.MustCompile(`(?i)\[(debug|info|warn|warning|error|fatal|panic)\]`)
+	wordLevelRegex    = regexp.MustCompile(`^(?i)(debug|info|warn|warning|error|fatal|panic)[\s:]`)
+	traceIDRegex      = regexp.MustCompile(`(?i)trace[_-]?id["']?\s*[=:]\s*["']?([\w-]+)`)
+)
+
+// levelFromText finds a level marker such as "[ERROR]" or a leading "error:"
+func levelFromText(text string) string {
+	if m := bracketLevelRegex.FindStringSubmatch(text); m != nil {
+		return NormalizeLevel(m[1])
+	}
+	if m := wordLevelRegex.FindStringSubmatch(text); m != nil {
+		return NormalizeLevel(m[1])
+	}
+	if strings.HasPrefix(text, "panic:") {
+		return "PANIC"
+	}
+	return ""
+}
+
+func traceIDFromText(text string) string {
+	if m := traceIDRegex.FindStringSubmatch(text); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// parseTime accepts the timestamp encodings used by zap and logrus
+func parseTime(v any) *time.Time {
+	switch t := v.(type) {
+	case float64:
+		sec := int64(t)
+		ts := time.Unix(sec, int64((t-float64(sec))*1e9))
+		return &ts
+	case string:
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05Z0700", "2006-01-02 15:04:05", "2006/01/02 15:04:05"} {
+			if ts, err := time.ParseInLocation(layout, t, time.Local); err == nil {
+				return &ts
+			}
+		}
+		if f, err := strconv.ParseFloat(t, 64); err == nil {
+			return parseTime(f)
+		}
+	}
+	return nil
+}
+
+// jsonParser handles zap production output and logrus' JSON formatter
+type jsonParser struct{}
+
+func (jsonParser) Name() string { return "json" }
+
+func (jsonParser) Parse(line string) (Entry, bool) {
+	trimmed := strings.TrimSpace(line)
+	if !strings.HasPrefix(trimmed, "{") {
+		return Entry{}, false
+	}
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(trimmed), &fields); err != nil {
+		return Entry{}, false
+	}
+
+	entry := Entry{Format: "json", Raw: line}
+	take := func(keys ...string) (any, bool) {
+		for _, key := range keys {
+			if v, ok := fields[key]; ok {
+				delete(fields, key)
+				return v, true
+			}
+		}
+		return nil, false
+	}
+
+	if v, ok := take("ts", "time", "timestamp", "@timestamp"); ok {
+		entry.Time = parseTime(v)
+	}
+	if v, ok := take("level", "lvl", "severity"); ok {
+		entry.Level = NormalizeLevel(fmt.Sprint(v))
+	}
+	if v, ok := take("msg", "message"); ok {
+		entry.Message = fmt.Sprint(v)
+	}
+	if v, ok := take("caller", "file"); ok {
+		entry.Caller = fmt.Sprint(v)
+	}
+	if v, ok := take("trace_id", "traceId", "traceID", "trace-id"); ok {
+		entry.TraceID = fmt.Sprint(v)
+	}
+	if v, ok := take("stacktrace", "stack"); ok {
+		entry.Stack = fmt.Sprint(v)
+	}
+	if len(fields) > 0 {
+		entry.Fields = fields
+	}
+	return entry, true
+}
+
+// consoleParser handles zap's development (console) encoder, which separates
+// time, level, caller and message with tabs and appends fields as JSON:
+// 2025-09-02T15:04:05.000+0800	INFO	server/main.go:42	started	{"port": 8080}
+type consoleParser struct{}
+
+func (consoleParser) Name() string { return "console" }
+
+func (consoleParser) Parse(line string) (Entry, bool) {
+	parts := strings.Split(line, "\t")
+	if len(parts) < 3 {
+		return Entry{}, false
+	}
+	ts := parseTime(parts[0])
+	level := NormalizeLevel(parts[1])
+	if ts == nil || level == "" {
+		return Entry{}, false
+	}
+
+	entry := Entry{Format: "console", Raw: line, Time: ts, Level: level}
+	rest := parts[2:]
+	if strings.Contains(rest[0], ".go:") {
+		entry.Caller = rest[0]
+		rest = rest[1:]
+	}
+	if len(rest) > 0 {
+		entry.Message = rest[0]
+		rest = rest[1:]
+	}
+	if len(rest) > 0 && strings.HasPrefix(rest[len(rest)-1], "{") {
+		var fields map[string]any
+		if err := json.Unmarshal([]byte(rest[len(rest)-1]), &fields); err == nil {
+			for _, key := range []string{"trace_id", "traceId", "traceID"} {
+				if v, ok := fields[key]; ok {
+					entry.TraceID = fmt.Sprint(v)
+					delete(fields, key)
+				}
+			}
+			if len(fields) > 0 {
+				entry.Fields = fields
+			}
+		}
+	}
+	if entry.TraceID == "" {
+		entry.TraceID = traceIDFromText(entry.Message)
+	}
+	return entry, true
+}
+
+// logrusParser handles logrus' default text formatter:
+// time="2025-09-02T15:04:05+08:00" level=info msg="started" port=8080
+type logrusParser struct{}
+
+func (logrusParser) Name() string { return "logrus" }
+
+func (logrusParser) Parse(line string) (Entry, bool) {
+	pairs, ok := parseLogfmt(line)
+	if !ok {
+		return Entry{}, false
+	}
+	level, hasLevel := pairs["level"]
+	_, hasMsg := pairs["msg"]
+	if !hasLevel || !hasMsg {
+		return Entry{}, false
+	}
+
+	entry := Entry{Format: "logrus", Raw: line, Level: NormalizeLevel(level), Message: pairs["msg"]}
+	delete(pairs, "level")
+	delete(pairs, "msg")
+	if v, ok := pairs["time"]; ok {
+		entry.Time = parseTime(v)
+		delete(pairs, "time")
+	}
+	for _, key := range []string{"func", "file", "caller"} {
+		if v, ok := pairs[key]; ok && entry.Caller == "" {
+			entry.Caller = v
+			delete(pairs, key)
+		}
+	}
+	for _, key := range []string{"trace_id", "traceId", "traceID"} {
+		if v, ok := pairs[key]; ok {
+			entry.TraceID = v
+			delete(pairs, key)
+		}
+	}
+	if len(pairs) > 0 {
+		entry.Fields = make(map[string]any, len(pairs))
+		for k, v := range pairs {
+			entry.Fields[k] = v
+		}
+	}
+	return entry, true
+}
+
+// parseLogfmt splits key=value pairs, honouring double-quoted values
+func parseLogfmt(line string) (map[string]string, bool) {
+	pairs := make(map[string]string)
+	i := 0
+	for i < len(line) {
+		for i < len(line) && line[i] == ' ' {
+			i++
+		}
+		if i >= len(line) {
+			break
+		}
+		eq := strings.IndexByte(line[i:], '=')
+		if eq <= 0 {
+			return nil, false
+		}
+		key := line[i : i+eq]
+		if strings.ContainsAny(key, " \"") {
+			return nil, false
+		}
+		i += eq + 1
+
+		var value string
+		if i < len(line) && line[i] == '"' {
+			end := i + 1
+			for end < len(line) && (line[end] != '"' || line[end-1] == '\\') {
+				end++
+			}
+			if end >= len(line) {
+				return nil, false
+			}
+			unquoted, err := strconv.Unquote(line[i : end+1])
+			if err != nil {
+				unquoted = line[i+1 : end]
+			}
+			value = unquoted
+			i = end + 1
+		} else {
+			end := strings.IndexByte(line[i:], ' ')
+			if end < 0 {
+				end = len(line) - i
+			}
+			value = line[i : i+end]
+			i += end
+		}
+		pairs[key] = value
+	}
+	return pairs, len(pairs) > 0
+}
+
+// plainParser handles the standard library logger:
+// 2025/09/02 15:04:05 handler.go:42: [ERROR] request failed trace_id=abc
+type plainParser struct{}
+
+var (
+	plainPrefixRegex = regexp.MustCompile(`^(\d{4}[/-]\d{2}[/-]\d{2}[ T]\d{2}:\d{2}:\d{2}(?:[.,]\d+)?)\s+(.*)$`)
+	plainCallerRegex = regexp.MustCompile(`^([\w./-]+\.go:\d+):?\s+(.*)$`)
+)
+
+func (plainParser) Name() string { return "plain" }
+
+func (plainParser) Parse(line string) (Entry, bool) {
+	m := plainPrefixRegex.FindStringSubmatch(line)
+	if m == nil {
+		return Entry{}, false
+	}
+
+	entry := Entry{Format: "plain", Raw: line}
+	ts := strings.Replace(m[1], "-", "/", 2)
+	ts = strings.Replace(ts, "T", " ", 1)
+	ts = strings.Replace(ts, ",", ".", 1)
+	entry.Time = parseTime(ts)
+	rest := m[2]
+	if c := plainCallerRegex.FindStringSubmatch(rest); c != nil {
+		entry.Caller = c[1]
+		rest = c[2]
+	}
+	entry.Level = levelFromText(rest)
+	entry.TraceID = traceIDFromText(rest)
+	entry.Message = rest
+	return entry, true
+}
+
+// FieldNames lists the distinct field keys found in entries, for UI filters
+func FieldNames(entries []Entry) []string {
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		for k := range e.Fields {
+			seen[k] = true
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for k := range seen {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,264 @@
+package logs
+
+import (
+	"compress/gzip"
+	"control/go_server/config"
+	"control/go_server/internal/models"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// PolicyFor returns the rotation policy of a service, falling back to the
+// global default when the service doesn't define one
+func PolicyFor(service models.Service) models.LogRotation {
+	if service.LogRotation != nil {
+		return *service.LogRotation
+	}
+	return config.Conf.LogRotation
+}
+
+// Rotator rotates log files using copytruncate, so services that keep their
+// log file open keep writing to the same inode
+type Rotator struct {
+	mutex   sync.Mutex
+	started time.Time
+	locks   map[string]*sync.Mutex // logPath -> lock held while rotating
+}
+
+// NewRotator creates a new log rotator
+func NewRotator() *Rotator {
+	return &Rotator{
+		started: time.Now(),
+		locks:   make(map[string]*sync.Mutex),
+	}
+}
+
+func (r *Rotator) lockFor(logPath string) *sync.Mutex {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	lock, ok := r.locks[logPath]
+	if !ok {
+		lock = &sync.Mutex{}
+		r.locks[logPath] = lock
+	}
+	return lock
+}
+
+// Check rotates logPath if it exceeds the size or age limits of the policy,
+// then compresses pending segments and applies retention. It returns whether
+// a rotation took place.
+func (r *Rotator) Check(logPath string, policy models.LogRotation) (bool, error) {
+	lock := r.lockFor(logPath)
+	lock.Lock()
+	defer lock.Unlock()
+
+	rotated := false
+	segments, err := Segments(logPath)
+	if err != nil {
+		return false, err
+	}
+
+	if len(segments) > 0 && segments[len(segments)-1].Active {
+		active := segments[len(segments)-1]
+		if r.due(active, segments[:len(segments)-1], policy) {
+			if err := r.rotate(logPath, time.Now()); err != nil {
+				return false, err
+			}
+			rotated = true
+		}
+	}
+
+	if policy.Compress {
+		if err := compressPending(logPath); err != nil {
+			return rotated, err
+		}
+	}
+
+	return rotated, applyRetention(logPath, policy)
+}
+
+// Rotate forces a rotation of logPath regardless of the policy limits
+func (r *Rotator) Rotate(logPath string, policy models.LogRotation) error {
+	lock := r.lockFor(logPath)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := r.rotate(logPath, time.Now()); err != nil {
+		return err
+	}
+	if policy.Compress {
+		if err := compressPending(logPath); err != nil {
+			return err
+		}
+	}
+	return applyRetention(logPath, policy)
+}
+
+// due reports whether the active segment should be rotated
+func (r *Rotator) due(active Segment, rotated []Segment, policy models.LogRotation) bool {
+	if active.Size == 0 {
+		return false
+	}
+	if policy.MaxSizeMB > 0 && active.Size >= policy.MaxSizeMB*1024*1024 {
+		return true
+	}
+	if policy.IntervalSeconds > 0 {
+		last := r.started
+		if len(rotated) > 0 {
+			last = rotated[len(rotated)-1].Time
+		}
+		return time.Since(last) >= policy.Interval()
+	}
+	return false
+}
+
+// rotate copies the active file to a timestamped segment and truncates it
+func (r *Rotator) rotate(logPath string, now time.Time) error {
+	// segment names have second resolution, never overwrite an earlier one
+	target := segmentName(logPath, now)
+	for fileExists(target) || fileExists(target+".gz") {
+		now = now.Add(time.Second)
+		target = segmentName(logPath, now)
+	}
+	tmp := target + ".tmp"
+
+	src, err := os.Open(logPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("failed to copy %s: %v", logPath, err)
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, target); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Truncate(logPath, 0); err != nil {
+		return fmt.Errorf("failed to truncate %s: %v", logPath, err)
+	}
+
+	log.Printf("Rotated log %s -> %s", logPath, target)
+	return nil
+}
+
+// compressPending gzips every rotated segment that isn't compressed yet
+func compressPending(logPath string) error {
+	segments, err := Segments(logPath)
+	if err != nil {
+		return err
+	}
+	for _, segment := range segments {
+		if segment.Active || segment.Compressed {
+			continue
+		}
+		if err := compressFile(segment.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compressFile writes path.gz and removes path once the copy is complete
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(dst)
+	gz.ModTime = info.ModTime()
+	if _, err := io.Copy(gz, src); err != nil {
+		gz.Close()
+		dst.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("failed to compress %s: %v", path, err)
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(path)
+}
+
+// applyRetention removes rotated segments beyond MaxBackups or older than MaxAgeDays
+func applyRetention(logPath string, policy models.LogRotation) error {
+	segments, err := Segments(logPath)
+	if err != nil {
+		return err
+	}
+
+	var rotated []Segment
+	for _, segment := range segments {
+		if !segment.Active {
+			rotated = append(rotated, segment)
+		}
+	}
+
+	cutoff := time.Time{}
+	if policy.MaxAgeDays > 0 {
+		cutoff = time.Now().AddDate(0, 0, -policy.MaxAgeDays)
+	}
+
+	for i, segment := range rotated {
+		tooMany := policy.MaxBackups > 0 && len(rotated)-i > policy.MaxBackups
+		tooOld := !cutoff.IsZero() && segment.Time.Before(cutoff)
+		if !tooMany && !tooOld {
+			continue
+		}
+		if err := os.Remove(segment.Path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		log.Printf("Removed old log segment: %s", segment.Path)
+	}
+	return nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
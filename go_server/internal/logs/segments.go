@@ -0,0 +1,309 @@
+package logs
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// segmentTimeLayout is the timestamp suffix appended to rotated segments,
+// e.g. run.log.20250902-153000 or run.log.20250902-153000.gz
+const segmentTimeLayout = "20060102-150405"
+
+// Segment is one file that makes up a service log: either the active file
+// or a rotated (optionally gzip compressed) copy of it
+type Segment struct {
+	Path       string    `json:"path"`
+	Name       string    `json:"name"`
+	Time       time.Time `json:"time"` // rotation time, or last modification for the active file
+	Size       int64     `json:"size"`
+	Compressed bool      `json:"compressed"`
+	Active     bool      `json:"active"`
+}
+
+// Open opens the segment for reading, transparently decompressing it
+func (s Segment) Open() (io.ReadCloser, error) {
+	f, err := os.Open(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	if !s.Compressed {
+		return f, nil
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to open gzip segment %s: %v", s.Name, err)
+	}
+	return &gzipReadCloser{Reader: gz, file: f}, nil
+}
+
+type gzipReadCloser struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (g *gzipReadCloser) Close() error {
+	g.Reader.Close()
+	return g.file.Close()
+}
+
+// segmentName builds the rotated segment path for a log file
+func segmentName(logPath string, t time.Time) string {
+	return logPath + "." + t.Format(segmentTimeLayout)
+}
+
+// parseSegmentName extracts the rotation time from a rotated segment path
+func parseSegmentName(logPath, path string) (time.Time, bool, bool) {
+	suffix := strings.TrimPrefix(path, logPath+".")
+	if suffix == path {
+		return time.Time{}, false, false
+	}
+	compressed := strings.HasSuffix(suffix, ".gz")
+	suffix = strings.TrimSuffix(suffix, ".gz")
+	t, err := time.ParseInLocation(segmentTimeLayout, suffix, time.Local)
+	if err != nil {
+		return time.Time{}, false, false
+	}
+	return t, compressed, true
+}
+
+// Segments lists the rotated segments of logPath followed by the active file,
+// ordered from oldest to newest. A missing active file is not an error.
+func Segments(logPath string) ([]Segment, error) {
+	// Listed rather than globbed, as the path may contain glob metacharacters
+	entries, err := os.ReadDir(filepath.Dir(logPath))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	prefix := filepath.Base(logPath) + "."
+
+	var segments []Segment
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), prefix) {
+			continue
+		}
+		path := filepath.Join(filepath.Dir(logPath), entry.Name())
+		t, compressed, ok := parseSegmentName(logPath, path)
+		if !ok {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		segments = append(segments, Segment{
+			Path:       path,
+			Name:       filepath.Base(path),
+			Time:       t,
+			Size:       info.Size(),
+			Compressed: compressed,
+		})
+	}
+
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].Time.Before(segments[j].Time)
+	})
+
+	if info, err := os.Stat(logPath); err == nil && !info.IsDir() {
+		segments = append(segments, Segment{
+			Path:   logPath,
+			Name:   filepath.Base(logPath),
+			Time:   info.ModTime(),
+			Size:   info.Size(),
+			Active: true,
+		})
+	} else if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return segments, nil
+}
+
+// Tail returns the last n lines across all segments of logPath
+func Tail(logPath string, n int) ([]string, error) {
+	segments, err := Segments(logPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("log file %s does not exist", logPath)
+	}
+	return tailSegments(segments, n)
+}
+
+// tailSegments returns the last n lines across segments ordered oldest first
+func tailSegments(segments []Segment, n int) ([]string, error) {
+	var lines []string
+	var err error
+	for i := len(segments) - 1; i >= 0 && len(lines) < n; i-- {
+		var chunk []string
+		if segments[i].Compressed {
+			chunk, err = tailReader(segments[i], n-len(lines))
+		} else {
+			chunk, err = tailPlainFile(segments[i].Path, n-len(lines))
+		}
+		if err != nil {
+			return nil, err
+		}
+		lines = append(chunk, lines...)
+	}
+	return lines, nil
+}
+
+// tailReader scans the whole segment keeping the last n lines
+func tailReader(segment Segment, n int) ([]string, error) {
+	rc, err := segment.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	ring := make([]string, 0, n)
+	start := 0
+	err = ScanLines(rc, func(line string) bool {
+		if len(ring) < n {
+			ring = append(ring, line)
+		} else {
+			ring[start] = line
+			start = (start + 1) % n
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(ring[start:], ring[:start]...), nil
+}
+
+// tailPlainFile reads an uncompressed file backwards until n lines are found,
+// so the active log never has to be read in full
+func tailPlainFile(path string, n int) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	const chunkSize = 64 * 1024
+	offset := info.Size()
+	// Chunks from the end of the file backwards, and the newlines in them
+	// without those ending the file
+	var chunks [][]byte
+	newlines, trailing := 0, 0
+	onlyNewlines := true
+	for offset > 0 {
+		readSize := int64(chunkSize)
+		if offset < readSize {
+			readSize = offset
+		}
+		offset -= readSize
+		chunk := make([]byte, readSize)
+		if _, err := f.ReadAt(chunk, offset); err != nil && err != io.EOF {
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
+		newlines += bytes.Count(chunk, []byte{'\n'})
+		if onlyNewlines {
+			ending := len(chunk) - len(bytes.TrimRight(chunk, "\n"))
+			trailing += ending
+			onlyNewlines = ending == len(chunk)
+		}
+		// one extra newline is needed to be sure the first line is complete
+		if newlines-trailing >= n {
+			break
+		}
+	}
+
+	buf := make([]byte, 0, len(chunks)*chunkSize)
+	for i := len(chunks) - 1; i >= 0; i-- {
+		buf = append(buf, chunks[i]...)
+	}
+	text := strings.TrimRight(string(buf), "\n")
+	if text == "" {
+		return nil, nil
+	}
+	lines := strings.Split(text, "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines, nil
+}
+
+// ScanLines calls fn for every line in r until fn returns false.
+// Lines longer than the default scanner limit are supported up to 1MB.
+func ScanLines(r io.Reader, fn func(line string) bool) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if !fn(scanner.Text()) {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+// SearchOptions controls a search across log segments
+type SearchOptions struct {
+	Keyword       string // empty matches every line
+	CaseSensitive bool
+	Limit         int    // keep the most recent Limit matches, 0 means 500
+	Parser        Parser // when set, matches carry a structured entry
+	Filter        Filter // applied to the parsed entry, requires Parser
+}
+
+// SearchMatch is a single line matching a search
+type SearchMatch struct {
+	Segment string `json:"segment"`
+	Line    int    `json:"line"`
+	Text    string `json:"text"`
+	Entry   *Entry `json:"entry,omitempty"`
+}
+
+// DiskUsage summarizes how much disk a service log occupies
+type DiskUsage struct {
+	ActiveBytes     int64      `json:"activeBytes"`
+	RotatedBytes    int64      `json:"rotatedBytes"`
+	TotalBytes      int64      `json:"totalBytes"`
+	SegmentCount    int        `json:"segmentCount"`
+	CompressedCount int        `json:"compressedCount"`
+	OldestSegment   *time.Time `json:"oldestSegment,omitempty"`
+}
+
+// Usage computes the disk usage of logPath and its rotated segments
+func Usage(logPath string) (DiskUsage, error) {
+	var usage DiskUsage
+	segments, err := Segments(logPath)
+	if err != nil {
+		return usage, err
+	}
+	for _, segment := range segments {
+		if segment.Active {
+			usage.ActiveBytes = segment.Size
+		} else {
+			usage.RotatedBytes += segment.Size
+			usage.SegmentCount++
+			if segment.Compressed {
+				usage.CompressedCount++
+			}
+			if usage.OldestSegment == nil {
+				t := segment.Time
+				usage.OldestSegment = &t
+			}
+		}
+	}
+	usage.TotalBytes = usage.ActiveBytes + usage.RotatedBytes
+	return usage, nil
+}
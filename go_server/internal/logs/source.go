@@ -0,0 +1,498 @@
+package logs
+
+import (
+	"bufio"
+	"context"
+	"control/go_server/internal/docker"
+	"control/go_server/internal/models"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultSourceName names the implicit run.log source of services that don't declare any
+const DefaultSourceName = "run"
+
+// LineFollower returns lines appended to a log since the previous poll
+type LineFollower interface {
+	Poll() ([]string, error)
+}
+
+// Source is one readable log of a service
+type Source interface {
+	Definition() models.LogSource
+	// Tail returns the last n lines
+	Tail(n int) ([]string, error)
+	// Scan calls fn for every line from oldest to newest until fn returns false.
+	// segment names the file (or stream) the line came from.
+	Scan(fn func(segment, line string) bool) error
+	// NewFollower returns a follower positioned at the current end of the log
+	NewFollower() LineFollower
+}
+
+// SourceInfo describes a source for the sources API
+type SourceInfo struct {
+	models.LogSource
+	Available bool      `json:"available"`
+	Files     []Segment `json:"files,omitempty"` // file and glob sources only
+	Error     string    `json:"error,omitempty"`
+}
+
+// SourcesFor returns the log sources of a service with paths resolved
+// against the service path. Services without sources get run.log, or their
+// container's output when they run in one.
+func SourcesFor(service models.Service) []models.LogSource {
+	defs := service.LogSources
+	if len(defs) == 0 && service.Container != "" {
+		defs = []models.LogSource{{Name: DefaultSourceName, Type: models.LogSourceContainer, Container: service.Container}}
+	} else if len(defs) == 0 {
+		defs = []models.LogSource{{Name: DefaultSourceName, Type: models.LogSourceFile, Path: "run.log"}}
+	}
+
+	resolved := make([]models.LogSource, 0, len(defs))
+	for _, def := range defs {
+		if def.Type == "" {
+			def.Type = models.LogSourceFile
+		}
+		if def.Path != "" && !filepath.IsAbs(def.Path) {
+			def.Path = filepath.Join(service.Path, def.Path)
+		}
+		if def.Format == "" {
+			def.Format = service.LogFormat
+		}
+		resolved = append(resolved, def)
+	}
+	return resolved
+}
+
+// FindSource returns the named source of a service, or its first source when name is empty
+func FindSource(service models.Service, name string) (Source, error) {
+	defs := SourcesFor(service)
+	for _, def := range defs {
+		if name == "" || def.Name == name {
+			return NewSource(def)
+		}
+	}
+	return nil, fmt.Errorf("log source %q not found for service %s", name, service.Name)
+}
+
+// NewSource creates a reader for a source definition
+func NewSource(def models.LogSource) (Source, error) {
+	switch def.Type {
+	case models.LogSourceFile:
+		if def.Path == "" {
+			return nil, fmt.Errorf("log source %s has no path", def.Name)
+		}
+		return fileSource{def: def}, nil
+	case models.LogSourceGlob:
+		if def.Path == "" {
+			return nil, fmt.Errorf("log source %s has no path pattern", def.Name)
+		}
+		if _, err := filepath.Match(def.Path, ""); err != nil {
+			return nil, fmt.Errorf("log source %s has an invalid pattern: %v", def.Name, err)
+		}
+		return globSource{def: def}, nil
+	case models.LogSourceJournald:
+		if def.Unit == "" {
+			return nil, fmt.Errorf("log source %s has no unit", def.Name)
+		}
+		return journaldSource{def: def}, nil
+	case models.LogSourceContainer:
+		if def.Container == "" {
+			return nil, fmt.Errorf("log source %s has no container", def.Name)
+		}
+		return containerSource{def: def}, nil
+	}
+	return nil, fmt.Errorf("log source %s has unknown type %q", def.Name, def.Type)
+}
+
+// Describe reports whether a source is readable and which files back it
+func Describe(def models.LogSource) SourceInfo {
+	info := SourceInfo{LogSource: def}
+	src, err := NewSource(def)
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+
+	switch s := src.(type) {
+	case fileSource:
+		info.Files, err = Segments(s.def.Path)
+		info.Available = err == nil && len(info.Files) > 0
+	case globSource:
+		info.Files, err = s.files()
+		info.Available = err == nil && len(info.Files) > 0
+	default:
+		_, err = src.Tail(1)
+		info.Available = err == nil
+	}
+	if err != nil {
+		info.Error = err.Error()
+	}
+	return info
+}
+
+// Search scans a source from oldest to newest and returns the most recent
+// matches. truncated reports whether older matches were dropped.
+func Search(src Source, opts SearchOptions) (matches []SearchMatch, truncated bool, err error) {
+	if opts.Limit <= 0 {
+		opts.Limit = 500
+	}
+	keyword := opts.Keyword
+	if !opts.CaseSensitive {
+		keyword = strings.ToLower(keyword)
+	}
+
+	lineNo := 0
+	lastSegment := ""
+	err = src.Scan(func(segment, line string) bool {
+		if segment != lastSegment {
+			lastSegment, lineNo = segment, 0
+		}
+		lineNo++
+		haystack := line
+		if !opts.CaseSensitive {
+			haystack = strings.ToLower(line)
+		}
+		if !strings.Contains(haystack, keyword) {
+			return true
+		}
+		match := SearchMatch{Segment: segment, Line: lineNo, Text: line}
+		if opts.Parser != nil {
+			entry, ok := opts.Parser.Parse(line)
+			if !ok {
+				entry = rawEntry(line)
+			}
+			if !opts.Filter.Match(entry) {
+				return true
+			}
+			match.Entry = &entry
+		}
+		matches = append(matches, match)
+		if len(matches) > opts.Limit {
+			matches = matches[1:]
+			truncated = true
+		}
+		return true
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return matches, truncated, nil
+}
+
+// WriteAll copies every line of a source, oldest first, to w
+func WriteAll(src Source, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	var writeErr error
+	err := src.Scan(func(segment, line string) bool {
+		if _, writeErr = bw.WriteString(line + "\n"); writeErr != nil {
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if writeErr != nil {
+		return writeErr
+	}
+	return bw.Flush()
+}
+
+// scanSegments scans a list of segments in order
+func scanSegments(segments []Segment, fn func(segment, line string) bool) error {
+	for _, segment := range segments {
+		rc, err := segment.Open()
+		if err != nil {
+			return err
+		}
+		stopped := false
+		err = ScanLines(rc, func(line string) bool {
+			if !fn(segment.Name, line) {
+				stopped = true
+				return false
+			}
+			return true
+		})
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		if stopped {
+			return nil
+		}
+	}
+	return nil
+}
+
+// fileSource is a single log file plus its rotated segments
+type fileSource struct {
+	def models.LogSource
+}
+
+func (s fileSource) Definition() models.LogSource { return s.def }
+
+func (s fileSource) Tail(n int) ([]string, error) { return Tail(s.def.Path, n) }
+
+func (s fileSource) Scan(fn func(segment, line string) bool) error {
+	segments, err := Segments(s.def.Path)
+	if err != nil {
+		return err
+	}
+	return scanSegments(segments, fn)
+}
+
+func (s fileSource) NewFollower() LineFollower { return NewFollower(s.def.Path) }
+
+// globSource is a set of files matched by a pattern, ordered by modification time
+type globSource struct {
+	def models.LogSource
+}
+
+func (s globSource) Definition() models.LogSource { return s.def }
+
+// files lists the matched files, oldest first. gzip files are read transparently.
+func (s globSource) files() ([]Segment, error) {
+	matches, err := filepath.Glob(s.def.Path)
+	if err != nil {
+		return nil, err
+	}
+	var files []Segment
+	for _, path := range matches {
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() || strings.HasSuffix(path, ".tmp") {
+			continue
+		}
+		files = append(files, Segment{
+			Path:       path,
+			Name:       filepath.Base(path),
+			Time:       info.ModTime(),
+			Size:       info.Size(),
+			Compressed: strings.HasSuffix(path, ".gz"),
+		})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Time.Before(files[j].Time) })
+	if len(files) > 0 && !files[len(files)-1].Compressed {
+		files[len(files)-1].Active = true
+	}
+	return files, nil
+}
+
+func (s globSource) Tail(n int) ([]string, error) {
+	files, err := s.files()
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files match %s", s.def.Path)
+	}
+	return tailSegments(files, n)
+}
+
+func (s globSource) Scan(fn func(segment, line string) bool) error {
+	files, err := s.files()
+	if err != nil {
+		return err
+	}
+	return scanSegments(files, fn)
+}
+
+func (s globSource) NewFollower() LineFollower {
+	g := &globFollower{source: s}
+	if files, err := s.files(); err == nil && len(files) > 0 {
+		newest := files[len(files)-1].Path
+		g.follower = NewFollower(newest)
+	}
+	return g
+}
+
+// globFollower follows the newest matched file and switches over when a newer one appears
+type globFollower struct {
+	source   globSource
+	follower *Follower
+}
+
+func (g *globFollower) Poll() ([]string, error) {
+	files, err := g.source.files()
+	if err != nil || len(files) == 0 {
+		return nil, err
+	}
+	newest := files[len(files)-1].Path
+	if newest == "" || files[len(files)-1].Compressed {
+		return nil, nil
+	}
+
+	var lines []string
+	if g.follower != nil && g.follower.Path() != newest {
+		// drain the previous file before switching
+		lines, _ = g.follower.Poll()
+		g.follower = nil
+	}
+	if g.follower == nil {
+		g.follower = &Follower{path: newest}
+	}
+	more, err := g.follower.Poll()
+	return append(lines, more...), err
+}
+
+// journaldSource reads a systemd unit's journal through journalctl
+type journaldSource struct {
+	def models.LogSource
+}
+
+func (s journaldSource) Definition() models.LogSource { return s.def }
+
+func (s journaldSource) Tail(n int) ([]string, error) {
+	out, err := exec.Command("journalctl", "-u", s.def.Unit, "-n", fmt.Sprint(n), "--no-pager", "-o", "cat").Output()
+	if err != nil {
+		return nil, fmt.Errorf("journalctl failed: %v", err)
+	}
+	return splitOutput(out), nil
+}
+
+func (s journaldSource) Scan(fn func(segment, line string) bool) error {
+	return scanCommand(exec.Command("journalctl", "-u", s.def.Unit, "--no-pager", "-o", "cat"), s.def.Unit, fn)
+}
+
+func (s journaldSource) NewFollower() LineFollower {
+	return &journaldFollower{unit: s.def.Unit}
+}
+
+// journaldFollower uses journal cursors so no entry is read twice
+type journaldFollower struct {
+	unit   string
+	cursor string
+}
+
+func (j *journaldFollower) Poll() ([]string, error) {
+	args := []string{"-u", j.unit, "--no-pager", "-o", "cat", "--show-cursor"}
+	if j.cursor == "" {
+		args = append(args, "-n", "0")
+	} else {
+		args = append(args, "--after-cursor", j.cursor)
+	}
+	out, err := exec.Command("journalctl", args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("journalctl failed: %v", err)
+	}
+
+	var lines []string
+	for _, line := range splitOutput(out) {
+		if strings.HasPrefix(line, "-- cursor: ") {
+			j.cursor = strings.TrimPrefix(line, "-- cursor: ")
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+// containerSource reads a docker container's output through the Engine API
+type containerSource struct {
+	def models.LogSource
+}
+
+// containerLogsTimeout bounds the tail and poll requests of container logs
+const containerLogsTimeout = 30 * time.Second
+
+func (s containerSource) Definition() models.LogSource { return s.def }
+
+func (s containerSource) Tail(n int) ([]string, error) {
+	return readContainerLogs(s.def.Container, docker.LogsOptions{Tail: n})
+}
+
+func (s containerSource) Scan(fn func(segment, line string) bool) error {
+	logs, err := docker.Default.Logs(context.Background(), s.def.Container, docker.LogsOptions{})
+	if err != nil {
+		return err
+	}
+	defer logs.Close()
+	return ScanLines(logs, func(line string) bool { return fn(s.def.Container, line) })
+}
+
+func (s containerSource) NewFollower() LineFollower {
+	return &containerFollower{container: s.def.Container, since: time.Now()}
+}
+
+// readContainerLogs returns the lines of a bounded logs request
+func readContainerLogs(container string, opts docker.LogsOptions) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), containerLogsTimeout)
+	defer cancel()
+	logs, err := docker.Default.Logs(ctx, container, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer logs.Close()
+	out, err := io.ReadAll(logs)
+	if err != nil {
+		return nil, err
+	}
+	return splitOutput(out), nil
+}
+
+// containerFollower polls the logs with timestamps and skips lines it already returned
+type containerFollower struct {
+	container string
+	since     time.Time
+}
+
+func (d *containerFollower) Poll() ([]string, error) {
+	out, err := readContainerLogs(d.container, docker.LogsOptions{Since: d.since, Timestamps: true})
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	for _, line := range out {
+		ts, rest, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339Nano, ts)
+		if err != nil || !t.After(d.since) {
+			continue
+		}
+		d.since = t
+		lines = append(lines, rest)
+	}
+	return lines, nil
+}
+
+// scanCommand streams a command's combined output line by line
+func scanCommand(cmd *exec.Cmd, segment string, fn func(segment, line string) bool) error {
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go func() {
+		pw.CloseWithError(cmd.Wait())
+	}()
+
+	err := ScanLines(pr, func(line string) bool { return fn(segment, line) })
+	pr.Close()
+	if cmd.Process != nil {
+		cmd.Process.Kill()
+	}
+	if err != nil && err != io.ErrClosedPipe {
+		if _, ok := err.(*exec.ExitError); !ok {
+			return err
+		}
+	}
+	return nil
+}
+
+func splitOutput(out []byte) []string {
+	text := strings.TrimRight(string(out), "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
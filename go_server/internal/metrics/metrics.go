@@ -0,0 +1,296 @@
+// Package metrics implements counters, gauges and histograms with labels and
+// renders them in the Prometheus text exposition format (version 0.0.4).
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the Content-Type of the exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are histogram buckets in seconds suited to HTTP latencies
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metric is implemented by every vector type
+type metric interface {
+	describe() (name, help, kind string)
+	write(w *bufio.Writer)
+}
+
+// Registry holds metrics and collect hooks
+type Registry struct {
+	mutex      sync.Mutex
+	metrics    []metric
+	names      map[string]bool
+	collectors []func()
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+// Default is the registry used by the package level constructors
+var Default = NewRegistry()
+
+func (r *Registry) register(m metric) {
+	name, _, _ := m.describe()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.names[name] {
+		panic("metrics: duplicate metric " + name)
+	}
+	r.names[name] = true
+	r.metrics = append(r.metrics, m)
+}
+
+// OnCollect registers fn to run before every exposition, for gauges that are
+// cheaper to read on demand than to keep up to date
+func (r *Registry) OnCollect(fn func()) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.collectors = append(r.collectors, fn)
+}
+
+// WriteText runs the collect hooks and writes every metric, sorted by name
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mutex.Lock()
+	collectors := append([]func(){}, r.collectors...)
+	metrics := append([]metric{}, r.metrics...)
+	r.mutex.Unlock()
+
+	for _, fn := range collectors {
+		fn()
+	}
+
+	sort.Slice(metrics, func(i, j int) bool {
+		a, _, _ := metrics[i].describe()
+		b, _, _ := metrics[j].describe()
+		return a < b
+	})
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		name, help, kind := m.describe()
+		fmt.Fprintf(bw, "# HELP %s %s\n", name, escapeHelp(help))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", name, kind)
+		m.write(bw)
+	}
+	return bw.Flush()
+}
+
+// vec is the label handling shared by all vector types
+type vec struct {
+	name       string
+	help       string
+	labelNames []string
+	mutex      sync.Mutex
+	children   map[string][]string // key -> label values
+}
+
+func newVec(name, help string, labelNames []string) vec {
+	return vec{name: name, help: help, labelNames: labelNames, children: make(map[string][]string)}
+}
+
+// key checks the label values and returns the child key
+func (v *vec) key(values []string) string {
+	if len(values) != len(v.labelNames) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labelNames), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	if _, ok := v.children[key]; !ok {
+		v.children[key] = append([]string(nil), values...)
+	}
+	return key
+}
+
+// sortedKeys returns the child keys in a stable order; mutex must be held
+func (v *vec) sortedKeys() []string {
+	keys := make([]string, 0, len(v.children))
+	for k := range v.children {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// labels formats label pairs, with extra pairs such as le appended
+func (v *vec) labels(values []string, extra ...string) string {
+	if len(values) == 0 && len(extra) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(values)+len(extra)/2)
+	for i, name := range v.labelNames {
+		parts = append(parts, fmt.Sprintf("%s=\"%s\"", name, escapeLabel(values[i])))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		parts = append(parts, fmt.Sprintf("%s=\"%s\"", extra[i], escapeLabel(extra[i+1])))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// CounterVec is a monotonically increasing value per label set
+type CounterVec struct {
+	vec
+	values map[string]float64
+}
+
+// NewCounterVec creates and registers a counter in the default registry
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	c := &CounterVec{vec: newVec(name, help, labelNames), values: make(map[string]float64)}
+	Default.register(c)
+	return c
+}
+
+// Inc adds one to the counter with the given label values
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds delta, which must not be negative
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.values[c.key(labelValues)] += delta
+}
+
+func (c *CounterVec) describe() (string, string, string) { return c.name, c.help, "counter" }
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, key := range c.sortedKeys() {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labels(c.children[key]), formatValue(c.values[key]))
+	}
+}
+
+// GaugeVec is a value per label set that can go up and down
+type GaugeVec struct {
+	vec
+	values map[string]float64
+}
+
+// NewGaugeVec creates and registers a gauge in the default registry
+func NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	g := &GaugeVec{vec: newVec(name, help, labelNames), values: make(map[string]float64)}
+	Default.register(g)
+	return g
+}
+
+// Set sets the gauge with the given label values
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.values[g.key(labelValues)] = value
+}
+
+// Delete removes the gauge with the given label values, e.g. for a removed service
+func (g *GaugeVec) Delete(labelValues ...string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	key := strings.Join(labelValues, "\xff")
+	delete(g.values, key)
+	delete(g.children, key)
+}
+
+func (g *GaugeVec) describe() (string, string, string) { return g.name, g.help, "gauge" }
+
+func (g *GaugeVec) write(w *bufio.Writer) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	for _, key := range g.sortedKeys() {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, g.labels(g.children[key]), formatValue(g.values[key]))
+	}
+}
+
+// HistogramVec counts observations into cumulative buckets per label set
+type HistogramVec struct {
+	vec
+	buckets []float64
+	counts  map[string][]uint64 // per bucket, not cumulative
+	sums    map[string]float64
+	totals  map[string]uint64
+}
+
+// NewHistogramVec creates and registers a histogram in the default registry.
+// buckets must be sorted; nil uses DefaultBuckets.
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	h := &HistogramVec{
+		vec:     newVec(name, help, labelNames),
+		buckets: buckets,
+		counts:  make(map[string][]uint64),
+		sums:    make(map[string]float64),
+		totals:  make(map[string]uint64),
+	}
+	Default.register(h)
+	return h
+}
+
+// Observe records a value with the given label values
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	key := h.key(labelValues)
+	counts, ok := h.counts[key]
+	if !ok {
+		counts = make([]uint64, len(h.buckets))
+		h.counts[key] = counts
+	}
+	if i := sort.SearchFloat64s(h.buckets, value); i < len(counts) {
+		counts[i]++
+	}
+	h.sums[key] += value
+	h.totals[key]++
+}
+
+func (h *HistogramVec) describe() (string, string, string) { return h.name, h.help, "histogram" }
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for _, key := range h.sortedKeys() {
+		values := h.children[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += h.counts[key][i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labels(values, "le", formatValue(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labels(values, "le", "+Inf"), h.totals[key])
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labels(values), formatValue(h.sums[key]))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labels(values), h.totals[key])
+	}
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
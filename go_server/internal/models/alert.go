@@ -0,0 +1,137 @@
+package models
+
+import "time"
+
+// AlertOperator compares a metric value with a rule threshold
+type AlertOperator string
+
+const (
+	AlertAbove        AlertOperator = ">"
+	AlertAboveOrEqual AlertOperator = ">="
+	AlertBelow        AlertOperator = "<"
+	AlertBelowOrEqual AlertOperator = "<="
+	AlertEqual        AlertOperator = "=="
+	AlertNotEqual     AlertOperator = "!="
+)
+
+// Compare reports whether value op threshold holds
+func (op AlertOperator) Compare(value, threshold float64) bool {
+	switch op {
+	case AlertAbove:
+		return value > threshold
+	case AlertAboveOrEqual:
+		return value >= threshold
+	case AlertBelow:
+		return value < threshold
+	case AlertBelowOrEqual:
+		return value <= threshold
+	case AlertEqual:
+		return value == threshold
+	case AlertNotEqual:
+		return value != threshold
+	}
+	return false
+}
+
+// AlertReducer turns the samples of a series inside the rule window into one value
+type AlertReducer string
+
+const (
+	ReduceLast AlertReducer = "last"
+	ReduceAvg  AlertReducer = "avg"
+	ReduceMin  AlertReducer = "min"
+	ReduceMax  AlertReducer = "max"
+)
+
+// AlertRule fires for every stored series of Metric matching Matchers whose
+// value compares true against Threshold for at least ForSeconds. For example
+// metric service_cpu_percent, matchers {service: ims_server_ws}, operator >,
+// threshold 80 and forSeconds 300.
+type AlertRule struct {
+	ID            int64             `json:"id" gorm:"primaryKey"`
+	Name          string            `json:"name" gorm:"not null;size:128;uniqueIndex"`
+	Metric        string            `json:"metric" gorm:"not null;size:128"`
+	Matchers      map[string]string `json:"matchers" gorm:"serializer:json;type:text"` // label values the series must have
+	Reducer       AlertReducer      `json:"reducer" gorm:"size:16"`                    // last (default), avg, min or max
+	WindowSeconds int               `json:"windowSeconds"`                             // samples looked at, default 2 minutes
+	Operator      AlertOperator     `json:"operator" gorm:"not null;size:4"`
+	Threshold     float64           `json:"threshold"`
+	ForSeconds    int               `json:"forSeconds"` // how long the condition must hold before firing
+	Severity      string            `json:"severity" gorm:"size:16"`
+	Labels        map[string]string `json:"labels" gorm:"serializer:json;type:text"`      // added to the alert labels
+	Annotations   map[string]string `json:"annotations" gorm:"serializer:json;type:text"` // templates, e.g. "{{ .Labels.service }} CPU at {{ .Value }}%"
+	Enabled       bool              `json:"enabled"`
+	CreatedAt     time.Time         `json:"createdAt"`
+	UpdatedAt     time.Time         `json:"updatedAt"`
+}
+
+// Window returns the evaluation window of the rule, defaulting to two minutes
+// so a series that stops being written stops matching
+func (r AlertRule) Window() time.Duration {
+	if r.WindowSeconds <= 0 {
+		return 2 * time.Minute
+	}
+	return time.Duration(r.WindowSeconds) * time.Second
+}
+
+// For returns how long the condition must hold before the alert fires
+func (r AlertRule) For() time.Duration {
+	return time.Duration(r.ForSeconds) * time.Second
+}
+
+// AlertState is the state of one alert of a rule
+type AlertState string
+
+const (
+	AlertPending  AlertState = "pending"
+	AlertFiring   AlertState = "firing"
+	AlertResolved AlertState = "resolved"
+)
+
+// AlertEvent records an alert starting or stopping to fire
+type AlertEvent struct {
+	ID          int64             `json:"id" gorm:"primaryKey"`
+	RuleID      int64             `json:"ruleId" gorm:"index"`
+	RuleName    string            `json:"ruleName" gorm:"size:128"`
+	Fingerprint string            `json:"fingerprint" gorm:"size:64;index"` // identifies the alert across its events
+	Labels      map[string]string `json:"labels" gorm:"serializer:json;type:text"`
+	Annotations map[string]string `json:"annotations" gorm:"serializer:json;type:text"`
+	Severity    string            `json:"severity" gorm:"size:16"`
+	State       AlertState        `json:"state" gorm:"size:16;index"`
+	Value       float64           `json:"value"`
+	Threshold   float64           `json:"threshold"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      *time.Time        `json:"endsAt,omitempty"`
+	Silenced    bool              `json:"silenced"`
+	CreatedAt   time.Time         `json:"createdAt" gorm:"index"`
+}
+
+// AlertSilence mutes the alerts whose labels include Matchers between
+// StartsAt and EndsAt. The alertname label holds the rule name.
+type AlertSilence struct {
+	ID        int64             `json:"id" gorm:"primaryKey"`
+	Matchers  map[string]string `json:"matchers" gorm:"serializer:json;type:text"`
+	StartsAt  time.Time         `json:"startsAt"`
+	EndsAt    time.Time         `json:"endsAt" gorm:"index"`
+	CreatedBy string            `json:"createdBy" gorm:"size:64"`
+	Comment   string            `json:"comment"`
+	CreatedAt time.Time         `json:"createdAt"`
+}
+
+// Active reports whether the silence applies at t
+func (s AlertSilence) Active(t time.Time) bool {
+	return !t.Before(s.StartsAt) && t.Before(s.EndsAt)
+}
+
+// Matches reports whether the silence covers an alert with labels
+func (s AlertSilence) Matches(labels map[string]string) bool {
+	if len(s.Matchers) == 0 {
+		return false
+	}
+	for k, v := range s.Matchers {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
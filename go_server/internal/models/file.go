@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// FileChangeAction is how a file of a service was changed
+type FileChangeAction string
+
+const (
+	FileEdited   FileChangeAction = "edit"
+	FileUploaded FileChangeAction = "upload"
+)
+
+// FileChange records a change of a file in a service's directory, with the
+// content it replaced and what changed
+type FileChange struct {
+	ID      int64            `json:"id" gorm:"primaryKey"`
+	Service string           `json:"service" gorm:"size:128;index"`
+	Path    string           `json:"path" gorm:"size:1024"` // relative to the service's directory
+	User    string           `json:"user" gorm:"size:64"`
+	Action  FileChangeAction `json:"action" gorm:"size:16"`
+	Size    int64            `json:"size"` // of the new content
+	OldETag string           `json:"oldEtag,omitempty" gorm:"size:64"`
+	NewETag string           `json:"newEtag" gorm:"size:64"`
+	// Backup is the file holding the replaced content in the backup
+	// directory, empty when the file was created
+	Backup string `json:"-" gorm:"size:255"`
+	// Diff is the unified diff of the change; empty for binary files or
+	// files too large to diff, see DiffNote
+	Diff      string    `json:"diff,omitempty" gorm:"type:mediumtext"`
+	DiffNote  string    `json:"diffNote,omitempty"`
+	CreatedAt time.Time `json:"createdAt" gorm:"index"`
+}
@@ -0,0 +1,49 @@
+package models
+
+import "time"
+
+// LogAlertRule fires when more than Threshold lines of a service log match
+// Pattern and Level within WindowSeconds
+type LogAlertRule struct {
+	Name          string `json:"name"`
+	Service       string `json:"service"`
+	Source        string `json:"source,omitempty"`  // log source name, empty uses the service's first source
+	Pattern       string `json:"pattern,omitempty"` // regular expression matched against the raw line
+	Level         string `json:"level,omitempty"`   // minimum level, e.g. ERROR also matches FATAL and PANIC
+	Threshold     int    `json:"threshold"`         // fire when the count exceeds this, 0 fires on the first match
+	WindowSeconds int    `json:"windowSeconds"`     // sliding window the count is taken over
+	Severity      string `json:"severity"`          // info, warning or critical
+	Enabled       bool   `json:"enabled"`
+}
+
+// Window returns the counting window of the rule, defaulting to five minutes
+func (r LogAlertRule) Window() time.Duration {
+	if r.WindowSeconds <= 0 {
+		return 5 * time.Minute
+	}
+	return time.Duration(r.WindowSeconds) * time.Second
+}
+
+// LogAlertState is the state of a log alert rule
+type LogAlertState string
+
+const (
+	LogAlertInactive LogAlertState = "inactive"
+	LogAlertFiring   LogAlertState = "firing"
+	LogAlertResolved LogAlertState = "resolved"
+)
+
+// LogAlertEvent records a log alert rule starting or stopping to fire
+type LogAlertEvent struct {
+	ID        string        `json:"id"`
+	Rule      string        `json:"rule"`
+	Service   string        `json:"service"`
+	Source    string        `json:"source,omitempty"`
+	Severity  string        `json:"severity"`
+	State     LogAlertState `json:"state"`
+	StartsAt  time.Time     `json:"startsAt"`
+	EndsAt    *time.Time    `json:"endsAt,omitempty"`
+	Count     int           `json:"count"`   // matching lines seen while firing
+	Samples   []string      `json:"samples"` // first matching lines
+	EventTime time.Time     `json:"eventTime"`
+}
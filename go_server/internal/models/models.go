@@ -2,18 +2,96 @@ package models
 
 import "time"
 
-// LoginCredentials matches the structure of config.json
+// LoginCredentials matches the structure of config.json. Username and
+// Password are the admin account; Users adds accounts with their own roles.
 type LoginCredentials struct {
+	Username string        `json:"username"`
+	Password string        `json:"password"`
+	Users    []UserAccount `json:"users,omitempty"`
+}
+
+// UserAccount is a login with a role: admin, operator or viewer
+type UserAccount struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+	Role     string `json:"role"`
 }
 
 // Service defines a manageable service
 type Service struct {
-	Name         string `json:"serviceName"`
-	Path         string `json:"servicePath"`
-	DeployScript string `json:"deployScript"`
-	PprofURL     string `json:"pprofUrl,omitempty"`
+	Name          string         `json:"serviceName"`
+	Path          string         `json:"servicePath"`
+	DeployScript  string         `json:"deployScript"`
+	PprofURL      string         `json:"pprofUrl,omitempty"`
+	LogRotation   *LogRotation   `json:"logRotation,omitempty"` // nil uses the global default
+	LogFormat     string         `json:"logFormat,omitempty"`   // json, console, logrus, plain; empty detects it
+	LogSources    []LogSource    `json:"logSources,omitempty"`  // empty means a single run.log in Path
+	ScrapeTargets []ScrapeTarget `json:"scrapeTargets,omitempty"`
+	// Container names the docker container running the service, whose output
+	// is then its default log; containers labelled control.service=<Name> run
+	// the service as well
+	Container string `json:"container,omitempty"`
+}
+
+// ScrapeFormat is the payload format of a scrape target
+type ScrapeFormat string
+
+const (
+	ScrapePrometheus ScrapeFormat = "prometheus" // Prometheus text exposition, e.g. /metrics
+	ScrapeExpvar     ScrapeFormat = "expvar"     // expvar JSON, e.g. /debug/vars
+)
+
+// ScrapeTarget is an endpoint of a service whose application metrics are
+// collected into the metrics store
+type ScrapeTarget struct {
+	Name            string            `json:"name"`
+	URL             string            `json:"url"`
+	Format          ScrapeFormat      `json:"format"`
+	IntervalSeconds int               `json:"intervalSeconds,omitempty"` // 0 means 30 seconds
+	Include         []string          `json:"include,omitempty"`         // metric name globs to store, empty stores all
+	Labels          map[string]string `json:"labels,omitempty"`          // extra labels added to every series
+}
+
+// LogSourceType is where a log source reads from
+type LogSourceType string
+
+const (
+	LogSourceFile      LogSourceType = "file"      // a single file, rotated by the control plane
+	LogSourceGlob      LogSourceType = "glob"      // a set of files such as dated logs, read in modification order
+	LogSourceJournald  LogSourceType = "journald"  // a systemd unit's journal
+	LogSourceContainer LogSourceType = "container" // a docker container's stdout/stderr
+)
+
+// LogSource is one named log of a service
+type LogSource struct {
+	Name      string        `json:"name"`
+	Type      LogSourceType `json:"type"`
+	Path      string        `json:"path,omitempty"`      // file path or glob pattern, relative to the service path
+	Unit      string        `json:"unit,omitempty"`      // journald unit
+	Container string        `json:"container,omitempty"` // container name or ID
+	Format    string        `json:"format,omitempty"`    // overrides the service's LogFormat
+}
+
+// LogRotation describes how a service's log files are rotated and retained
+type LogRotation struct {
+	MaxSizeMB       int64 `json:"maxSizeMB"`       // rotate once the active file grows past this size, 0 disables
+	IntervalSeconds int   `json:"intervalSeconds"` // rotate at least this often, 0 disables
+	MaxBackups      int   `json:"maxBackups"`      // rotated segments to keep, 0 keeps all
+	MaxAgeDays      int   `json:"maxAgeDays"`      // remove segments older than this, 0 keeps all
+	Compress        bool  `json:"compress"`        // gzip rotated segments
+}
+
+// Interval returns how often logs are rotated at least, 0 when not by time
+func (r LogRotation) Interval() time.Duration {
+	return time.Duration(r.IntervalSeconds) * time.Second
+}
+
+// MetricsRetention sets how long each resolution of the metrics store is kept
+type MetricsRetention struct {
+	RawHours       int `json:"rawHours"`       // raw collection points
+	MinuteDays     int `json:"minuteDays"`     // 1m rollups
+	FiveMinuteDays int `json:"fiveMinuteDays"` // 5m rollups
+	HourDays       int `json:"hourDays"`       // 1h rollups
 }
 
 // Environment represents deployment environment
@@ -0,0 +1,71 @@
+package models
+
+import "time"
+
+// NotificationChannelType selects the driver that delivers a channel's messages
+type NotificationChannelType string
+
+const (
+	ChannelWebhook  NotificationChannelType = "webhook"
+	ChannelEmail    NotificationChannelType = "email"
+	ChannelDingTalk NotificationChannelType = "dingtalk"
+	ChannelFeishu   NotificationChannelType = "feishu"
+	ChannelSlack    NotificationChannelType = "slack"
+	ChannelTelegram NotificationChannelType = "telegram"
+)
+
+// NotificationChannel is a configured destination for notifications. Settings
+// hold the driver options, e.g. url and secret for DingTalk or host, port,
+// username, password, from and to for email.
+type NotificationChannel struct {
+	ID                 int64                   `json:"id" gorm:"primaryKey"`
+	Name               string                  `json:"name" gorm:"not null;size:128;uniqueIndex"`
+	Type               NotificationChannelType `json:"type" gorm:"not null;size:16"`
+	Settings           map[string]string       `json:"settings" gorm:"serializer:json;type:text"`
+	Topics             []string                `json:"topics" gorm:"serializer:json;type:text"` // message topics sent here, empty for all
+	TitleTemplate      string                  `json:"titleTemplate" gorm:"type:text"`          // Go template, empty uses the message title
+	BodyTemplate       string                  `json:"bodyTemplate" gorm:"type:text"`           // Go template, empty uses the message text
+	RateLimitPerMinute int                     `json:"rateLimitPerMinute"`                      // 0 for no limit
+	MaxRetries         int                     `json:"maxRetries"`
+	Enabled            bool                    `json:"enabled"`
+	CreatedAt          time.Time               `json:"createdAt"`
+	UpdatedAt          time.Time               `json:"updatedAt"`
+}
+
+// Subscribed reports whether the channel receives messages of topic
+func (c NotificationChannel) Subscribed(topic string) bool {
+	if len(c.Topics) == 0 {
+		return true
+	}
+	for _, t := range c.Topics {
+		if t == topic {
+			return true
+		}
+	}
+	return false
+}
+
+// DeliveryStatus is the outcome of sending a notification to a channel
+type DeliveryStatus string
+
+const (
+	DeliveryPending     DeliveryStatus = "pending"
+	DeliverySent        DeliveryStatus = "sent"
+	DeliveryFailed      DeliveryStatus = "failed"
+	DeliveryRateLimited DeliveryStatus = "rate_limited"
+)
+
+// NotificationDelivery records one message sent, or not sent, to a channel
+type NotificationDelivery struct {
+	ID          int64          `json:"id" gorm:"primaryKey"`
+	ChannelID   int64          `json:"channelId" gorm:"index"`
+	ChannelName string         `json:"channelName" gorm:"size:128"`
+	Topic       string         `json:"topic" gorm:"size:32;index"`
+	Title       string         `json:"title"`
+	Body        string         `json:"body" gorm:"type:text"`
+	Status      DeliveryStatus `json:"status" gorm:"size:16;index"`
+	Attempts    int            `json:"attempts"`
+	Error       string         `json:"error,omitempty" gorm:"type:text"`
+	SentAt      *time.Time     `json:"sentAt,omitempty"`
+	CreatedAt   time.Time      `json:"createdAt" gorm:"index"`
+}
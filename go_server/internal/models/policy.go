@@ -0,0 +1,93 @@
+package models
+
+import "time"
+
+// Roles of the users in config.json
+const (
+	RoleAdmin    = "admin"
+	RoleOperator = "operator"
+	RoleViewer   = "viewer"
+)
+
+// PolicyAction is what the command policy does with a command
+type PolicyAction string
+
+const (
+	PolicyAllow   PolicyAction = "allow"
+	PolicyDeny    PolicyAction = "deny"
+	PolicyApprove PolicyAction = "approve" // held until a second operator approves it
+)
+
+// CommandRule is a rule of the command policy. A rule applies to a command
+// when every criterion it sets matches; rules are tried by ascending
+// priority and the first that applies decides.
+type CommandRule struct {
+	ID       int64        `json:"id" gorm:"primaryKey"`
+	Name     string       `json:"name" gorm:"not null;size:128;uniqueIndex"`
+	Priority int          `json:"priority"`
+	Roles    []string     `json:"roles" gorm:"serializer:json;type:text"` // roles the rule applies to, empty for all
+	Action   PolicyAction `json:"action" gorm:"not null;size:16"`
+	// Programs are globs matched against the program's base name, e.g. rm or mkfs.*
+	Programs []string `json:"programs" gorm:"serializer:json;type:text"`
+	// Args are regular expressions that must each match one of the arguments
+	Args []string `json:"args" gorm:"serializer:json;type:text"`
+	// Paths are globs matched against the path arguments and redirections,
+	// resolved against the working directory; a trailing /** also matches
+	// everything under the directory
+	Paths []string `json:"paths" gorm:"serializer:json;type:text"`
+	// Pattern is a regular expression matched against the command's text
+	Pattern string `json:"pattern,omitempty" gorm:"type:text"`
+	// Writes restricts the rule to commands redirecting their output to a
+	// file other than /dev/null
+	Writes    bool      `json:"writes,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// CommandDecision records the policy's decision on a command
+type CommandDecision struct {
+	ID         int64        `json:"id" gorm:"primaryKey"`
+	User       string       `json:"user" gorm:"size:64;index"`
+	Role       string       `json:"role" gorm:"size:32"`
+	SessionID  string       `json:"sessionId" gorm:"size:64"`
+	Source     string       `json:"source" gorm:"size:32"` // execute, interactive, runbook or approval
+	Command    string       `json:"command" gorm:"type:text"`
+	Dir        string       `json:"dir"`
+	Action     PolicyAction `json:"action" gorm:"size:16;index"`
+	Rule       string       `json:"rule,omitempty" gorm:"size:128"`
+	Reason     string       `json:"reason,omitempty" gorm:"type:text"`
+	ApprovalID int64        `json:"approvalId,omitempty"`
+	CreatedAt  time.Time    `json:"createdAt" gorm:"index"`
+}
+
+// ApprovalStatus is the state of a held command
+type ApprovalStatus string
+
+const (
+	ApprovalPending  ApprovalStatus = "pending"
+	ApprovalApproved ApprovalStatus = "approved"
+	ApprovalRejected ApprovalStatus = "rejected"
+	ApprovalExpired  ApprovalStatus = "expired"
+	ApprovalExecuted ApprovalStatus = "executed"
+)
+
+// CommandApproval is a command held for approval by a second operator. Once
+// approved, the requester runs it by resubmitting it with the approval's ID.
+type CommandApproval struct {
+	ID        int64          `json:"id" gorm:"primaryKey"`
+	User      string         `json:"user" gorm:"size:64;index"`
+	Role      string         `json:"role" gorm:"size:32"`
+	SessionID string         `json:"sessionId" gorm:"size:64"`
+	Command   string         `json:"command" gorm:"type:text"`
+	Dir       string         `json:"dir"`
+	Rule      string         `json:"rule" gorm:"size:128"`
+	Reason    string         `json:"reason,omitempty" gorm:"type:text"`
+	Status    ApprovalStatus `json:"status" gorm:"size:16;index"`
+	Approver  string         `json:"approver,omitempty" gorm:"size:64"`
+	Comment   string         `json:"comment,omitempty" gorm:"type:text"`
+	ExpiresAt time.Time      `json:"expiresAt"`
+	DecidedAt *time.Time     `json:"decidedAt,omitempty"`
+	CreatedAt time.Time      `json:"createdAt" gorm:"index"`
+}
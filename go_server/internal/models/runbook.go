@@ -0,0 +1,85 @@
+package models
+
+import "time"
+
+// RunbookParamType is the type of a runbook parameter's value
+type RunbookParamType string
+
+const (
+	ParamString  RunbookParamType = "string"
+	ParamInt     RunbookParamType = "int"
+	ParamBool    RunbookParamType = "bool"    // true or false
+	ParamEnum    RunbookParamType = "enum"    // one of Options
+	ParamService RunbookParamType = "service" // the name of a configured service
+)
+
+// RunbookParam is a parameter of a runbook, exported to its script as a
+// shell variable of the same name
+type RunbookParam struct {
+	Name        string           `json:"name"`
+	Label       string           `json:"label,omitempty"`
+	Description string           `json:"description,omitempty"`
+	Type        RunbookParamType `json:"type"`
+	Required    bool             `json:"required"`
+	Default     string           `json:"default,omitempty"`
+	Options     []string         `json:"options,omitempty"` // values of an enum
+	Pattern     string           `json:"pattern,omitempty"` // regular expression a string must match
+}
+
+// Runbook is a stored script run from the UI with typed parameters
+type Runbook struct {
+	ID          int64          `json:"id" gorm:"primaryKey"`
+	Name        string         `json:"name" gorm:"not null;size:128;uniqueIndex"`
+	Description string         `json:"description,omitempty" gorm:"type:text"`
+	Script      string         `json:"script" gorm:"type:text"`
+	Params      []RunbookParam `json:"params" gorm:"serializer:json;type:text"`
+	// Service runs the script in the service's directory, with SERVICE_NAME
+	// and SERVICE_PATH set
+	Service string `json:"service,omitempty"`
+	// Host runs the script over ssh on another host, empty for this one
+	Host           string    `json:"host,omitempty"`
+	RequiredRole   string    `json:"requiredRole" gorm:"size:32"` // the least role that may run it
+	TimeoutSeconds int       `json:"timeoutSeconds"`              // 0 uses the terminal's command timeout
+	Enabled        bool      `json:"enabled"`
+	CreatedBy      string    `json:"createdBy,omitempty" gorm:"size:64"`
+	CreatedAt      time.Time `json:"createdAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+// RunbookRunStatus is the state of a runbook execution
+type RunbookRunStatus string
+
+const (
+	RunRunning   RunbookRunStatus = "running"
+	RunSucceeded RunbookRunStatus = "succeeded"
+	RunFailed    RunbookRunStatus = "failed"
+	RunTimeout   RunbookRunStatus = "timeout"
+	RunCanceled  RunbookRunStatus = "canceled"
+	RunDenied    RunbookRunStatus = "denied"  // refused by the command policy
+	RunPending   RunbookRunStatus = "pending" // held for approval
+)
+
+// RunbookRun is an execution of a runbook and the output it captured
+type RunbookRun struct {
+	ID          int64             `json:"id" gorm:"primaryKey"`
+	RunbookID   int64             `json:"runbookId" gorm:"index"`
+	RunbookName string            `json:"runbookName" gorm:"size:128"`
+	User        string            `json:"user" gorm:"size:64;index"`
+	Params      map[string]string `json:"params" gorm:"serializer:json;type:text"`
+	Service     string            `json:"service,omitempty"`
+	Host        string            `json:"host,omitempty"`
+	Dir         string            `json:"dir"`
+	// Script is the script as run, with its parameters assigned
+	Script          string           `json:"script" gorm:"type:text"`
+	Status          RunbookRunStatus `json:"status" gorm:"size:16;index"`
+	Reason          string           `json:"reason,omitempty" gorm:"type:text"`
+	CommandID       string           `json:"commandId,omitempty" gorm:"size:64"` // cancels it while running
+	ApprovalID      int64            `json:"approvalId,omitempty"`
+	ExitCode        *int             `json:"exitCode,omitempty"`
+	Stdout          string           `json:"stdout" gorm:"type:mediumtext"`
+	Stderr          string           `json:"stderr" gorm:"type:mediumtext"`
+	StdoutTruncated bool             `json:"stdoutTruncated,omitempty"`
+	StderrTruncated bool             `json:"stderrTruncated,omitempty"`
+	StartedAt       time.Time        `json:"startedAt" gorm:"index"`
+	EndedAt         *time.Time       `json:"endedAt,omitempty"`
+}
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+type TerminalRecordingKind string
+
+const (
+	RecordingInteractive TerminalRecordingKind = "interactive" // a PTY session over WebSocket
+	RecordingCommand     TerminalRecordingKind = "command"     // the commands of an /terminal/execute session
+)
+
+// TerminalRecording is a terminal session recorded as an asciicast v2 file.
+// Commands holds the command lines typed, one per line, for searching.
+type TerminalRecording struct {
+	ID        int64                 `json:"id" gorm:"primaryKey"`
+	SessionID string                `json:"sessionId" gorm:"size:64;index"`
+	Kind      TerminalRecordingKind `json:"kind" gorm:"size:16;index"`
+	User      string                `json:"user" gorm:"size:64;index"`
+	Host      string                `json:"host" gorm:"size:128"`
+	Width     int                   `json:"width"`
+	Height    int                   `json:"height"`
+	File      string                `json:"-" gorm:"size:255"` // relative to the recordings directory
+	Size      int64                 `json:"size"`
+	Commands  string                `json:"commands" gorm:"type:mediumtext"`
+	ExitCode  *int                  `json:"exitCode,omitempty"`
+	StartedAt time.Time             `json:"startedAt" gorm:"index"`
+	EndedAt   *time.Time            `json:"endedAt,omitempty"`
+}
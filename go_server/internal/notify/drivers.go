@@ -0,0 +1,218 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"control/go_server/internal/models"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var httpClient = &http.Client{}
+
+// requireSettings reports the first missing required setting
+func requireSettings(settings map[string]string, names ...string) error {
+	for _, name := range names {
+		if strings.TrimSpace(settings[name]) == "" {
+			return fmt.Errorf("setting %s is required", name)
+		}
+	}
+	return nil
+}
+
+// post sends body to target and returns the response body of a 2xx response
+func post(ctx context.Context, method, target, contentType string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		// The URL may carry an access token; keep it out of delivery records
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return nil, fmt.Errorf("%s request failed: %w", method, urlErr.Err)
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return respBody, fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	return respBody, nil
+}
+
+func postJSON(ctx context.Context, target string, payload any) ([]byte, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return post(ctx, http.MethodPost, target, "application/json", data)
+}
+
+// webhookDriver posts {"title", "text"} as JSON, or the rendered body as it
+// is when the channel has a body template
+type webhookDriver struct{}
+
+func (webhookDriver) Validate(settings map[string]string) error {
+	return requireSettings(settings, "url")
+}
+
+func (webhookDriver) Send(ctx context.Context, channel models.NotificationChannel, title, body string) error {
+	method := channel.Settings["method"]
+	if method == "" {
+		method = http.MethodPost
+	}
+	contentType := channel.Settings["contentType"]
+	if contentType == "" {
+		contentType = "application/json"
+	}
+
+	data := []byte(body)
+	if channel.BodyTemplate == "" {
+		var err error
+		if data, err = json.Marshal(map[string]string{"title": title, "text": body}); err != nil {
+			return err
+		}
+	}
+	_, err := post(ctx, method, channel.Settings["url"], contentType, data)
+	return err
+}
+
+// dingTalkSignedURL adds the timestamp and HMAC-SHA256 signature DingTalk robots
+// with a secret require
+func dingTalkSignedURL(target, secret string, now time.Time) (string, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", err
+	}
+	timestamp := strconv.FormatInt(now.UnixMilli(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "\n" + secret))
+	query := u.Query()
+	query.Set("timestamp", timestamp)
+	query.Set("sign", base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// dingTalkDriver sends markdown messages to a DingTalk custom robot
+type dingTalkDriver struct{}
+
+func (dingTalkDriver) Validate(settings map[string]string) error {
+	return requireSettings(settings, "url")
+}
+
+func (dingTalkDriver) Send(ctx context.Context, channel models.NotificationChannel, title, body string) error {
+	target := channel.Settings["url"]
+	if secret := channel.Settings["secret"]; secret != "" {
+		var err error
+		if target, err = dingTalkSignedURL(target, secret, time.Now()); err != nil {
+			return err
+		}
+	}
+	respBody, err := postJSON(ctx, target, map[string]any{
+		"msgtype":  "markdown",
+		"markdown": map[string]string{"title": title, "text": "### " + title + "\n\n" + body},
+	})
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		ErrCode int    `json:"errcode"`
+		ErrMsg  string `json:"errmsg"`
+	}
+	if json.Unmarshal(respBody, &resp) == nil && resp.ErrCode != 0 {
+		return fmt.Errorf("dingtalk error %d: %s", resp.ErrCode, resp.ErrMsg)
+	}
+	return nil
+}
+
+// feishuDriver sends text messages to a Feishu custom bot
+type feishuDriver struct{}
+
+func (feishuDriver) Validate(settings map[string]string) error {
+	return requireSettings(settings, "url")
+}
+
+func (feishuDriver) Send(ctx context.Context, channel models.NotificationChannel, title, body string) error {
+	payload := map[string]any{
+		"msg_type": "text",
+		"content":  map[string]string{"text": title + "\n" + body},
+	}
+	if secret := channel.Settings["secret"]; secret != "" {
+		// Feishu signs with timestamp+"\n"+secret as the key and an empty message
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(timestamp+"\n"+secret))
+		payload["timestamp"] = timestamp
+		payload["sign"] = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	}
+	respBody, err := postJSON(ctx, channel.Settings["url"], payload)
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+	}
+	if json.Unmarshal(respBody, &resp) == nil && resp.Code != 0 {
+		return fmt.Errorf("feishu error %d: %s", resp.Code, resp.Msg)
+	}
+	return nil
+}
+
+// slackDriver posts to a Slack incoming webhook
+type slackDriver struct{}
+
+func (slackDriver) Validate(settings map[string]string) error {
+	return requireSettings(settings, "url")
+}
+
+func (slackDriver) Send(ctx context.Context, channel models.NotificationChannel, title, body string) error {
+	_, err := postJSON(ctx, channel.Settings["url"], map[string]string{"text": "*" + title + "*\n" + body})
+	return err
+}
+
+// telegramDriver sends through the Bot API; apiUrl overrides https://api.telegram.org
+type telegramDriver struct{}
+
+func (telegramDriver) Validate(settings map[string]string) error {
+	return requireSettings(settings, "token", "chatId")
+}
+
+func (telegramDriver) Send(ctx context.Context, channel models.NotificationChannel, title, body string) error {
+	apiURL := strings.TrimSuffix(channel.Settings["apiUrl"], "/")
+	if apiURL == "" {
+		apiURL = "https://api.telegram.org"
+	}
+	token := channel.Settings["token"]
+	respBody, err := postJSON(ctx, apiURL+"/bot"+token+"/sendMessage", map[string]string{
+		"chat_id": channel.Settings["chatId"],
+		"text":    title + "\n" + body,
+	})
+	if err != nil {
+		// the token is part of the URL; keep it out of delivery records
+		return fmt.Errorf("%s", strings.ReplaceAll(err.Error(), token, "***"))
+	}
+	var resp struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	if json.Unmarshal(respBody, &resp) == nil && !resp.OK {
+		return fmt.Errorf("telegram error: %s", resp.Description)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"control/go_server/internal/models"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// emailDriver sends plain text mail over SMTP. The tls setting is "starttls"
+// (the default, used when the server offers it), "tls" for implicit TLS
+// on port 465, or "none".
+type emailDriver struct{}
+
+func (emailDriver) Validate(settings map[string]string) error {
+	if err := requireSettings(settings, "host", "from", "to"); err != nil {
+		return err
+	}
+	switch settings["tls"] {
+	case "", "starttls", "tls", "none":
+		return nil
+	}
+	return fmt.Errorf("tls must be starttls, tls or none")
+}
+
+func (emailDriver) Send(ctx context.Context, channel models.NotificationChannel, title, body string) error {
+	settings := channel.Settings
+	host := settings["host"]
+	port := settings["port"]
+	if port == "" {
+		port = "25"
+		if settings["tls"] == "tls" {
+			port = "465"
+		}
+	}
+	addr := net.JoinHostPort(host, port)
+	tlsConfig := &tls.Config{ServerName: host}
+
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if settings["tls"] == "tls" {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if settings["tls"] == "" || settings["tls"] == "starttls" {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(tlsConfig); err != nil {
+				return err
+			}
+		}
+	}
+	if settings["username"] != "" {
+		if err := client.Auth(smtp.PlainAuth("", settings["username"], settings["password"], host)); err != nil {
+			return err
+		}
+	}
+
+	recipients := splitAddresses(settings["to"])
+	if err := client.Mail(settings["from"]); err != nil {
+		return err
+	}
+	for _, rcpt := range recipients {
+		if err := client.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(buildMail(settings["from"], recipients, title, body, time.Now())); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func splitAddresses(list string) []string {
+	var addresses []string
+	for _, address := range strings.Split(list, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
+
+// buildMail formats a UTF-8 plain text message with CRLF line endings
+func buildMail(from string, to []string, subject, body string, date time.Time) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	buf.WriteString(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n"))
+	buf.WriteString("\r\n")
+	return buf.Bytes()
+}
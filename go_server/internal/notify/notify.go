@@ -0,0 +1,282 @@
+// Package notify delivers notifications to webhook, email, DingTalk, Feishu,
+// Slack and Telegram channels.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"control/go_server/internal/models"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	sendTimeout    = 15 * time.Second
+	maxRetries     = 5
+	firstRetryWait = time.Second
+)
+
+// Message is a notification before it is rendered for a channel. Data is
+// available to channel templates, e.g. the models.AlertEvent of an alert.
+type Message struct {
+	Topic string // e.g. alert, log_alert, proxy or test
+	Title string
+	Text  string
+	Data  any
+	Time  time.Time
+}
+
+// Driver sends a rendered message through one channel type
+type Driver interface {
+	Send(ctx context.Context, channel models.NotificationChannel, title, body string) error
+}
+
+// SettingsValidator is implemented by drivers that check channel settings before they are saved
+type SettingsValidator interface {
+	Validate(settings map[string]string) error
+}
+
+var drivers = map[models.NotificationChannelType]Driver{
+	models.ChannelWebhook:  webhookDriver{},
+	models.ChannelEmail:    emailDriver{},
+	models.ChannelDingTalk: dingTalkDriver{},
+	models.ChannelFeishu:   feishuDriver{},
+	models.ChannelSlack:    slackDriver{},
+	models.ChannelTelegram: telegramDriver{},
+}
+
+// DriverSettings lists the settings of each channel type, required ones first
+var DriverSettings = map[models.NotificationChannelType][]string{
+	models.ChannelWebhook:  {"url", "method", "contentType"},
+	models.ChannelEmail:    {"host", "port", "from", "to", "username", "password", "tls"},
+	models.ChannelDingTalk: {"url", "secret"},
+	models.ChannelFeishu:   {"url", "secret"},
+	models.ChannelSlack:    {"url"},
+	models.ChannelTelegram: {"token", "chatId", "apiUrl"},
+}
+
+// SecretSettings are the settings of each channel type that are never
+// returned by the API; the webhook URLs of chat apps carry their access token
+var SecretSettings = map[models.NotificationChannelType][]string{
+	models.ChannelEmail:    {"password"},
+	models.ChannelDingTalk: {"url", "secret"},
+	models.ChannelFeishu:   {"url", "secret"},
+	models.ChannelSlack:    {"url"},
+	models.ChannelTelegram: {"token"},
+}
+
+// ValidateChannel checks a channel before it is saved
+func ValidateChannel(channel models.NotificationChannel) error {
+	if channel.Name == "" {
+		return fmt.Errorf("channel name is required")
+	}
+	driver, ok := drivers[channel.Type]
+	if !ok {
+		return fmt.Errorf("unknown channel type %q", channel.Type)
+	}
+	if v, ok := driver.(SettingsValidator); ok {
+		if err := v.Validate(channel.Settings); err != nil {
+			return err
+		}
+	}
+	if channel.RateLimitPerMinute < 0 {
+		return fmt.Errorf("rate limit cannot be negative")
+	}
+	if channel.MaxRetries < 0 || channel.MaxRetries > maxRetries {
+		return fmt.Errorf("max retries must be between 0 and %d", maxRetries)
+	}
+	for name, text := range map[string]string{"title": channel.TitleTemplate, "body": channel.BodyTemplate} {
+		if _, err := newTemplate(name, text); err != nil {
+			return fmt.Errorf("invalid %s template: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// DeliveryRecorder stores delivery records; it is called when a delivery is
+// created and again when it finishes
+type DeliveryRecorder interface {
+	SaveDelivery(delivery *models.NotificationDelivery) error
+}
+
+// Notifier renders messages with each channel's templates and sends them,
+// enforcing per-channel rate limits and retrying failed sends
+type Notifier struct {
+	recorder DeliveryRecorder
+
+	mutex    sync.Mutex
+	channels []models.NotificationChannel
+	sent     map[int64][]time.Time // send times in the last minute, by channel
+}
+
+// NewNotifier creates a notifier without channels
+func NewNotifier(recorder DeliveryRecorder) *Notifier {
+	return &Notifier{recorder: recorder, sent: make(map[int64][]time.Time)}
+}
+
+// SetChannels replaces the channels
+func (n *Notifier) SetChannels(channels []models.NotificationChannel) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.channels = channels
+}
+
+// Notify sends msg in the background to every enabled channel subscribed to
+// its topic and returns the number of channels it is sent to
+func (n *Notifier) Notify(msg Message) int {
+	if msg.Time.IsZero() {
+		msg.Time = time.Now()
+	}
+	n.mutex.Lock()
+	var targets []models.NotificationChannel
+	for _, channel := range n.channels {
+		if channel.Enabled && channel.Subscribed(msg.Topic) {
+			targets = append(targets, channel)
+		}
+	}
+	n.mutex.Unlock()
+
+	for _, channel := range targets {
+		go n.Send(channel, msg)
+	}
+	return len(targets)
+}
+
+// Send renders and sends msg to one channel, retrying with exponential
+// backoff, and returns the delivery record
+func (n *Notifier) Send(channel models.NotificationChannel, msg Message) models.NotificationDelivery {
+	if msg.Time.IsZero() {
+		msg.Time = time.Now()
+	}
+	delivery := models.NotificationDelivery{
+		ChannelID:   channel.ID,
+		ChannelName: channel.Name,
+		Topic:       msg.Topic,
+		Status:      models.DeliveryPending,
+		CreatedAt:   time.Now(),
+	}
+
+	title, body, err := Render(channel, msg)
+	delivery.Title, delivery.Body = title, body
+	if err != nil {
+		return n.finish(delivery, models.DeliveryFailed, err)
+	}
+	if !n.allow(channel, time.Now()) {
+		return n.finish(delivery, models.DeliveryRateLimited, fmt.Errorf("more than %d messages per minute", channel.RateLimitPerMinute))
+	}
+	n.record(&delivery)
+
+	driver, ok := drivers[channel.Type]
+	if !ok {
+		return n.finish(delivery, models.DeliveryFailed, fmt.Errorf("unknown channel type %q", channel.Type))
+	}
+
+	wait := firstRetryWait
+	for {
+		delivery.Attempts++
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		err = driver.Send(ctx, channel, title, body)
+		cancel()
+		if err == nil {
+			return n.finish(delivery, models.DeliverySent, nil)
+		}
+		if delivery.Attempts > min(channel.MaxRetries, maxRetries) {
+			return n.finish(delivery, models.DeliveryFailed, err)
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// allow applies the channel's rate limit and counts the message when it is allowed
+func (n *Notifier) allow(channel models.NotificationChannel, now time.Time) bool {
+	if channel.RateLimitPerMinute <= 0 {
+		return true
+	}
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	recent := n.sent[channel.ID][:0]
+	for _, t := range n.sent[channel.ID] {
+		if now.Sub(t) < time.Minute {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= channel.RateLimitPerMinute {
+		n.sent[channel.ID] = recent
+		return false
+	}
+	n.sent[channel.ID] = append(recent, now)
+	return true
+}
+
+func (n *Notifier) finish(delivery models.NotificationDelivery, status models.DeliveryStatus, err error) models.NotificationDelivery {
+	delivery.Status = status
+	if err != nil {
+		delivery.Error = err.Error()
+		log.Printf("Failed to notify channel %s: %v", delivery.ChannelName, err)
+	}
+	if status == models.DeliverySent {
+		sentAt := time.Now()
+		delivery.SentAt = &sentAt
+	}
+	n.record(&delivery)
+	return delivery
+}
+
+func (n *Notifier) record(delivery *models.NotificationDelivery) {
+	if n.recorder == nil {
+		return
+	}
+	if err := n.recorder.SaveDelivery(delivery); err != nil {
+		log.Printf("Failed to record notification delivery: %v", err)
+	}
+}
+
+// templateFuncs are available in channel templates
+var templateFuncs = template.FuncMap{
+	"json": func(v any) string {
+		data, _ := json.Marshal(v)
+		return string(data)
+	},
+	"formatTime": func(t time.Time, layout string) string { return t.Format(layout) },
+}
+
+func newTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs).Option("missingkey=zero").Parse(text)
+}
+
+// Render applies the channel's title and body templates to msg. The templates
+// see .Topic, .Title, .Text, .Data and .Time; empty templates use the
+// message title and text as they are.
+func Render(channel models.NotificationChannel, msg Message) (string, string, error) {
+	title, body := msg.Title, msg.Text
+	var err error
+	if channel.TitleTemplate != "" {
+		if title, err = execute("title", channel.TitleTemplate, msg); err != nil {
+			return msg.Title, msg.Text, err
+		}
+	}
+	if channel.BodyTemplate != "" {
+		if body, err = execute("body", channel.BodyTemplate, msg); err != nil {
+			return title, msg.Text, err
+		}
+	}
+	return title, body, nil
+}
+
+func execute(name, text string, msg Message) (string, error) {
+	tmpl, err := newTemplate(name, text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, msg); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,531 @@
+package policy
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// maxNesting bounds command substitutions and shell -c scripts within each other
+const maxNesting = 8
+
+// Command is a simple command of a shell line: its words with quotes
+// removed, and the targets of its redirections
+type Command struct {
+	Args      []string `json:"args"`
+	Redirects []string `json:"redirects,omitempty"`
+	// Outputs are the redirection targets written to, also in Redirects
+	Outputs []string `json:"outputs,omitempty"`
+}
+
+// Program returns the base name of the program run
+func (c Command) Program() string {
+	if len(c.Args) == 0 {
+		return ""
+	}
+	return filepath.Base(c.Args[0])
+}
+
+// Text returns the command's words joined by spaces
+func (c Command) Text() string {
+	return strings.Join(c.Args, " ")
+}
+
+// Parse splits a shell line into the simple commands it runs: those joined by
+// ; & && || and pipes, those in command and process substitutions, scripts
+// passed to sh -c and eval, those run by find -exec, and the commands run by
+// wrappers like sudo, env, nohup and timeout, which are reported both as the
+// wrapper and unwrapped. Reserved words like if, do and { are dropped, so a
+// command in a compound command is reported as its own program, and the
+// headers of for and case and the patterns of case aren't commands. Variables
+// and globs aren't expanded.
+func Parse(line string) ([]Command, error) {
+	return parse(line, 0)
+}
+
+func parse(line string, depth int) ([]Command, error) {
+	if depth > maxNesting {
+		return nil, fmt.Errorf("commands nested too deeply")
+	}
+	p := parser{input: []rune(line), depth: depth}
+	if err := p.run(); err != nil {
+		return nil, err
+	}
+
+	var commands []Command
+	for _, c := range p.commands {
+		expanded, err := unwrap(c, depth)
+		if err != nil {
+			return nil, err
+		}
+		commands = append(commands, expanded...)
+	}
+	return append(commands, p.nested...), nil
+}
+
+// parser tokenizes one shell line
+type parser struct {
+	input []rune
+	pos   int
+	depth int
+
+	commands []Command
+	nested   []Command // from substitutions
+
+	current      Command
+	word         strings.Builder
+	inWord       bool
+	quoted       bool // the word has quotes or escapes, so it can't be a reserved word
+	redirectNext bool // the next word is a redirection target
+	outputNext   bool // the redirection writes to its target
+	heredocNext  bool // the next word is a here-document delimiter
+
+	header      string // for or case while the words of its header are read
+	caseDepth   int    // case statements open
+	casePattern bool   // the words up to ) are a case pattern
+}
+
+func (p *parser) peek(offset int) rune {
+	if p.pos+offset < len(p.input) {
+		return p.input[p.pos+offset]
+	}
+	return 0
+}
+
+func (p *parser) run() error {
+	for p.pos < len(p.input) {
+		r := p.input[p.pos]
+		switch {
+		case r == ' ' || r == '\t':
+			p.endWord()
+			p.pos++
+		case r == ';' && p.caseDepth > 0 && (p.peek(1) == ';' || p.peek(1) == '&'):
+			// ;; ;& and ;;& end a case branch; a pattern follows
+			p.endCommand()
+			p.pos += 2
+			if p.peek(0) == '&' {
+				p.pos++
+			}
+			p.casePattern = true
+		case r == ')' && p.casePattern:
+			// The pattern is matched, not run
+			p.word.Reset()
+			p.inWord, p.quoted = false, false
+			p.current = Command{}
+			p.casePattern = false
+			p.pos++
+		case (r == '(' || r == '|') && p.casePattern:
+			// An optional ( before the pattern, and | between alternatives
+			p.endWord()
+			p.pos++
+		case r == '\n' || r == ';' || r == '(' || r == ')':
+			p.endCommand()
+			p.pos++
+		case r == '&' && p.peek(1) == '>':
+			// &> and &>> redirect both outputs
+			p.endWord()
+			p.pos += 2
+			if p.peek(0) == '>' {
+				p.pos++
+			}
+			p.redirectNext, p.outputNext = true, true
+		case r == '&' || r == '|':
+			p.endCommand()
+			p.pos++
+			if next := p.peek(0); next == r || (r == '|' && next == '&') {
+				p.pos++
+			}
+		case r == '<' || r == '>':
+			if err := p.redirection(); err != nil {
+				return err
+			}
+		case r == '#' && !p.inWord:
+			// A comment runs to the end of the line
+			for p.pos < len(p.input) && p.input[p.pos] != '\n' {
+				p.pos++
+			}
+		case r == '\\':
+			p.inWord, p.quoted = true, true
+			p.pos++
+			if p.pos < len(p.input) {
+				if p.input[p.pos] != '\n' {
+					p.word.WriteRune(p.input[p.pos])
+				}
+				p.pos++
+			}
+		case r == '\'':
+			p.inWord, p.quoted = true, true
+			end := p.find('\'', p.pos+1)
+			if end < 0 {
+				return fmt.Errorf("unterminated single quote")
+			}
+			p.word.WriteString(string(p.input[p.pos+1 : end]))
+			p.pos = end + 1
+		case r == '"':
+			if err := p.doubleQuoted(); err != nil {
+				return err
+			}
+		case r == '$' && p.peek(1) == '(' && p.peek(2) != '(':
+			if err := p.substitution(p.pos+2, ')'); err != nil {
+				return err
+			}
+		case r == '`':
+			if err := p.substitution(p.pos+1, '`'); err != nil {
+				return err
+			}
+		default:
+			p.inWord = true
+			p.word.WriteRune(r)
+			p.pos++
+		}
+	}
+	p.endCommand()
+	return nil
+}
+
+func (p *parser) find(r rune, from int) int {
+	for i := from; i < len(p.input); i++ {
+		if p.input[i] == r {
+			return i
+		}
+	}
+	return -1
+}
+
+// redirection reads <, >, >>, >|, <>, <<, <<< and <( >( process substitutions.
+// A file descriptor number before it, as in 2>, is part of the operator.
+func (p *parser) redirection() error {
+	if (p.input[p.pos] == '<' || p.input[p.pos] == '>') && p.peek(1) == '(' {
+		p.endWord()
+		return p.substitution(p.pos+2, ')')
+	}
+	if p.inWord && isDigits(p.word.String()) {
+		p.word.Reset()
+		p.inWord = false
+	}
+	p.endWord()
+
+	r := p.input[p.pos]
+	p.pos++
+	switch {
+	case r == '<' && p.peek(0) == '<' && p.peek(1) == '<':
+		p.pos += 2 // a here-string is an argument, not a file
+		return nil
+	case r == '<' && p.peek(0) == '<':
+		p.pos++
+		if p.peek(0) == '-' {
+			p.pos++
+		}
+		p.heredocNext = true
+		return nil
+	case p.peek(0) == '>' || p.peek(0) == '|' || (r == '<' && p.peek(0) == '>'):
+		p.pos++
+	}
+	// > >> >| and <> write to their target
+	output := r == '>' || p.input[p.pos-1] == '>'
+	if p.peek(0) == '&' {
+		p.pos++
+		if next := p.peek(0); isDigits(string(next)) || next == '-' {
+			// Duplicating a descriptor, as in 2>&1
+			for p.pos < len(p.input) && (isDigits(string(p.input[p.pos])) || p.input[p.pos] == '-') {
+				p.pos++
+			}
+			return nil
+		}
+		// >& file redirects both outputs
+	}
+	p.redirectNext, p.outputNext = true, output
+	return nil
+}
+
+func (p *parser) doubleQuoted() error {
+	p.inWord, p.quoted = true, true
+	p.pos++
+	for p.pos < len(p.input) {
+		r := p.input[p.pos]
+		switch {
+		case r == '"':
+			p.pos++
+			return nil
+		case r == '\\' && strings.ContainsRune("\"\\$`\n", p.peek(1)):
+			if p.peek(1) != '\n' {
+				p.word.WriteRune(p.peek(1))
+			}
+			p.pos += 2
+		case r == '$' && p.peek(1) == '(' && p.peek(2) != '(':
+			if err := p.substitution(p.pos+2, ')'); err != nil {
+				return err
+			}
+		case r == '`':
+			if err := p.substitution(p.pos+1, '`'); err != nil {
+				return err
+			}
+		default:
+			p.word.WriteRune(r)
+			p.pos++
+		}
+	}
+	return fmt.Errorf("unterminated double quote")
+}
+
+// substitution parses the commands of $(...), `...`, <(...) or >(...)
+// starting at from, keeping its text in the current word
+func (p *parser) substitution(from int, closing rune) error {
+	end, err := p.matching(from, closing)
+	if err != nil {
+		return err
+	}
+	inner := string(p.input[from:end])
+	commands, err := parse(inner, p.depth+1)
+	if err != nil {
+		return err
+	}
+	p.nested = append(p.nested, commands...)
+
+	p.inWord, p.quoted = true, true
+	p.word.WriteString(string(p.input[p.pos : end+1]))
+	p.pos = end + 1
+	return nil
+}
+
+// matching finds the closing rune of a substitution, skipping nested
+// parentheses and quotes
+func (p *parser) matching(from int, closing rune) (int, error) {
+	depth := 0
+	for i := from; i < len(p.input); i++ {
+		switch r := p.input[i]; {
+		case r == '\\':
+			i++
+		case r == '\'' && closing != '`':
+			end := p.find('\'', i+1)
+			if end < 0 {
+				return 0, fmt.Errorf("unterminated single quote")
+			}
+			i = end
+		case r == closing && depth == 0:
+			return i, nil
+		case closing == ')' && r == '(':
+			depth++
+		case closing == ')' && r == ')':
+			depth--
+		}
+	}
+	return 0, fmt.Errorf("unterminated command substitution")
+}
+
+func (p *parser) endWord() {
+	if !p.inWord {
+		return
+	}
+	word := p.word.String()
+	quoted := p.quoted
+	p.word.Reset()
+	p.inWord, p.quoted = false, false
+
+	switch {
+	case p.header != "":
+		// The variable and words of for x in ..., the word of case ... in and
+		// the name of a function aren't run; substitutions in them were
+		// parsed already
+		switch {
+		case p.header == "case" && word == "in":
+			p.header = ""
+			p.caseDepth++
+			p.casePattern = true
+		case p.header == "for" && word == "do" && !quoted:
+			// for x do ... runs the list right away
+			p.header = ""
+		case p.header == "function":
+			p.header = "" // the function's name
+		}
+	case len(p.current.Args) == 0 && len(p.current.Redirects) == 0 && !quoted && reserved[word]:
+		// Reserved words open and close compound commands; the program is
+		// the word after them
+		switch word {
+		case "for", "select":
+			p.header = "for"
+		case "case", "function":
+			p.header = word
+		case "esac":
+			p.caseDepth = max(p.caseDepth-1, 0)
+			p.casePattern = false
+		}
+	case p.heredocNext:
+		p.heredocNext = false
+	case p.redirectNext:
+		p.redirectNext = false
+		p.current.Redirects = append(p.current.Redirects, word)
+		if p.outputNext {
+			p.current.Outputs = append(p.current.Outputs, word)
+		}
+	case len(p.current.Args) == 0 && isAssignment(word):
+		// NAME=value before the program sets its environment
+	default:
+		p.current.Args = append(p.current.Args, word)
+	}
+}
+
+func (p *parser) endCommand() {
+	p.endWord()
+	p.redirectNext, p.outputNext, p.heredocNext = false, false, false
+	if p.header == "for" {
+		p.header = ""
+	}
+	if len(p.current.Args) > 0 || len(p.current.Redirects) > 0 {
+		p.commands = append(p.commands, p.current)
+	}
+	p.current = Command{}
+}
+
+// reserved are the shell's reserved words that can start a command. time is
+// left to the wrappers, which also know its options.
+var reserved = map[string]bool{
+	"if": true, "then": true, "elif": true, "else": true, "fi": true,
+	"do": true, "done": true, "while": true, "until": true, "for": true, "select": true,
+	"case": true, "esac": true, "{": true, "}": true, "!": true,
+	"function": true, "coproc": true,
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isAssignment(word string) bool {
+	i := strings.IndexByte(word, '=')
+	if i <= 0 {
+		return false
+	}
+	for j, r := range word[:i] {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || j > 0 && r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// wrappers run the command in their arguments, mapped to their options that take a value
+var wrappers = map[string]map[string]bool{
+	"sudo":    {"-u": true, "-g": true, "-C": true, "-D": true, "-h": true, "-p": true, "-r": true, "-t": true, "-U": true},
+	"doas":    {"-u": true, "-C": true},
+	"env":     {"-u": true, "-C": true, "-S": true, "--unset": true, "--chdir": true},
+	"nohup":   {},
+	"nice":    {"-n": true, "--adjustment": true},
+	"ionice":  {"-c": true, "-n": true, "-p": true, "-P": true, "-u": true, "--class": true, "--classdata": true},
+	"timeout": {"-s": true, "-k": true, "--signal": true, "--kill-after": true},
+	"time":    {"-f": true, "-o": true, "--format": true, "--output": true},
+	"command": {},
+	"exec":    {"-a": true},
+	"builtin": {},
+	"stdbuf":  {"-i": true, "-o": true, "-e": true},
+	"xargs":   {"-a": true, "-d": true, "-E": true, "-I": true, "-L": true, "-n": true, "-P": true, "-s": true, "--arg-file": true, "--delimiter": true, "--max-args": true, "--max-procs": true},
+	"watch":   {"-n": true, "-d": true, "--interval": true},
+	"chroot":  {"--userspec": true, "--groups": true},
+	"setsid":  {},
+	"strace":  {"-e": true, "-o": true, "-p": true, "-s": true, "-u": true},
+	"flock":   {"-w": true, "-E": true, "--timeout": true, "--conflict-exit-code": true},
+}
+
+// shells run the script after -c
+var shells = map[string]bool{"sh": true, "bash": true, "dash": true, "zsh": true, "ksh": true, "ash": true}
+
+// unwrap returns a command followed by the commands it runs
+func unwrap(c Command, depth int) ([]Command, error) {
+	commands := []Command{c}
+	if len(c.Args) == 0 {
+		return commands, nil
+	}
+	program := c.Program()
+
+	switch {
+	case program == "eval":
+		inner, err := parse(strings.Join(c.Args[1:], " "), depth+1)
+		if err != nil {
+			return nil, err
+		}
+		return append(commands, inner...), nil
+
+	case program == "find":
+		// -exec and -ok run a command with the arguments up to ; or +
+		for i := 1; i < len(c.Args); i++ {
+			switch c.Args[i] {
+			case "-exec", "-execdir", "-ok", "-okdir":
+				end := i + 1
+				for end < len(c.Args) && c.Args[end] != ";" && c.Args[end] != "+" {
+					end++
+				}
+				if end > i+1 {
+					inner, err := unwrap(Command{Args: c.Args[i+1 : end]}, depth+1)
+					if err != nil {
+						return nil, err
+					}
+					commands = append(commands, inner...)
+				}
+				i = end
+			}
+		}
+		return commands, nil
+
+	case shells[program]:
+		for i := 1; i < len(c.Args); i++ {
+			arg := c.Args[i]
+			if !strings.HasPrefix(arg, "-") || arg == "--" {
+				break
+			}
+			// -c, or combined flags like -ec and -lc
+			if !strings.HasPrefix(arg, "--") && strings.ContainsRune(arg, 'c') && i+1 < len(c.Args) {
+				inner, err := parse(c.Args[i+1], depth+1)
+				if err != nil {
+					return nil, err
+				}
+				return append(commands, inner...), nil
+			}
+		}
+		return commands, nil
+	}
+
+	options, ok := wrappers[program]
+	if !ok {
+		return commands, nil
+	}
+	i := 1
+	for i < len(c.Args) {
+		arg := c.Args[i]
+		if arg == "--" {
+			i++
+			break
+		}
+		if program == "env" && isAssignment(arg) {
+			i++
+			continue
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			break
+		}
+		i++
+		if options[arg] {
+			i++ // the option's value
+		}
+	}
+	switch program {
+	case "timeout":
+		i++ // the duration
+	case "chroot", "flock":
+		i++ // the new root, the lock file
+	}
+	if i >= len(c.Args) {
+		return commands, nil
+	}
+	inner, err := unwrap(Command{Args: c.Args[i:], Redirects: c.Redirects}, depth+1)
+	if err != nil {
+		return nil, err
+	}
+	return append(commands, inner...), nil
+}
@@ -0,0 +1,267 @@
+// Package policy decides whether a shell command may run, needs a second
+// operator's approval or is refused, from per-role rules over its parsed
+// commands, arguments and the paths it touches.
+package policy
+
+import (
+	"control/go_server/internal/models"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Decision is the policy's verdict on a command line
+type Decision struct {
+	Action  models.PolicyAction `json:"action"`
+	Rule    string              `json:"rule,omitempty"` // the deciding rule, empty when none applied
+	Reason  string              `json:"reason,omitempty"`
+	Command string              `json:"command,omitempty"` // the simple command the rule applied to
+}
+
+// Env resolves the relative paths of a command
+type Env struct {
+	Dir  string // working directory
+	Home string // replaces ~
+}
+
+// Policy is a compiled, ordered set of rules
+type Policy struct {
+	rules []rule
+}
+
+type rule struct {
+	models.CommandRule
+	args    []*regexp.Regexp
+	pattern *regexp.Regexp
+}
+
+// ValidateRule checks a rule before it is saved
+func ValidateRule(r models.CommandRule) error {
+	_, err := compile(r)
+	return err
+}
+
+func compile(r models.CommandRule) (rule, error) {
+	if r.Name == "" {
+		return rule{}, fmt.Errorf("rule name is required")
+	}
+	switch r.Action {
+	case models.PolicyAllow, models.PolicyDeny, models.PolicyApprove:
+	default:
+		return rule{}, fmt.Errorf("unknown action %q", r.Action)
+	}
+	if len(r.Programs) == 0 && len(r.Args) == 0 && len(r.Paths) == 0 && r.Pattern == "" && !r.Writes {
+		return rule{}, fmt.Errorf("rule %s matches nothing: set programs, args, paths, a pattern or writes", r.Name)
+	}
+
+	compiled := rule{CommandRule: r}
+	for _, glob := range append(append([]string{}, r.Programs...), r.Paths...) {
+		if _, err := filepath.Match(glob, ""); err != nil {
+			return rule{}, fmt.Errorf("invalid glob %q: %w", glob, err)
+		}
+	}
+	for _, expr := range r.Args {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return rule{}, fmt.Errorf("invalid args expression %q: %w", expr, err)
+		}
+		compiled.args = append(compiled.args, re)
+	}
+	if r.Pattern != "" {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return rule{}, fmt.Errorf("invalid pattern: %w", err)
+		}
+		compiled.pattern = re
+	}
+	return compiled, nil
+}
+
+// Compile orders the enabled rules by priority
+func Compile(rules []models.CommandRule) (*Policy, error) {
+	p := &Policy{}
+	for _, r := range rules {
+		if !r.Enabled {
+			continue
+		}
+		compiled, err := compile(r)
+		if err != nil {
+			return nil, err
+		}
+		p.rules = append(p.rules, compiled)
+	}
+	sort.SliceStable(p.rules, func(i, j int) bool { return p.rules[i].Priority < p.rules[j].Priority })
+	return p, nil
+}
+
+// severity orders actions from the least to the most restrictive
+var severity = map[models.PolicyAction]int{models.PolicyAllow: 0, models.PolicyApprove: 1, models.PolicyDeny: 2}
+
+// Decide decides on a command line for a role. Every simple command of the
+// line is decided by the first rule applying to it, or allowed when none
+// does; the line gets the most restrictive of those decisions. A line that
+// can't be parsed is denied, and so is one running a program whose name comes
+// from a variable or substitution, as no rule can be matched against it.
+func (p *Policy) Decide(role, line string, env Env) Decision {
+	commands, err := Parse(line)
+	if err != nil {
+		return Decision{Action: models.PolicyDeny, Reason: "Command can't be parsed: " + err.Error(), Command: line}
+	}
+
+	decision := Decision{Action: models.PolicyAllow}
+	for _, c := range commands {
+		if len(c.Args) > 0 && strings.ContainsAny(c.Args[0], "$`") {
+			return Decision{Action: models.PolicyDeny, Reason: "Program name is built from a variable or substitution", Command: c.Text()}
+		}
+		if c.Program() == "cd" && len(c.Args) > 1 {
+			// Later commands run in the new directory, as in cd / && rm -rf *
+			env.Dir = resolve(c.Args[1], env)
+		}
+		for _, r := range p.rules {
+			if !r.appliesTo(role, c, env) {
+				continue
+			}
+			if severity[r.Action] > severity[decision.Action] || decision.Rule == "" && r.Action == decision.Action {
+				decision = Decision{Action: r.Action, Rule: r.Name, Reason: r.Reason, Command: c.Text()}
+			}
+			break
+		}
+	}
+	return decision
+}
+
+func (r rule) appliesTo(role string, c Command, env Env) bool {
+	if len(r.Roles) > 0 && !contains(r.Roles, role) {
+		return false
+	}
+	if len(r.Programs) > 0 {
+		program := c.Program()
+		matched := false
+		for _, glob := range r.Programs {
+			if ok, _ := filepath.Match(glob, program); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	args := []string{}
+	if len(c.Args) > 1 {
+		args = c.Args[1:]
+	}
+	for _, re := range r.args {
+		matched := false
+		for _, arg := range args {
+			if re.MatchString(arg) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(r.Paths) > 0 && !r.touchesPaths(c, env) {
+		return false
+	}
+	if r.pattern != nil && !r.pattern.MatchString(c.Text()) {
+		return false
+	}
+	if r.Writes && !writes(c) {
+		return false
+	}
+	return true
+}
+
+// writes reports whether the command redirects output to a file
+func writes(c Command) bool {
+	for _, target := range c.Outputs {
+		if target != "/dev/null" {
+			return true
+		}
+	}
+	return false
+}
+
+// touchesPaths reports whether an argument or redirection of the command is
+// in the rule's paths. Arguments with unexpanded variables could be any
+// path, so they are in every path.
+func (r rule) touchesPaths(c Command, env Env) bool {
+	var candidates []string
+	if len(c.Args) > 1 {
+		candidates = append(candidates, c.Args[1:]...)
+	}
+	candidates = append(candidates, c.Redirects...)
+
+	for _, arg := range candidates {
+		if strings.HasPrefix(arg, "-") {
+			// Option values, as in --file=/etc/x or of=/dev/sda
+			i := strings.IndexByte(arg, '=')
+			if i < 0 {
+				continue
+			}
+			arg = arg[i+1:]
+		} else if i := strings.IndexByte(arg, '='); i > 0 && !strings.ContainsRune(arg[:i], '/') {
+			arg = arg[i+1:]
+		}
+		if arg == "" {
+			continue
+		}
+		if strings.ContainsAny(arg, "$`") {
+			return true
+		}
+		path := resolve(arg, env)
+		for _, glob := range r.Paths {
+			if matchPath(glob, path) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func resolve(arg string, env Env) string {
+	switch {
+	case arg == "~":
+		arg = env.Home
+	case strings.HasPrefix(arg, "~/"):
+		arg = filepath.Join(env.Home, arg[2:])
+	case !filepath.IsAbs(arg):
+		arg = filepath.Join(env.Dir, arg)
+	}
+	return filepath.Clean(arg)
+}
+
+// matchPath matches a path against a glob; a glob ending in /** also matches
+// everything under the directories it matches
+func matchPath(glob, path string) bool {
+	base, recursive := strings.CutSuffix(glob, "/**")
+	if !recursive {
+		ok, _ := filepath.Match(glob, path)
+		return ok
+	}
+	if base == "" {
+		base = "/"
+	}
+	for p := path; ; p = filepath.Dir(p) {
+		if ok, _ := filepath.Match(base, p); ok {
+			return true
+		}
+		if p == "/" || p == "." {
+			return false
+		}
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,229 @@
+package policy
+
+import (
+	"control/go_server/config"
+	"control/go_server/internal/models"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// defaultPolicy compiles the rules the configuration seeds
+func defaultPolicy(t *testing.T) *Policy {
+	t.Helper()
+	login := filepath.Join(t.TempDir(), "login.json")
+	if err := os.WriteFile(login, []byte(`{}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.LoadConfig(login); err != nil {
+		t.Fatal(err)
+	}
+	p, err := Compile(config.Conf.CommandRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func programs(commands []Command) []string {
+	var names []string
+	for _, c := range commands {
+		names = append(names, c.Program())
+	}
+	return names
+}
+
+func TestParseReservedWords(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"for i in 1; do rm -rf /; done", []string{"rm"}},
+		{"for i in a b\ndo\n\trm -rf /\ndone", []string{"rm"}},
+		{"for i do rm -rf /; done", []string{"rm"}},
+		{"for f in $(ls /); do rm -rf /; done", []string{"rm", "ls"}},
+		{"if true; then rm -rf /; fi", []string{"true", "rm"}},
+		{"if false; then :; elif true; then rm -rf /; else ls; fi", []string{"false", ":", "true", "rm", "ls"}},
+		{"while true; do rm -rf /; done", []string{"true", "rm"}},
+		{"until false; do rm -rf /; done", []string{"false", "rm"}},
+		{"{ rm -rf /; }", []string{"rm"}},
+		{"! rm -rf /", []string{"rm"}},
+		{"if ! rm -rf /; then :; fi", []string{"rm", ":"}},
+		{"case x in a|b) rm -rf /;; (c) ls;; esac", []string{"rm", "ls"}},
+		{"case $x in\n  a) rm -rf /\n  ;;\n  *) ls ;;\nesac", []string{"rm", "ls"}},
+		{"function f { rm -rf /; }", []string{"rm"}},
+		{"time rm -rf /", []string{"time", "rm"}},
+		// Quoted, they are programs like any other
+		{"'if' x", []string{"if"}},
+		{"echo if then fi", []string{"echo"}},
+	}
+	for _, tt := range tests {
+		commands, err := Parse(tt.line)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.line, err)
+			continue
+		}
+		if got := programs(commands); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Parse(%q) programs = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestDecideReservedWords(t *testing.T) {
+	p := defaultPolicy(t)
+	env := Env{Dir: "/root", Home: "/root"}
+	for _, line := range []string{
+		"rm -rf /",
+		"for i in 1; do rm -rf /; done",
+		"if true; then rm -rf /; fi",
+		"{ rm -rf /; }",
+		"! rm -rf /",
+	} {
+		for _, role := range []string{models.RoleAdmin, models.RoleOperator, models.RoleViewer} {
+			if d := p.Decide(role, line, env); d.Action != models.PolicyDeny {
+				t.Errorf("Decide(%s, %q) = %s, want deny", role, line, d.Action)
+			}
+		}
+	}
+}
+
+func TestDecideViewerWrites(t *testing.T) {
+	p := defaultPolicy(t)
+	env := Env{Dir: "/root", Home: "/root"}
+	tests := []struct {
+		line string
+		want models.PolicyAction
+	}{
+		{"cat /etc/hosts", models.PolicyAllow},
+		{"ls /opt 2>/dev/null", models.PolicyAllow},
+		{"grep error app.log | wc -l", models.PolicyAllow},
+		{"cat < /etc/hosts", models.PolicyAllow},
+		{"cat /dev/null > /etc/passwd", models.PolicyDeny},
+		{"ls > /opt/ims_server_api/deploy.sh", models.PolicyDeny},
+		{"ls >> notes", models.PolicyDeny},
+		{"ls >| notes", models.PolicyDeny},
+		{"ls &> notes", models.PolicyDeny},
+		{"ls >& notes", models.PolicyDeny},
+		{"ls 2> errors", models.PolicyDeny},
+		{"cat <> /etc/passwd", models.PolicyDeny},
+		{"date", models.PolicyDeny},
+		{"date -s 2000-01-01", models.PolicyDeny},
+	}
+	for _, tt := range tests {
+		if d := p.Decide(models.RoleViewer, tt.line, env); d.Action != tt.want {
+			t.Errorf("Decide(viewer, %q) = %s (%s), want %s", tt.line, d.Action, d.Rule, tt.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		line string
+		want []Command
+	}{
+		{"ls -la /tmp", []Command{{Args: []string{"ls", "-la", "/tmp"}}}},
+		{`echo 'a b' "c d" e\ f`, []Command{{Args: []string{"echo", "a b", "c d", "e f"}}}},
+		{"ls | grep x && rm y; df &", []Command{
+			{Args: []string{"ls"}}, {Args: []string{"grep", "x"}}, {Args: []string{"rm", "y"}}, {Args: []string{"df"}},
+		}},
+		{"echo $(rm -rf /tmp/x)", []Command{
+			{Args: []string{"echo", "$(rm -rf /tmp/x)"}}, {Args: []string{"rm", "-rf", "/tmp/x"}},
+		}},
+		{"echo `whoami` \"$(id -u)\"", []Command{
+			{Args: []string{"echo", "`whoami`", "$(id -u)"}}, {Args: []string{"whoami"}}, {Args: []string{"id", "-u"}},
+		}},
+		{"diff <(ls a) <(ls b)", []Command{
+			{Args: []string{"diff", "<(ls a)", "<(ls b)"}}, {Args: []string{"ls", "a"}}, {Args: []string{"ls", "b"}},
+		}},
+		{"cat < in > out 2>&1 >> log", []Command{
+			{Args: []string{"cat"}, Redirects: []string{"in", "out", "log"}, Outputs: []string{"out", "log"}},
+		}},
+		{"cmd &> all 2> err", []Command{
+			{Args: []string{"cmd"}, Redirects: []string{"all", "err"}, Outputs: []string{"all", "err"}},
+		}},
+		{"cat <<EOF", []Command{{Args: []string{"cat"}}}},
+		{"X=1 Y=2 env", []Command{{Args: []string{"env"}}}},
+		{"sudo -u root rm -rf /", []Command{
+			{Args: []string{"sudo", "-u", "root", "rm", "-rf", "/"}}, {Args: []string{"rm", "-rf", "/"}},
+		}},
+		{"bash -c 'rm -rf /'", []Command{
+			{Args: []string{"bash", "-c", "rm -rf /"}}, {Args: []string{"rm", "-rf", "/"}},
+		}},
+		{`find / -name x -exec rm {} \;`, []Command{
+			{Args: []string{"find", "/", "-name", "x", "-exec", "rm", "{}", ";"}}, {Args: []string{"rm", "{}"}},
+		}},
+		{"ls # rm -rf /", []Command{{Args: []string{"ls"}}}},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.line)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Parse(%q) = %+v, want %+v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, line := range []string{
+		"echo 'unterminated",
+		`echo "unterminated`,
+		"echo $(unterminated",
+		"echo `unterminated",
+	} {
+		if _, err := Parse(line); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", line)
+		}
+	}
+}
+
+func TestDecide(t *testing.T) {
+	p := defaultPolicy(t)
+	env := Env{Dir: "/root", Home: "/root"}
+	tests := []struct {
+		role string
+		line string
+		want models.PolicyAction
+	}{
+		{models.RoleOperator, "ls -la", models.PolicyAllow},
+		{models.RoleOperator, "rm -rf /", models.PolicyDeny},
+		{models.RoleAdmin, "rm -rf /opt", models.PolicyDeny},
+		{models.RoleOperator, "rm -r build", models.PolicyApprove},
+		{models.RoleAdmin, "rm -r build", models.PolicyAllow},
+		{models.RoleOperator, "rm /etc/passwd", models.PolicyApprove},
+		{models.RoleOperator, "echo > /dev/sda", models.PolicyApprove},
+		{models.RoleOperator, "reboot", models.PolicyApprove},
+		{models.RoleOperator, "mkfs.ext4 /dev/sdb", models.PolicyDeny},
+		{models.RoleAdmin, "mkfs.ext4 /dev/sdb", models.PolicyApprove},
+		{models.RoleViewer, "ps aux | grep nginx", models.PolicyAllow},
+		{models.RoleViewer, "kill 1", models.PolicyDeny},
+		// Substitutions and wrappers are decided on the commands they run
+		{models.RoleOperator, "echo $(rm -rf /)", models.PolicyDeny},
+		{models.RoleOperator, "sudo rm -rf /", models.PolicyDeny},
+		{models.RoleOperator, "sh -c 'rm -rf /'", models.PolicyDeny},
+		{models.RoleViewer, "cat $(kill 1)", models.PolicyDeny},
+		// A program built from a variable or substitution can't be matched
+		{models.RoleAdmin, "$(printf rm) -rf /", models.PolicyDeny},
+		{models.RoleAdmin, "`echo rm` -rf /", models.PolicyDeny},
+		{models.RoleAdmin, "r=rm; $r -rf /", models.PolicyDeny},
+		{models.RoleAdmin, "${r:-rm} -rf /opt", models.PolicyDeny},
+		{models.RoleAdmin, "sudo $r -rf /opt", models.PolicyDeny},
+		// Unexpanded arguments could be any path, / and devices included
+		{models.RoleOperator, "ls $HOME", models.PolicyApprove},
+		{models.RoleOperator, "rm $target", models.PolicyDeny},
+		// cd changes the directory later relative paths resolve in
+		{models.RoleOperator, "cd / && rm -rf *", models.PolicyDeny},
+		{models.RoleOperator, "cd /etc; rm passwd", models.PolicyApprove},
+		{models.RoleOperator, "cd /tmp && rm passwd", models.PolicyAllow},
+		{models.RoleOperator, "cd ~ && rm ../etc/passwd", models.PolicyApprove},
+		{models.RoleOperator, "rm 'unterminated", models.PolicyDeny},
+	}
+	for _, tt := range tests {
+		if d := p.Decide(tt.role, tt.line, env); d.Action != tt.want {
+			t.Errorf("Decide(%s, %q) = %s (%s), want %s", tt.role, tt.line, d.Action, d.Rule, tt.want)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// Package recording writes terminal sessions as asciicast v2 files, the
+// format played by asciinema, and extracts the commands typed into them.
+package recording
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// ContentType is the media type of asciicast files
+const ContentType = "application/x-asciicast"
+
+// Header is the first line of an asciicast v2 file
+type Header struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"` // unix seconds of the start
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Event types of asciicast v2
+const (
+	EventOutput = "o"
+	EventInput  = "i"
+	EventResize = "r"
+)
+
+// Writer appends the events of a session to an asciicast file. Every event is
+// written through to the file, so a recording survives a crash of the server.
+type Writer struct {
+	mutex   sync.Mutex
+	file    *os.File
+	started time.Time
+	size    int64
+}
+
+// Create starts a recording at path with the header
+func Create(path string, header Header) (*Writer, error) {
+	header.Version = 2
+	line, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	w := &Writer{file: file, started: time.Unix(header.Timestamp, 0)}
+	if err := w.writeLine(line); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// Open continues a recording at path; event times stay relative to the
+// start in its header
+func Open(path string) (*Writer, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	line, err := bufio.NewReader(file).ReadBytes('\n')
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("read asciicast header: %w", err)
+	}
+	var header Header
+	if err := json.Unmarshal(line, &header); err != nil || header.Version != 2 {
+		file.Close()
+		return nil, errors.New("not an asciicast v2 file")
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &Writer{file: file, started: time.Unix(header.Timestamp, 0), size: info.Size()}, nil
+}
+
+// Output records what the terminal printed
+func (w *Writer) Output(data []byte) error {
+	return w.event(time.Now(), EventOutput, string(data))
+}
+
+// Input records what was typed
+func (w *Writer) Input(data []byte) error {
+	return w.event(time.Now(), EventInput, string(data))
+}
+
+// Resize records a change of the terminal's size
+func (w *Writer) Resize(cols, rows uint16) error {
+	return w.event(time.Now(), EventResize, fmt.Sprintf("%dx%d", cols, rows))
+}
+
+func (w *Writer) event(at time.Time, kind, data string) error {
+	// Invalid UTF-8, e.g. a multibyte character split across reads, is
+	// replaced when encoded; players do the same
+	line, err := json.Marshal([]any{at.Sub(w.started).Seconds(), kind, data})
+	if err != nil {
+		return err
+	}
+	return w.writeLine(line)
+}
+
+func (w *Writer) writeLine(line []byte) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	n, err := w.file.Write(append(line, '\n'))
+	w.size += int64(n)
+	return err
+}
+
+// Size returns the size of the file written so far
+func (w *Writer) Size() int64 {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.size
+}
+
+// Close closes the file
+func (w *Writer) Close() error {
+	return w.file.Close()
+}
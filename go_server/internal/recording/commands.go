@@ -0,0 +1,67 @@
+package recording
+
+import "strings"
+
+// maxLineLength bounds a line that is never ended, e.g. a binary paste
+const maxLineLength = 4096
+
+// LineBuffer reassembles the command lines typed into a terminal from its
+// keystrokes, for searching recordings. It applies backspace and line kill
+// and drops escape sequences, so lines edited with the cursor keys or
+// recalled from the shell's history are approximate; the recording itself
+// has the exact keystrokes.
+type LineBuffer struct {
+	line   []rune
+	escape int // 0 outside an escape sequence, 1 after ESC, 2 inside a CSI or SS3 sequence
+}
+
+// Write feeds keystrokes and returns the lines they completed, without empty ones
+func (b *LineBuffer) Write(data []byte) []string {
+	var lines []string
+	for _, r := range string(data) {
+		switch b.escape {
+		case 1:
+			b.escape = 0
+			if r == '[' || r == 'O' {
+				b.escape = 2
+			}
+			continue
+		case 2:
+			// Parameters and intermediates until the final byte
+			if r >= 0x40 && r <= 0x7e {
+				b.escape = 0
+			}
+			continue
+		}
+
+		switch r {
+		case '\r', '\n':
+			if line := strings.TrimSpace(string(b.line)); line != "" {
+				lines = append(lines, line)
+			}
+			b.line = b.line[:0]
+		case 0x1b:
+			b.escape = 1
+		case 0x7f, 0x08: // backspace
+			if len(b.line) > 0 {
+				b.line = b.line[:len(b.line)-1]
+			}
+		case 0x03, 0x15: // Ctrl-C abandons the line, Ctrl-U kills it
+			b.line = b.line[:0]
+		case 0x17: // Ctrl-W deletes the word before the cursor
+			line := strings.TrimRight(string(b.line), " ")
+			if i := strings.LastIndexByte(line, ' '); i >= 0 {
+				b.line = []rune(line[:i+1])
+			} else {
+				b.line = b.line[:0]
+			}
+		case '\t':
+			b.line = append(b.line, ' ')
+		default:
+			if r >= 0x20 && len(b.line) < maxLineLength {
+				b.line = append(b.line, r)
+			}
+		}
+	}
+	return lines
+}
@@ -0,0 +1,332 @@
+// Package runbook validates runbooks and renders a runbook with the values
+// of its parameters into the script that is run.
+package runbook
+
+import (
+	"control/go_server/internal/models"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	paramName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	// hosts are ssh destinations: host, user@host or an ssh config alias
+	hostName = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._@-]*$`)
+)
+
+// reserved variables would change how the shell or the script's programs
+// behave, so parameters can't be named after them
+var reserved = map[string]bool{
+	"PATH": true, "HOME": true, "IFS": true, "SHELL": true, "USER": true, "ENV": true,
+	"BASH_ENV": true, "LD_PRELOAD": true, "LD_LIBRARY_PATH": true, "PS4": true,
+	"SERVICE_NAME": true, "SERVICE_PATH": true,
+}
+
+// ValidateRunbook checks a runbook before it is saved; services are the
+// names of the configured services
+func ValidateRunbook(r models.Runbook, services map[string]models.Service) error {
+	if r.Name == "" {
+		return fmt.Errorf("runbook name is required")
+	}
+	if strings.TrimSpace(r.Script) == "" {
+		return fmt.Errorf("runbook %s has no script", r.Name)
+	}
+	switch r.RequiredRole {
+	case models.RoleAdmin, models.RoleOperator, models.RoleViewer:
+	default:
+		return fmt.Errorf("unknown role %q", r.RequiredRole)
+	}
+	if r.TimeoutSeconds < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+	if r.Service != "" {
+		if _, ok := services[r.Service]; !ok {
+			return fmt.Errorf("unknown service %q", r.Service)
+		}
+	}
+	if r.Host != "" && !hostName.MatchString(r.Host) {
+		return fmt.Errorf("invalid host %q", r.Host)
+	}
+
+	seen := make(map[string]bool)
+	for _, p := range r.Params {
+		if !paramName.MatchString(p.Name) {
+			return fmt.Errorf("invalid parameter name %q: use letters, digits and underscores", p.Name)
+		}
+		if reserved[p.Name] {
+			return fmt.Errorf("parameter name %s is reserved", p.Name)
+		}
+		if seen[p.Name] {
+			return fmt.Errorf("duplicate parameter %s", p.Name)
+		}
+		seen[p.Name] = true
+
+		switch p.Type {
+		case models.ParamString:
+			if p.Pattern != "" {
+				if _, err := regexp.Compile(p.Pattern); err != nil {
+					return fmt.Errorf("parameter %s: invalid pattern: %w", p.Name, err)
+				}
+			}
+		case models.ParamInt, models.ParamBool, models.ParamService:
+		case models.ParamEnum:
+			if len(p.Options) == 0 {
+				return fmt.Errorf("parameter %s: an enum needs options", p.Name)
+			}
+		default:
+			return fmt.Errorf("parameter %s: unknown type %q", p.Name, p.Type)
+		}
+		if p.Default != "" {
+			if _, err := checkValue(p, p.Default, services); err != nil {
+				return fmt.Errorf("parameter %s: invalid default: %w", p.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// ResolveParams checks the values given for a run against the runbook's
+// parameters, filling in defaults. Every parameter gets a value, empty for
+// optional ones without a default.
+func ResolveParams(r models.Runbook, values map[string]string, services map[string]models.Service) (map[string]string, error) {
+	known := make(map[string]bool, len(r.Params))
+	resolved := make(map[string]string, len(r.Params))
+	for _, p := range r.Params {
+		known[p.Name] = true
+		value, ok := values[p.Name]
+		if !ok || value == "" {
+			value = p.Default
+		}
+		if value == "" {
+			if p.Required {
+				return nil, fmt.Errorf("parameter %s is required", p.Name)
+			}
+			resolved[p.Name] = ""
+			continue
+		}
+		value, err := checkValue(p, value, services)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %s: %w", p.Name, err)
+		}
+		resolved[p.Name] = value
+	}
+	for name := range values {
+		if !known[name] {
+			return nil, fmt.Errorf("unknown parameter %s", name)
+		}
+	}
+	return resolved, nil
+}
+
+// checkValue checks a value against a parameter's type, returning it in its canonical form
+func checkValue(p models.RunbookParam, value string, services map[string]models.Service) (string, error) {
+	if strings.ContainsRune(value, 0) {
+		return "", fmt.Errorf("value contains a NUL byte")
+	}
+	switch p.Type {
+	case models.ParamInt:
+		n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("%q is not an integer", value)
+		}
+		return strconv.FormatInt(n, 10), nil
+	case models.ParamBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", fmt.Errorf("%q is not true or false", value)
+		}
+		return strconv.FormatBool(b), nil
+	case models.ParamEnum:
+		for _, option := range p.Options {
+			if value == option {
+				return value, nil
+			}
+		}
+		return "", fmt.Errorf("%q is not one of %s", value, strings.Join(p.Options, ", "))
+	case models.ParamService:
+		if _, ok := services[value]; !ok {
+			return "", fmt.Errorf("unknown service %q", value)
+		}
+		return value, nil
+	default:
+		if p.Pattern != "" {
+			re, err := regexp.Compile(p.Pattern)
+			if err != nil {
+				return "", err
+			}
+			if !re.MatchString(value) {
+				return "", fmt.Errorf("%q doesn't match %s", value, p.Pattern)
+			}
+		}
+		return value, nil
+	}
+}
+
+// Render returns the script to run: the variables of the run are exported,
+// quoted so their values are never parsed as shell code, followed by the
+// runbook's script, in its service's directory when it has one
+func Render(r models.Runbook, params map[string]string, services map[string]models.Service) string {
+	vars := variables(r, params, services)
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var script strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&script, "%s=%s\n", name, Quote(vars[name]))
+	}
+	if len(names) > 0 {
+		fmt.Fprintf(&script, "export %s\n", strings.Join(names, " "))
+	}
+	if r.Service != "" {
+		script.WriteString("cd \"$SERVICE_PATH\" || exit 1\n")
+	}
+	script.WriteString(r.Script)
+	return script.String()
+}
+
+// Expand substitutes the variables of the run into the runbook's script,
+// like the shell would expand $name and ${name}, so the command policy
+// decides on the paths and arguments the script will really use. Values are
+// quoted, so each expands to the single word the shell would make of it; in
+// double quotes the quotes are closed around the quoted value. Other
+// expansions, and those in single quotes, are left as they are.
+func Expand(r models.Runbook, params map[string]string, services map[string]models.Service) string {
+	vars := variables(r, params, services)
+	in := []rune(r.Script)
+	var out strings.Builder
+
+	// The innermost of the nested quotes and substitutions, and how many
+	// parentheses are open in a $( substitution
+	type frame struct {
+		kind   rune // 0 for the script, " for double quotes, ( or ` for substitutions
+		parens int
+	}
+	stack := []frame{{}}
+	for i := 0; i < len(in); i++ {
+		top := &stack[len(stack)-1]
+		ch := in[i]
+		switch {
+		case ch == '\\' && i+1 < len(in):
+			out.WriteRune(ch)
+			i++
+			out.WriteRune(in[i])
+			continue
+		case ch == '\'' && top.kind != '"':
+			end := i + 1
+			for end < len(in) && in[end] != '\'' {
+				end++
+			}
+			end = min(end, len(in)-1)
+			out.WriteString(string(in[i : end+1]))
+			i = end
+			continue
+		case ch == '"' && top.kind == '"':
+			stack = stack[:len(stack)-1]
+		case ch == '"':
+			stack = append(stack, frame{kind: '"'})
+		case ch == '`' && top.kind == '`':
+			stack = stack[:len(stack)-1]
+		case ch == '`':
+			stack = append(stack, frame{kind: '`'})
+		case ch == '$' && i+1 < len(in) && in[i+1] == '(':
+			out.WriteString("$(")
+			i++
+			stack = append(stack, frame{kind: '('})
+			continue
+		case ch == '(' && top.kind == '(':
+			top.parens++
+		case ch == ')' && top.kind == '(':
+			if top.parens == 0 {
+				stack = stack[:len(stack)-1]
+			} else {
+				top.parens--
+			}
+		case ch == '$':
+			if name, n := variableAt(in[i+1:]); name != "" {
+				if value, ok := vars[name]; ok {
+					if top.kind == '"' {
+						out.WriteString(`"` + Quote(value) + `"`)
+					} else {
+						out.WriteString(Quote(value))
+					}
+					i += n
+					continue
+				}
+			}
+		}
+		out.WriteRune(ch)
+	}
+	return out.String()
+}
+
+// variableAt returns the name of the variable expanded by $ followed by s,
+// as name or {name}, and how many runes of s it takes
+func variableAt(s []rune) (string, int) {
+	braced := len(s) > 0 && s[0] == '{'
+	start := 0
+	if braced {
+		start = 1
+	}
+	end := start
+	for end < len(s) && (s[end] == '_' || s[end] >= 'a' && s[end] <= 'z' || s[end] >= 'A' && s[end] <= 'Z' || end > start && s[end] >= '0' && s[end] <= '9') {
+		end++
+	}
+	if end == start {
+		return "", 0
+	}
+	if !braced {
+		return string(s[start:end]), end
+	}
+	if end < len(s) && s[end] == '}' {
+		return string(s[start:end]), end + 1
+	}
+	return "", 0
+}
+
+// variables are the shell variables of a run: the parameters, and for a
+// service parameter the service's directory and deploy script as
+// <name>_path and <name>_deploy. The runbook's own service is SERVICE_NAME
+// and SERVICE_PATH.
+func variables(r models.Runbook, params map[string]string, services map[string]models.Service) map[string]string {
+	vars := make(map[string]string)
+	if service, ok := services[r.Service]; ok && r.Service != "" {
+		vars["SERVICE_NAME"] = service.Name
+		vars["SERVICE_PATH"] = service.Path
+	}
+	for _, p := range r.Params {
+		vars[p.Name] = params[p.Name]
+		if p.Type == models.ParamService {
+			// Empty for an optional service left out
+			service := services[params[p.Name]]
+			vars[p.Name+"_path"] = service.Path
+			vars[p.Name+"_deploy"] = service.DeployScript
+		}
+	}
+	return vars
+}
+
+// Remote wraps a rendered script to run it on host over ssh. The script is
+// passed on the remote shell's standard input in a quoted heredoc, so
+// nothing in it is expanded locally.
+func Remote(host, script string) (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	delimiter := "RUNBOOK_" + hex.EncodeToString(b)
+	return fmt.Sprintf("ssh -o BatchMode=yes -o ConnectTimeout=10 -- %s bash -s <<'%s'\n%s\n%s\n",
+		Quote(host), delimiter, script, delimiter), nil
+}
+
+// Quote quotes a value for the shell
+func Quote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
@@ -0,0 +1,124 @@
+package runbook
+
+import (
+	"control/go_server/internal/models"
+	"control/go_server/internal/policy"
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+// values that would break out of careless quoting
+var awkwardValues = []string{
+	"",
+	"plain",
+	"two words",
+	"it's",
+	"'",
+	"''",
+	`"double"`,
+	"$(touch /tmp/runbook-pwned)",
+	"`touch /tmp/runbook-pwned`",
+	"${HOME}",
+	"a; rm -rf /",
+	"line one\nline two",
+	"trailing newline\n",
+	`back\slash\`,
+	"tab\there",
+	"*",
+}
+
+func TestQuote(t *testing.T) {
+	for _, value := range awkwardValues {
+		out, err := exec.Command("bash", "-c", "printf %s "+Quote(value)).Output()
+		if err != nil {
+			t.Errorf("Quote(%q): %v", value, err)
+			continue
+		}
+		if string(out) != value {
+			t.Errorf("bash read Quote(%q) as %q", value, out)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	r := models.Runbook{
+		Params: []models.RunbookParam{{Name: "value", Type: models.ParamString}},
+		Script: `printf %s "$value"`,
+	}
+	for _, value := range awkwardValues {
+		out, err := exec.Command("bash", "-c", Render(r, map[string]string{"value": value}, nil)).Output()
+		if err != nil {
+			t.Errorf("Render with %q: %v", value, err)
+			continue
+		}
+		if string(out) != value {
+			t.Errorf("rendered script printed %q, want %q", out, value)
+		}
+	}
+}
+
+func TestExpand(t *testing.T) {
+	services := map[string]models.Service{
+		"api": {Name: "api", Path: "/srv/my api", DeployScript: "./deploy.sh"},
+	}
+	r := models.Runbook{Params: []models.RunbookParam{
+		{Name: "x", Type: models.ParamString},
+		{Name: "first", Type: models.ParamService},
+		{Name: "second", Type: models.ParamService},
+	}}
+	params := map[string]string{"x": "a b; rm -rf /'q", "first": "api", "second": ""}
+	const x = "a b; rm -rf /'q"
+
+	tests := []struct {
+		script string
+		want   [][]string // the args of each parsed command
+	}{
+		{"rm -rf $x", [][]string{{"rm", "-rf", x}}},
+		{"rm -rf ${x}", [][]string{{"rm", "-rf", x}}},
+		{`echo "before $x after"`, [][]string{{"echo", "before " + x + " after"}}},
+		{`echo "${x}"`, [][]string{{"echo", x}}},
+		// Not expanded: single quotes, escapes, other names and other forms
+		{`echo '$x' \$x $xy ${x:-d} $1`, [][]string{{"echo", "$x", "$x", "$xy", "${x:-d}", "$1"}}},
+		{`echo "$(echo "$x")"`, [][]string{{"echo", `$(echo ""'a b; rm -rf /'\''q'"")`}, {"echo", x}}},
+		{`echo $(cat $x)`, [][]string{{"echo", `$(cat 'a b; rm -rf /'\''q')`}, {"cat", x}}},
+		{`cd "$first_path" && "$first_deploy"`, [][]string{{"cd", "/srv/my api"}, {"./deploy.sh"}}},
+		// An optional service left out expands to empty words
+		{`"$second_deploy"`, [][]string{{""}}},
+	}
+	for _, tt := range tests {
+		r.Script = tt.script
+		expanded := Expand(r, params, services)
+		commands, err := policy.Parse(expanded)
+		if err != nil {
+			t.Errorf("Expand(%q) = %q: %v", tt.script, expanded, err)
+			continue
+		}
+		var got [][]string
+		for _, c := range commands {
+			got = append(got, c.Args)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Expand(%q) = %q, parsed as %q, want %q", tt.script, expanded, got, tt.want)
+		}
+	}
+}
+
+func TestExpandAwkwardValues(t *testing.T) {
+	r := models.Runbook{
+		Params: []models.RunbookParam{{Name: "v", Type: models.ParamString}},
+		Script: `cat $v "$v" "<$v>" ${v}`,
+	}
+	for _, value := range awkwardValues {
+		expanded := Expand(r, map[string]string{"v": value}, nil)
+		commands, err := policy.Parse(expanded)
+		if err != nil {
+			t.Errorf("Expand with %q = %q: %v", value, expanded, err)
+			continue
+		}
+		want := []string{"cat", value, value, "<" + value + ">", value}
+		if len(commands) != 1 || !reflect.DeepEqual(commands[0].Args, want) {
+			t.Errorf("Expand with %q = %q, parsed as %+v, want %q", value, expanded, commands, want)
+		}
+	}
+}
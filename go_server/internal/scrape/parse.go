@@ -0,0 +1,211 @@
+package scrape
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Sample is one scraped value
+type Sample struct {
+	Name   string
+	Labels map[string]string
+	Value  float64
+}
+
+// ParsePrometheus parses the Prometheus text exposition format. Histogram and
+// summary series come out as their _bucket, _sum and _count samples.
+func ParsePrometheus(r io.Reader) ([]Sample, error) {
+	var samples []Sample
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		sample, err := parsePrometheusLine(line)
+		if err != nil {
+			return samples, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+		samples = append(samples, sample)
+	}
+	return samples, scanner.Err()
+}
+
+// parsePrometheusLine parses `name{label="value",...} value [timestamp]`
+func parsePrometheusLine(line string) (Sample, error) {
+	sample := Sample{}
+	i := strings.IndexAny(line, "{ \t")
+	if i <= 0 {
+		return sample, fmt.Errorf("missing value")
+	}
+	sample.Name = line[:i]
+	rest := line[i:]
+
+	if rest[0] == '{' {
+		labels, n, err := parseLabels(rest)
+		if err != nil {
+			return sample, err
+		}
+		sample.Labels = labels
+		rest = rest[n:]
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return sample, fmt.Errorf("missing value")
+	}
+	value, err := parseValue(fields[0])
+	if err != nil {
+		return sample, err
+	}
+	sample.Value = value
+	return sample, nil
+}
+
+// parseLabels parses a {...} label set and returns the bytes consumed
+func parseLabels(s string) (map[string]string, int, error) {
+	labels := make(map[string]string)
+	i := 1
+	for {
+		for i < len(s) && (s[i] == ' ' || s[i] == ',') {
+			i++
+		}
+		if i >= len(s) {
+			return nil, 0, fmt.Errorf("unterminated label set")
+		}
+		if s[i] == '}' {
+			return labels, i + 1, nil
+		}
+
+		eq := strings.IndexByte(s[i:], '=')
+		if eq < 0 {
+			return nil, 0, fmt.Errorf("label without value")
+		}
+		name := strings.TrimSpace(s[i : i+eq])
+		i += eq + 1
+		if i >= len(s) || s[i] != '"' {
+			return nil, 0, fmt.Errorf("label %s is not quoted", name)
+		}
+		i++
+
+		var value strings.Builder
+		for {
+			if i >= len(s) {
+				return nil, 0, fmt.Errorf("unterminated label value")
+			}
+			c := s[i]
+			if c == '"' {
+				i++
+				break
+			}
+			if c == '\\' && i+1 < len(s) {
+				i++
+				switch s[i] {
+				case 'n':
+					value.WriteByte('\n')
+				default:
+					value.WriteByte(s[i])
+				}
+				i++
+				continue
+			}
+			value.WriteByte(c)
+			i++
+		}
+		labels[name] = value.String()
+	}
+}
+
+func parseValue(s string) (float64, error) {
+	switch s {
+	case "+Inf", "Inf":
+		return math.Inf(1), nil
+	case "-Inf":
+		return math.Inf(-1), nil
+	case "NaN":
+		return math.NaN(), nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// ParseExpvar flattens expvar JSON into samples. Nested object keys are
+// joined with underscores, e.g. memstats.HeapAlloc becomes
+// memstats_heap_alloc. Booleans become 0 or 1; strings and arrays are skipped.
+func ParseExpvar(r io.Reader) ([]Sample, error) {
+	var vars map[string]any
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	if err := decoder.Decode(&vars); err != nil {
+		return nil, fmt.Errorf("invalid expvar JSON: %v", err)
+	}
+
+	var samples []Sample
+	var walk func(prefix string, v any)
+	walk = func(prefix string, v any) {
+		switch t := v.(type) {
+		case json.Number:
+			if f, err := t.Float64(); err == nil {
+				samples = append(samples, Sample{Name: prefix, Value: f})
+			}
+		case bool:
+			value := 0.0
+			if t {
+				value = 1
+			}
+			samples = append(samples, Sample{Name: prefix, Value: value})
+		case map[string]any:
+			keys := make([]string, 0, len(t))
+			for k := range t {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				walk(prefix+"_"+SanitizeName(k), t[k])
+			}
+		}
+	}
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		walk(SanitizeName(k), vars[k])
+	}
+	return samples, nil
+}
+
+// SanitizeName turns an arbitrary key into a metric name: CamelCase becomes
+// snake_case and characters outside [a-zA-Z0-9_:] become underscores
+func SanitizeName(s string) string {
+	var b strings.Builder
+	runes := []rune(s)
+	for i, r := range runes {
+		switch {
+		case r >= 'A' && r <= 'Z':
+			// start a new word at a lower-to-upper boundary or at the end of an acronym
+			if i > 0 && (isLower(runes[i-1]) || (i+1 < len(runes) && isLower(runes[i+1]) && isUpper(runes[i-1]))) {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r + 'a' - 'A')
+		case isLower(r) || r == '_' || r == ':' || (r >= '0' && r <= '9' && i > 0):
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+func isLower(r rune) bool { return r >= 'a' && r <= 'z' }
+
+func isUpper(r rune) bool { return r >= 'A' && r <= 'Z' }
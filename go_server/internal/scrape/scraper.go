@@ -0,0 +1,281 @@
+// Package scrape collects application metrics from the Prometheus and expvar
+// endpoints of managed services into the metrics store.
+package scrape
+
+import (
+	"control/go_server/internal/models"
+	"control/go_server/internal/tsdb"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultInterval = 30 * time.Second
+	scrapeTimeout   = 10 * time.Second
+	maxBodyBytes    = 16 << 20
+
+	// MaxSeriesPerTarget caps the series stored per scrape so a target
+	// exposing high-cardinality labels can't flood the store
+	MaxSeriesPerTarget = 2000
+)
+
+// Appender stores a point; *tsdb.DB implements it
+type Appender interface {
+	Append(name string, labels tsdb.Labels, t time.Time, value float64) error
+}
+
+// Target is a scrape target of a service
+type Target struct {
+	Service string
+	models.ScrapeTarget
+}
+
+// Interval returns how often the target is scraped
+func (t Target) Interval() time.Duration {
+	if t.IntervalSeconds <= 0 {
+		return defaultInterval
+	}
+	return time.Duration(t.IntervalSeconds) * time.Second
+}
+
+// TargetStatus is the result of the last scrape of a target
+type TargetStatus struct {
+	Service    string              `json:"service"`
+	Name       string              `json:"name"`
+	URL        string              `json:"url"`
+	Format     models.ScrapeFormat `json:"format"`
+	Interval   string              `json:"interval"`
+	Up         bool                `json:"up"`
+	LastScrape *time.Time          `json:"lastScrape,omitempty"`
+	Duration   float64             `json:"durationSeconds"`
+	Samples    int                 `json:"samples"` // samples in the payload
+	Stored     int                 `json:"stored"`  // samples kept after Include and the series cap
+	Error      string              `json:"error,omitempty"`
+}
+
+// Scraper scrapes every target on its own interval
+type Scraper struct {
+	store  Appender
+	client *http.Client
+
+	mutex   sync.Mutex
+	targets []Target
+	status  map[string]*TargetStatus
+	next    map[string]time.Time
+}
+
+// NewScraper creates a scraper that stores samples in store
+func NewScraper(store Appender) *Scraper {
+	return &Scraper{
+		store:  store,
+		client: &http.Client{Timeout: scrapeTimeout},
+		status: make(map[string]*TargetStatus),
+		next:   make(map[string]time.Time),
+	}
+}
+
+func targetKey(t Target) string {
+	return t.Service + "/" + t.Name
+}
+
+// TargetsFor lists the scrape targets of services
+func TargetsFor(services []models.Service) []Target {
+	var targets []Target
+	for _, service := range services {
+		for _, st := range service.ScrapeTargets {
+			targets = append(targets, Target{Service: service.Name, ScrapeTarget: st})
+		}
+	}
+	return targets
+}
+
+// SetTargets replaces the targets; targets that keep their key keep their status
+func (s *Scraper) SetTargets(targets []Target) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	status := make(map[string]*TargetStatus, len(targets))
+	for _, t := range targets {
+		key := targetKey(t)
+		if old, ok := s.status[key]; ok {
+			status[key] = old
+		} else {
+			status[key] = &TargetStatus{Service: t.Service, Name: t.Name}
+		}
+		st := status[key]
+		st.URL, st.Format, st.Interval = t.URL, t.Format, t.Interval().String()
+	}
+	s.targets = targets
+	s.status = status
+}
+
+// Run scrapes due targets until stop is closed
+func (s *Scraper) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			for _, t := range s.due(now) {
+				go s.Scrape(t)
+			}
+		}
+	}
+}
+
+// due returns the targets whose interval has elapsed and schedules their next scrape
+func (s *Scraper) due(now time.Time) []Target {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var due []Target
+	for _, t := range s.targets {
+		key := targetKey(t)
+		if next, ok := s.next[key]; ok && now.Before(next) {
+			continue
+		}
+		s.next[key] = now.Add(t.Interval())
+		due = append(due, t)
+	}
+	return due
+}
+
+// Scrape fetches one target, stores the selected samples and records the status.
+// Every scrape also stores scrape_up and scrape_duration_seconds for the target.
+func (s *Scraper) Scrape(t Target) TargetStatus {
+	start := time.Now()
+	samples, err := s.fetch(t)
+	duration := time.Since(start)
+
+	stored := 0
+	if err == nil {
+		stored, err = s.storeSamples(t, samples, start)
+	}
+
+	targetLabels := tsdb.Labels{"service": t.Service, "target": t.Name}
+	up := 0.0
+	if err == nil {
+		up = 1
+	}
+	s.store.Append("scrape_up", targetLabels, start, up)
+	s.store.Append("scrape_duration_seconds", targetLabels, start, duration.Seconds())
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	st, ok := s.status[targetKey(t)]
+	if !ok {
+		st = &TargetStatus{Service: t.Service, Name: t.Name, URL: t.URL, Format: t.Format, Interval: t.Interval().String()}
+	}
+	st.Up = err == nil
+	st.LastScrape = &start
+	st.Duration = duration.Seconds()
+	st.Samples = len(samples)
+	st.Stored = stored
+	st.Error = ""
+	if err != nil {
+		st.Error = err.Error()
+	}
+	return *st
+}
+
+func (s *Scraper) fetch(t Target) ([]Sample, error) {
+	resp, err := s.client.Get(t.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	body := io.LimitReader(resp.Body, maxBodyBytes)
+	switch t.Format {
+	case models.ScrapeExpvar:
+		return ParseExpvar(body)
+	case models.ScrapePrometheus, "":
+		return ParsePrometheus(body)
+	}
+	return nil, fmt.Errorf("unknown scrape format %q", t.Format)
+}
+
+// storeSamples stores the samples matching the target's Include globs
+func (s *Scraper) storeSamples(t Target, samples []Sample, now time.Time) (int, error) {
+	stored := 0
+	for _, sample := range samples {
+		if !included(t.Include, sample.Name) || math.IsNaN(sample.Value) {
+			continue
+		}
+		if stored >= MaxSeriesPerTarget {
+			return stored, fmt.Errorf("more than %d series, narrow the target's include list", MaxSeriesPerTarget)
+		}
+
+		labels := make(tsdb.Labels, len(sample.Labels)+len(t.Labels)+1)
+		for k, v := range sample.Labels {
+			if k == "service" {
+				// keep the service's own label without clashing with ours
+				k = "exported_service"
+			}
+			labels[k] = v
+		}
+		for k, v := range t.Labels {
+			labels[k] = v
+		}
+		labels["service"] = t.Service
+		if err := s.store.Append(sample.Name, labels, now, sample.Value); err != nil {
+			return stored, err
+		}
+		stored++
+	}
+	return stored, nil
+}
+
+func included(patterns []string, name string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Status returns the status of every target, sorted by service and name
+func (s *Scraper) Status() []TargetStatus {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	statuses := make([]TargetStatus, 0, len(s.status))
+	for _, st := range s.status {
+		statuses = append(statuses, *st)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Service != statuses[j].Service {
+			return statuses[i].Service < statuses[j].Service
+		}
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// Target returns a configured target by service and name
+func (s *Scraper) Target(service, name string) (Target, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, t := range s.targets {
+		if t.Service == service && t.Name == name {
+			return t, true
+		}
+	}
+	return Target{}, false
+}
@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"control/go_server/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type AlertStore struct {
+	db *gorm.DB
+}
+
+func NewAlertStore(db *gorm.DB) *AlertStore {
+	return &AlertStore{db: db}
+}
+
+// AutoMigrate creates the alerting tables
+func (s *AlertStore) AutoMigrate() error {
+	return s.db.AutoMigrate(
+		&models.AlertRule{},
+		&models.AlertEvent{},
+		&models.AlertSilence{},
+	)
+}
+
+// GetRules gets all alert rules
+func (s *AlertStore) GetRules() ([]models.AlertRule, error) {
+	var rules []models.AlertRule
+	err := s.db.Order("name").Find(&rules).Error
+	return rules, err
+}
+
+// GetRule gets an alert rule by ID
+func (s *AlertStore) GetRule(id int64) (*models.AlertRule, error) {
+	var rule models.AlertRule
+	if err := s.db.First(&rule, id).Error; err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// CreateRule creates a new alert rule
+func (s *AlertStore) CreateRule(rule *models.AlertRule) error {
+	rule.CreatedAt = time.Now()
+	rule.UpdatedAt = time.Now()
+	return s.db.Create(rule).Error
+}
+
+// UpdateRule saves every field of an existing alert rule
+func (s *AlertStore) UpdateRule(rule *models.AlertRule) error {
+	rule.UpdatedAt = time.Now()
+	return s.db.Omit("created_at").Save(rule).Error
+}
+
+// DeleteRule deletes an alert rule; its history is kept
+func (s *AlertStore) DeleteRule(id int64) error {
+	return s.db.Delete(&models.AlertRule{}, id).Error
+}
+
+// CreateEvent records a firing or resolved alert
+func (s *AlertStore) CreateEvent(event *models.AlertEvent) error {
+	event.CreatedAt = time.Now()
+	return s.db.Create(event).Error
+}
+
+// GetEvents gets alert history, newest first, optionally of one rule or state
+func (s *AlertStore) GetEvents(ruleID int64, state models.AlertState, since time.Time, limit int) ([]models.AlertEvent, error) {
+	var events []models.AlertEvent
+	query := s.db.Model(&models.AlertEvent{})
+	if ruleID > 0 {
+		query = query.Where("rule_id = ?", ruleID)
+	}
+	if state != "" {
+		query = query.Where("state = ?", state)
+	}
+	if !since.IsZero() {
+		query = query.Where("created_at >= ?", since)
+	}
+	err := query.Order("created_at DESC").Limit(limit).Find(&events).Error
+	return events, err
+}
+
+// CleanupOldEvents removes alert history older than days
+func (s *AlertStore) CleanupOldEvents(days int) error {
+	return s.db.Where("created_at < ?", time.Now().AddDate(0, 0, -days)).
+		Delete(&models.AlertEvent{}).Error
+}
+
+// GetActiveSilences gets the silences that haven't ended yet
+func (s *AlertStore) GetActiveSilences(now time.Time) ([]models.AlertSilence, error) {
+	var silences []models.AlertSilence
+	err := s.db.Where("ends_at > ?", now).Order("ends_at").Find(&silences).Error
+	return silences, err
+}
+
+// CreateSilence creates a new silence
+func (s *AlertStore) CreateSilence(silence *models.AlertSilence) error {
+	silence.CreatedAt = time.Now()
+	return s.db.Create(silence).Error
+}
+
+// ExpireSilence ends a silence now
+func (s *AlertStore) ExpireSilence(id int64) error {
+	return s.db.Model(&models.AlertSilence{}).Where("id = ?", id).Update("ends_at", time.Now()).Error
+}
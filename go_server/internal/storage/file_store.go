@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"control/go_server/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type FileStore struct {
+	db *gorm.DB
+}
+
+func NewFileStore(db *gorm.DB) *FileStore {
+	return &FileStore{db: db}
+}
+
+// AutoMigrate creates the file changes table
+func (s *FileStore) AutoMigrate() error {
+	return s.db.AutoMigrate(&models.FileChange{})
+}
+
+// CreateChange records a change of a file
+func (s *FileStore) CreateChange(change *models.FileChange) error {
+	change.CreatedAt = time.Now()
+	return s.db.Create(change).Error
+}
+
+// GetChange gets a change by ID
+func (s *FileStore) GetChange(id int64) (*models.FileChange, error) {
+	var change models.FileChange
+	if err := s.db.First(&change, id).Error; err != nil {
+		return nil, err
+	}
+	return &change, nil
+}
+
+// GetChanges gets the changes of a service's files, newest first, optionally
+// of one file. The diffs are left out; GetChange has them.
+func (s *FileStore) GetChanges(service, path string, limit int) ([]models.FileChange, error) {
+	var changes []models.FileChange
+	query := s.db.Model(&models.FileChange{}).Omit("diff").Where("service = ?", service)
+	if path != "" {
+		query = query.Where("path = ?", path)
+	}
+	err := query.Order("created_at DESC, id DESC").Limit(limit).Find(&changes).Error
+	return changes, err
+}
+
+// GetChangesBefore gets the changes made before t, to expire them
+func (s *FileStore) GetChangesBefore(t time.Time) ([]models.FileChange, error) {
+	var changes []models.FileChange
+	err := s.db.Omit("diff").Where("created_at < ?", t).Find(&changes).Error
+	return changes, err
+}
+
+// DeleteChange deletes a change's row; the caller removes its backup
+func (s *FileStore) DeleteChange(id int64) error {
+	return s.db.Delete(&models.FileChange{}, id).Error
+}
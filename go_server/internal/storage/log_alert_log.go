@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"control/go_server/internal/models"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LogAlertStorage manages log alert rules and alert events in files
+type LogAlertStorage struct {
+	logDir string
+	mutex  sync.RWMutex
+}
+
+// NewLogAlertStorage creates a new log alert storage
+func NewLogAlertStorage(logDir string) *LogAlertStorage {
+	// Ensure log directory exists
+	os.MkdirAll(logDir, 0755)
+
+	return &LogAlertStorage{
+		logDir: logDir,
+	}
+}
+
+func (las *LogAlertStorage) rulesPath() string {
+	return filepath.Join(las.logDir, "rules.json")
+}
+
+// LoadRules reads the saved rules. ok is false when no rules were saved yet.
+func (las *LogAlertStorage) LoadRules() (rules []models.LogAlertRule, ok bool, err error) {
+	las.mutex.RLock()
+	defer las.mutex.RUnlock()
+
+	data, err := os.ReadFile(las.rulesPath())
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, false, fmt.Errorf("failed to parse rules file: %v", err)
+	}
+	return rules, true, nil
+}
+
+// SaveRules replaces the saved rules
+func (las *LogAlertStorage) SaveRules(rules []models.LogAlertRule) error {
+	las.mutex.Lock()
+	defer las.mutex.Unlock()
+
+	data, err := json.MarshalIndent(rules, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal rules: %v", err)
+	}
+	tmp := las.rulesPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write rules file: %v", err)
+	}
+	return os.Rename(tmp, las.rulesPath())
+}
+
+// LogEvent appends an alert event to today's event file
+func (las *LogAlertStorage) LogEvent(event models.LogAlertEvent) error {
+	las.mutex.Lock()
+	defer las.mutex.Unlock()
+
+	// Generate filename based on the event date
+	filename := fmt.Sprintf("log_alert_%s.json", event.EventTime.Format("2006-01-02"))
+	filepath := filepath.Join(las.logDir, filename)
+
+	// Read existing events for the day
+	var events []models.LogAlertEvent
+	if data, err := os.ReadFile(filepath); err == nil {
+		json.Unmarshal(data, &events)
+	}
+
+	events = append(events, event)
+
+	data, err := json.MarshalIndent(events, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal event data: %v", err)
+	}
+
+	if err := os.WriteFile(filepath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write event file: %v", err)
+	}
+
+	return nil
+}
+
+// GetEvents retrieves alert events within a date range, newest first
+func (las *LogAlertStorage) GetEvents(startDate, endDate time.Time) ([]models.LogAlertEvent, error) {
+	las.mutex.RLock()
+	defer las.mutex.RUnlock()
+
+	var allEvents []models.LogAlertEvent
+
+	files, err := os.ReadDir(las.logDir)
+	if err != nil {
+		return allEvents, fmt.Errorf("failed to read log directory: %v", err)
+	}
+
+	for _, file := range files {
+		filename := file.Name()
+		if file.IsDir() || filepath.Ext(filename) != ".json" || len(filename) < 25 || filename[:10] != "log_alert_" {
+			continue
+		}
+		fileDate, err := time.ParseInLocation("2006-01-02", filename[10:20], time.Local)
+		if err != nil || fileDate.AddDate(0, 0, 1).Before(startDate) || fileDate.After(endDate) {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(las.logDir, filename))
+		if err != nil {
+			continue
+		}
+		var events []models.LogAlertEvent
+		if err := json.Unmarshal(data, &events); err != nil {
+			continue
+		}
+		for _, event := range events {
+			if !event.EventTime.Before(startDate) && !event.EventTime.After(endDate) {
+				allEvents = append(allEvents, event)
+			}
+		}
+	}
+
+	sort.Slice(allEvents, func(i, j int) bool {
+		return allEvents[i].EventTime.After(allEvents[j].EventTime)
+	})
+
+	return allEvents, nil
+}
+
+// CleanupOldLogs removes event files older than specified days
+func (las *LogAlertStorage) CleanupOldLogs(retentionDays int) error {
+	las.mutex.Lock()
+	defer las.mutex.Unlock()
+
+	cutoffDate := time.Now().AddDate(0, 0, -retentionDays)
+
+	files, err := os.ReadDir(las.logDir)
+	if err != nil {
+		return fmt.Errorf("failed to read log directory: %v", err)
+	}
+
+	for _, file := range files {
+		filename := file.Name()
+		if file.IsDir() || filepath.Ext(filename) != ".json" || len(filename) < 25 || filename[:10] != "log_alert_" {
+			continue
+		}
+		if fileDate, err := time.Parse("2006-01-02", filename[10:20]); err == nil && fileDate.Before(cutoffDate) {
+			if err := os.Remove(filepath.Join(las.logDir, filename)); err == nil {
+				fmt.Printf("Removed old log alert file: %s\n", filename)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"control/go_server/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type NotificationStore struct {
+	db *gorm.DB
+}
+
+func NewNotificationStore(db *gorm.DB) *NotificationStore {
+	return &NotificationStore{db: db}
+}
+
+// AutoMigrate creates the notification tables
+func (s *NotificationStore) AutoMigrate() error {
+	return s.db.AutoMigrate(
+		&models.NotificationChannel{},
+		&models.NotificationDelivery{},
+	)
+}
+
+// GetChannels gets all notification channels
+func (s *NotificationStore) GetChannels() ([]models.NotificationChannel, error) {
+	var channels []models.NotificationChannel
+	err := s.db.Order("name").Find(&channels).Error
+	return channels, err
+}
+
+// GetChannel gets a notification channel by ID
+func (s *NotificationStore) GetChannel(id int64) (*models.NotificationChannel, error) {
+	var channel models.NotificationChannel
+	if err := s.db.First(&channel, id).Error; err != nil {
+		return nil, err
+	}
+	return &channel, nil
+}
+
+// CreateChannel creates a new notification channel
+func (s *NotificationStore) CreateChannel(channel *models.NotificationChannel) error {
+	channel.CreatedAt = time.Now()
+	channel.UpdatedAt = time.Now()
+	return s.db.Create(channel).Error
+}
+
+// UpdateChannel saves every field of an existing notification channel
+func (s *NotificationStore) UpdateChannel(channel *models.NotificationChannel) error {
+	channel.UpdatedAt = time.Now()
+	return s.db.Omit("created_at").Save(channel).Error
+}
+
+// DeleteChannel deletes a notification channel; its deliveries are kept
+func (s *NotificationStore) DeleteChannel(id int64) error {
+	return s.db.Delete(&models.NotificationChannel{}, id).Error
+}
+
+// SaveDelivery creates a delivery record or updates it once it has an ID
+func (s *NotificationStore) SaveDelivery(delivery *models.NotificationDelivery) error {
+	if delivery.ID == 0 {
+		return s.db.Create(delivery).Error
+	}
+	return s.db.Save(delivery).Error
+}
+
+// GetDeliveries gets delivery records, newest first, optionally of one channel or status
+func (s *NotificationStore) GetDeliveries(channelID int64, status models.DeliveryStatus, limit int) ([]models.NotificationDelivery, error) {
+	var deliveries []models.NotificationDelivery
+	query := s.db.Model(&models.NotificationDelivery{})
+	if channelID > 0 {
+		query = query.Where("channel_id = ?", channelID)
+	}
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	err := query.Order("created_at DESC").Limit(limit).Find(&deliveries).Error
+	return deliveries, err
+}
+
+// CleanupOldDeliveries removes delivery records older than days
+func (s *NotificationStore) CleanupOldDeliveries(days int) error {
+	return s.db.Where("created_at < ?", time.Now().AddDate(0, 0, -days)).
+		Delete(&models.NotificationDelivery{}).Error
+}
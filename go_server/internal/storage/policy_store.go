@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"control/go_server/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type PolicyStore struct {
+	db *gorm.DB
+}
+
+func NewPolicyStore(db *gorm.DB) *PolicyStore {
+	return &PolicyStore{db: db}
+}
+
+// AutoMigrate creates the command policy tables
+func (s *PolicyStore) AutoMigrate() error {
+	return s.db.AutoMigrate(
+		&models.CommandRule{},
+		&models.CommandDecision{},
+		&models.CommandApproval{},
+	)
+}
+
+// GetRules gets all command rules in the order they are tried
+func (s *PolicyStore) GetRules() ([]models.CommandRule, error) {
+	var rules []models.CommandRule
+	err := s.db.Order("priority, id").Find(&rules).Error
+	return rules, err
+}
+
+// GetRule gets a command rule by ID
+func (s *PolicyStore) GetRule(id int64) (*models.CommandRule, error) {
+	var rule models.CommandRule
+	if err := s.db.First(&rule, id).Error; err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// CountRules counts the command rules, to seed the defaults into an empty table
+func (s *PolicyStore) CountRules() (int64, error) {
+	var count int64
+	err := s.db.Model(&models.CommandRule{}).Count(&count).Error
+	return count, err
+}
+
+// CreateRule creates a new command rule
+func (s *PolicyStore) CreateRule(rule *models.CommandRule) error {
+	rule.CreatedAt = time.Now()
+	rule.UpdatedAt = time.Now()
+	return s.db.Create(rule).Error
+}
+
+// UpdateRule saves every field of an existing command rule
+func (s *PolicyStore) UpdateRule(rule *models.CommandRule) error {
+	rule.UpdatedAt = time.Now()
+	return s.db.Omit("created_at").Save(rule).Error
+}
+
+// DeleteRule deletes a command rule; the decisions it made are kept
+func (s *PolicyStore) DeleteRule(id int64) error {
+	return s.db.Delete(&models.CommandRule{}, id).Error
+}
+
+// CreateDecision records a decision of the policy
+func (s *PolicyStore) CreateDecision(decision *models.CommandDecision) error {
+	decision.CreatedAt = time.Now()
+	return s.db.Create(decision).Error
+}
+
+// GetDecisions gets recorded decisions, newest first, optionally of one user or action
+func (s *PolicyStore) GetDecisions(user string, action models.PolicyAction, since time.Time, limit int) ([]models.CommandDecision, error) {
+	var decisions []models.CommandDecision
+	query := s.db.Model(&models.CommandDecision{})
+	if user != "" {
+		query = query.Where("user = ?", user)
+	}
+	if action != "" {
+		query = query.Where("action = ?", action)
+	}
+	if !since.IsZero() {
+		query = query.Where("created_at >= ?", since)
+	}
+	err := query.Order("created_at DESC").Limit(limit).Find(&decisions).Error
+	return decisions, err
+}
+
+// CleanupOldDecisions removes decisions and settled approvals older than days
+func (s *PolicyStore) CleanupOldDecisions(days int) error {
+	cutoff := time.Now().AddDate(0, 0, -days)
+	if err := s.db.Where("created_at < ?", cutoff).Delete(&models.CommandDecision{}).Error; err != nil {
+		return err
+	}
+	return s.db.Where("created_at < ? AND status NOT IN ?", cutoff, []models.ApprovalStatus{models.ApprovalPending, models.ApprovalApproved}).
+		Delete(&models.CommandApproval{}).Error
+}
+
+// CreateApproval holds a command for approval
+func (s *PolicyStore) CreateApproval(approval *models.CommandApproval) error {
+	approval.CreatedAt = time.Now()
+	return s.db.Create(approval).Error
+}
+
+// GetApproval gets a held command by ID
+func (s *PolicyStore) GetApproval(id int64) (*models.CommandApproval, error) {
+	var approval models.CommandApproval
+	if err := s.db.First(&approval, id).Error; err != nil {
+		return nil, err
+	}
+	return &approval, nil
+}
+
+// GetApprovals gets held commands, newest first, optionally in one status
+func (s *PolicyStore) GetApprovals(status models.ApprovalStatus, limit int) ([]models.CommandApproval, error) {
+	var approvals []models.CommandApproval
+	query := s.db.Model(&models.CommandApproval{})
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	err := query.Order("created_at DESC").Limit(limit).Find(&approvals).Error
+	return approvals, err
+}
+
+// DecideApproval approves or rejects a pending command, setting the time an
+// approved command must run by. It reports false when the command is no
+// longer pending, as when another operator decided first.
+func (s *PolicyStore) DecideApproval(approval *models.CommandApproval) (bool, error) {
+	now := time.Now()
+	result := s.db.Model(&models.CommandApproval{}).
+		Where("id = ? AND status = ?", approval.ID, models.ApprovalPending).
+		Updates(map[string]interface{}{
+			"status":     approval.Status,
+			"approver":   approval.Approver,
+			"comment":    approval.Comment,
+			"decided_at": now,
+			"expires_at": approval.ExpiresAt,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	approval.DecidedAt = &now
+	return result.RowsAffected == 1, nil
+}
+
+// ExpireApprovals marks the commands that weren't approved or run in time as expired
+func (s *PolicyStore) ExpireApprovals(now time.Time) error {
+	return s.db.Model(&models.CommandApproval{}).
+		Where("status IN ? AND expires_at < ?", []models.ApprovalStatus{models.ApprovalPending, models.ApprovalApproved}, now).
+		Update("status", models.ApprovalExpired).Error
+}
+
+// ConsumeApproval marks an approved command as executed. It reports false
+// when the approval was already used, so an approval runs its command once.
+func (s *PolicyStore) ConsumeApproval(id int64) (bool, error) {
+	result := s.db.Model(&models.CommandApproval{}).
+		Where("id = ? AND status = ?", id, models.ApprovalApproved).
+		Update("status", models.ApprovalExecuted)
+	return result.RowsAffected == 1, result.Error
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"control/go_server/internal/models"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type RecordingStore struct {
+	db *gorm.DB
+}
+
+func NewRecordingStore(db *gorm.DB) *RecordingStore {
+	return &RecordingStore{db: db}
+}
+
+// AutoMigrate creates the terminal recordings table
+func (s *RecordingStore) AutoMigrate() error {
+	return s.db.AutoMigrate(&models.TerminalRecording{})
+}
+
+// RecordingFilter selects recordings; zero fields match everything
+type RecordingFilter struct {
+	User    string
+	Kind    models.TerminalRecordingKind
+	From    time.Time // sessions still open at or after From
+	To      time.Time // sessions started before To
+	Command string    // text contained in a typed command
+	Limit   int
+}
+
+// SaveRecording creates a recording or updates it once it has an ID
+func (s *RecordingStore) SaveRecording(recording *models.TerminalRecording) error {
+	if recording.ID == 0 {
+		return s.db.Create(recording).Error
+	}
+	return s.db.Save(recording).Error
+}
+
+// GetRecording gets a recording by ID
+func (s *RecordingStore) GetRecording(id int64) (*models.TerminalRecording, error) {
+	var recording models.TerminalRecording
+	if err := s.db.First(&recording, id).Error; err != nil {
+		return nil, err
+	}
+	return &recording, nil
+}
+
+// SearchRecordings gets the recordings matching filter, newest first
+func (s *RecordingStore) SearchRecordings(filter RecordingFilter) ([]models.TerminalRecording, error) {
+	var recordings []models.TerminalRecording
+	query := s.db.Model(&models.TerminalRecording{})
+	if filter.User != "" {
+		query = query.Where("user = ?", filter.User)
+	}
+	if filter.Kind != "" {
+		query = query.Where("kind = ?", filter.Kind)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("ended_at IS NULL OR ended_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("started_at < ?", filter.To)
+	}
+	if filter.Command != "" {
+		query = query.Where("commands LIKE ?", "%"+escapeLike(filter.Command)+"%")
+	}
+	err := query.Order("started_at DESC").Limit(filter.Limit).Find(&recordings).Error
+	return recordings, err
+}
+
+// GetRecordingsBefore gets the recordings that ended before t, or started
+// before it and were never ended, to expire them
+func (s *RecordingStore) GetRecordingsBefore(t time.Time) ([]models.TerminalRecording, error) {
+	var recordings []models.TerminalRecording
+	err := s.db.Where("ended_at < ? OR (ended_at IS NULL AND started_at < ?)", t, t).Find(&recordings).Error
+	return recordings, err
+}
+
+// DeleteRecording deletes a recording's row; the caller removes its file
+func (s *RecordingStore) DeleteRecording(id int64) error {
+	return s.db.Delete(&models.TerminalRecording{}, id).Error
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"control/go_server/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type RunbookStore struct {
+	db *gorm.DB
+}
+
+func NewRunbookStore(db *gorm.DB) *RunbookStore {
+	return &RunbookStore{db: db}
+}
+
+// AutoMigrate creates the runbook tables
+func (s *RunbookStore) AutoMigrate() error {
+	return s.db.AutoMigrate(&models.Runbook{}, &models.RunbookRun{})
+}
+
+// GetRunbooks gets all runbooks
+func (s *RunbookStore) GetRunbooks() ([]models.Runbook, error) {
+	var runbooks []models.Runbook
+	err := s.db.Order("name").Find(&runbooks).Error
+	return runbooks, err
+}
+
+// GetRunbook gets a runbook by ID
+func (s *RunbookStore) GetRunbook(id int64) (*models.Runbook, error) {
+	var runbook models.Runbook
+	if err := s.db.First(&runbook, id).Error; err != nil {
+		return nil, err
+	}
+	return &runbook, nil
+}
+
+// CountRunbooks counts the runbooks, to seed the defaults into an empty table
+func (s *RunbookStore) CountRunbooks() (int64, error) {
+	var count int64
+	err := s.db.Model(&models.Runbook{}).Count(&count).Error
+	return count, err
+}
+
+// CreateRunbook creates a new runbook
+func (s *RunbookStore) CreateRunbook(runbook *models.Runbook) error {
+	runbook.CreatedAt = time.Now()
+	runbook.UpdatedAt = time.Now()
+	return s.db.Create(runbook).Error
+}
+
+// UpdateRunbook saves every field of an existing runbook
+func (s *RunbookStore) UpdateRunbook(runbook *models.Runbook) error {
+	runbook.UpdatedAt = time.Now()
+	return s.db.Omit("created_at", "created_by").Save(runbook).Error
+}
+
+// DeleteRunbook deletes a runbook; its runs are kept
+func (s *RunbookStore) DeleteRunbook(id int64) error {
+	return s.db.Delete(&models.Runbook{}, id).Error
+}
+
+// SaveRun creates a run or updates it once it has an ID
+func (s *RunbookStore) SaveRun(run *models.RunbookRun) error {
+	if run.ID == 0 {
+		return s.db.Create(run).Error
+	}
+	return s.db.Save(run).Error
+}
+
+// GetRun gets a run by ID
+func (s *RunbookStore) GetRun(id int64) (*models.RunbookRun, error) {
+	var run models.RunbookRun
+	if err := s.db.First(&run, id).Error; err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// GetRuns gets runs, newest first, optionally of one runbook or user. The
+// output is left out; GetRun has it.
+func (s *RunbookStore) GetRuns(runbookID int64, user string, limit int) ([]models.RunbookRun, error) {
+	var runs []models.RunbookRun
+	query := s.db.Model(&models.RunbookRun{}).Omit("stdout", "stderr")
+	if runbookID > 0 {
+		query = query.Where("runbook_id = ?", runbookID)
+	}
+	if user != "" {
+		query = query.Where("user = ?", user)
+	}
+	err := query.Order("started_at DESC").Limit(limit).Find(&runs).Error
+	return runs, err
+}
+
+// FailRunningRuns marks the runs left running by a previous process as
+// failed; their commands died with it
+func (s *RunbookStore) FailRunningRuns(reason string) error {
+	return s.db.Model(&models.RunbookRun{}).Where("status = ?", models.RunRunning).
+		Updates(map[string]interface{}{"status": models.RunFailed, "reason": reason, "ended_at": time.Now()}).Error
+}
+
+// CleanupOldRuns removes runs older than days
+func (s *RunbookStore) CleanupOldRuns(days int) error {
+	return s.db.Where("started_at < ?", time.Now().AddDate(0, 0, -days)).
+		Delete(&models.RunbookRun{}).Error
+}
@@ -0,0 +1,325 @@
+package terminal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Stream is an output stream of a command
+type Stream string
+
+const (
+	Stdout Stream = "stdout"
+	Stderr Stream = "stderr"
+)
+
+// Why a command ended
+const (
+	EndExited   = "exited"
+	EndTimeout  = "timeout"
+	EndCanceled = "canceled"
+)
+
+// CommandOptions run a one-off command
+type CommandOptions struct {
+	Shell   string // runs the command with -c
+	Command string
+	Dir     string
+	Env     []string // the command's environment, the server's when nil
+	// Timeout kills the command when it runs longer, 0 for never
+	Timeout time.Duration
+	// MaxOutput is how many bytes of stdout, and of stderr, are kept and
+	// streamed, 0 for no limit
+	MaxOutput int
+	// Output receives the output as it is produced, when set. It is called
+	// from the goroutines copying stdout and stderr, so it may be called
+	// concurrently.
+	Output func(stream Stream, data []byte)
+	// State reports the shell's working directory and exported environment
+	// once the command is done, so a session can carry them to the next
+	// command. They aren't reported when the command exits the shell itself.
+	State bool
+}
+
+// CommandResult is how a command ended and what it printed
+type CommandResult struct {
+	Stdout          string `json:"stdout"`
+	Stderr          string `json:"stderr"`
+	StdoutTruncated bool   `json:"stdoutTruncated,omitempty"`
+	StderrTruncated bool   `json:"stderrTruncated,omitempty"`
+	ExitCode        int    `json:"exitCode"`
+	End             string `json:"end"` // exited, timeout or canceled
+	DurationMs      int64  `json:"durationMs"`
+
+	// The shell's state after the command, with CommandOptions.State; Dir is
+	// empty when it isn't known
+	Dir string   `json:"-"`
+	Env []string `json:"-"`
+}
+
+// Command is a one-off command running without a terminal. It leads its own
+// process group, so a timeout or cancellation takes down everything it
+// started that didn't leave the group.
+type Command struct {
+	ID        string    `json:"id"`
+	User      string    `json:"user"`
+	SessionID string    `json:"sessionId"`
+	Command   string    `json:"command"`
+	Dir       string    `json:"dir"`
+	Started   time.Time `json:"started"`
+	Deadline  time.Time `json:"deadline"` // zero without a timeout
+
+	cmd    *exec.Cmd
+	stdout *commandOutput
+	stderr *commandOutput
+	timer  *time.Timer
+	done   chan struct{}
+
+	mutex  sync.Mutex
+	end    string
+	result CommandResult
+}
+
+// commandOutput keeps the first max bytes of a stream
+type commandOutput struct {
+	stream    Stream
+	max       int
+	output    func(Stream, []byte)
+	mutex     sync.Mutex
+	data      []byte
+	truncated bool
+}
+
+func (o *commandOutput) Write(p []byte) (int, error) {
+	o.mutex.Lock()
+	keep := p
+	if o.max > 0 && len(o.data)+len(keep) > o.max {
+		keep = keep[:o.max-len(o.data)]
+		o.truncated = true
+	}
+	o.data = append(o.data, keep...)
+	o.mutex.Unlock()
+
+	if len(keep) > 0 && o.output != nil {
+		o.output(o.stream, keep)
+	}
+	// Everything is reported as written so the command isn't killed by a
+	// broken pipe once it exceeds the limit
+	return len(p), nil
+}
+
+func (o *commandOutput) result() (string, bool) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return string(o.data), o.truncated
+}
+
+// startCommand starts the command of opts
+func startCommand(id, user, sessionID string, opts CommandOptions) (*Command, error) {
+	if opts.Shell == "" {
+		return nil, errors.New("no shell configured")
+	}
+
+	script := opts.Command
+	var statePath string
+	if opts.State {
+		state, err := os.CreateTemp("", "terminal-state-*")
+		if err != nil {
+			return nil, err
+		}
+		state.Close()
+		statePath = state.Name()
+		// On a line of its own so a trailing comment doesn't swallow it, after
+		// a blank one that a trailing backslash joins instead of it
+		script += fmt.Sprintf("\n\n__status=$?; { pwd; env -0; } > '%s'; exit $__status", statePath)
+	}
+
+	cmd := exec.Command(opts.Shell, "-c", script)
+	cmd.Dir = opts.Dir
+	cmd.Env = opts.Env
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	// Background jobs may keep the output open after the command exits
+	cmd.WaitDelay = hangupGrace
+
+	c := &Command{
+		ID:        id,
+		User:      user,
+		SessionID: sessionID,
+		Command:   opts.Command,
+		Dir:       opts.Dir,
+		cmd:       cmd,
+		done:      make(chan struct{}),
+	}
+	c.stdout = &commandOutput{stream: Stdout, max: opts.MaxOutput, output: opts.Output}
+	c.stderr = &commandOutput{stream: Stderr, max: opts.MaxOutput, output: opts.Output}
+	cmd.Stdout = c.stdout
+	cmd.Stderr = c.stderr
+
+	if err := cmd.Start(); err != nil {
+		if statePath != "" {
+			os.Remove(statePath)
+		}
+		return nil, err
+	}
+	c.Started = time.Now()
+	if opts.Timeout > 0 {
+		c.Deadline = c.Started.Add(opts.Timeout)
+		c.timer = time.AfterFunc(opts.Timeout, func() { c.stop(EndTimeout) })
+	}
+
+	go func() {
+		err := cmd.Wait()
+		if errors.Is(err, exec.ErrWaitDelay) {
+			// The command exited, but a background job it left kept the output open
+			err = nil
+			if !cmd.ProcessState.Success() {
+				err = &exec.ExitError{ProcessState: cmd.ProcessState}
+			}
+		}
+		if c.timer != nil {
+			c.timer.Stop()
+		}
+		stdout, stdoutTruncated := c.stdout.result()
+		stderr, stderrTruncated := c.stderr.result()
+
+		c.mutex.Lock()
+		if c.end == "" {
+			c.end = EndExited
+		}
+		c.result = CommandResult{
+			Stdout:          stdout,
+			Stderr:          stderr,
+			StdoutTruncated: stdoutTruncated,
+			StderrTruncated: stderrTruncated,
+			ExitCode:        exitCode(err),
+			End:             c.end,
+			DurationMs:      time.Since(c.Started).Milliseconds(),
+		}
+		if statePath != "" {
+			c.result.Dir, c.result.Env = readState(statePath)
+			os.Remove(statePath)
+		}
+		c.mutex.Unlock()
+		close(c.done)
+	}()
+	return c, nil
+}
+
+// readState reads the working directory and environment the shell wrote
+// after the command: the directory on the first line, then the variables
+// separated by NULs
+func readState(path string) (string, []string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil
+	}
+	dir, env, ok := strings.Cut(string(data), "\n")
+	if !ok || dir == "" {
+		return "", nil
+	}
+	var vars []string
+	for _, v := range strings.Split(env, "\x00") {
+		if strings.Contains(v, "=") {
+			vars = append(vars, v)
+		}
+	}
+	return dir, vars
+}
+
+// Cancel kills the command and everything in its process group
+func (c *Command) Cancel() {
+	c.stop(EndCanceled)
+}
+
+// stop asks the process group to terminate and kills it after the grace
+// period; the first reason to stop a command is the one reported
+func (c *Command) stop(reason string) {
+	c.mutex.Lock()
+	if c.end != "" {
+		c.mutex.Unlock()
+		return
+	}
+	c.end = reason
+	c.mutex.Unlock()
+
+	pgid := c.cmd.Process.Pid
+	syscall.Kill(-pgid, syscall.SIGTERM)
+	select {
+	case <-c.done:
+	case <-time.After(hangupGrace):
+	}
+	syscall.Kill(-pgid, syscall.SIGKILL)
+}
+
+// Done is closed when the command has exited
+func (c *Command) Done() <-chan struct{} {
+	return c.done
+}
+
+// Wait waits for the command to exit and returns its result
+func (c *Command) Wait() CommandResult {
+	<-c.done
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.result
+}
+
+// Runner keeps the running one-off commands so they can be listed and canceled
+type Runner struct {
+	mutex    sync.Mutex
+	commands map[string]*Command
+}
+
+func NewRunner() *Runner {
+	return &Runner{commands: make(map[string]*Command)}
+}
+
+// Start starts a command for user in one of their terminal sessions
+func (r *Runner) Start(user, sessionID string, opts CommandOptions) (*Command, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	c, err := startCommand(id, user, sessionID, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mutex.Lock()
+	r.commands[id] = c
+	r.mutex.Unlock()
+	go func() {
+		<-c.Done()
+		r.mutex.Lock()
+		delete(r.commands, id)
+		r.mutex.Unlock()
+	}()
+	return c, nil
+}
+
+// Get returns a running command
+func (r *Runner) Get(id string) (*Command, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	c, ok := r.commands[id]
+	return c, ok
+}
+
+// List returns the running commands, oldest first
+func (r *Runner) List() []*Command {
+	r.mutex.Lock()
+	commands := make([]*Command, 0, len(r.commands))
+	for _, c := range r.commands {
+		commands = append(commands, c)
+	}
+	r.mutex.Unlock()
+	sort.Slice(commands, func(i, j int) bool { return commands[i].Started.Before(commands[j].Started) })
+	return commands
+}
@@ -0,0 +1,108 @@
+package terminal
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ErrSessionLimit is returned when a user already has the most sessions allowed
+var ErrSessionLimit = errors.New("terminal session limit reached")
+
+// Manager keeps the open sessions and limits how many each user may have
+type Manager struct {
+	maxPerUser int
+
+	mutex    sync.Mutex
+	sessions map[string]*Session
+}
+
+// NewManager creates a manager allowing maxPerUser sessions per user, or any
+// number when it is 0
+func NewManager(maxPerUser int) *Manager {
+	return &Manager{maxPerUser: maxPerUser, sessions: make(map[string]*Session)}
+}
+
+// Open starts a session for user
+func (m *Manager) Open(user string, opts Options) (*Session, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.maxPerUser > 0 && m.countLocked(user) >= m.maxPerUser {
+		return nil, fmt.Errorf("%w: %d open", ErrSessionLimit, m.maxPerUser)
+	}
+
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	s, err := start(id, user, opts)
+	if err != nil {
+		return nil, err
+	}
+	m.sessions[id] = s
+
+	// Forget the session once its shell exits, however it was ended
+	go func() {
+		<-s.Done()
+		m.mutex.Lock()
+		delete(m.sessions, id)
+		m.mutex.Unlock()
+	}()
+	return s, nil
+}
+
+// Close hangs up a session and waits until its processes are gone
+func (m *Manager) Close(s *Session) {
+	s.close()
+	m.mutex.Lock()
+	delete(m.sessions, s.ID)
+	m.mutex.Unlock()
+}
+
+// Get returns an open session
+func (m *Manager) Get(id string) (*Session, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	s, ok := m.sessions[id]
+	return s, ok
+}
+
+// List returns the open sessions, oldest first
+func (m *Manager) List() []*Session {
+	m.mutex.Lock()
+	sessions := make([]*Session, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		sessions = append(sessions, s)
+	}
+	m.mutex.Unlock()
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].Started.Before(sessions[j].Started) })
+	return sessions
+}
+
+// Count returns the number of open sessions of user
+func (m *Manager) Count(user string) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.countLocked(user)
+}
+
+func (m *Manager) countLocked(user string) int {
+	count := 0
+	for _, s := range m.sessions {
+		if s.User == user {
+			count++
+		}
+	}
+	return count
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
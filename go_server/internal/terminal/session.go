@@ -0,0 +1,215 @@
+// Package terminal runs the web terminal's interactive shells on
+// pseudo-terminals, one session per connection, and its one-off commands.
+package terminal
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+)
+
+// hangupGrace is how long the processes of a closed session get to exit
+// after the hangup before they are killed
+const hangupGrace = 2 * time.Second
+
+// Options start a session
+type Options struct {
+	Shell string
+	Dir   string
+	Env   []string // added to the server's environment
+	Cols  uint16
+	Rows  uint16
+}
+
+// Session is a shell running on a pseudo-terminal. The shell leads its own
+// session, so closing it takes down every process started from it.
+type Session struct {
+	ID      string    `json:"id"`
+	User    string    `json:"user"`
+	Shell   string    `json:"shell"`
+	Started time.Time `json:"started"`
+
+	cmd  *exec.Cmd
+	pty  *os.File
+	done chan struct{}
+
+	mutex      sync.Mutex
+	lastActive time.Time
+	cols, rows uint16
+	exitCode   int
+	closed     bool
+}
+
+// start runs the shell of opts on a new pseudo-terminal
+func start(id, user string, opts Options) (*Session, error) {
+	if opts.Shell == "" {
+		return nil, errors.New("no shell configured")
+	}
+	if opts.Cols == 0 || opts.Rows == 0 {
+		opts.Cols, opts.Rows = 80, 24
+	}
+
+	cmd := exec.Command(opts.Shell)
+	cmd.Dir = opts.Dir
+	cmd.Env = append(os.Environ(), "TERM=xterm-256color")
+	cmd.Env = append(cmd.Env, opts.Env...)
+	// Starts the shell as a session leader with the terminal as its controlling tty
+	ptmx, err := pty.StartWithSize(cmd, &pty.Winsize{Cols: opts.Cols, Rows: opts.Rows})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	s := &Session{
+		ID:         id,
+		User:       user,
+		Shell:      opts.Shell,
+		Started:    now,
+		cmd:        cmd,
+		pty:        ptmx,
+		done:       make(chan struct{}),
+		lastActive: now,
+		cols:       opts.Cols,
+		rows:       opts.Rows,
+	}
+	go func() {
+		err := cmd.Wait()
+		s.mutex.Lock()
+		s.exitCode = exitCode(err)
+		s.mutex.Unlock()
+		close(s.done)
+	}()
+	return s, nil
+}
+
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return 128 + int(status.Signal())
+		}
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// Read reads the terminal's output
+func (s *Session) Read(p []byte) (int, error) {
+	return s.pty.Read(p)
+}
+
+// Write types into the terminal
+func (s *Session) Write(p []byte) (int, error) {
+	s.touch()
+	return s.pty.Write(p)
+}
+
+// Resize changes the terminal's size, signalling the foreground process
+func (s *Session) Resize(cols, rows uint16) error {
+	if cols == 0 || rows == 0 {
+		return errors.New("terminal size must be positive")
+	}
+	s.mutex.Lock()
+	s.cols, s.rows = cols, rows
+	s.mutex.Unlock()
+	return pty.Setsize(s.pty, &pty.Winsize{Cols: cols, Rows: rows})
+}
+
+// Size returns the terminal's columns and rows
+func (s *Session) Size() (cols, rows uint16) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.cols, s.rows
+}
+
+func (s *Session) touch() {
+	s.mutex.Lock()
+	s.lastActive = time.Now()
+	s.mutex.Unlock()
+}
+
+// LastActive returns when input was last typed
+func (s *Session) LastActive() time.Time {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.lastActive
+}
+
+// Done is closed when the shell has exited
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// ExitCode returns the shell's exit status once Done is closed, 128+signal
+// when it was killed
+func (s *Session) ExitCode() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.exitCode
+}
+
+// close hangs up the terminal and kills what is left of its session after
+// the grace period
+func (s *Session) close() {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return
+	}
+	s.closed = true
+	s.mutex.Unlock()
+
+	sid := s.cmd.Process.Pid
+	// Like a dropped connection: the shell passes the hangup on to its jobs
+	syscall.Kill(-sid, syscall.SIGHUP)
+	s.pty.Close()
+	select {
+	case <-s.done:
+	case <-time.After(hangupGrace):
+	}
+	// Jobs the shell put in their own process groups, and anything ignoring SIGHUP
+	for _, pid := range sessionProcesses(sid) {
+		syscall.Kill(pid, syscall.SIGKILL)
+	}
+	<-s.done
+}
+
+// sessionProcesses lists the processes of a session from /proc
+func sessionProcesses(sid int) []int {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return []int{sid}
+	}
+	var pids []int
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		stat, err := os.ReadFile("/proc/" + entry.Name() + "/stat")
+		if err != nil {
+			continue
+		}
+		// The command may contain spaces and parentheses, so fields start after the last ')':
+		// state ppid pgrp session ...
+		i := strings.LastIndexByte(string(stat), ')')
+		if i < 0 {
+			continue
+		}
+		fields := strings.Fields(string(stat[i+1:]))
+		if len(fields) > 3 && fields[3] == strconv.Itoa(sid) {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
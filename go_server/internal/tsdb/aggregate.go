@@ -0,0 +1,193 @@
+package tsdb
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Aggregation combines the samples falling into one step of a query
+type Aggregation string
+
+const (
+	AggAvg  Aggregation = "avg"
+	AggMin  Aggregation = "min"
+	AggMax  Aggregation = "max"
+	AggP95  Aggregation = "p95"
+	AggRate Aggregation = "rate" // per-second increase of a counter, allowing for resets
+)
+
+// Aggregations lists the supported aggregations
+var Aggregations = []Aggregation{AggAvg, AggMin, AggMax, AggP95, AggRate}
+
+// ParseAggregation parses avg, min, max, p95 or rate
+func ParseAggregation(s string) (Aggregation, error) {
+	for _, a := range Aggregations {
+		if string(a) == s {
+			return a, nil
+		}
+	}
+	return AggAvg, fmt.Errorf("unknown aggregation %q", s)
+}
+
+// SourceResolution picks the resolution to read for a query: the coarsest one
+// that fits into step, but no finer than what is kept for the span. p95 reads
+// the finest kept resolution since percentiles of rollup averages flatten
+// peaks.
+func SourceResolution(span, step time.Duration, agg Aggregation) Resolution {
+	finest := ResolutionFor(span)
+	if agg == AggP95 {
+		return finest
+	}
+	r := finest
+	for _, candidate := range Resolutions {
+		if candidate > r && time.Duration(candidate) <= step && step%time.Duration(candidate) == 0 {
+			r = candidate
+		}
+	}
+	return r
+}
+
+// StepTimes returns the start of every step in [start, end], aligned to
+// multiples of step so repeated queries return the same timestamps
+func StepTimes(start, end time.Time, step time.Duration) []time.Time {
+	var times []time.Time
+	for t := start.Truncate(step); !t.After(end); t = t.Add(step) {
+		times = append(times, t)
+	}
+	return times
+}
+
+// Align aggregates samples into the steps returned by StepTimes. Steps
+// without samples are nil. Rollup samples count for the step their bucket
+// starts in.
+func Align(samples []Sample, start, end time.Time, step time.Duration, agg Aggregation) []*float64 {
+	times := StepTimes(start, end, step)
+	values := make([]*float64, len(times))
+	if len(times) == 0 {
+		return values
+	}
+	first := times[0]
+
+	// Samples grouped by step; for rate the last sample of the previous step
+	// is kept so the increase across the boundary is counted
+	groups := make([][]Sample, len(times))
+	var previous []*Sample
+	if agg == AggRate {
+		previous = make([]*Sample, len(times))
+	}
+	var last *Sample
+	for i := range samples {
+		s := &samples[i]
+		idx := int(s.Time.Sub(first) / step)
+		if s.Time.Before(first) || idx >= len(times) {
+			last = s
+			continue
+		}
+		if previous != nil && len(groups[idx]) == 0 && last != nil {
+			previous[idx] = last
+		}
+		groups[idx] = append(groups[idx], *s)
+		last = s
+	}
+
+	for i, group := range groups {
+		var prev *Sample
+		if previous != nil {
+			prev = previous[i]
+		}
+		if v, ok := aggregate(prev, group, agg, step.Seconds()); ok {
+			values[i] = &v
+		}
+	}
+	return values
+}
+
+// Reduce aggregates every sample of a range into one value; ok is false when
+// there are none. A rate is the increase over the time between the first and
+// last sample.
+func Reduce(samples []Sample, agg Aggregation) (float64, bool) {
+	if len(samples) == 0 {
+		return 0, false
+	}
+	seconds := samples[len(samples)-1].Time.Sub(samples[0].Time).Seconds()
+	if agg == AggRate && seconds <= 0 {
+		return 0, true
+	}
+	return aggregate(nil, samples, agg, seconds)
+}
+
+// aggregate combines the samples of one step; prev is the sample before them
+// for rates and seconds the length of the step
+func aggregate(prev *Sample, samples []Sample, agg Aggregation, seconds float64) (float64, bool) {
+	if len(samples) == 0 {
+		return 0, false
+	}
+	switch agg {
+	case AggMin:
+		v := samples[0].Min
+		for _, s := range samples[1:] {
+			v = math.Min(v, s.Min)
+		}
+		return v, true
+	case AggMax:
+		v := samples[0].Max
+		for _, s := range samples[1:] {
+			v = math.Max(v, s.Max)
+		}
+		return v, true
+	case AggP95:
+		return percentile(samples, 0.95), true
+	case AggRate:
+		return increase(prev, samples) / seconds, true
+	}
+	var sum float64
+	var count int
+	for _, s := range samples {
+		sum += s.Avg * float64(s.Count)
+		count += s.Count
+	}
+	if count == 0 {
+		return 0, false
+	}
+	return sum / float64(count), true
+}
+
+// percentile returns the q-th percentile of the sample averages, interpolated
+// between the closest ranks
+func percentile(samples []Sample, q float64) float64 {
+	values := make([]float64, len(samples))
+	for i, s := range samples {
+		values[i] = s.Avg
+	}
+	sort.Float64s(values)
+	pos := q * float64(len(values)-1)
+	lower := int(pos)
+	if lower+1 >= len(values) {
+		return values[lower]
+	}
+	return values[lower] + (values[lower+1]-values[lower])*(pos-float64(lower))
+}
+
+// increase sums the rises of a counter from prev through samples, treating a
+// drop as a reset to zero. Rollups use their max, the counter's last value in
+// the bucket.
+func increase(prev *Sample, samples []Sample) float64 {
+	var total float64
+	last := math.NaN()
+	if prev != nil {
+		last = prev.Max
+	}
+	for _, s := range samples {
+		switch {
+		case math.IsNaN(last):
+		case s.Max >= last:
+			total += s.Max - last
+		default:
+			total += s.Max
+		}
+		last = s.Max
+	}
+	return total
+}
@@ -0,0 +1,178 @@
+package tsdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Segment files hold one UTC day of fixed-size records, named 20060102.seg
+const (
+	segmentLayout = "20060102"
+	segmentExt    = ".seg"
+	segmentSpan   = 24 * time.Hour
+	recordSize    = 4 + 8 + 8 + 8 + 8 + 4
+)
+
+// record is the on-disk form of a sample: series id, unix millis, min, max, sum and count
+type record struct {
+	id    uint32
+	ts    int64
+	min   float64
+	max   float64
+	sum   float64
+	count uint32
+}
+
+func (r *record) merge(o record) {
+	r.min = math.Min(r.min, o.min)
+	r.max = math.Max(r.max, o.max)
+	r.sum += o.sum
+	r.count += o.count
+}
+
+func (r record) sample() Sample {
+	s := Sample{Time: time.UnixMilli(r.ts), Min: r.min, Max: r.max, Count: int(r.count)}
+	if r.count > 0 {
+		s.Avg = r.sum / float64(r.count)
+	}
+	return s
+}
+
+func (r record) encode(buf []byte) {
+	binary.LittleEndian.PutUint32(buf[0:], r.id)
+	binary.LittleEndian.PutUint64(buf[4:], uint64(r.ts))
+	binary.LittleEndian.PutUint64(buf[12:], math.Float64bits(r.min))
+	binary.LittleEndian.PutUint64(buf[20:], math.Float64bits(r.max))
+	binary.LittleEndian.PutUint64(buf[28:], math.Float64bits(r.sum))
+	binary.LittleEndian.PutUint32(buf[36:], r.count)
+}
+
+func decodeRecord(buf []byte) record {
+	return record{
+		id:    binary.LittleEndian.Uint32(buf[0:]),
+		ts:    int64(binary.LittleEndian.Uint64(buf[4:])),
+		min:   math.Float64frombits(binary.LittleEndian.Uint64(buf[12:])),
+		max:   math.Float64frombits(binary.LittleEndian.Uint64(buf[20:])),
+		sum:   math.Float64frombits(binary.LittleEndian.Uint64(buf[28:])),
+		count: binary.LittleEndian.Uint32(buf[36:]),
+	}
+}
+
+// segmentWriter appends records to the segment of the day they belong to
+type segmentWriter struct {
+	dir  string
+	path string
+	file *os.File
+	buf  *bufio.Writer
+}
+
+func (w *segmentWriter) write(t time.Time, r record) error {
+	path := filepath.Join(w.dir, t.UTC().Format(segmentLayout)+segmentExt)
+	if path != w.path {
+		if err := w.close(); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		// drop a torn record left by a crash so later records stay aligned
+		if info, err := f.Stat(); err == nil && info.Size()%recordSize != 0 {
+			if err := f.Truncate(info.Size() - info.Size()%recordSize); err != nil {
+				f.Close()
+				return err
+			}
+		}
+		w.path, w.file, w.buf = path, f, bufio.NewWriter(f)
+	}
+
+	var buf [recordSize]byte
+	r.encode(buf[:])
+	_, err := w.buf.Write(buf[:])
+	return err
+}
+
+func (w *segmentWriter) flush() error {
+	if w.buf == nil {
+		return nil
+	}
+	return w.buf.Flush()
+}
+
+func (w *segmentWriter) close() error {
+	if w.file == nil {
+		return nil
+	}
+	err := w.buf.Flush()
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	w.path, w.file, w.buf = "", nil, nil
+	return err
+}
+
+// closeIf closes the writer when it has path open, before the file is removed
+func (w *segmentWriter) closeIf(path string) {
+	if w.path == path {
+		w.close()
+	}
+}
+
+// segmentDay returns the day a segment file covers
+func segmentDay(path string) (time.Time, bool) {
+	name := strings.TrimSuffix(filepath.Base(path), segmentExt)
+	day, err := time.ParseInLocation(segmentLayout, name, time.UTC)
+	return day, err == nil
+}
+
+// segmentFiles lists the segments of dir overlapping [start, end], oldest first
+func segmentFiles(dir string, start, end time.Time) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), segmentExt) {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		day, ok := segmentDay(path)
+		if !ok || day.Add(segmentSpan).Before(start) || day.After(end) {
+			continue
+		}
+		files = append(files, path)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// readSegment calls fn for every complete record of a segment
+func readSegment(path string, fn func(record)) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReaderSize(f, 64*1024)
+	var buf [recordSize]byte
+	for {
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil
+			}
+			return err
+		}
+		fn(decodeRecord(buf[:]))
+	}
+}
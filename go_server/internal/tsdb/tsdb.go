@@ -0,0 +1,528 @@
+// Package tsdb is a small embedded time-series store. Points are appended to
+// daily segment files per resolution and rolled up into 1m, 5m and 1h
+// min/max/avg buckets as they arrive, so long ranges are served from a few
+// thousand rollup samples instead of millions of raw points.
+package tsdb
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Resolution is the bucket width of a series; Raw stores points as appended
+type Resolution time.Duration
+
+const (
+	Raw        Resolution = 0
+	Minute     Resolution = Resolution(time.Minute)
+	FiveMinute Resolution = Resolution(5 * time.Minute)
+	Hour       Resolution = Resolution(time.Hour)
+)
+
+// Resolutions lists every stored resolution, finest first
+var Resolutions = []Resolution{Raw, Minute, FiveMinute, Hour}
+
+// String returns the directory name of the resolution
+func (r Resolution) String() string {
+	switch r {
+	case Raw:
+		return "raw"
+	case Minute:
+		return "1m"
+	case FiveMinute:
+		return "5m"
+	case Hour:
+		return "1h"
+	}
+	return time.Duration(r).String()
+}
+
+// ParseResolution parses raw, 1m, 5m or 1h
+func ParseResolution(s string) (Resolution, error) {
+	for _, r := range Resolutions {
+		if r.String() == s {
+			return r, nil
+		}
+	}
+	return Raw, fmt.Errorf("unknown resolution %q", s)
+}
+
+// ResolutionFor picks the coarsest resolution that still gives a useful
+// number of samples over a query range
+func ResolutionFor(span time.Duration) Resolution {
+	switch {
+	case span <= 6*time.Hour:
+		return Raw
+	case span <= 2*24*time.Hour:
+		return Minute
+	case span <= 14*24*time.Hour:
+		return FiveMinute
+	}
+	return Hour
+}
+
+// Labels identify a series together with its metric name
+type Labels map[string]string
+
+// String formats labels as {a="1",b="2"} with sorted keys
+func (l Labels) String() string {
+	if len(l) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s=%q", k, l[k])
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// Matches reports whether l has every label of match
+func (l Labels) Matches(match Labels) bool {
+	for k, v := range match {
+		if l[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// SeriesKey returns the unique key of a series, e.g. service_cpu_percent{service="api"}
+func SeriesKey(name string, labels Labels) string {
+	return name + labels.String()
+}
+
+// Sample is one point of a series. Raw samples have Min == Max == Avg and Count 1.
+type Sample struct {
+	Time  time.Time `json:"timestamp"` // bucket start for rollups
+	Min   float64   `json:"min"`
+	Max   float64   `json:"max"`
+	Avg   float64   `json:"avg"`
+	Count int       `json:"count"`
+}
+
+// Series is a named, labelled list of samples
+type Series struct {
+	Name    string   `json:"name"`
+	Labels  Labels   `json:"labels"`
+	Samples []Sample `json:"samples"`
+}
+
+// SeriesInfo describes a stored series
+type SeriesInfo struct {
+	ID     uint32     `json:"id"`
+	Name   string     `json:"name"`
+	Labels Labels     `json:"labels"`
+	Last   *time.Time `json:"last,omitempty"` // last append since the store was opened
+}
+
+// bucket accumulates a rollup sample that is still open
+type bucket struct {
+	start time.Time
+	rec   record
+}
+
+// DB is an open store
+type DB struct {
+	dir       string
+	retention map[Resolution]time.Duration
+
+	mutex   sync.Mutex
+	ids     map[string]uint32
+	series  []SeriesInfo // indexed by id-1
+	index   *os.File
+	writers map[Resolution]*segmentWriter
+	buckets map[Resolution]map[uint32]*bucket
+	last    map[uint32]time.Time
+}
+
+// Open opens or creates a store in dir. retention maps each resolution to how
+// long it is kept; resolutions missing from it are kept forever.
+func Open(dir string, retention map[Resolution]time.Duration) (*DB, error) {
+	for _, r := range Resolutions {
+		if err := os.MkdirAll(filepath.Join(dir, r.String()), 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	db := &DB{
+		dir:       dir,
+		retention: retention,
+		ids:       make(map[string]uint32),
+		writers:   make(map[Resolution]*segmentWriter),
+		buckets:   make(map[Resolution]map[uint32]*bucket),
+		last:      make(map[uint32]time.Time),
+	}
+	if err := db.loadIndex(); err != nil {
+		return nil, err
+	}
+	for _, r := range Resolutions {
+		db.writers[r] = &segmentWriter{dir: filepath.Join(dir, r.String())}
+		if r != Raw {
+			db.buckets[r] = make(map[uint32]*bucket)
+		}
+	}
+	return db, nil
+}
+
+// indexEntry is one line of series.idx
+type indexEntry struct {
+	ID     uint32 `json:"id"`
+	Name   string `json:"name"`
+	Labels Labels `json:"labels,omitempty"`
+}
+
+// loadIndex reads the series dictionary, which maps series keys to the
+// numeric ids stored in segment records
+func (db *DB) loadIndex() error {
+	path := filepath.Join(db.dir, "series.idx")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+
+	var valid int64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry indexEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil || entry.ID != uint32(len(db.series)+1) {
+			break
+		}
+		db.series = append(db.series, SeriesInfo{ID: entry.ID, Name: entry.Name, Labels: entry.Labels})
+		db.ids[SeriesKey(entry.Name, entry.Labels)] = entry.ID
+		valid += int64(len(scanner.Bytes())) + 1
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to read series index: %v", err)
+	}
+
+	// cut a torn last line left by a crash so new entries follow the valid ones
+	if err := f.Truncate(valid); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Seek(valid, 0); err != nil {
+		f.Close()
+		return err
+	}
+	db.index = f
+	return nil
+}
+
+// seriesID returns the id of a series, registering it on first use; mutex must be held
+func (db *DB) seriesID(name string, labels Labels) (uint32, error) {
+	key := SeriesKey(name, labels)
+	if id, ok := db.ids[key]; ok {
+		return id, nil
+	}
+
+	copied := make(Labels, len(labels))
+	for k, v := range labels {
+		copied[k] = v
+	}
+	id := uint32(len(db.series) + 1)
+	data, err := json.Marshal(indexEntry{ID: id, Name: name, Labels: copied})
+	if err != nil {
+		return 0, err
+	}
+	if _, err := db.index.Write(append(data, '\n')); err != nil {
+		return 0, fmt.Errorf("failed to write series index: %v", err)
+	}
+	db.series = append(db.series, SeriesInfo{ID: id, Name: name, Labels: copied})
+	db.ids[key] = id
+	return id, nil
+}
+
+// Append stores a point and folds it into the rollups. Finished rollup
+// buckets are written when the first point of the next bucket arrives.
+func (db *DB) Append(name string, labels Labels, t time.Time, value float64) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	id, err := db.seriesID(name, labels)
+	if err != nil {
+		return err
+	}
+	db.last[id] = t
+
+	point := record{id: id, ts: t.UnixMilli(), min: value, max: value, sum: value, count: 1}
+	if err := db.writers[Raw].write(t, point); err != nil {
+		return err
+	}
+
+	for _, r := range Resolutions[1:] {
+		start := t.Truncate(time.Duration(r))
+		b, ok := db.buckets[r][id]
+		if ok && !b.start.Equal(start) {
+			if err := db.writers[r].write(b.start, b.rec); err != nil {
+				return err
+			}
+			ok = false
+		}
+		if !ok {
+			db.buckets[r][id] = &bucket{start: start, rec: record{id: id, ts: start.UnixMilli(), min: value, max: value, sum: value, count: 1}}
+			continue
+		}
+		b.rec.merge(point)
+	}
+	return nil
+}
+
+// Flush writes buffered records to disk
+func (db *DB) Flush() error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	return db.flushLocked()
+}
+
+func (db *DB) flushLocked() error {
+	for _, w := range db.writers {
+		if err := w.flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close flushes buffered records and closes the store. Rollup buckets that
+// are still open are written too, so a restart doesn't lose the current hour.
+func (db *DB) Close() error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	for r, buckets := range db.buckets {
+		for id, b := range buckets {
+			if err := db.writers[r].write(b.start, b.rec); err != nil {
+				return err
+			}
+			delete(buckets, id)
+		}
+	}
+	for _, w := range db.writers {
+		if err := w.close(); err != nil {
+			return err
+		}
+	}
+	return db.index.Close()
+}
+
+// Select returns every series called name whose labels include match, with
+// the samples of resolution r in [start, end]. Open rollup buckets are
+// included so the newest bucket is visible before it is written.
+func (db *DB) Select(name string, match Labels, r Resolution, start, end time.Time) ([]Series, error) {
+	db.mutex.Lock()
+	wanted := make(map[uint32]*Series)
+	for _, info := range db.series {
+		if info.Name == name && info.Labels.Matches(match) {
+			wanted[info.ID] = &Series{Name: info.Name, Labels: info.Labels}
+		}
+	}
+	var pending []record
+	if buckets, ok := db.buckets[r]; ok {
+		for id, b := range buckets {
+			if wanted[id] != nil {
+				pending = append(pending, b.rec)
+			}
+		}
+	}
+	err := db.flushLocked()
+	db.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if len(wanted) == 0 {
+		return nil, nil
+	}
+
+	startMs, endMs := start.UnixMilli(), end.UnixMilli()
+	add := func(rec record) {
+		if s := wanted[rec.id]; s != nil && rec.ts >= startMs && rec.ts <= endMs {
+			s.Samples = append(s.Samples, rec.sample())
+		}
+	}
+
+	files, err := segmentFiles(filepath.Join(db.dir, r.String()), start, end)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if err := readSegment(file, add); err != nil {
+			return nil, err
+		}
+	}
+	for _, rec := range pending {
+		add(rec)
+	}
+
+	result := make([]Series, 0, len(wanted))
+	for _, s := range wanted {
+		sort.Slice(s.Samples, func(i, j int) bool { return s.Samples[i].Time.Before(s.Samples[j].Time) })
+		if r != Raw {
+			s.Samples = mergeBuckets(s.Samples)
+		}
+		result = append(result, *s)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Labels.String() < result[j].Labels.String() })
+	return result, nil
+}
+
+// mergeBuckets combines rollup samples with the same start, which happens when
+// a bucket written on Close is continued after a restart
+func mergeBuckets(samples []Sample) []Sample {
+	merged := samples[:0]
+	for _, s := range samples {
+		if n := len(merged); n > 0 && merged[n-1].Time.Equal(s.Time) {
+			last := &merged[n-1]
+			total := last.Count + s.Count
+			if total > 0 {
+				last.Avg = (last.Avg*float64(last.Count) + s.Avg*float64(s.Count)) / float64(total)
+			}
+			last.Min = math.Min(last.Min, s.Min)
+			last.Max = math.Max(last.Max, s.Max)
+			last.Count = total
+			continue
+		}
+		merged = append(merged, s)
+	}
+	return merged
+}
+
+// Query returns the samples of a single series
+func (db *DB) Query(name string, labels Labels, r Resolution, start, end time.Time) ([]Sample, error) {
+	series, err := db.Select(name, labels, r, start, end)
+	if err != nil {
+		return nil, err
+	}
+	key := SeriesKey(name, labels)
+	for _, s := range series {
+		if SeriesKey(s.Name, s.Labels) == key {
+			return s.Samples, nil
+		}
+	}
+	return nil, nil
+}
+
+// Series lists the stored series, optionally only those called name
+func (db *DB) Series(name string) []SeriesInfo {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	var infos []SeriesInfo
+	for _, info := range db.series {
+		if name != "" && info.Name != name {
+			continue
+		}
+		if last, ok := db.last[info.ID]; ok {
+			info.Last = &last
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// Last returns the time of the last point appended to a series since the store was opened
+func (db *DB) Last(name string, labels Labels) (time.Time, bool) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	id, ok := db.ids[SeriesKey(name, labels)]
+	if !ok {
+		return time.Time{}, false
+	}
+	t, ok := db.last[id]
+	return t, ok
+}
+
+// ApplyRetention removes segment files that are entirely older than the
+// retention of their resolution
+func (db *DB) ApplyRetention(now time.Time) error {
+	for _, r := range Resolutions {
+		keep, ok := db.retention[r]
+		if !ok || keep <= 0 {
+			continue
+		}
+		dir := filepath.Join(db.dir, r.String())
+		files, err := segmentFiles(dir, time.Time{}, now.Add(-keep).Add(-segmentSpan))
+		if err != nil {
+			return err
+		}
+		cutoff := now.Add(-keep)
+		for _, file := range files {
+			day, ok := segmentDay(file)
+			if !ok || !day.Add(segmentSpan).Before(cutoff) {
+				continue
+			}
+			db.mutex.Lock()
+			db.writers[r].closeIf(file)
+			err := os.Remove(file)
+			db.mutex.Unlock()
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ResolutionStats describes the files of one resolution
+type ResolutionStats struct {
+	Resolution string     `json:"resolution"`
+	Retention  string     `json:"retention"`
+	Segments   int        `json:"segments"`
+	Bytes      int64      `json:"bytes"`
+	Records    int64      `json:"records"`
+	Oldest     *time.Time `json:"oldest,omitempty"` // start of the oldest segment
+}
+
+// Stats describes the store
+type Stats struct {
+	Dir         string            `json:"dir"`
+	Series      int               `json:"series"`
+	Resolutions []ResolutionStats `json:"resolutions"`
+	TotalBytes  int64             `json:"totalBytes"`
+}
+
+// Stats returns the size of the store per resolution
+func (db *DB) Stats() (Stats, error) {
+	db.mutex.Lock()
+	stats := Stats{Dir: db.dir, Series: len(db.series)}
+	db.mutex.Unlock()
+
+	for _, r := range Resolutions {
+		rs := ResolutionStats{Resolution: r.String(), Retention: "forever"}
+		if keep, ok := db.retention[r]; ok && keep > 0 {
+			rs.Retention = keep.String()
+		}
+		files, err := segmentFiles(filepath.Join(db.dir, r.String()), time.Time{}, time.Now().Add(segmentSpan))
+		if err != nil {
+			return stats, err
+		}
+		for _, file := range files {
+			info, err := os.Stat(file)
+			if err != nil {
+				continue
+			}
+			rs.Segments++
+			rs.Bytes += info.Size()
+			rs.Records += info.Size() / recordSize
+			if day, ok := segmentDay(file); ok && rs.Oldest == nil {
+				rs.Oldest = &day
+			}
+		}
+		stats.TotalBytes += rs.Bytes
+		stats.Resolutions = append(stats.Resolutions, rs)
+	}
+	return stats, nil
+}
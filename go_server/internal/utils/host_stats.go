@@ -0,0 +1,246 @@
+package utils
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/load"
+	"github.com/shirou/gopsutil/v3/mem"
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+// CPUStats is the CPU time split of one core, or of all cores, in percent
+type CPUStats struct {
+	CPU    string  `json:"cpu"` // "all" or cpu0, cpu1, ...
+	Usage  float64 `json:"usage"`
+	User   float64 `json:"user"`
+	System float64 `json:"system"`
+	IOWait float64 `json:"iowait"`
+	Steal  float64 `json:"steal"`
+}
+
+// MemoryStats is the host memory and swap usage in bytes
+type MemoryStats struct {
+	Total       uint64  `json:"total"`
+	Used        uint64  `json:"used"`
+	Available   uint64  `json:"available"`
+	UsedPercent float64 `json:"usedPercent"`
+	SwapTotal   uint64  `json:"swapTotal"`
+	SwapUsed    uint64  `json:"swapUsed"`
+	SwapPercent float64 `json:"swapPercent"`
+}
+
+// FilesystemStats is the usage of one mounted filesystem
+type FilesystemStats struct {
+	Mountpoint        string  `json:"mountpoint"`
+	Device            string  `json:"device"`
+	Fstype            string  `json:"fstype"`
+	Total             uint64  `json:"total"`
+	Used              uint64  `json:"used"`
+	Free              uint64  `json:"free"`
+	UsedPercent       float64 `json:"usedPercent"`
+	InodesUsedPercent float64 `json:"inodesUsedPercent"`
+}
+
+// DiskIOStats is the IO rate of one block device
+type DiskIOStats struct {
+	Device           string  `json:"device"`
+	ReadIOPS         float64 `json:"readIops"`
+	WriteIOPS        float64 `json:"writeIops"`
+	ReadBytesPerSec  float64 `json:"readBytesPerSec"`
+	WriteBytesPerSec float64 `json:"writeBytesPerSec"`
+	BusyPercent      float64 `json:"busyPercent"` // time spent doing IO
+}
+
+// InterfaceStats is the traffic rate of one network interface
+type InterfaceStats struct {
+	Name                  string  `json:"name"`
+	ReceiveBytesPerSec    float64 `json:"receiveBytesPerSec"`
+	TransmitBytesPerSec   float64 `json:"transmitBytesPerSec"`
+	ReceivePacketsPerSec  float64 `json:"receivePacketsPerSec"`
+	TransmitPacketsPerSec float64 `json:"transmitPacketsPerSec"`
+	ErrorsPerSec          float64 `json:"errorsPerSec"`
+	DropsPerSec           float64 `json:"dropsPerSec"`
+}
+
+// HostStats is one sample of host resource usage. CPU percentages and rates
+// cover the time since the previous sample; the first sample reports CPU
+// since boot and zero rates.
+type HostStats struct {
+	CPU         CPUStats          `json:"cpu"`
+	Cores       []CPUStats        `json:"cores"`
+	Memory      MemoryStats       `json:"memory"`
+	Filesystems []FilesystemStats `json:"filesystems"`
+	Disks       []DiskIOStats     `json:"disks"`
+	Interfaces  []InterfaceStats  `json:"interfaces"`
+	Load        [3]float64        `json:"load"` // 1m, 5m, 15m
+
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// HostSampler collects HostStats, keeping the previous cumulative counters
+// to turn them into per-second rates
+type HostSampler struct {
+	mutex      sync.Mutex
+	time       time.Time
+	cpu        map[string]cpu.TimesStat
+	disks      map[string]disk.IOCountersStat
+	interfaces map[string]net.IOCountersStat
+}
+
+// NewHostSampler creates a sampler without history
+func NewHostSampler() *HostSampler {
+	return &HostSampler{}
+}
+
+// Sample collects the current host stats. Collection errors leave the
+// affected section empty rather than failing the whole sample.
+func (s *HostSampler) Sample() HostStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	stats := HostStats{Timestamp: now, Cores: []CPUStats{}, Filesystems: []FilesystemStats{}, Disks: []DiskIOStats{}, Interfaces: []InterfaceStats{}}
+	elapsed := now.Sub(s.time).Seconds()
+	if s.time.IsZero() {
+		elapsed = 0
+	}
+
+	// CPU, from the difference between the cumulative times
+	cpuTimes := make(map[string]cpu.TimesStat)
+	if total, err := cpu.Times(false); err == nil && len(total) > 0 {
+		total[0].CPU = "all"
+		cpuTimes["all"] = total[0]
+		stats.CPU = cpuPercentages(s.cpu["all"], total[0])
+	}
+	if cores, err := cpu.Times(true); err == nil {
+		for _, core := range cores {
+			cpuTimes[core.CPU] = core
+			stats.Cores = append(stats.Cores, cpuPercentages(s.cpu[core.CPU], core))
+		}
+	}
+	s.cpu = cpuTimes
+
+	// Memory and swap
+	if vm, err := mem.VirtualMemory(); err == nil {
+		stats.Memory.Total, stats.Memory.Used, stats.Memory.Available = vm.Total, vm.Used, vm.Available
+		stats.Memory.UsedPercent = vm.UsedPercent
+	}
+	if swap, err := mem.SwapMemory(); err == nil {
+		stats.Memory.SwapTotal, stats.Memory.SwapUsed, stats.Memory.SwapPercent = swap.Total, swap.Used, swap.UsedPercent
+	}
+
+	// Mounted filesystems; a device mounted twice (bind mounts) is listed once
+	if partitions, err := disk.Partitions(false); err == nil {
+		seen := make(map[string]bool)
+		for _, p := range partitions {
+			if seen[p.Device] || strings.HasPrefix(p.Device, "/dev/loop") {
+				continue
+			}
+			usage, err := disk.Usage(p.Mountpoint)
+			if err != nil || usage.Total == 0 {
+				continue
+			}
+			seen[p.Device] = true
+			stats.Filesystems = append(stats.Filesystems, FilesystemStats{
+				Mountpoint:        p.Mountpoint,
+				Device:            p.Device,
+				Fstype:            p.Fstype,
+				Total:             usage.Total,
+				Used:              usage.Used,
+				Free:              usage.Free,
+				UsedPercent:       usage.UsedPercent,
+				InodesUsedPercent: usage.InodesUsedPercent,
+			})
+		}
+		sort.Slice(stats.Filesystems, func(i, j int) bool { return stats.Filesystems[i].Mountpoint < stats.Filesystems[j].Mountpoint })
+	}
+
+	// Block device IO, skipping loop and ram devices
+	diskCounters := make(map[string]disk.IOCountersStat)
+	if counters, err := disk.IOCounters(); err == nil {
+		for name, c := range counters {
+			if strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram") {
+				continue
+			}
+			diskCounters[name] = c
+			d := DiskIOStats{Device: name}
+			if prev, ok := s.disks[name]; ok && elapsed > 0 {
+				d.ReadIOPS = rate(prev.ReadCount, c.ReadCount, elapsed)
+				d.WriteIOPS = rate(prev.WriteCount, c.WriteCount, elapsed)
+				d.ReadBytesPerSec = rate(prev.ReadBytes, c.ReadBytes, elapsed)
+				d.WriteBytesPerSec = rate(prev.WriteBytes, c.WriteBytes, elapsed)
+				// IoTime is in milliseconds
+				d.BusyPercent = min(rate(prev.IoTime, c.IoTime, elapsed)/10, 100)
+			}
+			stats.Disks = append(stats.Disks, d)
+		}
+		sort.Slice(stats.Disks, func(i, j int) bool { return stats.Disks[i].Device < stats.Disks[j].Device })
+	}
+	s.disks = diskCounters
+
+	// Network interfaces, skipping loopback
+	netCounters := make(map[string]net.IOCountersStat)
+	if counters, err := net.IOCounters(true); err == nil {
+		for _, c := range counters {
+			if c.Name == "lo" {
+				continue
+			}
+			netCounters[c.Name] = c
+			i := InterfaceStats{Name: c.Name}
+			if prev, ok := s.interfaces[c.Name]; ok && elapsed > 0 {
+				i.ReceiveBytesPerSec = rate(prev.BytesRecv, c.BytesRecv, elapsed)
+				i.TransmitBytesPerSec = rate(prev.BytesSent, c.BytesSent, elapsed)
+				i.ReceivePacketsPerSec = rate(prev.PacketsRecv, c.PacketsRecv, elapsed)
+				i.TransmitPacketsPerSec = rate(prev.PacketsSent, c.PacketsSent, elapsed)
+				i.ErrorsPerSec = rate(prev.Errin+prev.Errout, c.Errin+c.Errout, elapsed)
+				i.DropsPerSec = rate(prev.Dropin+prev.Dropout, c.Dropin+c.Dropout, elapsed)
+			}
+			stats.Interfaces = append(stats.Interfaces, i)
+		}
+		sort.Slice(stats.Interfaces, func(i, j int) bool { return stats.Interfaces[i].Name < stats.Interfaces[j].Name })
+	}
+	s.interfaces = netCounters
+
+	if avg, err := load.Avg(); err == nil {
+		stats.Load = [3]float64{avg.Load1, avg.Load5, avg.Load15}
+	}
+
+	s.time = now
+	return stats
+}
+
+// cpuPercentages splits the CPU time spent between prev and cur. A zero prev
+// gives the split since boot.
+func cpuPercentages(prev, cur cpu.TimesStat) CPUStats {
+	total := cpuTotal(cur) - cpuTotal(prev)
+	stats := CPUStats{CPU: cur.CPU}
+	if total <= 0 {
+		return stats
+	}
+	percent := func(p, c float64) float64 { return max(c-p, 0) / total * 100 }
+	idle := percent(prev.Idle, cur.Idle)
+	stats.IOWait = percent(prev.Iowait, cur.Iowait)
+	stats.User = percent(prev.User+prev.Nice, cur.User+cur.Nice)
+	stats.System = percent(prev.System+prev.Irq+prev.Softirq, cur.System+cur.Irq+cur.Softirq)
+	stats.Steal = percent(prev.Steal, cur.Steal)
+	stats.Usage = max(100-idle-stats.IOWait, 0)
+	return stats
+}
+
+// cpuTotal sums the CPU times; guest time is already part of user time
+func cpuTotal(t cpu.TimesStat) float64 {
+	return t.User + t.System + t.Idle + t.Nice + t.Iowait + t.Irq + t.Softirq + t.Steal
+}
+
+// rate returns the per-second increase of a counter, 0 if it was reset
+func rate(prev, cur uint64, seconds float64) float64 {
+	if cur < prev {
+		return 0
+	}
+	return float64(cur-prev) / seconds
+}
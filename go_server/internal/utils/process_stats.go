@@ -0,0 +1,166 @@
+package utils
+
+import (
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// ProcessStats aggregates the resource usage of all processes of a service
+type ProcessStats struct {
+	Processes  int     `json:"processes"`
+	CPUPercent float64 `json:"cpu"`
+	MemoryMB   float64 `json:"memory"` // resident set size
+
+	OpenFDs        int32   `json:"openFds"`
+	FDLimit        uint64  `json:"fdLimit"`        // lowest soft RLIMIT_NOFILE among the processes
+	FDUsagePercent float64 `json:"fdUsagePercent"` // highest open/limit ratio among the processes
+	Threads        int32   `json:"threads"`
+
+	// Rates since the previous sample of the same service, 0 on the first sample
+	ReadBytesPerSec              float64 `json:"readBytesPerSec"`
+	WriteBytesPerSec             float64 `json:"writeBytesPerSec"`
+	VoluntaryCtxSwitchesPerSec   float64 `json:"voluntaryCtxSwitchesPerSec"`
+	InvoluntaryCtxSwitchesPerSec float64 `json:"involuntaryCtxSwitchesPerSec"`
+
+	TCPConnections map[string]int `json:"tcpConnections"` // by state, e.g. ESTABLISHED, TIME_WAIT
+
+	StartTime     *time.Time `json:"startTime,omitempty"` // earliest process start
+	UptimeSeconds float64    `json:"uptimeSeconds"`
+
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// processCounters are the cumulative counters of one process, used for rates
+type processCounters struct {
+	createTime  int64
+	readBytes   uint64
+	writeBytes  uint64
+	voluntary   int64
+	involuntary int64
+}
+
+type processSample struct {
+	time     time.Time
+	counters map[int32]processCounters
+}
+
+// ProcessSampler collects ProcessStats and turns cumulative counters into
+// per-second rates between consecutive samples of the same key
+type ProcessSampler struct {
+	mutex sync.Mutex
+	prev  map[string]processSample
+}
+
+// NewProcessSampler creates a sampler without history
+func NewProcessSampler() *ProcessSampler {
+	return &ProcessSampler{prev: make(map[string]processSample)}
+}
+
+// Sample collects the stats of pids. Rates only count processes present in
+// both samples, so a restarted or exited process doesn't produce a negative
+// or inflated rate.
+func (s *ProcessSampler) Sample(key string, pids []int32) ProcessStats {
+	now := time.Now()
+	stats := ProcessStats{TCPConnections: make(map[string]int), Timestamp: now}
+	counters := make(map[int32]processCounters, len(pids))
+
+	for _, pid := range pids {
+		proc, err := process.NewProcess(pid)
+		if err != nil {
+			continue
+		}
+		stats.Processes++
+
+		cpuPercent, _ := proc.CPUPercent()
+		stats.CPUPercent += cpuPercent
+		if memInfo, err := proc.MemoryInfo(); err == nil {
+			stats.MemoryMB += float64(memInfo.RSS) / 1024 / 1024
+		}
+
+		fds, fdErr := proc.NumFDs()
+		if fdErr == nil {
+			stats.OpenFDs += fds
+		}
+		if limits, err := proc.Rlimit(); err == nil {
+			for _, limit := range limits {
+				if limit.Resource != process.RLIMIT_NOFILE || limit.Soft == 0 {
+					continue
+				}
+				if stats.FDLimit == 0 || limit.Soft < stats.FDLimit {
+					stats.FDLimit = limit.Soft
+				}
+				if fdErr == nil {
+					if usage := float64(fds) / float64(limit.Soft) * 100; usage > stats.FDUsagePercent {
+						stats.FDUsagePercent = usage
+					}
+				}
+			}
+		}
+		if threads, err := proc.NumThreads(); err == nil {
+			stats.Threads += threads
+		}
+
+		var c processCounters
+		if createTime, err := proc.CreateTime(); err == nil {
+			c.createTime = createTime
+			start := time.UnixMilli(createTime)
+			if stats.StartTime == nil || start.Before(*stats.StartTime) {
+				stats.StartTime = &start
+			}
+		}
+		if io, err := proc.IOCounters(); err == nil {
+			c.readBytes, c.writeBytes = io.ReadBytes, io.WriteBytes
+		}
+		if ctx, err := proc.NumCtxSwitches(); err == nil {
+			c.voluntary, c.involuntary = ctx.Voluntary, ctx.Involuntary
+		}
+		counters[pid] = c
+
+		if conns, err := proc.Connections(); err == nil {
+			for _, conn := range conns {
+				if conn.Type == syscall.SOCK_STREAM && conn.Status != "" && conn.Status != "NONE" {
+					stats.TCPConnections[conn.Status]++
+				}
+			}
+		}
+	}
+	if stats.StartTime != nil {
+		stats.UptimeSeconds = now.Sub(*stats.StartTime).Seconds()
+	}
+
+	s.mutex.Lock()
+	prev, ok := s.prev[key]
+	s.prev[key] = processSample{time: now, counters: counters}
+	s.mutex.Unlock()
+
+	if elapsed := now.Sub(prev.time).Seconds(); ok && elapsed > 0 {
+		var read, write uint64
+		var voluntary, involuntary int64
+		for pid, c := range counters {
+			p, ok := prev.counters[pid]
+			if !ok || p.createTime != c.createTime {
+				continue
+			}
+			if c.readBytes >= p.readBytes {
+				read += c.readBytes - p.readBytes
+			}
+			if c.writeBytes >= p.writeBytes {
+				write += c.writeBytes - p.writeBytes
+			}
+			if c.voluntary >= p.voluntary {
+				voluntary += c.voluntary - p.voluntary
+			}
+			if c.involuntary >= p.involuntary {
+				involuntary += c.involuntary - p.involuntary
+			}
+		}
+		stats.ReadBytesPerSec = float64(read) / elapsed
+		stats.WriteBytesPerSec = float64(write) / elapsed
+		stats.VoluntaryCtxSwitchesPerSec = float64(voluntary) / elapsed
+		stats.InvoluntaryCtxSwitchesPerSec = float64(involuntary) / elapsed
+	}
+	return stats
+}
@@ -206,11 +206,12 @@ func GetServicePorts(serviceName string) ([]string, error) {
 		return nil, err
 	}
 
-	if len(pids) == 0 {
-		return []string{}, nil
-	}
+	return GetPidsPorts(pids), nil
+}
 
-	var allPorts []string
+// GetPidsPorts returns the listening ports of the given processes, without duplicates
+func GetPidsPorts(pids []int32) []string {
+	allPorts := []string{}
 	for _, pid := range pids {
 		ports, err := GetProcessPorts(pid)
 		if err != nil {
@@ -226,5 +227,5 @@ func GetServicePorts(serviceName string) ([]string, error) {
 		}
 	}
 
-	return allPorts, nil
+	return allPorts
 }
@@ -2,7 +2,7 @@ import React, { useState, useEffect } from 'react';
 import { Card, Row, Col, Statistic, Spin, Alert, Divider, Button, Tag } from 'antd';
 import { ReloadOutlined, PlayCircleOutlined, StopOutlined } from '@ant-design/icons';
 import ReactECharts from 'echarts-for-react';
-import { fetchSystemMetrics } from '../services/api';
+import { fetchSystemMetrics, subscribeLive } from '../services/api';
 import { ServiceMetrics } from '../types';
 
 const ResourceMonitor: React.FC = () => {
@@ -38,8 +38,8 @@ const ResourceMonitor: React.FC = () => {
 
   useEffect(() => {
     fetchAllMetrics(true);
-    const interval = setInterval(() => fetchAllMetrics(false), 30000);
-    return () => clearInterval(interval);
+    // 服务端每次采集后推送最新数据，无需轮询
+    return subscribeLive(['metrics'], (_, data) => setMetrics(data));
   }, []);
 
   const getStatusTag = (status: string, processes: number) => {
@@ -2,7 +2,7 @@ import React, { useState, useEffect } from 'react';
 import { Card, Button, Table, Tag, message, Space, Popconfirm, Alert, Divider, Dropdown } from 'antd';
 import { PlayCircleOutlined, StopOutlined, ReloadOutlined, BarChartOutlined, DownOutlined } from '@ant-design/icons';
 import { services as initialServices } from '../config/services';
-import { startService, stopService, restartService, getAllServicesStatus, fetchSystemMetrics } from '../services/api';
+import { startService, stopService, restartService, getAllServicesStatus, fetchSystemMetrics, subscribeLive } from '../services/api';
 import { ServiceInfo, ServiceMetrics } from '../types';
 
 const ServiceManager: React.FC = () => {
@@ -300,6 +300,17 @@ const ServiceManager: React.FC = () => {
 
   useEffect(() => {
     fetchServicesStatus();
+    // 状态和指标由服务端推送
+    return subscribeLive(['services', 'metrics'], (topic, data) => {
+      if (topic === 'metrics') {
+        setMetrics(data);
+        return;
+      }
+      setServices(prev => prev.map(service => ({
+        ...service,
+        status: data[service.name] || 'unknown'
+      })));
+    });
   }, []);
 
   const runningServices = services.filter(s => s.status === 'running').length;
@@ -1,197 +1,149 @@
 import React, { useState, useEffect, useRef } from 'react';
-import { Card, Tabs, Button, Space, Input, Row, Col, message } from 'antd';
-import { 
+import { Card, Tabs, Button, Space, message } from 'antd';
+import {
   FullscreenOutlined,
   FullscreenExitOutlined,
   ClearOutlined
 } from '@ant-design/icons';
-import { executeRealCommand } from '../../services/api';
+import { Terminal as XTerm } from 'xterm';
+import { FitAddon } from 'xterm-addon-fit';
+import 'xterm/css/xterm.css';
+import { openTerminalSocket } from '../../services/api';
 import { TerminalSession } from '../../types';
 
 const { TabPane } = Tabs;
 
+// 终端标签页对外提供的操作，供快捷命令和清空按钮使用
+interface TerminalHandle {
+  send: (data: string) => void;
+  clear: () => void;
+}
+
 interface TerminalTabProps {
   session: TerminalSession;
   active: boolean;
-  output: string[];
-  onCommand: (command: string) => void;
-  onUpdateOutput: (sessionId: string, newOutput: string[]) => void;
+  onReady: (sessionId: string, handle: TerminalHandle | null) => void;
+  onConnectionChange: (sessionId: string, connected: boolean) => void;
 }
 
-const TerminalTab: React.FC<TerminalTabProps> = ({ session, active, output, onCommand, onUpdateOutput }) => {
-  const [input, setInput] = useState('');
-  const [commandHistory, setCommandHistory] = useState<string[]>([]);
-  const [historyIndex, setHistoryIndex] = useState(-1);
-  const [currentDir, setCurrentDir] = useState('~');
-  const terminalRef = useRef<HTMLDivElement>(null);
-
-  const handleCommand = (command: string) => {
-    if (!command.trim()) return;
-
-    // 添加到命令历史
-    setCommandHistory(prev => [...prev, command]);
-    setHistoryIndex(-1);
+const TerminalTab: React.FC<TerminalTabProps> = ({ session, active, onReady, onConnectionChange }) => {
+  const containerRef = useRef<HTMLDivElement>(null);
+  const fitRef = useRef<FitAddon | null>(null);
+  const termRef = useRef<XTerm | null>(null);
 
-    // 添加到输出
-    onUpdateOutput(session.id, [...output, `$ ${command}`]);
-
-    // 模拟命令执行
-    executeCommand(command);
-    onCommand(command);
-    setInput('');
-  };
+  useEffect(() => {
+    if (!containerRef.current) return;
 
-  const executeCommand = async (command: string) => {
-    if (command.toLowerCase() === 'clear') {
-      onUpdateOutput(session.id, []);
-      return;
-    }
+    const term = new XTerm({
+      cursorBlink: true,
+      fontFamily: 'Monaco, Menlo, "Ubuntu Mono", monospace',
+      fontSize: 14,
+      theme: { background: '#1e1e1e', foreground: '#d4d4d4' }
+    });
+    const fit = new FitAddon();
+    term.loadAddon(fit);
+    term.open(containerRef.current);
+    fit.fit();
+    termRef.current = term;
+    fitRef.current = fit;
 
-    try {
-      console.log(`Executing real command: ${command}`);
-      onUpdateOutput(session.id, [...output, '执行中...']);
-      
-      const result = await executeRealCommand(command, session.id);
-      console.log('Command result:', result);
-      
-      // 移除"执行中..."消息
-      let newOutput = output.slice(0, -1);
-      
-      // 更新当前目录（如果返回了workingDir）
-      if (result.workingDir) {
-        setCurrentDir(result.workingDir.replace(/^\/root$/, '~').replace(/^\/root\//, '~/'));
+    const socket = openTerminalSocket(term.cols, term.rows);
+    const encoder = new TextEncoder();
+    const sendControl = (msg: object) => {
+      if (socket.readyState === WebSocket.OPEN) {
+        socket.send(JSON.stringify(msg));
       }
-      
-      // 添加命令结果
-      if (result.stdout) {
-        newOutput = [...newOutput, result.stdout];
+    };
+    const sendInput = (data: string) => {
+      if (socket.readyState === WebSocket.OPEN) {
+        socket.send(encoder.encode(data));
       }
-      
-      if (result.stderr) {
-        newOutput = [...newOutput, `Error: ${result.stderr}`];
+    };
+
+    socket.onopen = () => onConnectionChange(session.id, true);
+    socket.onmessage = (event: MessageEvent) => {
+      if (event.data instanceof ArrayBuffer) {
+        term.write(new Uint8Array(event.data));
+        return;
       }
-      
-      if (result.exitCode !== 0 && !result.stdout && !result.stderr) {
-        newOutput = [...newOutput, `Command failed with exit code: ${result.exitCode}`];
+      try {
+        const msg = JSON.parse(event.data);
+        if (msg.type === 'exit') {
+          const reason = msg.reason === 'idle' ? '会话空闲超时' : msg.reason === 'exited' ? `Shell 已退出 (${msg.code})` : msg.reason;
+          term.write(`\r\n\x1b[33m[${reason}]\x1b[0m\r\n`);
+        }
+      } catch (error) {
+        console.error('Invalid terminal message:', error);
       }
-      
-      onUpdateOutput(session.id, newOutput);
-      
-    } catch (error: any) {
-      console.error('Command execution failed:', error);
-      
-      // 移除"执行中..."消息并添加错误信息
-      const newOutput = [...output.slice(0, -1), `Error: ${error.message || '命令执行失败'}`];
-      onUpdateOutput(session.id, newOutput);
-    }
-  };
+    };
+    socket.onclose = () => {
+      onConnectionChange(session.id, false);
+      term.write('\r\n\x1b[31m[连接已断开]\x1b[0m\r\n');
+    };
 
-  const handleKeyDown = (e: React.KeyboardEvent) => {
-    if (e.key === 'Enter') {
-      handleCommand(input);
-    } else if (e.key === 'ArrowUp') {
-      e.preventDefault();
-      if (commandHistory.length > 0) {
-        const newIndex = historyIndex === -1 ? commandHistory.length - 1 : Math.max(0, historyIndex - 1);
-        setHistoryIndex(newIndex);
-        setInput(commandHistory[newIndex]);
+    // 键盘输入和粘贴都按原始字节发送，Ctrl-C 等控制字符由伪终端处理
+    const dataListener = term.onData(sendInput);
+    const binaryListener = term.onBinary(data => {
+      if (socket.readyState === WebSocket.OPEN) {
+        socket.send(Uint8Array.from(data, c => c.charCodeAt(0)));
       }
-    } else if (e.key === 'ArrowDown') {
-      e.preventDefault();
-      if (historyIndex !== -1) {
-        const newIndex = Math.min(commandHistory.length - 1, historyIndex + 1);
-        setHistoryIndex(newIndex);
-        setInput(commandHistory[newIndex]);
-      }
-    }
-  };
+    });
+    const resizeListener = term.onResize(({ cols, rows }) => sendControl({ type: 'resize', cols, rows }));
+
+    const observer = new ResizeObserver(() => fit.fit());
+    observer.observe(containerRef.current);
+
+    onReady(session.id, { send: sendInput, clear: () => term.clear() });
+
+    return () => {
+      onReady(session.id, null);
+      observer.disconnect();
+      dataListener.dispose();
+      binaryListener.dispose();
+      resizeListener.dispose();
+      socket.close();
+      term.dispose();
+    };
+    // eslint-disable-next-line react-hooks/exhaustive-deps
+  }, [session.id]);
 
-  // 自动滚动到底部
+  // 切回标签页时重新计算尺寸并聚焦
   useEffect(() => {
-    if (terminalRef.current) {
-      terminalRef.current.scrollTop = terminalRef.current.scrollHeight;
+    if (active) {
+      fitRef.current?.fit();
+      termRef.current?.focus();
     }
-  }, [output]);
+  }, [active]);
 
   return (
-    <div style={{ height: '100%', display: 'flex', flexDirection: 'column' }}>
-      {/* Terminal output area - scrollable */}
-      <div 
-        ref={terminalRef}
-        style={{ 
-          flex: 1,
-          backgroundColor: '#1e1e1e',
-          color: '#d4d4d4',
-          padding: '16px',
-          fontFamily: 'Monaco, Menlo, "Ubuntu Mono", monospace',
-          fontSize: '14px',
-          overflow: 'auto',
-          whiteSpace: 'pre-wrap',
-          wordBreak: 'break-word',
-          minHeight: 0 // 重要：允许flex子元素缩小
-        }}
-      >
-        <div>Welcome to Terminal Session: {session.title}</div>
-        <div>Type 'help' for available commands.</div>
-        <div>---</div>
-        {output.map((line, index) => (
-          <div key={index} style={{ 
-            margin: '2px 0',
-            whiteSpace: 'pre-wrap',
-            wordBreak: 'break-word',
-            overflowWrap: 'anywhere' // 长行自动换行
-          }}>
-            {line}
-          </div>
-        ))}
-      </div>
-      
-      {/* Terminal input area - fixed at bottom */}
-      <div style={{ 
+    <div
+      ref={containerRef}
+      style={{
+        height: '100%',
         backgroundColor: '#1e1e1e',
-        padding: '8px 16px',
-        borderTop: '1px solid #404040',
-        display: 'flex',
-        alignItems: 'center',
-        flexShrink: 0 // 防止被压缩
-      }}>
-        <span style={{ color: '#569cd6', marginRight: '8px', flexShrink: 0 }}>
-          root@server:{currentDir}$
-        </span>
-        <Input
-          value={input}
-          onChange={(e) => setInput(e.target.value)}
-          onKeyDown={handleKeyDown}
-          placeholder="输入命令..."
-          bordered={false}
-          style={{ 
-            backgroundColor: 'transparent',
-            color: '#d4d4d4',
-            fontFamily: 'Monaco, Menlo, "Ubuntu Mono", monospace',
-            fontSize: '14px',
-            flex: 1
-          }}
-        />
-      </div>
-    </div>
+        padding: '8px',
+        minHeight: 0 // 重要：允许flex子元素缩小
+      }}
+    />
   );
 };
 
 const Terminal: React.FC = () => {
   const [sessions, setSessions] = useState<TerminalSession[]>([
-    { id: '1', title: '终端 1', active: true, connected: true }
+    { id: '1', title: '终端 1', active: true, connected: false }
   ]);
   const [activeKey, setActiveKey] = useState('1');
   const [fullscreen, setFullscreen] = useState(false);
-  const [sessionOutputs, setSessionOutputs] = useState<Record<string, string[]>>({});
+  const handles = useRef<Record<string, TerminalHandle>>({});
+  const nextId = useRef(2);
 
   const createNewSession = () => {
-    const newId = (sessions.length + 1).toString();
+    const newId = (nextId.current++).toString();
     const newSession: TerminalSession = {
       id: newId,
       title: `终端 ${newId}`,
       active: true,
-      connected: true
+      connected: false
     };
     setSessions(prev => [...prev, newSession]);
     setActiveKey(newId);
@@ -202,31 +154,33 @@ const Terminal: React.FC = () => {
       message.warning('至少需要保留一个终端会话');
       return;
     }
-    
+
     setSessions(prev => prev.filter(s => s.id !== sessionId));
-    
+
     if (activeKey === sessionId) {
       const remainingSessions = sessions.filter(s => s.id !== sessionId);
       setActiveKey(remainingSessions[0]?.id || '1');
     }
   };
 
-  const updateSessionOutput = (sessionId: string, newOutput: string[]) => {
-    setSessionOutputs(prev => ({
-      ...prev,
-      [sessionId]: newOutput
-    }));
+  const handleReady = (sessionId: string, handle: TerminalHandle | null) => {
+    if (handle) {
+      handles.current[sessionId] = handle;
+    } else {
+      delete handles.current[sessionId];
+    }
+  };
+
+  const handleConnectionChange = (sessionId: string, connected: boolean) => {
+    setSessions(prev => prev.map(s => (s.id === sessionId ? { ...s, connected } : s)));
   };
 
   const handleCommand = (command: string) => {
-    console.log('Executing command:', command);
+    handles.current[activeKey]?.send(command + '\r');
   };
 
   const handleClear = () => {
-    setSessionOutputs(prev => ({
-      ...prev,
-      [activeKey]: []
-    }));
+    handles.current[activeKey]?.clear();
   };
 
   const quickCommands = [
@@ -239,12 +193,12 @@ const Terminal: React.FC = () => {
   ];
 
   return (
-    <Card 
+    <Card
       title="终端操作"
       extra={
         <Space>
-          <Button 
-            size="small" 
+          <Button
+            size="small"
             onClick={handleClear}
             icon={<ClearOutlined />}
             ghost
@@ -252,9 +206,9 @@ const Terminal: React.FC = () => {
             清空
           </Button>
           {quickCommands.map(cmd => (
-            <Button 
+            <Button
               key={cmd.command}
-              size="small" 
+              size="small"
               onClick={() => handleCommand(cmd.command)}
             >
               {cmd.label}
@@ -267,10 +221,10 @@ const Terminal: React.FC = () => {
           />
         </Space>
       }
-      style={{ 
-        height: '100%', 
-        display: 'flex', 
-        flexDirection: 'column' 
+      style={{
+        height: '100%',
+        display: 'flex',
+        flexDirection: 'column'
       }}
       bodyStyle={{ flex: 1, padding: 0 }}
     >
@@ -292,8 +246,8 @@ const Terminal: React.FC = () => {
           <TabPane
             tab={
               <span>
-                <span 
-                  style={{ 
+                <span
+                  style={{
                     display: 'inline-block',
                     width: '8px',
                     height: '8px',
@@ -311,9 +265,8 @@ const Terminal: React.FC = () => {
             <TerminalTab
               session={session}
               active={activeKey === session.id}
-              output={sessionOutputs[session.id] || []}
-              onCommand={handleCommand}
-              onUpdateOutput={updateSessionOutput}
+              onReady={handleReady}
+              onConnectionChange={handleConnectionChange}
             />
           </TabPane>
         ))}
@@ -322,4 +275,4 @@ const Terminal: React.FC = () => {
   );
 };
 
-export default Terminal;
\ No newline at end of file
+export default Terminal;
@@ -51,6 +51,35 @@ export const fetchResourceHistory = async (duration: number = 60): Promise<Resou
   }
 };
 
+// 实时推送：订阅服务端的 SSE 流，返回取消订阅函数
+// topics: metrics, services, host, anomalies, events, alerts, log_alerts, approvals
+export const subscribeLive = (
+  topics: string[],
+  onEvent: (topic: string, data: any) => void
+): (() => void) => {
+  const source = new EventSource(`${API_BASE}/live?topics=${topics.join(',')}`, { withCredentials: true });
+  topics.forEach(topic => {
+    source.addEventListener(topic, (event: MessageEvent) => {
+      try {
+        onEvent(topic, JSON.parse(event.data));
+      } catch (error) {
+        console.error(`Failed to parse live ${topic} event:`, error);
+      }
+    });
+  });
+  // EventSource 断开后会自动重连
+  source.onerror = () => console.warn('Live stream disconnected, reconnecting...');
+  return () => source.close();
+};
+
+// 交互式终端：服务端在伪终端上运行 shell，二进制帧为终端输入输出，文本帧为 JSON 控制消息
+export const openTerminalSocket = (cols: number, rows: number): WebSocket => {
+  const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
+  const socket = new WebSocket(`${protocol}//${window.location.host}${API_BASE}/terminal/ws?cols=${cols}&rows=${rows}`);
+  socket.binaryType = 'arraybuffer';
+  return socket;
+};
+
 export const getServiceStatus = async (serviceName: string): Promise<'running' | 'stopped' | 'unknown'> => {
   try {
     const response = await api.get('/service-status', {
@@ -135,11 +164,16 @@ export const fetchRealLogs = async (serviceName: string, lines: number = 100) =>
   }
 };
 
-export const executeRealCommand = async (command: string, sessionId: string = 'default') => {
+// 需要审批的命令返回 202 和 approvalId；审批通过后带上 approvalId 重新提交即可执行
+// timeout 单位为秒，不传则使用服务端默认超时
+// sessionId 为空时服务端创建新会话，响应中的 sessionId 用于后续命令；会话只属于创建它的用户
+export const executeRealCommand = async (command: string, sessionId: string = '', approvalId?: number, timeout?: number) => {
   try {
     const response = await api.post('/terminal/execute', {
       command,
-      sessionId
+      sessionId,
+      approvalId,
+      timeout
     });
     return response.data;
   } catch (error: any) {
@@ -148,6 +182,239 @@ export const executeRealCommand = async (command: string, sessionId: string = 'd
   }
 };
 
+// 终端会话：包括命令会话和交互式会话，空闲超时后自动关闭
+export const fetchTerminalSessions = async () => {
+  try {
+    const response = await api.get('/terminal/sessions');
+    return response.data;
+  } catch (error: any) {
+    console.error('Failed to fetch terminal sessions:', error);
+    throw error;
+  }
+};
+
+export const fetchTerminalSessionHistory = async (id: string) => {
+  try {
+    const response = await api.get(`/terminal/sessions/${id}/history`);
+    return response.data;
+  } catch (error: any) {
+    console.error('Failed to fetch terminal session history:', error);
+    throw error;
+  }
+};
+
+export const closeTerminalSession = async (id: string) => {
+  try {
+    const response = await api.delete(`/terminal/sessions/${id}`);
+    return response.data;
+  } catch (error: any) {
+    console.error('Failed to close terminal session:', error);
+    throw error;
+  }
+};
+
+// 正在执行的命令：取消会结束整个进程组
+export const fetchRunningCommands = async () => {
+  try {
+    const response = await api.get('/terminal/commands');
+    return response.data;
+  } catch (error: any) {
+    console.error('Failed to fetch running commands:', error);
+    throw error;
+  }
+};
+
+export const cancelRunningCommand = async (id: string) => {
+  try {
+    const response = await api.post(`/terminal/commands/${id}/cancel`);
+    return response.data;
+  } catch (error: any) {
+    console.error('Failed to cancel command:', error);
+    throw error;
+  }
+};
+
+// 命令审批：status 为 pending、approved、rejected、expired 或 executed
+export const fetchCommandApprovals = async (status?: string) => {
+  try {
+    const response = await api.get('/terminal/approvals', { params: { status } });
+    return response.data;
+  } catch (error: any) {
+    console.error('Failed to fetch command approvals:', error);
+    throw error;
+  }
+};
+
+export const decideCommandApproval = async (id: number, approve: boolean, comment: string = '') => {
+  try {
+    const response = await api.post(`/terminal/approvals/${id}/${approve ? 'approve' : 'reject'}`, { comment });
+    return response.data;
+  } catch (error: any) {
+    console.error('Failed to decide command approval:', error);
+    throw error;
+  }
+};
+
+// 运维手册：带类型参数的脚本，经过与终端相同的命令策略和执行器运行
+export const fetchRunbooks = async () => {
+  try {
+    const response = await api.get('/runbooks');
+    return response.data;
+  } catch (error: any) {
+    console.error('Failed to fetch runbooks:', error);
+    throw error;
+  }
+};
+
+// 创建、修改和删除仅限管理员
+export const saveRunbook = async (runbook: any) => {
+  try {
+    const response = runbook.id
+      ? await api.put(`/runbooks/${runbook.id}`, runbook)
+      : await api.post('/runbooks', runbook);
+    return response.data;
+  } catch (error: any) {
+    console.error('Failed to save runbook:', error);
+    throw error;
+  }
+};
+
+export const deleteRunbook = async (id: number) => {
+  try {
+    const response = await api.delete(`/runbooks/${id}`);
+    return response.data;
+  } catch (error: any) {
+    console.error('Failed to delete runbook:', error);
+    throw error;
+  }
+};
+
+// 需要审批时返回 202 和 approvalId；审批通过后用相同参数带上 approvalId 重新运行
+// 运行开始后立即返回，用 run.commandId 取消，用 fetchRunbookRun 查看输出
+export const runRunbook = async (id: number, params: Record<string, string>, approvalId?: number) => {
+  try {
+    const response = await api.post(`/runbooks/${id}/run`, { params, approvalId });
+    return response.data;
+  } catch (error: any) {
+    console.error('Failed to run runbook:', error);
+    throw error;
+  }
+};
+
+// 运行记录（不含输出），非管理员只能看到自己的
+export const fetchRunbookRuns = async (runbookId?: number, limit: number = 100) => {
+  try {
+    const response = await api.get('/runbooks/runs', { params: { runbookId, limit } });
+    return response.data;
+  } catch (error: any) {
+    console.error('Failed to fetch runbook runs:', error);
+    throw error;
+  }
+};
+
+export const fetchRunbookRun = async (runId: number) => {
+  try {
+    const response = await api.get(`/runbooks/runs/${runId}`);
+    return response.data;
+  } catch (error: any) {
+    console.error('Failed to fetch runbook run:', error);
+    throw error;
+  }
+};
+
+// 服务文件浏览：路径相对于服务目录，无法访问目录之外的文件
+export const fetchServiceFiles = async (serviceName: string, path: string = '/') => {
+  try {
+    const response = await api.get(`/files/${serviceName}`, { params: { path } });
+    return response.data;
+  } catch (error: any) {
+    console.error('Failed to fetch service files:', error);
+    throw error;
+  }
+};
+
+// 读取文本文件，返回的 etag 在保存时带回；过大或二进制文件只能下载
+export const fetchServiceFileContent = async (serviceName: string, path: string) => {
+  try {
+    const response = await api.get(`/files/${serviceName}/content`, { params: { path } });
+    return response.data;
+  } catch (error: any) {
+    console.error('Failed to fetch service file content:', error);
+    throw error;
+  }
+};
+
+// 乐观锁：文件在读取后被修改时返回 412，需重新读取；etag 为空表示新建文件
+export const saveServiceFileContent = async (serviceName: string, path: string, content: string, etag: string = '') => {
+  try {
+    const response = await api.put(`/files/${serviceName}/content`, { path, content, etag });
+    return response.data;
+  } catch (error: any) {
+    console.error('Failed to save service file:', error);
+    throw error;
+  }
+};
+
+// 上传到 path 目录；已存在的文件需要 overwrite 或匹配的 etag
+export const uploadServiceFile = async (serviceName: string, path: string, file: File, overwrite: boolean = false) => {
+  try {
+    const form = new FormData();
+    form.append('file', file);
+    form.append('path', path);
+    form.append('overwrite', String(overwrite));
+    const response = await api.post(`/files/${serviceName}/upload`, form);
+    return response.data;
+  } catch (error: any) {
+    console.error('Failed to upload service file:', error);
+    throw error;
+  }
+};
+
+export const downloadServiceFile = async (serviceName: string, path: string) => {
+  try {
+    const response = await api.get(`/files/${serviceName}/download`, {
+      params: { path },
+      responseType: 'blob' // 处理文件下载
+    });
+
+    // 创建下载链接
+    const url = window.URL.createObjectURL(new Blob([response.data]));
+    const link = document.createElement('a');
+    link.href = url;
+    link.setAttribute('download', path.split('/').pop() || 'download');
+    document.body.appendChild(link);
+    link.click();
+    link.remove();
+    window.URL.revokeObjectURL(url);
+
+    return { success: true };
+  } catch (error: any) {
+    console.error('Failed to download service file:', error);
+    throw error;
+  }
+};
+
+// 文件修改记录：每次修改都保留备份和 diff
+export const fetchServiceFileChanges = async (serviceName: string, path?: string, limit: number = 100) => {
+  try {
+    const response = await api.get(`/files/${serviceName}/changes`, { params: { path, limit } });
+    return response.data;
+  } catch (error: any) {
+    console.error('Failed to fetch service file changes:', error);
+    throw error;
+  }
+};
+
+export const fetchServiceFileChange = async (serviceName: string, id: number) => {
+  try {
+    const response = await api.get(`/files/${serviceName}/changes/${id}`);
+    return response.data;
+  } catch (error: any) {
+    console.error('Failed to fetch service file change:', error);
+    throw error;
+  }
+};
+
 export const fetchRealSystemInfo = async () => {
   try {
     const response = await api.get('/system/info');
@@ -491,6 +758,56 @@ export const exportLogs = async (query: LogQuery, format: 'json' | 'csv' = 'json
   }
 };
 
+// 按时间窗口导出服务日志归档（tar.gz 或 zip）
+export const downloadLogArchive = async (params: {
+  services: string[]; // 服务名，或 服务名:日志源
+  start: string;
+  end?: string;
+  keyword?: string;
+  levels?: string[];
+  archive?: 'tar.gz' | 'zip';
+}) => {
+  try {
+    const archive = params.archive || 'tar.gz';
+    const response = await api.get('/logs/export', {
+      params: {
+        services: params.services.join(','),
+        start: params.start,
+        end: params.end,
+        keyword: params.keyword,
+        level: params.levels?.join(','),
+        archive
+      },
+      responseType: 'blob' // 处理文件下载
+    });
+
+    // 从响应头获取文件名
+    const contentDisposition = response.headers['content-disposition'];
+    let filename = `logs.${archive}`;
+    if (contentDisposition) {
+      const filenameMatch = contentDisposition.match(/filename=(.+)/);
+      if (filenameMatch) {
+        filename = filenameMatch[1];
+      }
+    }
+
+    // 创建下载链接
+    const url = window.URL.createObjectURL(new Blob([response.data]));
+    const link = document.createElement('a');
+    link.href = url;
+    link.setAttribute('download', filename);
+    document.body.appendChild(link);
+    link.click();
+    link.remove();
+    window.URL.revokeObjectURL(url);
+
+    return { success: true };
+  } catch (error: any) {
+    console.error('Failed to download log archive:', error);
+    throw error;
+  }
+};
+
 export const fetchLogServices = async () => {
   try {
     const response = await api.get('/logs/services');
@@ -6,6 +6,7 @@ module.exports = function(app) {
     createProxyMiddleware({
       target: 'http://localhost:9112',
       changeOrigin: true,
+      ws: true, // 终端 WebSocket
       timeout: 30000,
       onError: (err, req, res) => {
         console.error('Proxy error:', err.message);